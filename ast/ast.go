@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"strings"
@@ -18,11 +19,28 @@ func Parse(ctx context.Context, filePath string, enableSourceMap bool) (ef spec.
 	if err != nil {
 		return spec.Earthfile{}, err
 	}
+	input, err := antlr.NewFileStream(filePath)
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrapf(err, "new file stream %s", filePath)
+	}
+	return parse(ctx, filePath, input, version, enableSourceMap)
+}
+
+// ParseContent is like Parse, for the content of an earthfile which has already been read. The file
+// path is only used to locate the earthfile in errors and source maps.
+func ParseContent(ctx context.Context, filePath string, content []byte, enableSourceMap bool) (ef spec.Earthfile, err error) {
+	version, err := ParseVersionReader(bytes.NewReader(content), filePath, enableSourceMap)
+	if err != nil {
+		return spec.Earthfile{}, err
+	}
+	return parse(ctx, filePath, antlr.NewInputStream(string(content)), version, enableSourceMap)
+}
 
+func parse(ctx context.Context, filePath string, input antlr.CharStream, version *spec.Version, enableSourceMap bool) (ef spec.Earthfile, err error) {
 	// Convert.
 	errorListener := antlrhandler.NewReturnErrorListener()
 	errorStrategy := antlrhandler.NewReturnErrorStrategy()
-	tree, err := newEarthfileTree(filePath, errorListener, errorStrategy)
+	tree, err := newEarthfileTree(input, errorListener, errorStrategy)
 	if err != nil {
 		return spec.Earthfile{}, err
 	}
@@ -64,11 +82,7 @@ func walkTree(l *listener, tree parser.IEarthFileContext) (spec.Earthfile, error
 	return l.Earthfile(), nil
 }
 
-func newEarthfileTree(filename string, errorListener *antlrhandler.ReturnErrorListener, errorStrategy antlr.ErrorStrategy) (parser.IEarthFileContext, error) {
-	input, err := antlr.NewFileStream(filename)
-	if err != nil {
-		return nil, errors.Wrapf(err, "new file stream %s", filename)
-	}
+func newEarthfileTree(input antlr.CharStream, errorListener *antlrhandler.ReturnErrorListener, errorStrategy antlr.ErrorStrategy) (parser.IEarthFileContext, error) {
 	lexer := newLexer(input)
 	lexer.RemoveErrorListeners()
 	lexer.AddErrorListener(errorListener)
@@ -3,6 +3,7 @@ package ast
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -18,12 +19,17 @@ func ParseVersion(filePath string, enableSourceMap bool) (*spec.Version, error)
 		return nil, errors.Wrapf(err, "unable to open %q", filePath)
 	}
 	defer file.Close()
+	return ParseVersionReader(file, filePath, enableSourceMap)
+}
 
+// ParseVersionReader is like ParseVersion, for an Earthfile read out of r. The file path is only used
+// to locate the Earthfile in errors and source maps.
+func ParseVersionReader(r io.Reader, filePath string, enableSourceMap bool) (*spec.Version, error) {
 	var version spec.Version
 
 	foundVersion := false
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	i := 0
 	var startLine int
 	var endLine int
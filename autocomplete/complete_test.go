@@ -75,7 +75,7 @@ func getApp() *cli.App {
 func getPotentials(cmd string) ([]string, error) {
 	logger := conslogging.Current(conslogging.NoColor, 0, conslogging.Info)
 	gitLookup := buildcontext.NewGitLookup(logger, "")
-	resolver := buildcontext.NewResolver("", nil, gitLookup, logger, "")
+	resolver := buildcontext.NewResolver("", nil, gitLookup, logger, "", buildcontext.ResolverOpt{})
 	return GetPotentials(context.TODO(), resolver, nil, cmd, len(cmd), getApp())
 }
 
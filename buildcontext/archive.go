@@ -0,0 +1,93 @@
+package buildcontext
+
+import (
+	"context"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/stringutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// archiveFile is the name the remote archives are downloaded under.
+const archiveFile = "archive"
+
+// ErrArchiveDigestRequired is returned when resolving a reference to a remote archive whose url is
+// not pinned to the digest of the archive.
+type ErrArchiveDigestRequired struct {
+	// URL is the url of the archive.
+	URL string
+}
+
+// Error is function required by error interface.
+func (err ErrArchiveDigestRequired) Error() string {
+	return "the url of remote archive " + stringutil.ScrubCredentials(err.URL) +
+		" must be pinned to the sha256 digest of the archive, e.g. " + stringutil.ScrubCredentials(err.URL) + "#sha256=<hex>"
+}
+
+// archiveResolver resolves the references to remote archives (e.g.
+// https://example.com/project.tar.gz#sha256=abc...+target), which are downloaded over https, checked
+// against their digest and unpacked by buildkit, rather than cloned from git.
+type archiveResolver struct {
+	*stateSource
+}
+
+// resolveArchive resolves a remote archive reference. The archive comes with no git metadata.
+func (ar *archiveResolver) resolveArchive(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, contextPlatform platutil.Platform, featureFlagOverrides string) (*Data, error) {
+	ra, err := ar.parseArchive(ref)
+	if err != nil {
+		return nil, err
+	}
+	return ar.resolve(ctx, gwClient, platr, ref, ar.archiveState(ra, platr), ra.SubDir, archiveSource(ra), contextPlatform, featureFlagOverrides)
+}
+
+// resolveFeatures returns the features of the build file of a remote archive reference.
+func (ar *archiveResolver) resolveFeatures(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*features.Features, error) {
+	ra, err := ar.parseArchive(ref)
+	if err != nil {
+		return nil, err
+	}
+	bf, err := ar.resolveBuildFile(ctx, gwClient, platr, ref, ar.archiveState(ra, platr), ra.SubDir, archiveSource(ra), featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	return bf.ftrs, nil
+}
+
+// parseArchive parses the remote archive of ref, which must be pinned to its digest.
+func (ar *archiveResolver) parseArchive(ref domain.Reference) (domain.RemoteArchive, error) {
+	ra, err := domain.ParseRemoteArchive(ref.GetGitURL())
+	if err != nil {
+		return domain.RemoteArchive{}, err
+	}
+	if ra.SHA256 == "" {
+		return domain.RemoteArchive{}, ErrArchiveDigestRequired{URL: ra.URL}
+	}
+	return ra, nil
+}
+
+// archiveState returns the state of the unpacked archive. buildkit fails the download when the
+// archive does not have the expected digest, and caches it by digest.
+func (ar *archiveResolver) archiveState(ra domain.RemoteArchive, platr *platutil.Resolver) pllb.State {
+	scrubbedURL := stringutil.ScrubCredentials(ra.URL)
+	httpState := pllb.HTTP(ra.URL,
+		llb.Checksum(digest.NewDigestFromEncoded(digest.SHA256, ra.SHA256)),
+		llb.Filename(archiveFile),
+		llb.WithCustomNamef("[context %s] download archive", scrubbedURL))
+	return platr.Scratch().File(
+		pllb.Copy(httpState, archiveFile, "/", &llb.CopyInfo{
+			AttemptUnpack:  true,
+			CreateDestPath: true,
+		}),
+		llb.WithCustomNamef("[context %s] unpack archive", scrubbedURL))
+}
+
+// archiveSource describes the archive in errors.
+func archiveSource(ra domain.RemoteArchive) string {
+	return "archive " + stringutil.ScrubCredentials(ra.URL)
+}
@@ -0,0 +1,67 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveArchive(t *testing.T) {
+	archiveDigest := strings.Repeat("0f", 32)
+	gwClient := newTestGwClient(map[string]string{
+		"proj-1.0/Earthfile":      "VERSION --use-cache-command 0.6\n\nbuild:\n\tFROM alpine\n",
+		"proj-1.0/.earthlyignore": "dist\n",
+	})
+	r := newTestResolver(t, ResolverOpt{})
+	ref, err := domain.ParseTarget("https://example.com/proj.tar.gz//proj-1.0#sha256=" + archiveDigest + "+build")
+	NoError(t, err)
+
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "proj-1.0/Earthfile", d.BuildFileLocation)
+	True(t, d.Features.UseCacheCommand)
+	Nil(t, d.GitMetadata)
+	Equal(t, ref, d.Ref)
+	// The ignore file of the archive is applied.
+	var patterns []string
+	for _, p := range d.ExcludePatterns {
+		patterns = append(patterns, p.Pattern)
+	}
+	Contains(t, patterns, "dist")
+
+	// The archive is downloaded, checked against its digest, and unpacked; no git is involved.
+	var downloaded, unpacked bool
+	for _, op := range gwClient.solvedOps(t) {
+		if src := op.GetSource(); src != nil {
+			False(t, strings.HasPrefix(src.Identifier, "git://"), "unexpected git source %s", src.Identifier)
+			if src.Identifier == "https://example.com/proj.tar.gz" {
+				downloaded = true
+				Equal(t, "sha256:"+archiveDigest, src.Attrs[pb.AttrHTTPChecksum])
+			}
+		}
+		Nil(t, op.GetExec(), "unexpected exec op")
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if cp := action.GetCopy(); cp != nil && cp.AttemptUnpackDockerCompatibility {
+					unpacked = true
+				}
+			}
+		}
+	}
+	True(t, downloaded, "archive not downloaded")
+	True(t, unpacked, "archive not unpacked")
+
+	_, err = d.BuildContextFactory.Construct().Marshal(context.Background())
+	NoError(t, err, "marshal build context")
+
+	// Archives must be pinned to their digest.
+	unpinned, err := domain.ParseTarget("https://example.com/proj.tar.gz+build")
+	NoError(t, err)
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), unpinned)
+	IsType(t, ErrArchiveDigestRequired{}, err)
+}
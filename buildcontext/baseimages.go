@@ -0,0 +1,100 @@
+package buildcontext
+
+import (
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+)
+
+// BaseImage is an image referenced by a FROM command of an Earthfile.
+type BaseImage struct {
+	// Name is the image reference, as written in the Earthfile.
+	Name string
+	// Interpolated is set when Name references ARGs, which are left unexpanded.
+	Interpolated bool
+}
+
+// fromValueFlags are the FROM flags taking a value, which may be passed as a separate arg.
+var fromValueFlags = map[string]bool{
+	"--platform":  true,
+	"--build-arg": true,
+}
+
+// earthfileBaseImages returns the images referenced by the FROM commands of an Earthfile, in order
+// of appearance and without duplicates. Targets referenced by FROM, as well as FROM DOCKERFILE and
+// BUILD, point to other build definitions rather than images, and are not followed.
+func earthfileBaseImages(ef spec.Earthfile) []BaseImage {
+	var images []BaseImage
+	seen := make(map[string]bool)
+	var walk func(b spec.Block)
+	walk = func(b spec.Block) {
+		for _, stmt := range b {
+			switch {
+			case stmt.Command != nil:
+				if stmt.Command.Name != "FROM" {
+					continue
+				}
+				name := fromImageArg(stmt.Command.Args)
+				if name == "" || strings.Contains(name, "+") || seen[name] {
+					continue
+				}
+				seen[name] = true
+				images = append(images, BaseImage{
+					Name:         name,
+					Interpolated: strings.Contains(name, "$"),
+				})
+			case stmt.With != nil:
+				walk(stmt.With.Body)
+			case stmt.If != nil:
+				walk(stmt.If.IfBody)
+				for _, elseIf := range stmt.If.ElseIf {
+					walk(elseIf.Body)
+				}
+				if stmt.If.ElseBody != nil {
+					walk(*stmt.If.ElseBody)
+				}
+			case stmt.Try != nil:
+				walk(stmt.Try.TryBody)
+				if stmt.Try.CatchBody != nil {
+					walk(*stmt.Try.CatchBody)
+				}
+				if stmt.Try.FinallyBody != nil {
+					walk(*stmt.Try.FinallyBody)
+				}
+			case stmt.For != nil:
+				walk(stmt.For.Body)
+			case stmt.Wait != nil:
+				walk(stmt.Wait.Body)
+			}
+		}
+	}
+	walk(ef.BaseRecipe)
+	for _, t := range ef.Targets {
+		walk(t.Recipe)
+	}
+	for _, uc := range ef.UserCommands {
+		walk(uc.Recipe)
+	}
+	return images
+}
+
+// fromImageArg returns the first positional arg of a FROM command.
+func fromImageArg(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		}
+		if strings.HasPrefix(arg, "-") {
+			if fromValueFlags[arg] {
+				i++
+			}
+			continue
+		}
+		return arg
+	}
+	return ""
+}
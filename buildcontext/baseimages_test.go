@@ -0,0 +1,55 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+const baseImagesEarthfile = `VERSION 0.6
+FROM golang:1.19-alpine
+
+ARG ALPINE_VERSION=3.16
+
+deps:
+    FROM --platform linux/amd64 alpine:$ALPINE_VERSION
+    RUN echo deps
+
+build:
+    FROM +deps
+    IF [ -f go.mod ]
+        FROM --allow-privileged golang:1.19-alpine
+    ELSE
+        FROM --platform=linux/arm64 docker.io/library/debian:bullseye
+    END
+    BUILD ./sub+other
+
+docker:
+    FROM DOCKERFILE .
+    WITH DOCKER --pull redis:7
+        RUN docker run redis:7
+    END
+`
+
+func TestResolveBaseImages(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": baseImagesEarthfile,
+	})
+	r := newTestResolver(t, ResolverOpt{ExtractBaseImages: true})
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, []BaseImage{
+		{Name: "golang:1.19-alpine"},
+		{Name: "alpine:$ALPINE_VERSION", Interpolated: true},
+		{Name: "docker.io/library/debian:bullseye"},
+	}, d.BaseImages)
+
+	r = newTestResolver(t, ResolverOpt{})
+	d, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Nil(t, d.BaseImages)
+}
@@ -0,0 +1,63 @@
+package buildcontext
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+)
+
+// pathCasing returns the path p of fsys as it is cased on disk. On case-insensitive filesystems
+// (e.g. on macOS or Windows), a path requested with another casing is found all the same, yet would
+// not be on case-sensitive ones. The path is returned as is from the first directory leading to it
+// which cannot be read.
+func pathCasing(fsys fs.FS, p string) string {
+	parts := strings.Split(p, "/")
+	dir := "."
+	for i, part := range parts {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			break
+		}
+		for _, entry := range entries {
+			if entry.Name() == part {
+				parts[i] = part
+				break
+			}
+			if strings.EqualFold(entry.Name(), part) {
+				parts[i] = entry.Name()
+			}
+		}
+		dir = path.Join(dir, parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// checkBuildFileCasing warns when the build file bfPath, relative to root, the directory of fsys,
+// was detected under another casing than its own, so that it would not be found on case-sensitive
+// filesystems, such as those of Linux CI runners.
+func (lr *localResolver) checkBuildFileCasing(ref domain.Reference, fsys fs.FS, root, bfPath string) {
+	actual := pathCasing(fsys, bfPath)
+	if actual == bfPath {
+		return
+	}
+	lr.warn(ref, WarningBuildFileCasing, "the build file %s of %s is cased %s on disk, and would not be found on case-sensitive filesystems (e.g. on Linux)",
+		filepath.Join(root, filepath.FromSlash(bfPath)), ref.String(), filepath.Join(root, filepath.FromSlash(actual)))
+}
+
+// rootFS returns the root of the absolute path of p, its filesystem, and the path relative to it.
+func rootFS(p string) (fs.FS, string, string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, "", "", err
+	}
+	root := filepath.VolumeName(abs) + string(filepath.Separator)
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return os.DirFS(root), root, filepath.ToSlash(rel), nil
+}
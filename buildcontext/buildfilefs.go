@@ -0,0 +1,41 @@
+package buildcontext
+
+import (
+	"io/fs"
+	"os"
+)
+
+// BuildFileFS is the filesystem the build files of remote references are written to once read out
+// of their repository, and read back from to be parsed. Embedders may supply an in-memory
+// implementation to keep them off disk.
+type BuildFileFS interface {
+	// MkdirTemp creates a new, uniquely named directory, whose name starts with pattern, and
+	// returns its path.
+	MkdirTemp(pattern string) (string, error)
+	// WriteFile writes data to the named file, creating it if necessary.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// ReadFile returns the content of the named file.
+	ReadFile(name string) ([]byte, error)
+	// RemoveAll removes path and any children it contains.
+	RemoveAll(path string) error
+}
+
+// osBuildFileFS is the BuildFileFS of the OS filesystem, whose temp dirs are created in the default
+// directory for temporary files.
+type osBuildFileFS struct{}
+
+func (osBuildFileFS) MkdirTemp(pattern string) (string, error) {
+	return os.MkdirTemp(os.TempDir(), pattern)
+}
+
+func (osBuildFileFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (osBuildFileFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osBuildFileFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
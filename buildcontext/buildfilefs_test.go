@@ -0,0 +1,94 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/opencontainers/go-digest"
+	. "github.com/stretchr/testify/assert"
+)
+
+// memBuildFileFS is an in-memory BuildFileFS.
+type memBuildFileFS struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	numDirs int
+}
+
+func (m *memBuildFileFS) MkdirTemp(pattern string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.numDirs++
+	return fmt.Sprintf("/earthly-mem/%s%d", pattern, m.numDirs), nil
+}
+
+func (m *memBuildFileFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memBuildFileFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m *memBuildFileFS) RemoveAll(p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.files {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			delete(m.files, name)
+		}
+	}
+	return nil
+}
+
+func TestResolveBuildFileFS(t *testing.T) {
+	const earthfile = "VERSION --use-cache-command 0.6\n\nbuild:\n\tFROM alpine\n"
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	memFS := &memBuildFileFS{files: make(map[string][]byte)}
+	cleanCollection := cleanup.NewCollection()
+	console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info)
+	r := NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+		BuildFileFS: memFS,
+		BuildFileDigests: map[string]digest.Digest{
+			"github.com/earthly/test/sub:main": digest.FromString(earthfile),
+		},
+	})
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": earthfile,
+	})
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	True(t, d.Features.UseCacheCommand)
+	if Len(t, d.Earthfile.Targets, 1) {
+		Equal(t, "build", d.Earthfile.Targets[0].Name)
+	}
+	Equal(t, "Earthfile", path.Base(d.BuildFilePath))
+	content, err := memFS.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, earthfile, string(content))
+	// Nothing is written to disk.
+	_, err = os.Stat(d.BuildFilePath)
+	True(t, os.IsNotExist(err), "unexpected error %v", err)
+
+	Empty(t, cleanCollection.Close())
+	Empty(t, memFS.files)
+}
@@ -0,0 +1,23 @@
+package buildcontext
+
+import (
+	"context"
+
+	"github.com/earthly/earthly/util/syncutil/synccache"
+)
+
+var _ Cache = &synccache.SyncCache{}
+
+// Cache is a key-value store of the remote projects and build files resolved by a Resolver. The
+// default implementation is the in-memory synccache.SyncCache; others may back it with a store
+// shared by several resolvers (e.g. the replicas of a service).
+type Cache interface {
+	// Do returns the value for key, constructing it with c if there is none yet. Concurrent calls
+	// for the same key must share a single construction, the outcome of which is returned to
+	// all of them. Constructions failing with a context error must not be kept.
+	Do(ctx context.Context, key interface{}, c synccache.Constructor) (interface{}, error)
+	// Add sets a readily constructed value for key, failing if there already is one.
+	Add(ctx context.Context, key interface{}, value interface{}, valueErr error) error
+	// Delete removes the value for key, if any.
+	Delete(key interface{})
+}
@@ -0,0 +1,128 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/syncutil/synccache"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+// fakeCache is a minimal Cache, standing for e.g. one backed by a shared store.
+type fakeCache struct {
+	mu            sync.Mutex
+	entries       map[interface{}]*fakeCacheEntry
+	constructions map[interface{}]int
+	// joined receives the key of every call joining an ongoing construction.
+	joined chan interface{}
+}
+
+type fakeCacheEntry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{
+		entries:       make(map[interface{}]*fakeCacheEntry),
+		constructions: make(map[interface{}]int),
+		joined:        make(chan interface{}, 100),
+	}
+}
+
+func (c *fakeCache) Do(ctx context.Context, key interface{}, constructor synccache.Constructor) (interface{}, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.mu.Unlock()
+		select {
+		case <-e.done:
+		default:
+			c.joined <- key
+			<-e.done
+		}
+		return e.value, e.err
+	}
+	e = &fakeCacheEntry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.constructions[key]++
+	c.mu.Unlock()
+	e.value, e.err = constructor(ctx, key)
+	if errors.Is(e.err, context.Canceled) {
+		c.Delete(key)
+	}
+	close(e.done)
+	return e.value, e.err
+}
+
+func (c *fakeCache) Add(ctx context.Context, key interface{}, value interface{}, valueErr error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return errors.New("already exists")
+	}
+	e := &fakeCacheEntry{done: make(chan struct{}), value: value, err: valueErr}
+	close(e.done)
+	c.entries[key] = e
+	return nil
+}
+
+func (c *fakeCache) Delete(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func TestResolveCustomCache(t *testing.T) {
+	const numResolves = 8
+	gwClient := newTestGwClient(map[string]string{
+		"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	release := make(chan struct{})
+	gwClient.solveErr = func(def *pb.Definition) error {
+		<-release
+		return nil
+	}
+	projectCache := newFakeCache()
+	buildFileCache := newFakeCache()
+	r := newTestResolver(t, ResolverOpt{ProjectCache: projectCache, BuildFileCache: buildFileCache})
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numResolves)
+	for i := 0; i < numResolves; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			errs <- err
+		}()
+	}
+	// All but one of the resolutions join the construction of the project, which is held until
+	// they have.
+	for i := 0; i < numResolves-1; i++ {
+		select {
+		case key := <-projectCache.joined:
+			Equal(t, "https://github.com/earthly/test.git#main", key)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("only %d resolutions joined the construction", i)
+		}
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		NoError(t, err, "Resolve failed")
+	}
+
+	Equal(t, 1, projectCache.constructions["https://github.com/earthly/test.git#main"])
+	Equal(t, 1, buildFileCache.constructions["github.com/earthly/test:main"])
+	Equal(t, 1, gwClient.numMetaRuns(t))
+}
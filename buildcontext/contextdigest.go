@@ -0,0 +1,22 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// contextDigest returns the digest summarizing the build context of a remote target: the commit,
+// the subdirectory of the target within the repository along with its tree hash, and the exclude
+// patterns applied to it, in order.
+func contextDigest(hash, subDir, subtreeHash string, excludes []string) digest.Digest {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("commit %s\n", hash))
+	sb.WriteString(fmt.Sprintf("subdir %q\n", subDir))
+	sb.WriteString(fmt.Sprintf("tree %s\n", subtreeHash))
+	for _, pattern := range excludes {
+		sb.WriteString(fmt.Sprintf("exclude %q\n", pattern))
+	}
+	return digest.FromString(sb.String())
+}
@@ -0,0 +1,60 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/opencontainers/go-digest"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveContextDigest(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	resolve := func(opt ResolverOpt, files map[string]string) digest.Digest {
+		opt.ComputeContextDigest = true
+		d, err := newTestResolver(t, opt).Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		return d.ContextDigest
+	}
+
+	// Identical resolutions have identical digests.
+	dgst := resolve(ResolverOpt{}, files)
+	NoError(t, dgst.Validate())
+	Equal(t, dgst, resolve(ResolverOpt{}, files))
+
+	// Changing the excludes changes the digest.
+	excludesDgst := resolve(ResolverOpt{ExcludeGitDirs: true}, files)
+	NoError(t, excludesDgst.Validate())
+	NotEqual(t, dgst, excludesDgst)
+	Equal(t, excludesDgst, resolve(ResolverOpt{ExcludeGitDirs: true}, files))
+
+	// So does changing the tree of the subdirectory.
+	files["git-trees"] = "040000 tree 0e2f7d1c5b3a49687f5e4d3c2b1a09f8e7d6c5b4\tsub\x00"
+	NotEqual(t, dgst, resolve(ResolverOpt{}, files))
+
+	// Without the git metadata, or the option, there is no digest.
+	Empty(t, resolve(ResolverOpt{SkipGitMetadata: true}, files))
+	d, err := newTestResolver(t, ResolverOpt{}).Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.ContextDigest)
+}
+
+func TestContextDigest(t *testing.T) {
+	base := contextDigest("a7b2c4d5", "sub", "9c1f2a7e", nil)
+	Equal(t, base, contextDigest("a7b2c4d5", "sub", "9c1f2a7e", []string{}))
+	for _, other := range []digest.Digest{
+		contextDigest("b7b2c4d5", "sub", "9c1f2a7e", nil),
+		contextDigest("a7b2c4d5", "sub2", "9c1f2a7e", nil),
+		contextDigest("a7b2c4d5", "sub", "0c1f2a7e", nil),
+		contextDigest("a7b2c4d5", "sub", "9c1f2a7e", []string{".git"}),
+		contextDigest("a7b2c4d5", "sub", "9c1f2a7e", []string{".git", "**/.git"}),
+		contextDigest("a7b2c4d5", "sub", "9c1f2a7e", []string{"**/.git", ".git"}),
+	} {
+		NotEqual(t, base, other)
+	}
+}
@@ -1,7 +1,10 @@
 package buildcontext
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
@@ -10,6 +13,8 @@ import (
 	"github.com/earthly/earthly/domain"
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // ErrNotExist is the struct indicating that file does not exist.
@@ -22,49 +27,228 @@ func (err ErrEarthfileNotExist) Error() string {
 	return "No Earthfile nor build.earth file found for target " + err.Target
 }
 
-// detectBuildFile detects whether to use Earthfile, build.earth or Dockerfile.
-func detectBuildFile(ref domain.Reference, localDir string) (string, error) {
+// legacyBuildFileName is the deprecated name of Earthfiles.
+const legacyBuildFileName = "build.earth"
+
+// ErrLegacyBuildFile is returned, when forbidden, if the build file of a remote reference has the
+// deprecated build.earth name.
+type ErrLegacyBuildFile struct {
+	// Path is the path of the build file, relative to the root of the repository.
+	Path string
+	// Ref is the canonical form of the reference the build file belongs to.
+	Ref string
+}
+
+// Error is function required by error interface.
+func (err ErrLegacyBuildFile) Error() string {
+	return fmt.Sprintf("the build file %s of %s is named %s, which is deprecated: rename it to Earthfile", err.Path, err.Ref, legacyBuildFileName)
+}
+
+// BuildFileReadErrorKind classifies the reason a build file could not be read.
+type BuildFileReadErrorKind int
+
+const (
+	// BuildFileReadOther is any failure which is not otherwise classified.
+	BuildFileReadOther BuildFileReadErrorKind = iota
+	// BuildFileReadPermission is a failure due to the file mode of the build file.
+	BuildFileReadPermission
+	// BuildFileReadNotFound is a failure due to the build file not being present.
+	BuildFileReadNotFound
+)
+
+// String returns the human-readable name of the kind.
+func (k BuildFileReadErrorKind) String() string {
+	switch k {
+	case BuildFileReadPermission:
+		return "permission denied"
+	case BuildFileReadNotFound:
+		return "not found"
+	default:
+		return "read failure"
+	}
+}
+
+// ErrBuildFileRead is returned when a build file has been detected in a remote reference, but could
+// not be read.
+type ErrBuildFileRead struct {
+	// Path is the path of the build file, relative to the root of the repository.
+	Path string
+	// Ref is the canonical form of the reference the build file belongs to.
+	Ref string
+	// Kind is the classified reason of the failure.
+	Kind BuildFileReadErrorKind
+	// Err is the underlying error.
+	Err error
+}
+
+// Error is function required by error interface.
+func (err ErrBuildFileRead) Error() string {
+	return fmt.Sprintf("read build file %s of %s (%s): %v", err.Path, err.Ref, err.Kind, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err ErrBuildFileRead) Unwrap() error {
+	return err.Err
+}
+
+// newBuildFileReadError classifies the given error of reading a build file. Errors coming back from
+// buildkit have been through grpc, so they are matched on their status code and message as well.
+func newBuildFileReadError(ref domain.Reference, bfPath string, err error) ErrBuildFileRead {
+	kind := BuildFileReadOther
+	msg := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, fs.ErrPermission),
+		status.Code(errors.Cause(err)) == codes.PermissionDenied,
+		strings.Contains(msg, "permission denied"):
+		kind = BuildFileReadPermission
+	case errors.Is(err, fs.ErrNotExist),
+		status.Code(errors.Cause(err)) == codes.NotFound,
+		strings.Contains(msg, "no such file or directory"):
+		kind = BuildFileReadNotFound
+	}
+	return ErrBuildFileRead{
+		Path: bfPath,
+		Ref:  ref.ProjectCanonical(),
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// defaultBuildFileNames are the names of the build files of targets, in order of precedence.
+var defaultBuildFileNames = []string{"Earthfile", legacyBuildFileName}
+
+// buildFileNamesOrDefault returns names, or defaultBuildFileNames if there are none.
+func buildFileNamesOrDefault(names []string) []string {
+	if len(names) == 0 {
+		return defaultBuildFileNames
+	}
+	return names
+}
+
+// multipleBuildFilesWarning returns the warning about a directory holding several build files, the
+// first of which (as per their precedence) is used.
+func multipleBuildFilesWarning(ref string, found []string) string {
+	return fmt.Sprintf("the directory of %s holds several build files (%s): %s is used, as per the build file precedence", ref, strings.Join(found, ", "), found[0])
+}
+
+// detectBuildFile detects which of the build files named names to use, in order of precedence
+// (defaultBuildFileNames if none), or the Dockerfile of Dockerfile meta targets. All the build files
+// found are returned as well, the first being the one used.
+func detectBuildFile(ref domain.Reference, localDir string, names []string) (string, []string, error) {
 	if strings.HasPrefix(ref.GetName(), DockerfileMetaTarget) {
-		return filepath.Join(localDir, strings.TrimPrefix(ref.GetName(), DockerfileMetaTarget)), nil
+		bfPath := filepath.Join(localDir, strings.TrimPrefix(ref.GetName(), DockerfileMetaTarget))
+		return bfPath, []string{bfPath}, nil
 	}
-	earthfilePath := filepath.Join(localDir, "Earthfile")
-	_, err := os.Stat(earthfilePath)
-	if os.IsNotExist(err) {
-		buildEarthPath := filepath.Join(localDir, "build.earth")
-		_, err := os.Stat(buildEarthPath)
+	var found []string
+	for _, name := range buildFileNamesOrDefault(names) {
+		bfPath := filepath.Join(localDir, name)
+		fi, err := os.Stat(bfPath)
 		if os.IsNotExist(err) {
-			return "", ErrEarthfileNotExist{Target: ref.String()}
+			continue
 		} else if err != nil {
-			return "", errors.Wrapf(err, "stat file %s", buildEarthPath)
+			return "", nil, errors.Wrapf(err, "stat file %s", bfPath)
+		}
+		if !fi.IsDir() {
+			found = append(found, bfPath)
 		}
-		return buildEarthPath, nil
-	} else if err != nil {
-		return "", errors.Wrapf(err, "stat file %s", earthfilePath)
 	}
-	return earthfilePath, nil
+	if len(found) == 0 {
+		return "", nil, ErrEarthfileNotExist{Target: ref.String()}
+	}
+	return found[0], found, nil
 }
 
-func detectBuildFileInRef(ctx context.Context, earthlyRef domain.Reference, ref gwclient.Reference, subDir string) (string, error) {
+// defaultBuildFileLocations are the directories the build files of remote references are looked
+// for in, i.e. the subdirectory of the reference only.
+var defaultBuildFileLocations = []string{"."}
+
+// buildFileDirs returns the directories, relative to the root of the ref, to look for the build
+// file of subDir in, in order: the given locations (defaultBuildFileLocations if none), relative to
+// subDir or, when starting with a /, to the root of the ref, followed, when searchParents is set,
+// by the parent directories of subDir up to the root of the ref. Locations out of the ref are
+// rejected.
+func buildFileDirs(subDir string, locations []string, searchParents bool) ([]string, error) {
+	if len(locations) == 0 {
+		locations = defaultBuildFileLocations
+	}
+	subDir = path.Clean(subDir)
+	var dirs []string
+	seen := make(map[string]bool)
+	add := func(dir string) {
+		if dir == "/" {
+			dir = "."
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, loc := range locations {
+		var dir string
+		if strings.HasPrefix(loc, "/") {
+			dir = path.Clean(strings.TrimPrefix(loc, "/"))
+		} else {
+			dir = path.Join(subDir, loc)
+		}
+		if dir == ".." || strings.HasPrefix(dir, "../") {
+			return nil, errors.Errorf("build file location %s of %s is out of the repository", loc, subDir)
+		}
+		add(dir)
+	}
+	if searchParents {
+		for dir := subDir; dir != "." && dir != "/"; {
+			dir = path.Dir(dir)
+			add(dir)
+		}
+	}
+	return dirs, nil
+}
+
+// detectBuildFileInRef detects the build file of earthlyRef within subDir of the given ref, out of
+// those named names, in order of precedence (defaultBuildFileNames if none). The directories given
+// by locations are tried in order (see buildFileDirs), the first one holding a build file winning.
+// When searchParents is set and none does, the parent directories of subDir are searched as well,
+// up to the root of the ref. All the build files found in the directory of the detected one are
+// returned as well, the first being the one used.
+func detectBuildFileInRef(ctx context.Context, earthlyRef domain.Reference, ref gwclient.Reference, subDir string, locations []string, searchParents bool, names []string) (string, []string, error) {
 	if strings.HasPrefix(earthlyRef.GetName(), DockerfileMetaTarget) {
-		return filepath.Join(subDir, strings.TrimPrefix(earthlyRef.GetName(), DockerfileMetaTarget)), nil
+		bfPath := filepath.Join(subDir, strings.TrimPrefix(earthlyRef.GetName(), DockerfileMetaTarget))
+		return bfPath, []string{bfPath}, nil
 	}
-	earthfilePath := path.Join(subDir, "Earthfile")
-	exists, err := fileExists(ctx, ref, earthfilePath)
+	dirs, err := buildFileDirs(subDir, locations, searchParents)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	if exists {
-		return earthfilePath, nil
+	for _, dir := range dirs {
+		var found []string
+		for _, name := range buildFileNamesOrDefault(names) {
+			bfPath := path.Join(dir, name)
+			exists, err := fileExists(ctx, ref, bfPath)
+			if err != nil {
+				return "", nil, err
+			}
+			if exists {
+				found = append(found, bfPath)
+			}
+		}
+		if len(found) > 0 {
+			return found[0], found, nil
+		}
 	}
-	buildEarthPath := path.Join(subDir, "build.earth")
-	exists, err = fileExists(ctx, ref, buildEarthPath)
+	err = checkSubDirInRef(ctx, ref, earthlyRef.ProjectCanonical(), subDir)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	if exists {
-		return buildEarthPath, nil
+	return "", nil, errors.Errorf("no build file found in %s", subDir)
+}
+
+// normalizeLineEndings converts the CRLF line endings of a build file to LF, returning the bytes as
+// they are when there are none.
+func normalizeLineEndings(dt []byte) []byte {
+	if !bytes.Contains(dt, []byte("\r\n")) {
+		return dt
 	}
-	return "", errors.Errorf("no build file found in %s", subDir)
+	return bytes.ReplaceAll(dt, []byte("\r\n"), []byte("\n"))
 }
 
 func fileExists(ctx context.Context, ref gwclient.Reference, fpath string) (bool, error) {
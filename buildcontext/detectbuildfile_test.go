@@ -0,0 +1,296 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResolveBuildFileReadError(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	gwClient.readErrs = map[string]error{
+		"sub/Earthfile": status.Error(codes.Unknown, "open /var/lib/buildkit/snapshots/42/fs/sub/Earthfile: permission denied"),
+	}
+	r := newTestResolver(t, ResolverOpt{})
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	var readErr ErrBuildFileRead
+	True(t, errors.As(err, &readErr), "unexpected error %v", err)
+	Equal(t, "sub/Earthfile", readErr.Path)
+	Equal(t, "github.com/earthly/test/sub:main", readErr.Ref)
+	Equal(t, BuildFileReadPermission, readErr.Kind)
+	Contains(t, err.Error(), "sub/Earthfile")
+	Contains(t, err.Error(), "github.com/earthly/test/sub:main")
+	Contains(t, err.Error(), "permission denied")
+}
+
+func TestNewBuildFileReadError(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	tests := []struct {
+		err  error
+		kind BuildFileReadErrorKind
+	}{
+		{status.Error(codes.PermissionDenied, "denied"), BuildFileReadPermission},
+		{errors.New("open Earthfile: permission denied"), BuildFileReadPermission},
+		{status.Error(codes.NotFound, "gone"), BuildFileReadNotFound},
+		{errors.New("open Earthfile: no such file or directory"), BuildFileReadNotFound},
+		{errors.New("invalid utf-8 sequence"), BuildFileReadOther},
+	}
+	for _, tt := range tests {
+		readErr := newBuildFileReadError(ref, "Earthfile", tt.err)
+		Equal(t, tt.kind, readErr.Kind, tt.err.Error())
+		Equal(t, tt.err, errors.Unwrap(readErr))
+	}
+}
+
+func TestResolveSearchParentBuildFiles(t *testing.T) {
+	const rootEarthfile = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	files := map[string]string{
+		"Earthfile":      rootEarthfile,
+		"a/b/c/main.go":  "package main\n",
+		"x/Earthfile":    "VERSION 0.6\n\ntest:\n\tFROM alpine\n",
+		"x/y/z/build.go": "package main\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/a/b/c:main+build")
+	NoError(t, err)
+
+	// The subdirectory has no build file of its own.
+	r := newTestResolver(t, ResolverOpt{})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	Error(t, err)
+
+	r = newTestResolver(t, ResolverOpt{SearchParentBuildFiles: true})
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	bf, err := os.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, rootEarthfile, string(bf))
+	Equal(t, "a/b/c", d.GitMetadata.RelDir)
+
+	// The build context remains restricted to the subdirectory.
+	def, err := d.BuildContextFactory.Construct().Marshal(context.Background())
+	NoError(t, err, "marshal build context")
+	var copies []*pb.FileActionCopy
+	for _, dt := range def.Def {
+		var op pb.Op
+		NoError(t, op.Unmarshal(dt), "unmarshal op")
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if cp := action.GetCopy(); cp != nil {
+					copies = append(copies, cp)
+				}
+			}
+		}
+	}
+	Len(t, copies, 1)
+	Equal(t, "/a/b/c", copies[0].Src)
+
+	// The closest parent wins.
+	other, err := domain.ParseTarget("github.com/earthly/test/x/y/z:main+test")
+	NoError(t, err)
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), other)
+	NoError(t, err, "Resolve failed")
+	bf, err = os.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, files["x/Earthfile"], string(bf))
+}
+
+func TestResolveBuildFileLocations(t *testing.T) {
+	const sharedEarthfile = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	files := map[string]string{
+		"Earthfile":              "VERSION 0.6\n\nroot:\n\tFROM alpine\n",
+		"sub/main.go":            "package main\n",
+		"sub/.earthly/Earthfile": sharedEarthfile,
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	locations := []string{".", ".earthly", "/"}
+
+	// The Earthfile is only found in the .earthly directory, ahead of the root one.
+	r := newTestResolver(t, ResolverOpt{BuildFileLocations: locations})
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	bf, err := os.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, sharedEarthfile, string(bf))
+	Equal(t, "sub/.earthly/Earthfile", d.BuildFileLocation)
+	Equal(t, "sub", d.GitMetadata.RelDir)
+
+	// The first location holding a build file wins.
+	files["sub/Earthfile"] = "VERSION 0.6\n\nbuild:\n\tFROM busybox\n"
+	r = newTestResolver(t, ResolverOpt{BuildFileLocations: locations})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "sub/Earthfile", d.BuildFileLocation)
+
+	// Locations out of the repository are rejected.
+	r = newTestResolver(t, ResolverOpt{BuildFileLocations: []string{"../.."}})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	Error(t, err)
+	Contains(t, err.Error(), "out of the repository")
+}
+
+func TestBuildFileDirs(t *testing.T) {
+	dirs, err := buildFileDirs("a/b", nil, false)
+	NoError(t, err)
+	Equal(t, []string{"a/b"}, dirs)
+	dirs, err = buildFileDirs("a/b", []string{".", ".earthly", "/", "/.earthly"}, false)
+	NoError(t, err)
+	Equal(t, []string{"a/b", "a/b/.earthly", ".", ".earthly"}, dirs)
+	// The parents follow the locations, once each.
+	dirs, err = buildFileDirs("a/b", []string{".", "/"}, true)
+	NoError(t, err)
+	Equal(t, []string{"a/b", ".", "a"}, dirs)
+	dirs, err = buildFileDirs(".", []string{".", ".earthly"}, true)
+	NoError(t, err)
+	Equal(t, []string{".", ".earthly"}, dirs)
+	_, err = buildFileDirs("a", []string{"../.."}, false)
+	Error(t, err)
+}
+
+func TestResolveBuildFileLineEndings(t *testing.T) {
+	const earthfile = "VERSION 0.6\r\n\r\nbuild:\r\n\tFROM alpine:3.15\r\n\tRUN echo hello \\\r\n\t\tworld\r\n\tSAVE ARTIFACT /etc/os-release\r\n"
+	files := map[string]string{
+		"sub/Earthfile": earthfile,
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	r := newTestResolver(t, ResolverOpt{})
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	bf, err := os.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, strings.ReplaceAll(earthfile, "\r\n", "\n"), string(bf))
+	Len(t, d.Earthfile.Targets, 1)
+	Equal(t, "build", d.Earthfile.Targets[0].Name)
+	var cmds []string
+	for _, stmt := range d.Earthfile.Targets[0].Recipe {
+		NotNil(t, stmt.Command)
+		cmds = append(cmds, stmt.Command.Name+" "+strings.Join(stmt.Command.Args, " "))
+	}
+	Equal(t, []string{"FROM alpine:3.15", "RUN echo hello world", "SAVE ARTIFACT /etc/os-release"}, cmds)
+
+	r = newTestResolver(t, ResolverOpt{PreserveBuildFileLineEndings: true})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	bf, err = os.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, earthfile, string(bf))
+}
+
+func TestResolveLegacyBuildFile(t *testing.T) {
+	const earthfile = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	newResolver := func(forbid bool) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			ForbidLegacyBuildFile: forbid,
+		}), &buf
+	}
+
+	// Earthfiles are not warned about.
+	r, buf := newResolver(false)
+	_, err = r.Resolve(context.Background(), newTestGwClient(map[string]string{"sub/Earthfile": earthfile}), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, buf.String())
+
+	// Warned about once per resolution.
+	legacyFiles := map[string]string{"sub/build.earth": earthfile}
+	r, buf = newResolver(false)
+	for i := 0; i < 2; i++ {
+		d, err := r.Resolve(context.Background(), newTestGwClient(legacyFiles), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		Equal(t, "build.earth", filepath.Base(d.BuildFilePath))
+	}
+	Equal(t, 1, strings.Count(buf.String(), "Warning: the build file sub/build.earth of github.com/earthly/test/sub:main is named build.earth, which is deprecated"), buf.String())
+
+	r, buf = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(legacyFiles), newTestPlatformResolver(), ref)
+	var legacyErr ErrLegacyBuildFile
+	True(t, errors.As(err, &legacyErr), "unexpected error %v", err)
+	Equal(t, ErrLegacyBuildFile{Path: "sub/build.earth", Ref: "github.com/earthly/test/sub:main"}, legacyErr)
+	Empty(t, buf.String())
+}
+
+func TestResolveMultipleBuildFiles(t *testing.T) {
+	const earthfile = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	newResolver := func(names []string) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			BuildFileNames: names,
+		}), &buf
+	}
+	remote, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile":   earthfile,
+		"sub/build.earth": earthfile,
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"Earthfile", "build.earth"} {
+		NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(earthfile), 0644))
+	}
+	local, err := domain.ParseTarget(dir + "+build")
+	NoError(t, err)
+
+	tests := []struct {
+		names    []string
+		expected string
+		warning  string
+	}{
+		{nil, "Earthfile", "(sub/Earthfile, sub/build.earth): sub/Earthfile is used"},
+		{[]string{"build.earth", "Earthfile"}, "build.earth", "(sub/build.earth, sub/Earthfile): sub/build.earth is used"},
+	}
+	for _, tc := range tests {
+		r, buf := newResolver(tc.names)
+		for i := 0; i < 2; i++ {
+			d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), remote)
+			NoError(t, err, "Resolve failed")
+			Equal(t, tc.expected, filepath.Base(d.BuildFilePath))
+		}
+		// Warned about once per resolution.
+		Equal(t, 1, strings.Count(buf.String(), "Warning: the directory of github.com/earthly/test/sub:main holds several build files "+tc.warning), buf.String())
+
+		r, buf = newResolver(tc.names)
+		d, err := r.Resolve(context.Background(), newTestGwClient(nil), newTestPlatformResolver(), local)
+		NoError(t, err, "Resolve failed")
+		Equal(t, filepath.Join(dir, tc.expected), d.BuildFilePath)
+		Contains(t, buf.String(), "holds several build files")
+	}
+
+	// A single build file is not warned about, whatever its precedence.
+	r, buf := newResolver([]string{"build.earth", "Earthfile"})
+	d, err := r.Resolve(context.Background(), newTestGwClient(map[string]string{"sub/Earthfile": earthfile}), newTestPlatformResolver(), remote)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "Earthfile", filepath.Base(d.BuildFilePath))
+	Empty(t, buf.String())
+}
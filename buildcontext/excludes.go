@@ -1,11 +1,15 @@
 package buildcontext
 
 import (
+	"bytes"
+	"context"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/earthly/earthly/util/fileutil"
 	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/pkg/errors"
 )
 
@@ -23,26 +27,91 @@ var ImplicitExcludes = []string{
 	earthlyIgnoreFile,
 }
 
+// Sources of the exclude patterns of build contexts, other than ignore files.
+const (
+	// ExcludeSourceImplicit is the source of ImplicitExcludes.
+	ExcludeSourceImplicit = "implicit"
+	// ExcludeSourceGitDirs is the source of the patterns excluding .git directories from the build
+	// context of remote targets, with ExcludeGitDirs.
+	ExcludeSourceGitDirs = "ExcludeGitDirs"
+)
+
+// ExcludePattern is an exclude pattern applied to a build context.
+type ExcludePattern struct {
+	Pattern string
+	// Source is where the pattern comes from: the name of an ignore file (.earthignore or
+	// .earthlyignore), ExcludeSourceImplicit or ExcludeSourceGitDirs.
+	Source string
+}
+
+// excludePatterns returns the given patterns, all coming from source.
+func excludePatterns(patterns []string, source string) []ExcludePattern {
+	if len(patterns) == 0 {
+		return nil
+	}
+	ret := make([]ExcludePattern, 0, len(patterns))
+	for _, p := range patterns {
+		ret = append(ret, ExcludePattern{Pattern: p, Source: source})
+	}
+	return ret
+}
+
+// patternsOf returns the patterns of the given exclude patterns, without their source.
+func patternsOf(excludes []ExcludePattern) []string {
+	ret := make([]string, 0, len(excludes))
+	for _, e := range excludes {
+		ret = append(ret, e.Pattern)
+	}
+	return ret
+}
+
 func readExcludes(dir string, noImplicitIgnore bool) ([]string, error) {
+	excludes, err := readExcludePatterns(dir, noImplicitIgnore)
+	if excludes == nil {
+		return nil, err
+	}
+	return patternsOf(excludes), err
+}
+
+// readExcludePatterns is like readExcludes, but returns the source of each pattern as well.
+func readExcludePatterns(dir string, noImplicitIgnore bool) ([]ExcludePattern, error) {
+	return excludePatternsOf(filepath.Join(dir, earthIgnoreFile), filepath.Join(dir, earthlyIgnoreFile), fileutil.FileExists, os.ReadFile, noImplicitIgnore)
+}
+
+// readRefExcludePatterns is like readExcludePatterns, for the build context living in dir of the
+// given reference (e.g. the checkout of a remote repository).
+func readRefExcludePatterns(ctx context.Context, ref gwclient.Reference, dir string, noImplicitIgnore bool) ([]ExcludePattern, error) {
+	exists := func(p string) (bool, error) {
+		return fileExists(ctx, ref, p)
+	}
+	read := func(p string) ([]byte, error) {
+		return ref.ReadFile(ctx, gwclient.ReadRequest{
+			Filename: p,
+		})
+	}
+	return excludePatternsOf(path.Join(dir, earthIgnoreFile), path.Join(dir, earthlyIgnoreFile), exists, read, noImplicitIgnore)
+}
+
+// excludePatternsOf returns the exclude patterns of the build context whose .earthignore and
+// .earthlyignore files are at the given paths, checked for and read with exists and read.
+func excludePatternsOf(earthIgnoreFilePath, earthlyIgnoreFilePath string, exists func(p string) (bool, error), read func(p string) ([]byte, error), noImplicitIgnore bool) ([]ExcludePattern, error) {
 	var ignoreFile = earthIgnoreFile
 
 	//earthIgnoreFile
-	var earthIgnoreFilePath = filepath.Join(dir, earthIgnoreFile)
-	earthExists, err := fileutil.FileExists(earthIgnoreFilePath)
+	earthExists, err := exists(earthIgnoreFilePath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to check if %s exists", earthIgnoreFilePath)
 	}
 
 	//earthlyIgnoreFile
-	var earthlyIgnoreFilePath = filepath.Join(dir, earthlyIgnoreFile)
-	earthlyExists, err := fileutil.FileExists(earthlyIgnoreFilePath)
+	earthlyExists, err := exists(earthlyIgnoreFilePath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to check if %s exists", earthlyIgnoreFilePath)
 	}
 
-	defaultExcludes := ImplicitExcludes
+	defaultExcludes := excludePatterns(ImplicitExcludes, ExcludeSourceImplicit)
 	if noImplicitIgnore {
-		defaultExcludes = []string{}
+		defaultExcludes = []ExcludePattern{}
 	}
 
 	// Check which ones exists and which don't
@@ -52,18 +121,20 @@ func readExcludes(dir string, noImplicitIgnore bool) ([]string, error) {
 	} else if earthExists == earthlyExists {
 		// return just ImplicitExcludes if neither of them exist
 		return defaultExcludes, nil
-	} else if earthlyExists {
+	}
+	filePath := earthIgnoreFilePath
+	if earthlyExists {
 		ignoreFile = earthlyIgnoreFile
+		filePath = earthlyIgnoreFilePath
 	}
 
-	filePath := filepath.Join(dir, ignoreFile)
-	f, err := os.Open(filePath)
+	dt, err := read(filePath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "read %s", filePath)
 	}
-	excludes, err := dockerignore.ReadAll(f)
+	excludes, err := dockerignore.ReadAll(bytes.NewReader(dt))
 	if err != nil {
 		return nil, errors.Wrapf(err, "parse %s", filePath)
 	}
-	return append(excludes, defaultExcludes...), nil
+	return append(excludePatterns(excludes, ignoreFile), defaultExcludes...), nil
 }
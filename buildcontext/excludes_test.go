@@ -1,10 +1,15 @@
 package buildcontext
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
 )
 
 func Test_readExcludes(t *testing.T) {
@@ -99,3 +104,123 @@ func Test_readExcludes(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveExcludePatterns(t *testing.T) {
+	dir := t.TempDir()
+	NoError(t, os.WriteFile(filepath.Join(dir, "Earthfile"), []byte("VERSION 0.5\n\nbuild:\n\tFROM alpine\n"), 0644))
+	NoError(t, os.WriteFile(filepath.Join(dir, earthIgnoreFile), []byte("node_modules/\n*.log\n"), 0644))
+	ref, err := domain.ParseTarget(dir + "+build")
+	NoError(t, err)
+	r := newTestResolver(t, ResolverOpt{})
+	d, err := r.Resolve(context.Background(), newTestGwClient(nil), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, []ExcludePattern{
+		{Pattern: "node_modules", Source: ".earthignore"},
+		{Pattern: "*.log", Source: ".earthignore"},
+		{Pattern: ".tmp-earthly-out/", Source: ExcludeSourceImplicit},
+		{Pattern: "build.earth", Source: ExcludeSourceImplicit},
+		{Pattern: "Earthfile", Source: ExcludeSourceImplicit},
+		{Pattern: ".earthignore", Source: ExcludeSourceImplicit},
+		{Pattern: ".earthlyignore", Source: ExcludeSourceImplicit},
+	}, d.ExcludePatterns)
+
+	// Remote targets.
+	remote, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	r = newTestResolver(t, ResolverOpt{ExcludeGitDirs: true})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), remote)
+	NoError(t, err, "Resolve failed")
+	Equal(t, []ExcludePattern{
+		{Pattern: ".git", Source: ExcludeSourceGitDirs},
+		{Pattern: "**/.git", Source: ExcludeSourceGitDirs},
+	}, d.ExcludePatterns)
+
+	r = newTestResolver(t, ResolverOpt{})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), remote)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.ExcludePatterns)
+}
+
+func TestResolveRemoteIgnoreFiles(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile":      "VERSION 0.5\n\nbuild:\n\tFROM alpine\n",
+		"sub/.earthignore":   "node_modules/\n*.log\n",
+		".earthlyignore":     "sub/\n",
+		"other/.earthignore": "*\n",
+	}
+	contextCopies := func(d *Data) []*pb.FileActionCopy {
+		def, err := d.BuildContextFactory.Construct().Marshal(context.Background())
+		NoError(t, err, "marshal build context")
+		var copies []*pb.FileActionCopy
+		for _, dt := range def.Def {
+			var op pb.Op
+			NoError(t, op.Unmarshal(dt), "unmarshal op")
+			if file := op.GetFile(); file != nil {
+				for _, action := range file.Actions {
+					if cp := action.GetCopy(); cp != nil {
+						copies = append(copies, cp)
+					}
+				}
+			}
+		}
+		return copies
+	}
+
+	r := newTestResolver(t, ResolverOpt{RemoteIgnoreFiles: true, ExcludeGitDirs: true})
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	// The ignore file of the directory of the target, as for local targets.
+	expected := []ExcludePattern{
+		{Pattern: "node_modules", Source: ".earthignore"},
+		{Pattern: "*.log", Source: ".earthignore"},
+		{Pattern: ".tmp-earthly-out/", Source: ExcludeSourceImplicit},
+		{Pattern: "build.earth", Source: ExcludeSourceImplicit},
+		{Pattern: "Earthfile", Source: ExcludeSourceImplicit},
+		{Pattern: ".earthignore", Source: ExcludeSourceImplicit},
+		{Pattern: ".earthlyignore", Source: ExcludeSourceImplicit},
+		{Pattern: ".git", Source: ExcludeSourceGitDirs},
+		{Pattern: "**/.git", Source: ExcludeSourceGitDirs},
+	}
+	Equal(t, expected, d.ExcludePatterns)
+	copies := contextCopies(d)
+	if Len(t, copies, 1) {
+		Equal(t, "/sub", copies[0].Src)
+		Equal(t, patternsOf(expected), copies[0].ExcludePatterns)
+	}
+
+	// At the root of the repository, without implicit excludes.
+	files["Earthfile"] = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	root, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	r = newTestResolver(t, ResolverOpt{RemoteIgnoreFiles: true})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), root)
+	NoError(t, err, "Resolve failed")
+	Equal(t, []ExcludePattern{{Pattern: "sub", Source: ".earthlyignore"}}, d.ExcludePatterns)
+	copies = contextCopies(d)
+	if Len(t, copies, 1) {
+		Equal(t, []string{"sub"}, copies[0].ExcludePatterns)
+	}
+
+	// Both ignore files.
+	files[".earthignore"] = "*.log\n"
+	r = newTestResolver(t, ResolverOpt{RemoteIgnoreFiles: true})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), root)
+	if Error(t, err) {
+		Contains(t, err.Error(), errDuplicateIgnoreFile.Error())
+	}
+
+	// Not applied unless enabled.
+	r = newTestResolver(t, ResolverOpt{})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.ExcludePatterns)
+	copies = contextCopies(d)
+	if Len(t, copies, 1) {
+		Empty(t, copies[0].ExcludePatterns)
+	}
+}
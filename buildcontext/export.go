@@ -0,0 +1,106 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/earthly/earthly/util/platutil"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// ExportContext resolves the build context of a given Earthly target, and writes its contents to w
+// as a tar stream, without building the target. Remote build contexts are restricted to the
+// target's subdirectory, and respect ExcludeGitDirs.
+func (r *Resolver) ExportContext(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, w io.Writer) error {
+	if _, isTarget := ref.(domain.Target); !isTarget {
+		return errors.Errorf("cannot export the build context of non-target %s", ref.String())
+	}
+	d, err := r.Resolve(ctx, gwClient, platr, ref)
+	if err != nil {
+		return err
+	}
+	contextState, err := llbfactory.Construct(d.BuildContextFactory)
+	if err != nil {
+		return err
+	}
+	noCache := false
+	contextRef, err := llbutil.StateToRef(
+		ctx, gwClient, contextState, noCache,
+		platr.SubResolver(platutil.NativePlatform), nil)
+	if err != nil {
+		return errors.Wrap(err, "state to ref build context")
+	}
+	tw := tar.NewWriter(w)
+	err = exportRefDir(ctx, contextRef, tw, ".")
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(tw.Close(), "close tar")
+}
+
+// exportRefDir writes the contents of dir within the given ref to the tar writer, recursively.
+func exportRefDir(ctx context.Context, ref gwclient.Reference, tw *tar.Writer, dir string) error {
+	stats, err := ref.ReadDir(ctx, gwclient.ReadDirRequest{Path: dir})
+	if err != nil {
+		return errors.Wrapf(err, "read dir %s", dir)
+	}
+	for _, st := range stats {
+		name := path.Join(dir, st.Path)
+		mode := os.FileMode(st.Mode)
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    int64(mode.Perm()),
+			Uid:     int(st.Uid),
+			Gid:     int(st.Gid),
+			ModTime: time.Unix(0, st.ModTime),
+		}
+		switch {
+		case mode.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case mode&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = st.Linkname
+		case mode.IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = st.Size_
+		default:
+			// Devices, sockets and pipes have no place in a build context.
+			continue
+		}
+		var content []byte
+		if hdr.Typeflag == tar.TypeReg {
+			content, err = ref.ReadFile(ctx, gwclient.ReadRequest{Filename: name})
+			if err != nil {
+				return errors.Wrapf(err, "read file %s", name)
+			}
+			hdr.Size = int64(len(content))
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return errors.Wrapf(err, "write tar header for %s", name)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			_, err = tw.Write(content)
+			if err != nil {
+				return errors.Wrapf(err, "write tar content for %s", name)
+			}
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			err = exportRefDir(ctx, ref, tw, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
+)
+
+// simulateContextCopy returns a solveFiles func which applies the copy of the build context
+// subdirectory, when the definition contains one.
+func simulateContextCopy(t *testing.T, repoFiles map[string]string) func(def *pb.Definition) map[string]string {
+	return func(def *pb.Definition) map[string]string {
+		for _, dt := range def.Def {
+			var op pb.Op
+			NoError(t, op.Unmarshal(dt), "unmarshal op")
+			file := op.GetFile()
+			if file == nil {
+				continue
+			}
+			for _, action := range file.Actions {
+				cp := action.GetCopy()
+				if cp == nil {
+					continue
+				}
+				prefix := strings.TrimPrefix(cp.Src, "/") + "/"
+				copied := make(map[string]string)
+				for name, content := range repoFiles {
+					if !strings.HasPrefix(name, prefix) {
+						continue
+					}
+					rel := strings.TrimPrefix(name, prefix)
+					if len(cp.ExcludePatterns) > 0 && (strings.HasPrefix(rel, ".git/") || strings.Contains(rel, "/.git/")) {
+						continue
+					}
+					copied[rel] = content
+				}
+				return copied
+			}
+		}
+		return repoFiles
+	}
+}
+
+func TestExportContext(t *testing.T) {
+	repoFiles := map[string]string{
+		"sub/Earthfile":          "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"sub/main.go":            "package main\n",
+		"sub/pkg/lib.go":         "package pkg\n",
+		"sub/vendor/x/.git/HEAD": "ref: refs/heads/main\n",
+		"sub/vendor/x/x.go":      "package x\n",
+		"other/README.md":        "other\n",
+	}
+	for k, v := range testGitMetaFiles {
+		repoFiles[k] = v
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	for _, exclude := range []bool{false, true} {
+		gwClient := newTestGwClient(nil)
+		gwClient.solveFiles = simulateContextCopy(t, repoFiles)
+		r := newTestResolver(t, ResolverOpt{ExcludeGitDirs: exclude})
+		var buf bytes.Buffer
+		err = r.ExportContext(context.Background(), gwClient, newTestPlatformResolver(), ref, &buf)
+		NoError(t, err, "ExportContext failed")
+
+		var names []string
+		contents := make(map[string]string)
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			NoError(t, err)
+			names = append(names, hdr.Name)
+			if hdr.Typeflag == tar.TypeReg {
+				dt, err := io.ReadAll(tr)
+				NoError(t, err)
+				contents[hdr.Name] = string(dt)
+			}
+		}
+		sort.Strings(names)
+		expected := []string{"Earthfile", "main.go", "pkg/", "pkg/lib.go", "vendor/", "vendor/x/", "vendor/x/x.go"}
+		if !exclude {
+			expected = append(expected, "vendor/x/.git/", "vendor/x/.git/HEAD")
+		}
+		sort.Strings(expected)
+		Equal(t, expected, names)
+		Equal(t, "package pkg\n", contents["pkg/lib.go"])
+	}
+
+	cmdRef, err := domain.ParseCommand("github.com/earthly/test/sub:main+CMD")
+	NoError(t, err)
+	err = newTestResolver(t, ResolverOpt{}).ExportContext(context.Background(), newTestGwClient(nil), newTestPlatformResolver(), cmdRef, io.Discard)
+	Error(t, err)
+}
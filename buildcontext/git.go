@@ -3,12 +3,13 @@ package buildcontext
 import (
 	"context"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/earthly/earthly/analytics"
+	"github.com/alessio/shellescape"
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
@@ -18,26 +19,204 @@ import (
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/earthly/earthly/util/llbutil/llbfactory"
 	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/llbutil/secretprovider"
 	"github.com/earthly/earthly/util/platutil"
 	"github.com/earthly/earthly/util/stringutil"
 	"github.com/earthly/earthly/util/syncutil/synccache"
 
 	"github.com/moby/buildkit/client/llb"
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
 const (
 	defaultGitImage = "alpine/git:v2.30.1"
+	// defaultGitLFSImage is the image git LFS objects are fetched with, by default (as opposed to
+	// defaultGitImage, it comes with git-lfs).
+	defaultGitLFSImage = "alpine/git:v2.40.1"
+
+	// defaultGitSrcPath is where the git meta run mounts the clone, by default.
+	defaultGitSrcPath = "/git-src"
+	// defaultGitDestPath is where the git meta run writes the metadata files, by default.
+	defaultGitDestPath = "/dest"
 )
 
+// gitMetaScript returns the shell script which extracts the metadata of the commit checked out in the
+// working directory, into files under destPath. When signingKey is set, the key the commit is signed
+// with is extracted as well, and when submoduleStatus is set, the commits the submodules are pinned
+// to. Only the superproject is inspected: the submodules, whether initialized or not, and whatever
+// commit their checkouts are detached at, have no bearing on the metadata. maxBodyBytes, if set,
+// caps the commit message body.
+func gitMetaScript(destPath string, signingKey, submoduleStatus, rootCommit bool, maxBodyBytes int) string {
+	dest := func(name string) string {
+		return shellescape.Quote(path.Join(destPath, name))
+	}
+	var signing string
+	if signingKey {
+		// The fingerprint (%GF) is only known when the key is in the keyring of the verifier, while
+		// the key ID (%GK) is known as soon as the commit is signed.
+		signing = fmt.Sprintf("git log -1 --format=%%GF%%n%%GK >%s 2>/dev/null || touch %s ; ", dest(gitSigningKeyFile), dest(gitSigningKeyFile))
+	}
+	var submodules string
+	if submoduleStatus {
+		// --cached reports the commits recorded by the superproject, rather than those checked out.
+		submodules = fmt.Sprintf("git submodule status --cached >%s 2>/dev/null || touch %s ; ", dest(gitSubmoduleStatusFile), dest(gitSubmoduleStatusFile))
+	}
+	var root string
+	if rootCommit {
+		root = gitRootCommitCommand(dest(gitRootCommitFile))
+	}
+	// git-unborn holds the branch HEAD points to when it has no commits yet, and is empty otherwise.
+	// git-refs then holds any ref of the repository, and is empty when the repository is.
+	return fmt.Sprintf("if git rev-parse --verify --quiet HEAD >/dev/null ; then touch %s ; else git symbolic-ref --short -q HEAD >%s || touch %s ; fi ; ", dest("git-unborn"), dest("git-unborn"), dest("git-unborn")) +
+		fmt.Sprintf("git for-each-ref --count=1 --format='%%(refname)' >%s || touch %s ; ", dest("git-refs"), dest("git-refs")) +
+		fmt.Sprintf("git rev-parse HEAD >%s ; ", dest("git-hash")) +
+		fmt.Sprintf("git rev-parse --short=%d HEAD >%s ; ", gitShortHashLength, dest("git-short-hash")) +
+		fmt.Sprintf("git rev-parse --abbrev-ref HEAD >%s  || touch %s ; ", dest("git-branch"), dest("git-branch")) +
+		fmt.Sprintf("git branch --show-current >%s 2>/dev/null || touch %s ; ", dest("git-branch-current"), dest("git-branch-current")) +
+		fmt.Sprintf("git version >%s || touch %s ; ", dest("git-version"), dest("git-version")) +
+		fmt.Sprintf("git describe --exact-match --tags >%s || touch %s ; ", dest("git-tags"), dest("git-tags")) +
+		fmt.Sprintf("git describe --tags >%s 2>/dev/null || touch %s ; ", dest(gitDescribeFile), dest(gitDescribeFile)) +
+		fmt.Sprintf("git log -1 --format=%%ct >%s || touch %s ; ", dest("git-ts"), dest("git-ts")) +
+		fmt.Sprintf("git log -1 --format=%%ae >%s || touch %s ; ", dest("git-author"), dest("git-author")) +
+		gitBodyCommand(dest("git-body"), "", maxBodyBytes) +
+		fmt.Sprintf("git rev-parse 'HEAD^{tree}' >%s || touch %s ; ", dest("git-tree"), dest("git-tree")) +
+		fmt.Sprintf("git ls-tree -r -d -z HEAD >%s || touch %s ; ", dest("git-trees"), dest("git-trees")) +
+		gitParentsCommand(dest(gitParentsFile)) +
+		signing +
+		submodules +
+		root
+}
+
+// parseGitTrees parses the output of `git ls-tree -r -d -z` into the hashes of the trees, keyed by
+// their path.
+func parseGitTrees(out string) map[string]string {
+	trees := make(map[string]string)
+	for _, entry := range strings.Split(out, "\x00") {
+		// <mode> SP <type> SP <hash> TAB <path>
+		meta, p, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "tree" {
+			continue
+		}
+		trees[p] = fields[2]
+	}
+	return trees
+}
+
+// validateGitMetaPaths checks that the paths used by the git meta run are absolute and that neither
+// contains the other.
+func validateGitMetaPaths(srcPath, destPath string) error {
+	for _, p := range []string{srcPath, destPath} {
+		if !path.IsAbs(p) {
+			return errors.Errorf("git meta path %s is not absolute", p)
+		}
+		if path.Clean(p) == "/" {
+			return errors.Errorf("git meta path %s cannot be the root directory", p)
+		}
+	}
+	src := path.Clean(srcPath)
+	dest := path.Clean(destPath)
+	if src == dest || strings.HasPrefix(src, dest+"/") || strings.HasPrefix(dest, src+"/") {
+		return errors.Errorf("git meta paths %s and %s overlap", srcPath, destPath)
+	}
+	return nil
+}
+
+// gitDirExcludePatterns match .git directories at any depth.
+var gitDirExcludePatterns = []string{".git", "**/.git"}
+
 type gitResolver struct {
 	cleanCollection *cleanup.Collection
+	sessionID       string
 
-	projectCache   *synccache.SyncCache // "gitURL#gitRef" -> *resolvedGitProject
-	buildFileCache *synccache.SyncCache // project ref -> local path
+	projectCache   Cache          // "[namespace|]gitURL#gitRef" -> *resolvedGitProject
+	secondaryKeys  *secondaryKeys // branch and tag keys of projectCache
+	repoKeys       *repoKeys      // keys of projectCache and buildFileCache, by repo
+	buildFileCache Cache          // "[namespace|]project ref" -> local path
+	recordCache    Cache          // "[namespace|]hostkey#host#" -> fingerprints, "[namespace|]tag#gitURL#tag" -> commit
+	cacheNamespace string
 	gitLookup      *GitLookup
 	console        conslogging.ConsoleLogger
+
+	skipMeta            bool
+	gitMirror           GitMirrorOpt
+	excludeGitDirs      bool
+	gitSrcPath          string
+	gitDestPath         string
+	gitImage            GitImageOpt
+	gitImageCache       *synccache.SyncCache // image -> pinned image
+	onGitMetaStats      func(GitMetaStats)
+	attachGitLabels     bool
+	preserveGitRefOrder bool
+	tarExporter         TarExporter
+	normalizeCacheHosts bool
+	lfs                 LFSOpt
+	remoteIgnoreFiles   bool
+	tagChecks           TagChecksOpt
+	buildFileFS         BuildFileFS
+	minEarthfileVersion string
+
+	gitCommandLogLevel conslogging.LogLevel
+
+	metadataTransform   func(*gitutil.GitMetadata) error
+	coAuthorTrailerKeys []string
+	maxCommitBodyBytes  int
+	protectedBranch     string
+	maxResolveDuration  time.Duration
+
+	analytics *gitAnalytics
+	tracer    Tracer
+
+	defaultBranch           DefaultBranchOpt
+	lazy                    bool
+	gitExtraHosts           map[string]string        // host -> IP
+	buildFileDigests        map[string]digest.Digest // project ref -> build file digest
+	gitTransfer             GitTransferOpt
+	searchParentBuildFiles  bool
+	buildFileLocations      []string
+	readSubmodules          bool
+	initSubmodules          bool
+	preserveLineEndings     bool
+	readSigningKey          bool
+	readRootCommit          bool
+	primaryRefPolicy        PrimaryRefPolicy
+	gitObjectStores         map[string]string // clone url -> upstream
+	futureTimestampPolicy   FutureTimestampPolicy
+	futureTsTolerance       time.Duration
+	maxGitRefs              int
+	refAdvertiseTimeout     time.Duration
+	credentialExpiryMargin  time.Duration
+	verifyLockfile          bool
+	cacheTTLFunc            func(domain.Reference) time.Duration
+	readGitConfig           bool
+	strictShortHash         bool
+	buildFileNames          []string
+	maxDeepenDepth          int
+	cacheKeyHash            func(string) string
+	onWarning               func(Warning)
+	transcodeCommitEncoding bool
+	gitMetaCache            GitMetaCacheStrategy
+	gitCloneVerbosity       GitCloneVerbosity
+	gitCloneDepth           int
+	gitCloneDepths          map[string]int // host or repo -> depth
+	pinHostKeys             bool
+	repoSparsePatterns      bool
+	forbidLegacyBuildFile   bool
+	detectCaseCollisions    bool
+	strictCaseCollisions    bool
+	computeContextDigest    bool
+	snapshotRefs            map[string]SnapshotRef // project ref -> snapshot, nil when not in use
+	snapshotCache           *synccache.SyncCache   // "context#" or "buildfile#" project ref -> dir or *buildFile
+	scheduler               *gitScheduler
+
+	gitTLS              GitTLSOpt
+	internalSecretStore *secretprovider.MutableMapStore
+	gitTLSCache         *synccache.SyncCache // secret ID -> nil, once stored
 }
 
 type resolvedGitProject struct {
@@ -53,73 +232,369 @@ type resolvedGitProject struct {
 	ts        string
 	author    string
 	coAuthors []string
+	// coAuthorIdentities are the co-authors split into their name and email.
+	coAuthorIdentities []gitutil.CoAuthor
+	// signingKeyFingerprint and signingKeyID identify the key the commit is signed with, if read.
+	signingKeyFingerprint string
+	signingKeyID          string
+	// tagKinds are the object types of the tags pointing at the commit (tag for annotated tags,
+	// commit for lightweight ones), keyed by their name, when read.
+	tagKinds map[string]string
+	// treeHashes are the tree hashes of every directory of the commit, keyed by their path
+	// relative to the root of the repository ("." being the root).
+	treeHashes map[string]string
+	// parents are the parent commits of the commit, the first parent first.
+	parents []string
+	// describe is the output of git describe --tags for the commit.
+	describe string
+	// encoding is the encoding declared in the commit, when read.
+	encoding string
+	// gitURL is the url the project has been cloned from, and keyScans the ssh keyscans it needs.
+	gitURL   string
+	keyScans []string
 	// state is the state holding the git files.
 	state pllb.State
+	// submodules are the submodules of the project, read upon first use.
+	submodulesMu sync.Mutex
+	submodules   []gitutil.Submodule
+	// submoduleCommits are the commits the submodules are pinned to, keyed by their path, when
+	// read.
+	submoduleCommits map[string]string
+	// rootCommit is the root commit of the history of the commit, when read and available.
+	rootCommit string
+	// gitConfig is the git configuration in effect in the git meta run, when read.
+	gitConfig []GitConfigEntry
+	// lfsPointers are the paths of the git LFS pointer files of the project, sorted, when detected.
+	// lfsWarned holds the subdirectories they have been warned about.
+	lfsPointers []string
+	lfsWarnedMu sync.Mutex
+	lfsWarned   map[string]bool
+	// futureTsWarnOnce guards the warning about the commit being dated in the future.
+	futureTsWarnOnce sync.Once
+	// resolvedAt is when the project was resolved, for its cache entries to expire.
+	resolvedAt time.Time
+	// locked is set for projects imported from a lockfile. lockVerified is set once their commit
+	// has been verified, lockErr holding the outcome.
+	locked       bool
+	lockMu       sync.Mutex
+	lockVerified bool
+	lockErr      error
 }
 
-func (gr *gitResolver) resolveEarthProject(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*Data, error) {
+func (gr *gitResolver) resolveEarthProject(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, contextPlatform platutil.Platform, featureFlagOverrides string) (*Data, error) {
 	if !ref.IsRemote() {
 		return nil, errors.Errorf("unexpected local reference %s", ref.String())
 	}
+	if gr.snapshotRefs != nil {
+		return gr.resolveSnapshotProject(ctx, platr, ref, featureFlagOverrides)
+	}
+	ctx, span := gr.tracer.Start(ctx, spanResolveEarthProject)
+	defer span.End()
+	span.SetAttribute(spanAttrRef, ref.GetTag())
 	rgp, gitURL, subDir, err := gr.resolveGitProject(ctx, gwClient, platr, ref)
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttribute(spanAttrURL, stringutil.ScrubCredentials(gitURL))
+	span.SetAttribute(spanAttrHash, rgp.hash)
+	err = checkSubDir(rgp, ref.ProjectCanonical(), subDir)
+	if err != nil {
+		return nil, err
+	}
 
-	var buildContextFactory llbfactory.Factory
-	if _, isTarget := ref.(domain.Target); isTarget {
-		// Restrict the resulting build context to the right subdir.
-		if subDir == "." {
-			// Optimization.
-			buildContextFactory = llbfactory.PreconstructedState(rgp.state)
-		} else {
-			vm := &outmon.VertexMeta{
-				TargetName: ref.String(),
-				Internal:   true,
-			}
-			copyState, err := llbutil.CopyOp(ctx,
-				rgp.state, []string{subDir}, platr.Scratch(), "./", false, false, false, "root:root", nil, false, false, false,
-				llb.WithCustomNamef("%sCOPY git context %s", vm.ToVertexPrefix(), ref.String()))
-			if err != nil {
-				return nil, errors.Wrap(err, "copyOp failed in resolveEarthProject")
-			}
-			buildContextFactory = llbfactory.PreconstructedState(copyState)
+	localBuildFile, err := gr.resolveBuildFile(ctx, gwClient, platr, ref, rgp, gitURL, rgp.state, subDir, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	err = gr.verifyBuildFileDigest(ref, localBuildFile.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var buildContextState pllb.State
+	var ctxDigest digest.Digest
+	var excludes []ExcludePattern
+	_, isTarget := ref.(domain.Target)
+	if isTarget {
+		excludes = gr.contextExcludePatterns(localBuildFile, subDir)
+		buildContextState, err = gr.buildContextState(ctx, gwClient, platr, ref, rgp, subDir, contextPlatform, patternsOf(excludes))
+		if err != nil {
+			return nil, err
+		}
+		err = gr.checkLFSPointers(rgp, ref, subDir)
+		if err != nil {
+			return nil, err
+		}
+		if gr.computeContextDigest && !gr.skipMeta {
+			ctxDigest = contextDigest(rgp.hash, subDir, rgp.treeHashes[path.Clean(subDir)], patternsOf(excludes))
 		}
 	}
 	// Else not needed: Commands don't come with a build context.
 
-	key := ref.ProjectCanonical()
+	gitMeta, err := gr.gitMetadata(ctx, gwClient, platr, ref, rgp, gitURL, subDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buildContextFactory llbfactory.Factory
+	if isTarget {
+		if gr.attachGitLabels {
+			buildContextState = buildContextState.WithValue(gitLabelsKey{}, GitLabels(gitMeta))
+		}
+		buildContextFactory = llbfactory.PreconstructedState(buildContextState)
+	}
+	return &Data{
+		BuildFilePath:       localBuildFile.path,
+		BuildFileLocation:   localBuildFile.location,
+		BuildContextFactory: buildContextFactory,
+		GitMetadata:         gitMeta,
+		Features:            localBuildFile.ftrs,
+		ContextDigest:       ctxDigest,
+		ExcludePatterns:     excludes,
+		GitConfig:           rgp.gitConfig,
+	}, nil
+}
+
+// buildContextState returns the build context of a remote target, out of its resolved project,
+// without the paths matching the given exclude patterns.
+func (gr *gitResolver) buildContextState(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, rgp *resolvedGitProject, subDir string, contextPlatform platutil.Platform, excludes []string) (pllb.State, error) {
+	state, err := gr.contextState(ctx, gwClient, platr, ref, rgp)
+	if err != nil {
+		return pllb.State{}, err
+	}
+	// Restrict the resulting build context to the right subdir.
+	if subDir == "." && len(excludes) == 0 {
+		// Optimization.
+		return state, nil
+	}
+	vm := &outmon.VertexMeta{
+		TargetName: ref.String(),
+		Internal:   true,
+	}
+	copyName := llb.WithCustomNamef("%sCOPY git context %s", vm.ToVertexPrefix(), ref.String())
+	copyBase := platr.Scratch()
+	if contextPlatform != platutil.DefaultPlatform {
+		copyBase = pllb.Scratch().Platform(platr.ToLLBPlatform(contextPlatform))
+	}
+	if len(excludes) > 0 {
+		return llbutil.CopyDirContentsOp(
+			state, subDir, copyBase, "./", "root:root", excludes, copyName), nil
+	}
+	copyState, err := llbutil.CopyOp(ctx,
+		state, []string{subDir}, copyBase, "./", false, false, false, "root:root", nil, false, false, false,
+		copyName)
+	if err != nil {
+		return pllb.State{}, errors.Wrap(err, "copyOp failed in resolveEarthProject")
+	}
+	return copyState, nil
+}
+
+// contextExcludePatterns returns the exclude patterns applied to the build context of a remote
+// target living in the given subdirectory of its repository, out of its build file.
+func (gr *gitResolver) contextExcludePatterns(bf *buildFile, subDir string) []ExcludePattern {
+	return append(append([]ExcludePattern(nil), bf.excludes...), excludePatterns(gr.contextExcludes(subDir), ExcludeSourceGitDirs)...)
+}
+
+// contextExcludes returns the exclude patterns applied to the build context of remote targets
+// living in the given subdirectory of their repository.
+func (gr *gitResolver) contextExcludes(subDir string) []string {
+	if subDir == "." || !gr.excludeGitDirs {
+		return nil
+	}
+	// Submodules come with their own .git, which would otherwise bloat the context.
+	return gitDirExcludePatterns
+}
+
+// gitMetadata returns the git metadata of a remote reference, out of its resolved project.
+func (gr *gitResolver) gitMetadata(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, rgp *resolvedGitProject, gitURL, subDir string) (*gitutil.GitMetadata, error) {
+	gitMeta := &gitutil.GitMetadata{
+		BaseDir:     "",
+		RelDir:      subDir,
+		RemoteURL:   gitURL,
+		Hash:        rgp.hash,
+		ShortHash:   rgp.shortHash,
+		Branch:      rgp.branches,
+		Tags:        rgp.tags,
+		Timestamp:   rgp.ts,
+		Author:      rgp.author,
+		CoAuthors:   rgp.coAuthors,
+		SubtreeHash: rgp.treeHashes[path.Clean(subDir)],
+		Unpopulated: gr.skipMeta,
+
+		ParentHashes: rgp.parents,
+		IsMerge:      len(rgp.parents) > 1,
+		Describe:     rgp.describe,
+		Encoding:     rgp.encoding,
+
+		SigningKeyFingerprint: rgp.signingKeyFingerprint,
+		SigningKeyID:          rgp.signingKeyID,
+		CoAuthorIdentities:    rgp.coAuthorIdentities,
+	}
+	gr.checkFutureTimestamp(ref, rgp, gitMeta)
+	if gr.readRootCommit && !gr.skipMeta {
+		gitMeta.RootCommit = rgp.rootCommit
+		gitMeta.RootCommitUnavailable = rgp.rootCommit == ""
+	}
+	if _, ctxCreds := gitCredentialsFromContext(ctx); ctxCreds {
+		gitMeta.RemoteURL = stripGitURLCredentials(gitURL)
+	}
+	if gr.readSubmodules && !gr.skipMeta {
+		submodules, err := gr.submodules(ctx, gwClient, platr, rgp)
+		if err != nil {
+			return nil, err
+		}
+		gitMeta.Submodules = withSubmoduleCommits(submodules, rgp.submoduleCommits)
+	}
+	if gr.metadataTransform != nil {
+		// The slices are shared with the project cache.
+		gitMeta.Branch = append([]string(nil), gitMeta.Branch...)
+		gitMeta.Tags = append([]string(nil), gitMeta.Tags...)
+		gitMeta.CoAuthors = append([]string(nil), gitMeta.CoAuthors...)
+		gitMeta.CoAuthorIdentities = append([]gitutil.CoAuthor(nil), gitMeta.CoAuthorIdentities...)
+		gitMeta.Submodules = append([]gitutil.Submodule(nil), gitMeta.Submodules...)
+		err := gr.metadataTransform(gitMeta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "transform git metadata of %s", ref.String())
+		}
+	}
+	return gitMeta, nil
+}
+
+func (gr *gitResolver) resolveFeatures(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*features.Features, error) {
+	if !ref.IsRemote() {
+		return nil, errors.Errorf("unexpected local reference %s", ref.String())
+	}
+	if gr.snapshotRefs != nil {
+		d, err := gr.resolveSnapshotProject(ctx, platr, ref, featureFlagOverrides)
+		if err != nil {
+			return nil, err
+		}
+		return d.Features, nil
+	}
+	bf, err := gr.resolveRefBuildFile(ctx, gwClient, platr, ref, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	return bf.ftrs, nil
+}
+
+// resolveRefBuildFile resolves the build file of a remote reference, without resolving the project
+// (and thus its git metadata) when possible.
+func (gr *gitResolver) resolveRefBuildFile(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*buildFile, error) {
+	err := checkGitRefLength(ref)
+	if err != nil {
+		return nil, err
+	}
+	candidates, subDir, err := gr.gitLookup.getCloneURLs(ref.GetGitURL())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get url for cloning")
+	}
+	candidates, _ = withContextCredentials(ctx, candidates)
+	err = gr.checkHostKeys(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) > 1 {
+		// Finding out which of the urls can be cloned takes resolving the project.
+		rgp, gitURL, _, err := gr.resolveGitProject(ctx, gwClient, platr, ref)
+		if err != nil {
+			return nil, err
+		}
+		err = checkSubDir(rgp, ref.ProjectCanonical(), subDir)
+		if err != nil {
+			return nil, err
+		}
+		return gr.resolveBuildFile(ctx, gwClient, platr, ref, rgp, gitURL, rgp.state, subDir, featureFlagOverrides)
+	}
+	gitURL, keyScans := candidates[0].gitURL, candidates[0].keyScans
+	// The build file is read straight out of the requested ref. Unlike resolveGitProject, there is
+	// no need for the git meta step, as the state is never used as a build context.
+	gitOpts := []llb.GitOption{
+		llb.WithCustomNamef("[context %s] git build file %s", stringutil.ScrubCredentials(gitURL), ref.StringCanonical()),
+	}
+	if len(keyScans) > 0 {
+		gitOpts = append(gitOpts, llb.KnownSSHHosts(strings.Join(keyScans, "\n")))
+	}
+	gitState := pllb.Git(gitURL, ref.GetTag(), gitOpts...)
+	if gr.cloneInGitImage(ref) {
+		vm := &outmon.VertexMeta{
+			TargetName: ref.ProjectCanonical(),
+			Internal:   true,
+		}
+		_, gitState, err = gr.execGitMeta(ctx, gwClient, gitURL, ref.GetTag(), keyScans, platr, vm, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return gr.resolveBuildFile(ctx, gwClient, platr, ref, nil, gitURL, gitState, subDir, featureFlagOverrides)
+}
+
+// resolveBuildFile reads the build file of the given ref out of the git state (cloned from gitURL)
+// and parses its features. The result is cached per project. rgp is the project the ref has been
+// resolved to, if it has.
+func (gr *gitResolver) resolveBuildFile(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, rgp *resolvedGitProject, gitURL string, state pllb.State, subDir string, featureFlagOverrides string) (*buildFile, error) {
+	key := gr.namespacedKey(gr.cacheKeyProject(ref.ProjectCanonical()))
 	isDockerfile := strings.HasPrefix(ref.GetName(), DockerfileMetaTarget)
 	if isDockerfile {
 		// Different key for dockerfiles to include the dockerfile name itself.
-		key = ref.StringCanonical()
+		key = gr.namespacedKey(gr.cacheKeyProject(ref.StringCanonical()))
 	}
-	localBuildFileValue, err := gr.buildFileCache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
-		earthfileTmpDir, err := os.MkdirTemp(os.TempDir(), "earthly-git")
+	buildFileCache := gr.buildFileCache
+	if _, ctxCreds := gitCredentialsFromContext(ctx); ctxCreds {
+		buildFileCache = synccache.New()
+	} else {
+		gr.repoKeys.addBuildFile(gr.cacheKeyURL(gitURL), key)
+	}
+	cacheHit := true
+	bfValue, err := buildFileCache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		cacheHit = false
+		earthfileTmpDir, err := gr.buildFileFS.MkdirTemp("earthly-git")
 		if err != nil {
 			return nil, errors.Wrap(err, "create temp dir for Earthfile")
 		}
 		gr.cleanCollection.Add(func() error {
-			return os.RemoveAll(earthfileTmpDir)
+			return gr.buildFileFS.RemoveAll(earthfileTmpDir)
 		})
+		nativePlatr := platr.SubResolver(platutil.NativePlatform)
 		gitState, err := llbutil.StateToRef(
-			ctx, gwClient, rgp.state, false,
-			platr.SubResolver(platutil.NativePlatform), nil)
+			ctx, gwClient, state, false,
+			nativePlatr, nil)
+		if err != nil {
+			return nil, classifyGitError(errors.Wrap(err, "state to ref git meta"))
+		}
+		gitState = gr.withReadFallback(gitState, state, nativePlatr)
+		bf, found, err := detectBuildFileInRef(ctx, ref, gitState, subDir, gr.buildFileLocations, gr.searchParentBuildFiles, gr.buildFileNames)
 		if err != nil {
-			return nil, errors.Wrap(err, "state to ref git meta")
+			return nil, err
 		}
-		bf, err := detectBuildFileInRef(ctx, ref, gitState, subDir)
+		if len(found) > 1 {
+			gr.warn(ref, WarningMultipleBuildFiles, "%s", multipleBuildFilesWarning(ref.ProjectCanonical(), found))
+		}
+		err = gr.checkCaseCollisions(ctx, ref, gitState, bf)
 		if err != nil {
 			return nil, err
 		}
+		if path.Base(bf) == legacyBuildFileName && !isDockerfile {
+			legacyErr := ErrLegacyBuildFile{
+				Path: bf,
+				Ref:  ref.ProjectCanonical(),
+			}
+			if gr.forbidLegacyBuildFile {
+				return nil, legacyErr
+			}
+			gr.warn(ref, WarningLegacyBuildFile, "%s", legacyErr.Error())
+		}
 		bfBytes, err := gitState.ReadFile(ctx, gwclient.ReadRequest{
 			Filename: bf,
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "read build file")
+			return nil, newBuildFileReadError(ref, bf, err)
+		}
+		if !isDockerfile && !gr.preserveLineEndings {
+			bfBytes = normalizeLineEndings(bfBytes)
 		}
 		localBuildFilePath := filepath.Join(earthfileTmpDir, path.Base(bf))
-		err = os.WriteFile(localBuildFilePath, bfBytes, 0700)
+		err = gr.buildFileFS.WriteFile(localBuildFilePath, bfBytes, 0700)
 		if err != nil {
 			return nil, errors.Wrapf(err, "write build file to tmp dir at %s", localBuildFilePath)
 		}
@@ -127,202 +602,412 @@ func (gr *gitResolver) resolveEarthProject(ctx context.Context, gwClient gwclien
 		if isDockerfile {
 			ftrs = new(features.Features)
 		} else {
-			ftrs, err = parseFeatures(localBuildFilePath, featureFlagOverrides, ref.ProjectCanonical(), gr.console)
+			ftrs, err = parseFeatures(gr.buildFileFS, localBuildFilePath, featureFlagOverrides, ref.ProjectCanonical(), gr.console)
+			if err != nil {
+				return nil, err
+			}
+			err = gr.checkMinEarthfileVersion(ref.ProjectCanonical(), ftrs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var excludes []ExcludePattern
+		if gr.remoteIgnoreFiles {
+			excludes, err = readRefExcludePatterns(ctx, gitState, subDir, ftrs.NoImplicitIgnore)
 			if err != nil {
 				return nil, err
 			}
 		}
 		return &buildFile{
-			path: localBuildFilePath,
-			ftrs: ftrs,
+			path:     localBuildFilePath,
+			location: bf,
+			ftrs:     ftrs,
+			excludes: excludes,
+			cachedAt: time.Now(),
 		}, nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	localBuildFile := localBuildFileValue.(*buildFile)
-
-	// TODO: Apply excludes / .earthignore.
-	return &Data{
-		BuildFilePath:       localBuildFile.path,
-		BuildContextFactory: buildContextFactory,
-		GitMetadata: &gitutil.GitMetadata{
-			BaseDir:   "",
-			RelDir:    subDir,
-			RemoteURL: gitURL,
-			Hash:      rgp.hash,
-			ShortHash: rgp.shortHash,
-			Branch:    rgp.branches,
-			Tags:      rgp.tags,
-			Timestamp: rgp.ts,
-			Author:    rgp.author,
-			CoAuthors: rgp.coAuthors,
-		},
-		Features: localBuildFile.ftrs,
-	}, nil
+	bf := bfValue.(*buildFile)
+	if cacheHit && gr.cacheExpired(ref, rgp, bf.cachedAt) {
+		buildFileCache.Delete(key)
+		return gr.resolveBuildFile(ctx, gwClient, platr, ref, rgp, gitURL, state, subDir, featureFlagOverrides)
+	}
+	return bf, nil
 }
 
-func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference) (rgp *resolvedGitProject, gitURL string, subDir string, finalErr error) {
+// resolveGitProject resolves the project of a remote reference, returning it along with the url it
+// is cloned from and the subdirectory of the reference within it. The results are not named, as the
+// construction of the project may outlive this call (when ctx is done), and must not race with them.
+func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference) (*resolvedGitProject, string, string, error) {
 	gitRef := ref.GetTag()
+	ctx, span := gr.tracer.Start(ctx, spanResolveGitProject)
+	defer span.End()
+	span.SetAttribute(spanAttrRef, gitRef)
 
-	var err error
-	var keyScans []string
-	gitURL, subDir, keyScans, err = gr.gitLookup.GetCloneURL(ref.GetGitURL())
+	err := validateGitMetaPaths(gr.gitSrcPath, gr.gitDestPath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	err = checkGitRefLength(ref)
+	if err != nil {
+		return nil, "", "", err
+	}
+	candidates, subDir, err := gr.gitLookup.getCloneURLs(ref.GetGitURL())
 	if err != nil {
 		return nil, "", "", errors.Wrap(err, "failed to get url for cloning")
 	}
-	analytics.Count("gitResolver.resolveEarthProject", analytics.RepoHashFromCloneURL(gitURL))
+	// The project is cached under the preferred url, whichever one it ends up being cloned from.
+	gitURL := candidates[0].gitURL
+	span.SetAttribute(spanAttrURL, stringutil.ScrubCredentials(gitURL))
+	gr.analytics.resolveStarted(gitURL)
+	start := time.Now()
+	projectCache := gr.projectCache
+	candidates, ctxCreds := withContextCredentials(ctx, candidates)
+	err = gr.checkHostKeys(ctx, candidates)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if ctxCreds {
+		// Neither the context credentials nor what they grant access to outlive the resolution.
+		projectCache = synccache.New()
+	}
 
 	// Check the cache first.
-	cacheKey := fmt.Sprintf("%s#%s", gitURL, gitRef)
-	rgpValue, err := gr.projectCache.Do(ctx, cacheKey, func(ctx context.Context, k interface{}) (interface{}, error) {
-		// Copy all Earthfile, build.earth and Dockerfile files.
-		vm := &outmon.VertexMeta{
-			TargetName: cacheKey,
-			Internal:   true,
-		}
-		gitOpts := []llb.GitOption{
-			llb.WithCustomNamef("%sGIT CLONE %s", vm.ToVertexPrefix(), stringutil.ScrubCredentials(gitURL)),
-			llb.KeepGitDir(),
-		}
-		if len(keyScans) > 0 {
-			gitOpts = append(gitOpts, llb.KnownSSHHosts(strings.Join(keyScans, "\n")))
-		}
-
-		gitState := llb.Git(gitURL, gitRef, gitOpts...)
-		opImg := pllb.Image(
-			defaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
-			llb.Platform(platr.LLBNative()))
-
-		// Get git hash.
-		gitHashOpts := []llb.RunOption{
-			llb.Args([]string{
-				"/bin/sh", "-c",
-				"git rev-parse HEAD >/dest/git-hash ; " +
-					"git rev-parse --short=8 HEAD >/dest/git-short-hash ; " +
-					"git rev-parse --abbrev-ref HEAD >/dest/git-branch  || touch /dest/git-branch ; " +
-					"git describe --exact-match --tags >/dest/git-tags || touch /dest/git-tags ; " +
-					"git log -1 --format=%ct >/dest/git-ts || touch /dest/git-ts ; " +
-					"git log -1 --format=%ae >/dest/git-author || touch /dest/git-author ; " +
-					"git log -1 --format=%b >/dest/git-body || touch /dest/git-body ; " +
-					"",
-			}),
-			llb.Dir("/git-src"),
-			llb.ReadonlyRootFS(),
-			llb.AddMount("/git-src", gitState, llb.Readonly),
-			llb.WithCustomNamef("%sGET GIT META %s", vm.ToVertexPrefix(), ref.ProjectCanonical()),
-		}
-		gitHashOp := opImg.Run(gitHashOpts...)
-		gitMetaState := gitHashOp.AddMount("/dest", platr.Scratch())
-
-		noCache := false // TODO figure out if we want to propagate --no-cache here
-		gitMetaRef, err := llbutil.StateToRef(
-			ctx, gwClient, gitMetaState, noCache,
-			platr.SubResolver(platutil.NativePlatform), nil)
+	projectKey := fmt.Sprintf("%s#%s", gitURL, gitRef)
+	keyURL := gr.cacheKeyURL(gitURL)
+	cacheKey := gr.namespacedKey(gitProjectKey(keyURL, gitRef))
+	if !ctxCreds {
+		gr.repoKeys.addProject(keyURL, cacheKey)
+	}
+	cacheHit := true
+	rgpValue, err := projectCache.Do(ctx, cacheKey, gr.withStaleHeadFallback(gwClient, platr, ref, stampResolvedAt(func(ctx context.Context, k interface{}) (interface{}, error) {
+		cacheHit = false
+		release, err := gr.scheduler.acquire(ctx, gitHost(ref.GetGitURL()))
 		if err != nil {
-			return nil, errors.Wrap(err, "state to ref git meta")
+			return nil, err
 		}
-		gitHashBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-hash",
+		defer release()
+		rgp, err := gr.constructGitProject(ctx, &gitMetaRun{
+			gwClient:   gwClient,
+			platr:      platr,
+			ref:        ref,
+			gitRef:     gitRef,
+			subDir:     subDir,
+			keyURL:     keyURL,
+			candidates: candidates,
+			ctxCreds:   ctxCreds,
+			vm: &outmon.VertexMeta{
+				TargetName: projectKey,
+				Internal:   true,
+			},
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-hash")
+			return nil, err
 		}
-		gitShortHashBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-short-hash",
-		})
+		return rgp, nil
+	})))
+	if err != nil {
+		return nil, "", "", err
+	}
+	rgp := rgpValue.(*resolvedGitProject)
+	if cacheHit && gr.cacheExpired(ref, rgp, rgp.resolvedAt) {
+		// Concurrent callers may each drop the entry, which costs an extra resolution at worst.
+		projectCache.Delete(cacheKey)
+		span.SetAttribute(spanAttrCache, "expired")
+		return gr.resolveGitProject(ctx, gwClient, platr, ref)
+	}
+	gr.secondaryKeys.touch(cacheKey)
+	err = gr.verifyLockedProject(ctx, gwClient, platr, ref, rgp)
+	if err != nil {
+		return nil, "", "", err
+	}
+	// Checked past the cache, as the project may have been cached under another ref of the commit.
+	err = gr.checkTagKind(rgp, ref.ProjectCanonical(), gitRef)
+	if err != nil {
+		return nil, "", "", err
+	}
+	gr.analytics.resolveDone(gitRef, rgp, cacheHit, time.Since(start))
+	if cacheHit {
+		span.SetAttribute(spanAttrCache, "hit")
+	} else {
+		span.SetAttribute(spanAttrCache, "miss")
+	}
+	span.SetAttribute(spanAttrHash, rgp.hash)
+	return rgp, rgp.gitURL, subDir, nil
+}
+
+// constructGitProject resolves the project of run afresh: it is cloned from the first of its
+// candidate urls which can be, the gitMetaSteps are run against the clone, its git metadata is read
+// and the gitProjectSteps are run against it.
+func (gr *gitResolver) constructGitProject(ctx context.Context, run *gitMetaRun) (*resolvedGitProject, error) {
+	if gr.skipMeta && !gr.cloneInGitImage(run.ref) && !gr.needsMetaChecks() && len(run.candidates) == 1 {
+		// No git meta step: the context is cloned straight at the requested ref.
+		clone := run.candidates[0]
+		return &resolvedGitProject{
+			gitURL:   clone.gitURL,
+			keyScans: clone.keyScans,
+			state:    pllb.Git(clone.gitURL, run.gitRef, contextGitOpts(clone.gitURL, run.ref, clone.keyScans)...),
+		}, nil
+	}
+
+	// Attempt each of the urls in turn, until one of them can be cloned.
+	stats := gr.newGitMetaStatsRecorder(run.gitRef)
+	defer stats.done()
+	for i, c := range run.candidates {
+		run.clone = c
+		gitMetaState, execState, err := gr.gitMetaState(ctx, run.gwClient, run.platr, run.ref, c, run.gitRef, run.vm)
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-short-hash")
+			return nil, err
 		}
-		gitBranchBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-branch",
-		})
+		run.execState = execState
+		if gr.skipMeta && !gr.needsMetaChecks() && len(run.candidates) == 1 {
+			return &resolvedGitProject{
+				gitURL:   c.gitURL,
+				keyScans: c.keyScans,
+				state:    execState,
+			}, nil
+		}
+
+		runStart := time.Now()
+		run.metaRef, err = gr.cloneGitMeta(ctx, run.gwClient, run.platr, c, gitMetaState)
+		if err == nil {
+			run.metaRef = stats.ran(c.gitURL, run.metaRef, time.Since(runStart))
+			break
+		}
+		if i == len(run.candidates)-1 || ctx.Err() != nil {
+			return nil, err
+		}
+		gr.warn(run.ref, WarningCloneFallback, "failed to clone %s, falling back to %s: %s",
+			stringutil.ScrubCredentials(c.gitURL), stringutil.ScrubCredentials(run.candidates[i+1].gitURL), err.Error())
+	}
+	rgp := &resolvedGitProject{
+		gitURL:   run.clone.gitURL,
+		keyScans: run.clone.keyScans,
+	}
+	if gr.skipMeta && !gr.needsMetaChecks() {
+		rgp.state = pllb.Git(run.clone.gitURL, run.gitRef, contextGitOpts(run.clone.gitURL, run.ref, run.clone.keyScans)...)
+		if gr.useGitExec(run.ref) {
+			rgp.state = run.execState
+		}
+		return rgp, nil
+	}
+	err := runGitResolveSteps(ctx, gr.gitMetaSteps(), run, rgp)
+	if err != nil {
+		return nil, err
+	}
+	if gr.skipMeta {
+		rgp.state = run.execState
+		return rgp, nil
+	}
+	err = gr.readGitProject(ctx, run, rgp)
+	if err != nil {
+		return nil, err
+	}
+	err = runGitResolveSteps(ctx, gr.gitProjectSteps(), run, rgp)
+	if err != nil {
+		return nil, err
+	}
+	return rgp, nil
+}
+
+// gitProjectMetaFiles are the git meta files read into every project whose git metadata is read.
+var gitProjectMetaFiles = []string{
+	"git-hash", "git-short-hash", "git-branch", "git-branch-current", "git-version", "git-tags",
+	gitDescribeFile, "git-ts", "git-author", "git-body", "git-tree", "git-trees", gitParentsFile,
+}
+
+// readGitProject reads the git metadata of the git meta run into the project, along with the state
+// its build context is cloned from.
+func (gr *gitResolver) readGitProject(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	meta := make(map[string]string, len(gitProjectMetaFiles))
+	for _, filename := range gitProjectMetaFiles {
+		dt, err := gr.readGitMeta(ctx, run.metaRef, filename)
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-branch")
+			return err
 		}
-		gitTagsBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-tags",
-		})
+		meta[filename] = string(dt)
+	}
+	gitAuthorBytes, gitBodyBytes := []byte(meta["git-author"]), []byte(meta["git-body"])
+	if gr.transcodeCommitEncoding {
+		gitEncodingBytes, err := gr.readGitMeta(ctx, run.metaRef, gitEncodingFile)
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-tags")
+			return err
 		}
-		gitTsBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-ts",
-		})
+		rgp.encoding = strings.TrimSpace(string(gitEncodingBytes))
+		gitAuthorBytes, gitBodyBytes = gr.transcodeCommit(run.ref, rgp.encoding, gitAuthorBytes, gitBodyBytes)
+	}
+	if gr.readSigningKey {
+		gitSigningKeyBytes, err := gr.readGitMeta(ctx, run.metaRef, gitSigningKeyFile)
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-ts")
+			return err
 		}
-		gitAuthorBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-author",
-		})
+		rgp.signingKeyFingerprint, rgp.signingKeyID = parseGitSigningKey(string(gitSigningKeyBytes))
+	}
+	if gr.readRootCommit {
+		gitRootCommitBytes, err := gr.readGitMeta(ctx, run.metaRef, gitRootCommitFile)
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-author")
+			return err
 		}
-		gitBodyBytes, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
-			Filename: "git-body",
-		})
+		rgp.rootCommit = parseGitRootCommit(string(gitRootCommitBytes))
+	}
+	if gr.readGitConfig {
+		gitConfigBytes, err := gr.readGitMeta(ctx, run.metaRef, gitConfigListFile)
 		if err != nil {
-			return nil, errors.Wrap(err, "read git-body")
-		}
-
-		gitHash := strings.SplitN(string(gitHashBytes), "\n", 2)[0]
-		gitShortHash := strings.SplitN(string(gitShortHashBytes), "\n", 2)[0]
-		gitBranches := strings.SplitN(string(gitBranchBytes), "\n", 2)
-		gitAuthor := strings.SplitN(string(gitAuthorBytes), "\n", 2)[0]
-		gitCoAuthors := gitutil.ParseCoAuthorsFromBody(string(gitBodyBytes))
-		var gitBranches2 []string
-		for _, gitBranch := range gitBranches {
-			if gitBranch != "" {
-				gitBranches2 = append(gitBranches2, gitBranch)
-			}
+			return err
 		}
-		gitTags := strings.SplitN(string(gitTagsBytes), "\n", 2)
-		var gitTags2 []string
-		for _, gitTag := range gitTags {
-			if gitTag != "" && gitTag != "HEAD" {
-				gitTags2 = append(gitTags2, gitTag)
-			}
+		rgp.gitConfig = parseGitConfigList(string(gitConfigBytes))
+	}
+	if gr.readSubmoduleStatus() {
+		gitSubmoduleStatusBytes, err := gr.readGitMeta(ctx, run.metaRef, gitSubmoduleStatusFile)
+		if err != nil {
+			return err
+		}
+		rgp.submoduleCommits = parseGitSubmoduleStatus(string(gitSubmoduleStatusBytes))
+	}
+
+	rgp.hash = strings.SplitN(meta["git-hash"], "\n", 2)[0]
+	rgp.shortHash = strings.SplitN(meta["git-short-hash"], "\n", 2)[0]
+	gitBranch := gr.detectGitBranch(meta["git-version"], meta["git-branch"], meta["git-branch-current"])
+	for _, gitBranch := range strings.Split(gitBranch, "\n") {
+		if gitBranch != "" {
+			rgp.branches = append(rgp.branches, gitBranch)
+		}
+	}
+	for _, gitTag := range strings.Split(meta["git-tags"], "\n") {
+		if gitTag != "" && gitTag != "HEAD" {
+			rgp.tags = append(rgp.tags, gitTag)
 		}
-		gitTs := strings.SplitN(string(gitTsBytes), "\n", 2)[0]
-
-		gitOpts = []llb.GitOption{
-			llb.WithCustomNamef("[context %s] git context %s", stringutil.ScrubCredentials(gitURL), ref.StringCanonical()),
-			llb.KeepGitDir(),
-		}
-		if len(keyScans) > 0 {
-			gitOpts = append(gitOpts, llb.KnownSSHHosts(strings.Join(keyScans, "\n")))
-		}
-
-		rgp := &resolvedGitProject{
-			hash:      gitHash,
-			shortHash: gitShortHash,
-			branches:  gitBranches2,
-			tags:      gitTags2,
-			ts:        gitTs,
-			author:    gitAuthor,
-			coAuthors: gitCoAuthors,
-			state: pllb.Git(
-				gitURL,
-				gitHash,
-				gitOpts...,
-			),
-		}
-		go func() {
-			// Add cache entries for the branch and for the tag (if any).
-			if len(gitBranches2) > 0 {
-				cacheKey3 := fmt.Sprintf("%s#%s", gitURL, gitBranches2[0])
-				_ = gr.projectCache.Add(ctx, cacheKey3, rgp, nil)
+	}
+	if !gr.preserveGitRefOrder {
+		// The first branch and tag are the canonical ones, under which the project is cached.
+		sortGitBranches(rgp.branches)
+		sortGitTags(rgp.tags)
+	}
+	rgp.ts = strings.SplitN(meta["git-ts"], "\n", 2)[0]
+	rgp.author = strings.SplitN(string(gitAuthorBytes), "\n", 2)[0]
+	gitBody := truncateGitBody(string(gitBodyBytes), gr.maxCommitBodyBytes)
+	rgp.coAuthors = gitutil.ParseCoAuthorsFromBodyWithKeys(gitBody, gr.coAuthorTrailerKeys)
+	rgp.coAuthorIdentities = gitutil.ParseCoAuthorTrailersWithKeys(gitBody, gr.coAuthorTrailerKeys)
+	rgp.treeHashes = parseGitTrees(meta["git-trees"])
+	if gitTree := strings.SplitN(meta["git-tree"], "\n", 2)[0]; gitTree != "" {
+		rgp.treeHashes["."] = gitTree
+	}
+	rgp.parents = parseGitParents(meta[gitParentsFile])
+	rgp.describe = strings.TrimSpace(meta[gitDescribeFile])
+
+	rgp.state = pllb.Git(run.clone.gitURL, rgp.hash, contextGitOpts(run.clone.gitURL, run.ref, run.clone.keyScans)...)
+	if gr.useGitExec(run.ref) {
+		rgp.state = run.execState
+	}
+	return nil
+}
+
+// cloneGitMeta solves the git meta state, which is when the repository actually gets cloned.
+func (gr *gitResolver) cloneGitMeta(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, clone cloneCandidate, gitMetaState pllb.State) (gwclient.Reference, error) {
+	ctx, span := gr.tracer.Start(ctx, spanGitClone)
+	defer span.End()
+	span.SetAttribute(spanAttrURL, stringutil.ScrubCredentials(clone.gitURL))
+	// TODO figure out if we want to propagate --no-cache here
+	noCache := gr.gitMetaCache == EphemeralGitMeta
+	nativePlatr := platr.SubResolver(platutil.NativePlatform)
+	gitMetaRef, err := llbutil.StateToRef(
+		ctx, gwClient, gitMetaState, noCache,
+		nativePlatr, nil)
+	if err != nil {
+		if isEmptyRepositoryError(err) {
+			return nil, ErrEmptyRepository{
+				Repo: stringutil.ScrubCredentials(clone.gitURL),
 			}
-			if len(gitTags2) > 0 {
-				cacheKey4 := fmt.Sprintf("%s#%s", gitURL, gitTags2[0])
-				_ = gr.projectCache.Add(ctx, cacheKey4, rgp, nil)
+		}
+		if isNoDefaultBranchError(err) {
+			return nil, ErrStaleHead{
+				Repo: stringutil.ScrubCredentials(clone.gitURL),
 			}
-		}()
-		return rgp, nil
-	})
+		}
+		return nil, classifyGitError(errors.Wrap(err, "state to ref git meta"))
+	}
+	return gr.withReadFallback(gitMetaRef, gitMetaState, nativePlatr), nil
+}
+
+// gitMetaState returns the state holding the git metadata of the given clone url, along with the
+// build context state when the clone is made by running git in the git image.
+func (gr *gitResolver) gitMetaState(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, clone cloneCandidate, gitRef string, vm *outmon.VertexMeta) (pllb.State, pllb.State, error) {
+	if gr.useGitExec(ref) {
+		return gr.execGitMeta(ctx, gwClient, clone.gitURL, gitRef, clone.keyScans, platr, vm, ref)
+	}
+	gitOpts := []llb.GitOption{
+		llb.WithCustomNamef("%sGIT CLONE %s", vm.ToVertexPrefix(), stringutil.ScrubCredentials(clone.gitURL)),
+		llb.KeepGitDir(),
+	}
+	if len(clone.keyScans) > 0 {
+		gitOpts = append(gitOpts, llb.KnownSSHHosts(strings.Join(clone.keyScans, "\n")))
+	}
+
+	gitState := llb.Git(clone.gitURL, gitRef, gitOpts...)
+	opImg, err := gr.gitImageState(ctx, gwClient, platr)
 	if err != nil {
-		return nil, "", "", err
+		return pllb.State{}, pllb.State{}, err
+	}
+
+	// Get git hash.
+	script := gitMetaScript(gr.gitDestPath, gr.readSigningKey, gr.readSubmoduleStatus(), gr.readRootCommit, gr.maxCommitBodyBytes)
+	if gr.readGitConfig {
+		script += gitConfigListCommand(shellescape.Quote(path.Join(gr.gitDestPath, gitConfigListFile)))
+	}
+	if gr.transcodeCommitEncoding {
+		script += gitEncodingCommand(gr.gitDestPath, gr.maxCommitBodyBytes)
+	}
+	if gr.maxDeepenDepth > 0 {
+		script += gitShallowCommand(shellescape.Quote(path.Join(gr.gitDestPath, gitShallowFile)))
+	}
+	gr.logGitCommand(ref, clone.gitURL, gitRef, script, true)
+	gitHashOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", script}),
+		llb.Dir(gr.gitSrcPath),
+		llb.ReadonlyRootFS(),
+		llb.AddMount(gr.gitSrcPath, gitState, llb.Readonly),
+		llb.WithCustomNamef("%sGET GIT META %s", vm.ToVertexPrefix(), ref.ProjectCanonical()),
+	}
+	gitHashOp := opImg.Run(gitHashOpts...)
+	return gitHashOp.AddMount(gr.gitDestPath, platr.Scratch()), pllb.State{}, nil
+}
+
+// namespacedKey prefixes a key of the project or build file caches with the cache namespace, if any,
+// and hashes it with the CacheKeyHash, if set.
+func (gr *gitResolver) namespacedKey(key string) string {
+	if gr.cacheNamespace != "" {
+		key = gitKeyEscaper.Replace(gr.cacheNamespace) + "|" + key
+	}
+	if gr.cacheKeyHash != nil {
+		return gr.cacheKeyHash(key)
+	}
+	return key
+}
+
+// addSecondaryProject adds a project cache entry for a branch or tag of an already resolved
+// project, evicting the least recently used secondary entries beyond the configured maximum.
+func (gr *gitResolver) addSecondaryProject(ctx context.Context, cacheKey string, rgp *resolvedGitProject) {
+	err := gr.projectCache.Add(ctx, cacheKey, rgp, nil)
+	if err != nil {
+		// Already exists.
+		return
+	}
+	gr.repoKeys.addProject(gr.cacheKeyURL(rgp.gitURL), cacheKey)
+	for _, evictedKey := range gr.secondaryKeys.add(cacheKey) {
+		gr.projectCache.Delete(evictedKey)
+	}
+}
+
+// contextGitOpts returns the git options used for cloning the build context of the given ref.
+func contextGitOpts(gitURL string, ref domain.Reference, keyScans []string) []llb.GitOption {
+	gitOpts := []llb.GitOption{
+		llb.WithCustomNamef("[context %s] git context %s", stringutil.ScrubCredentials(gitURL), ref.StringCanonical()),
+		llb.KeepGitDir(),
+	}
+	if len(keyScans) > 0 {
+		gitOpts = append(gitOpts, llb.KnownSSHHosts(strings.Join(keyScans, "\n")))
 	}
-	rgp = rgpValue.(*resolvedGitProject)
-	return rgp, gitURL, subDir, nil
+	return gitOpts
 }
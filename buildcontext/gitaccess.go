@@ -0,0 +1,241 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/stringutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	// gitAccessRCFile and gitAccessErrFile hold the exit code and the stderr of the access probe.
+	gitAccessRCFile  = "git-access-rc"
+	gitAccessErrFile = "git-access-err"
+)
+
+// AccessFailureKind classifies the reason a remote repository is inaccessible.
+type AccessFailureKind int
+
+const (
+	// AccessFailureOther is any failure which is not otherwise classified.
+	AccessFailureOther AccessFailureKind = iota
+	// AccessFailureAuth is a failure due to missing or rejected credentials.
+	AccessFailureAuth
+	// AccessFailureNotFound is a failure due to the repository not existing (some servers also
+	// report repositories the credentials cannot access as not found).
+	AccessFailureNotFound
+	// AccessFailureNetwork is a failure to reach the git server.
+	AccessFailureNetwork
+)
+
+// String returns the human-readable name of the kind.
+func (k AccessFailureKind) String() string {
+	switch k {
+	case AccessFailureAuth:
+		return "authentication failed"
+	case AccessFailureNotFound:
+		return "not found"
+	case AccessFailureNetwork:
+		return "network error"
+	default:
+		return "error"
+	}
+}
+
+// RepoAccess is the outcome of probing the access to a remote repository.
+type RepoAccess struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	// Refs are the references to the repository which have been checked.
+	Refs []string
+	// Accessible is set when the repository can be accessed.
+	Accessible bool
+	// Kind is the classified reason the repository is inaccessible.
+	Kind AccessFailureKind
+	// Detail is the reason the repository is inaccessible, as reported by git, with credentials
+	// scrubbed.
+	Detail string
+}
+
+// AccessReport is the outcome of probing the access to a set of remote repositories, in the order
+// they are first referenced.
+type AccessReport []RepoAccess
+
+// Inaccessible returns the repositories which cannot be accessed.
+func (r AccessReport) Inaccessible() []RepoAccess {
+	var ret []RepoAccess
+	for _, ra := range r {
+		if !ra.Accessible {
+			ret = append(ret, ra)
+		}
+	}
+	return ret
+}
+
+// Err returns an ErrReposInaccessible listing the repositories which cannot be accessed, if any.
+func (r AccessReport) Err() error {
+	inaccessible := r.Inaccessible()
+	if len(inaccessible) == 0 {
+		return nil
+	}
+	return ErrReposInaccessible{Repos: inaccessible}
+}
+
+// ErrReposInaccessible is returned when some remote repositories cannot be accessed.
+type ErrReposInaccessible struct {
+	Repos []RepoAccess
+}
+
+// Error is function required by error interface.
+func (err ErrReposInaccessible) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%d remote repositories cannot be accessed:", len(err.Repos)))
+	for _, ra := range err.Repos {
+		sb.WriteString(fmt.Sprintf("\n\t%s (referenced by %s): %s", ra.Repo, strings.Join(ra.Refs, ", "), ra.Kind))
+		if ra.Detail != "" {
+			sb.WriteString(fmt.Sprintf(": %s", ra.Detail))
+		}
+	}
+	return sb.String()
+}
+
+// CheckAccess probes the access to the repositories of the given remote references, with the
+// credentials which would be used to clone them, but without cloning them. Local references are
+// ignored. The returned error is only set when probing itself fails; see AccessReport.Err.
+func (r *Resolver) CheckAccess(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, refs []domain.Reference) (AccessReport, error) {
+	var report AccessReport
+	indexes := make(map[string]int) // clone url -> index in report
+	for _, ref := range refs {
+		if !ref.IsRemote() {
+			continue
+		}
+		gitURL, _, keyScans, err := r.gr.gitLookup.GetCloneURL(ref.GetGitURL())
+		if err != nil {
+			report = append(report, RepoAccess{
+				Repo:   ref.GetGitURL(),
+				Refs:   []string{ref.StringCanonical()},
+				Kind:   AccessFailureOther,
+				Detail: stringutil.ScrubCredentials(err.Error()),
+			})
+			continue
+		}
+		if creds, ok := gitCredentialsFromContext(ctx); ok {
+			gitURL = creds.cloneURL(gitURL)
+		}
+		if i, ok := indexes[gitURL]; ok {
+			report[i].Refs = append(report[i].Refs, ref.StringCanonical())
+			continue
+		}
+		ra, err := r.gr.checkAccess(ctx, gwClient, platr, ref, gitURL, keyScans)
+		if err != nil {
+			return nil, err
+		}
+		indexes[gitURL] = len(report)
+		report = append(report, ra)
+	}
+	return report, nil
+}
+
+// checkAccess runs git ls-remote against the repository in the git image. The run never fails on
+// the probe itself, so that its outcome can be read back.
+func (gr *gitResolver) checkAccess(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, gitURL string, keyScans []string) (RepoAccess, error) {
+	ra := RepoAccess{
+		Repo: stringutil.ScrubCredentials(gitURL),
+		Refs: []string{ref.StringCanonical()},
+	}
+	vm := &outmon.VertexMeta{
+		TargetName: ref.ProjectCanonical(),
+		Internal:   true,
+	}
+	probeState, _, err := gr.execGitScript(ctx, gwClient, gitURL, "", keyScans, platr, vm, ref,
+		func(gitConfig []string) string {
+			return gitAccessScript(gr.gitDestPath, gitConfig)
+		},
+		llb.AddEnv("GIT_TERMINAL_PROMPT", "0"),
+		llb.IgnoreCache,
+		llb.WithCustomNamef("%sGIT CHECK ACCESS %s", vm.ToVertexPrefix(), stringutil.ScrubCredentials(gitURL)))
+	if err != nil {
+		return RepoAccess{}, err
+	}
+	noCache := true
+	probeRef, err := llbutil.StateToRef(
+		ctx, gwClient, probeState, noCache,
+		platr.SubResolver(platutil.NativePlatform), nil)
+	if err != nil {
+		return RepoAccess{}, errors.Wrap(err, "state to ref git access check")
+	}
+	rcBytes, err := gr.readGitMeta(ctx, probeRef, gitAccessRCFile)
+	if err != nil {
+		return RepoAccess{}, err
+	}
+	if strings.TrimSpace(string(rcBytes)) == "0" {
+		ra.Accessible = true
+		return ra, nil
+	}
+	errBytes, err := gr.readGitMeta(ctx, probeRef, gitAccessErrFile)
+	if err != nil {
+		return RepoAccess{}, err
+	}
+	stderr := strings.TrimSpace(string(errBytes))
+	ra.Kind = classifyAccessFailure(stderr)
+	ra.Detail = stringutil.ScrubCredentials(gitAccessDetail(stderr))
+	return ra, nil
+}
+
+// gitAccessScript returns the shell script which probes the access to the repository, writing the
+// exit code and stderr of git ls-remote to destPath.
+func gitAccessScript(destPath string, gitConfig []string) string {
+	var sb strings.Builder
+	sb.WriteString(gitConfigFunc(gitConfig))
+	sb.WriteString(fmt.Sprintf("rc=0 ; git ls-remote -- \"$EARTHLY_GIT_URL\" HEAD >/dev/null 2>%s || rc=$? ; echo $rc >%s ; ",
+		shellescape.Quote(path.Join(destPath, gitAccessErrFile)), shellescape.Quote(path.Join(destPath, gitAccessRCFile))))
+	return sb.String()
+}
+
+var (
+	gitAccessAuthRegexp     = regexp.MustCompile(`(?i)(authentication failed|permission denied|could not read (username|password)|terminal prompts disabled|invalid username or password|returned error: 40[13]|access denied|SAML SSO|two-factor authentication)`)
+	gitAccessNotFoundRegexp = regexp.MustCompile(`(?i)(repository not found|not found|does not appear to be a git repository|does not exist|returned error: 404)`)
+	gitAccessNetworkRegexp  = regexp.MustCompile(`(?i)(could not resolve host|connection refused|connection timed out|operation timed out|network is unreachable|no route to host|failed to connect|connection reset)`)
+)
+
+// classifyAccessFailure classifies a failed access probe, based on the stderr of git.
+func classifyAccessFailure(stderr string) AccessFailureKind {
+	switch {
+	case gitAccessNetworkRegexp.MatchString(stderr):
+		return AccessFailureNetwork
+	case gitAccessAuthRegexp.MatchString(stderr):
+		return AccessFailureAuth
+	case gitAccessNotFoundRegexp.MatchString(stderr):
+		return AccessFailureNotFound
+	default:
+		return AccessFailureOther
+	}
+}
+
+// gitAccessDetail returns the most relevant lines of the stderr of git: those reported as fatal, or
+// all of them otherwise.
+func gitAccessDetail(stderr string) string {
+	var fatal []string
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "fatal: ") {
+			fatal = append(fatal, strings.TrimPrefix(line, "fatal: "))
+		}
+	}
+	if len(fatal) == 0 {
+		return strings.Join(strings.Fields(stderr), " ")
+	}
+	return strings.Join(fatal, "; ")
+}
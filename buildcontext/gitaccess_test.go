@@ -0,0 +1,119 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCheckAccess(t *testing.T) {
+	stderrs := map[string]string{
+		"https://github.com/earthly/public.git":  "",
+		"https://github.com/earthly/private.git": "remote: Repository not found.\nfatal: repository 'https://github.com/earthly/private.git/' not found\n",
+		"https://github.com/earthly/secret.git":  "fatal: could not read Username for 'https://github.com': terminal prompts disabled\n",
+		"https://gitlab.com/acme/project.git":    "fatal: unable to access 'https://gitlab.com/acme/project.git/': Could not resolve host: gitlab.com\n",
+	}
+	gwClient := newTestGwClient(nil)
+	gwClient.solveFiles = func(def *pb.Definition) map[string]string {
+		for _, dt := range def.Def {
+			var op pb.Op
+			NoError(t, op.Unmarshal(dt), "unmarshal op")
+			exec := op.GetExec()
+			if exec == nil {
+				continue
+			}
+			for _, env := range exec.Meta.Env {
+				if gitURL := strings.TrimPrefix(env, "EARTHLY_GIT_URL="); gitURL != env {
+					if stderrs[gitURL] == "" {
+						return map[string]string{gitAccessRCFile: "0\n", gitAccessErrFile: ""}
+					}
+					return map[string]string{gitAccessRCFile: "128\n", gitAccessErrFile: stderrs[gitURL]}
+				}
+			}
+		}
+		return nil
+	}
+	var refs []domain.Reference
+	for _, target := range []string{
+		"github.com/earthly/public:main+build",
+		"github.com/earthly/private+build",
+		"./local+build",
+		"github.com/earthly/public/sub:v1.0.0+test",
+		"github.com/earthly/secret+build",
+		"gitlab.com/acme/project+build",
+	} {
+		ref, err := domain.ParseTarget(target)
+		NoError(t, err)
+		refs = append(refs, ref)
+	}
+
+	r := newTestResolver(t, ResolverOpt{})
+	report, err := r.CheckAccess(context.Background(), gwClient, newTestPlatformResolver(), refs)
+	NoError(t, err, "CheckAccess failed")
+	Equal(t, AccessReport{
+		{
+			Repo:       "https://github.com/earthly/public.git",
+			Refs:       []string{"github.com/earthly/public:main+build", "github.com/earthly/public/sub:v1.0.0+test"},
+			Accessible: true,
+		},
+		{
+			Repo:   "https://github.com/earthly/private.git",
+			Refs:   []string{"github.com/earthly/private+build"},
+			Kind:   AccessFailureNotFound,
+			Detail: "repository 'https://github.com/earthly/private.git/' not found",
+		},
+		{
+			Repo:   "https://github.com/earthly/secret.git",
+			Refs:   []string{"github.com/earthly/secret+build"},
+			Kind:   AccessFailureAuth,
+			Detail: "could not read Username for 'https://github.com': terminal prompts disabled",
+		},
+		{
+			Repo:   "https://gitlab.com/acme/project.git",
+			Refs:   []string{"gitlab.com/acme/project+build"},
+			Kind:   AccessFailureNetwork,
+			Detail: "unable to access 'https://gitlab.com/acme/project.git/': Could not resolve host: gitlab.com",
+		},
+	}, report)
+	Len(t, report.Inaccessible(), 3)
+
+	err = report.Err()
+	var inaccessibleErr ErrReposInaccessible
+	True(t, errors.As(err, &inaccessibleErr))
+	Contains(t, err.Error(), "3 remote repositories cannot be accessed")
+	Contains(t, err.Error(), "https://github.com/earthly/secret.git (referenced by github.com/earthly/secret+build): authentication failed")
+
+	// The probes are never cached, and nothing is cloned.
+	runs := 0
+	for _, op := range gwClient.solvedOps(t) {
+		if src := op.GetSource(); src != nil {
+			NotContains(t, src.Identifier, "git://", "unexpected git source clone")
+		}
+		if exec := op.GetExec(); exec != nil {
+			runs++
+			NotContains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "clone")
+		}
+	}
+	Equal(t, 4, runs)
+	NoError(t, report[:1].Err())
+}
+
+func TestClassifyAccessFailure(t *testing.T) {
+	for stderr, expected := range map[string]AccessFailureKind{
+		"fatal: Authentication failed for 'https://github.com/earthly/private.git/'":                               AccessFailureAuth,
+		"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.":            AccessFailureAuth,
+		"fatal: unable to access 'https://github.com/earthly/private.git/': The requested URL returned error: 403": AccessFailureAuth,
+		"ERROR: Repository not found.\nfatal: Could not read from remote repository.":                              AccessFailureNotFound,
+		"fatal: 'earthly/missing.git' does not appear to be a git repository":                                      AccessFailureNotFound,
+		"ssh: connect to host github.com port 22: Connection refused":                                              AccessFailureNetwork,
+		"fatal: unable to access 'https://github.com/earthly/earthly.git/': Failed to connect to github.com":       AccessFailureNetwork,
+		"fatal: protocol error: bad line length character: Welc":                                                   AccessFailureOther,
+	} {
+		Equal(t, expected, classifyAccessFailure(stderr), stderr)
+	}
+}
@@ -0,0 +1,73 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alessio/shellescape"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+// gitAdvertiseTimeoutFile is the git meta file which is written to, with RefAdvertisementTimeout,
+// when the remote repository has not advertised its refs in time. It is empty otherwise.
+const gitAdvertiseTimeoutFile = "git-advertise-timeout"
+
+// ErrRefAdvertisementTimeout is returned when a remote repository has not advertised its refs
+// within RefAdvertisementTimeout, i.e. the server is slow to list its refs, as opposed to slow to
+// transfer objects.
+type ErrRefAdvertisementTimeout struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	// Timeout is the maximum duration of the advertisement.
+	Timeout time.Duration
+}
+
+// Error is function required by error interface.
+func (err ErrRefAdvertisementTimeout) Error() string {
+	return fmt.Sprintf("%s has not advertised its refs within %s", err.Repo, err.Timeout)
+}
+
+// Unwrap returns context.DeadlineExceeded, so that the error can be matched as such.
+func (err ErrRefAdvertisementTimeout) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// gitAdvertiseCheck returns the commands stopping the script, after recording it in destPath,
+// should the remote repository not advertise its refs within timeout. Running git from timeout
+// bypasses the git function of the script, if any, so the given config (key=value) entries are
+// passed along explicitly. Other failures are left to the clone to report.
+func gitAdvertiseCheck(timeout time.Duration, destPath string, gitConfig []string) string {
+	dest := shellescape.Quote(path.Join(destPath, gitAdvertiseTimeoutFile))
+	var config strings.Builder
+	for _, c := range gitConfig {
+		config.WriteString(fmt.Sprintf(" -c %s", shellescape.Quote(c)))
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(": >%s ; ", dest))
+	// GNU timeout exits with 124 when the command times out, and busybox's with that of the
+	// command, killed by SIGTERM.
+	sb.WriteString(fmt.Sprintf("rc=0 ; timeout %d git%s ls-remote --refs -- \"$EARTHLY_GIT_URL\" >/dev/null || rc=$? ; ",
+		int64(math.Ceil(timeout.Seconds())), config.String()))
+	sb.WriteString(fmt.Sprintf("if [ $rc -eq 124 ] || [ $rc -eq 143 ] ; then echo timeout >%s ; exit 0 ; fi ; ", dest))
+	return sb.String()
+}
+
+// checkAdvertiseTimeout checks whether the git meta run stopped, the remote repository not having
+// advertised its refs within RefAdvertisementTimeout.
+func (gr *gitResolver) checkAdvertiseTimeout(ctx context.Context, gitMetaRef gwclient.Reference, repo string) error {
+	dt, err := gr.readGitMeta(ctx, gitMetaRef, gitAdvertiseTimeoutFile)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(dt)) == "" {
+		return nil
+	}
+	return ErrRefAdvertisementTimeout{
+		Repo:    repo,
+		Timeout: gr.refAdvertiseTimeout,
+	}
+}
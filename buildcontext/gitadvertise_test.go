@@ -0,0 +1,79 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitAdvertiseCheck(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("timeout is not available for tests, skipping")
+	}
+	// A fake git, whose advertisement of refs takes delay seconds.
+	run := func(delay string) map[string]string {
+		bin := t.TempDir()
+		fakeGit := "#!/bin/sh\ncase \"$*\" in *ls-remote*) exec sleep " + delay + " ;; esac\n"
+		NoError(t, os.WriteFile(filepath.Join(bin, "git"), []byte(fakeGit), 0755))
+		dest := t.TempDir()
+		script := gitAdvertiseCheck(time.Second, dest, nil) + "echo done >" + filepath.Join(dest, "cloned")
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Env = append(os.Environ(),
+			"PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"),
+			"EARTHLY_GIT_URL=https://github.com/earthly/test.git",
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "advertise check: %s", out)
+		files := make(map[string]string)
+		for _, name := range []string{gitAdvertiseTimeoutFile, "cloned"} {
+			dt, err := os.ReadFile(filepath.Join(dest, name))
+			if err == nil {
+				files[name] = strings.TrimSpace(string(dt))
+			}
+		}
+		return files
+	}
+
+	// The refs are advertised in time, and the clone goes ahead.
+	Equal(t, map[string]string{gitAdvertiseTimeoutFile: "", "cloned": "done"}, run("0"))
+	// The advertisement hangs, and the script stops.
+	Equal(t, map[string]string{gitAdvertiseTimeoutFile: "timeout"}, run("10"))
+}
+
+func TestResolveRefAdvertisementTimeout(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"Earthfile":             "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitAdvertiseTimeoutFile: "",
+	}
+	r := newTestResolver(t, ResolverOpt{RefAdvertisementTimeout: 30 * time.Second})
+	gwClient := newTestGwClient(files)
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	found := false
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			found = true
+			Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "timeout 30 git ls-remote --refs")
+		}
+	}
+	True(t, found)
+
+	// The server is slow to list its refs, even with the git metadata skipped.
+	files[gitAdvertiseTimeoutFile] = "timeout\n"
+	r = newTestResolver(t, ResolverOpt{RefAdvertisementTimeout: 30 * time.Second, SkipGitMetadata: true})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	var timeoutErr ErrRefAdvertisementTimeout
+	True(t, errors.As(err, &timeoutErr), "unexpected error %v", err)
+	Equal(t, ErrRefAdvertisementTimeout{Repo: "https://github.com/earthly/test.git", Timeout: 30 * time.Second}, timeoutErr)
+	True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,107 @@
+package buildcontext
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/analytics"
+	"github.com/earthly/earthly/util/stringutil"
+)
+
+const defaultGitAnalyticsEvent = "gitResolver.resolveEarthProject"
+
+// AnalyticsOpt customizes the analytics reported when resolving remote references.
+type AnalyticsOpt struct {
+	// EventName is the subsystem the counts are reported under. Defaults to
+	// gitResolver.resolveEarthProject.
+	EventName string
+	// Count, if set, receives the counts instead of analytics.Count.
+	Count func(subsystem, key string)
+	// Properties are additional static properties, each reported as a count of the value under the
+	// subsystem "<EventName>.<name>". Credentials embedded in values are scrubbed.
+	Properties map[string]string
+	// Detailed additionally reports the kind of ref resolved (default, branch, tag or commit),
+	// whether the project cache was hit, and the bucket of the resolution duration.
+	Detailed bool
+}
+
+// gitAnalytics reports the resolution of remote references. Only the hash of repositories is ever
+// reported, never their url.
+type gitAnalytics struct {
+	eventName  string
+	count      func(subsystem, key string)
+	properties map[string]string
+	detailed   bool
+}
+
+func newGitAnalytics(opt AnalyticsOpt) *gitAnalytics {
+	ga := &gitAnalytics{
+		eventName:  opt.EventName,
+		count:      opt.Count,
+		properties: opt.Properties,
+		detailed:   opt.Detailed,
+	}
+	if ga.eventName == "" {
+		ga.eventName = defaultGitAnalyticsEvent
+	}
+	if ga.count == nil {
+		ga.count = analytics.Count
+	}
+	return ga
+}
+
+// resolveStarted reports the resolution of the given repository.
+func (ga *gitAnalytics) resolveStarted(gitURL string) {
+	ga.count(ga.eventName, analytics.RepoHashFromCloneURL(gitURL))
+	for name, value := range ga.properties {
+		ga.count(ga.eventName+"."+name, stringutil.ScrubCredentials(value))
+	}
+}
+
+// resolveDone reports the details of a completed resolution, if enabled.
+func (ga *gitAnalytics) resolveDone(gitRef string, rgp *resolvedGitProject, cacheHit bool, duration time.Duration) {
+	if !ga.detailed {
+		return
+	}
+	ga.count(ga.eventName+".ref-kind", gitRefKind(gitRef, rgp))
+	if cacheHit {
+		ga.count(ga.eventName+".cache", "hit")
+	} else {
+		ga.count(ga.eventName+".cache", "miss")
+	}
+	ga.count(ga.eventName+".duration", durationBucket(duration))
+}
+
+var gitHashPrefixRegexp = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// gitRefKind returns the kind of the requested ref, based on what it resolved to.
+func gitRefKind(gitRef string, rgp *resolvedGitProject) string {
+	switch {
+	case gitRef == "":
+		return "default"
+	case gitHashPrefixRegexp.MatchString(gitRef) && strings.HasPrefix(rgp.hash, gitRef):
+		return "commit"
+	}
+	for _, tag := range rgp.tags {
+		if tag == gitRef {
+			return "tag"
+		}
+	}
+	return "branch"
+}
+
+func durationBucket(d time.Duration) string {
+	switch {
+	case d < 100*time.Millisecond:
+		return "<100ms"
+	case d < time.Second:
+		return "100ms-1s"
+	case d < 10*time.Second:
+		return "1s-10s"
+	case d < time.Minute:
+		return "10s-1m"
+	default:
+		return ">1m"
+	}
+}
@@ -0,0 +1,36 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gitBodyTruncatedMarker is the line the commit message bodies cut short by the body cap start with.
+const gitBodyTruncatedMarker = "[truncated]\n"
+
+// gitBodyCommand returns the shell command writing the commit message body to the given (quoted)
+// file. logOpts, if set, are extra options of git log, starting with a space. When maxBytes is set,
+// only the end of the body is kept, as that is where its trailers (e.g. Co-authored-by) are. One
+// more byte than the cap is kept, so that truncateGitBody can tell whether the body has been cut.
+func gitBodyCommand(file, logOpts string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return fmt.Sprintf("git log -1%s --format=%%b >%s || touch %s ; ", logOpts, file, file)
+	}
+	return fmt.Sprintf("git log -1%s --format=%%b | tail -c %d >%s || touch %s ; ", logOpts, maxBytes+1, file, file)
+}
+
+// truncateGitBody caps body to its last maxBytes bytes, if set. The beginning of a truncated body,
+// up to its first full line, is replaced with gitBodyTruncatedMarker, so that no line cut short (such
+// as a trailer) is mistaken for a full one.
+func truncateGitBody(body string, maxBytes int) string {
+	if maxBytes <= 0 || len(body) <= maxBytes {
+		return body
+	}
+	body = body[len(body)-maxBytes:]
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else {
+		body = ""
+	}
+	return gitBodyTruncatedMarker + body
+}
@@ -0,0 +1,90 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/gitutil"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestTruncateGitBody(t *testing.T) {
+	body := "first line\nsecond line\nCo-authored-by: A <a@example.com>\n"
+	Equal(t, body, truncateGitBody(body, 0))
+	Equal(t, body, truncateGitBody(body, len(body)))
+	// The partial line is dropped.
+	Equal(t, "[truncated]\nsecond line\nCo-authored-by: A <a@example.com>\n", truncateGitBody(body, len(body)-1))
+	Equal(t, "[truncated]\nCo-authored-by: A <a@example.com>\n", truncateGitBody(body, len(body)-len("first line\n")-1))
+	Equal(t, "[truncated]\n", truncateGitBody(body, 10))
+}
+
+func TestResolveMaxCommitBodyBytes(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"git-body": strings.Repeat("A very verbose commit message.\n", 10000) +
+			"\nCo-authored-by: Someone Else <someone-else@example.com>\nCo-authored-by: Another One <another@example.com>\n",
+	}
+	for _, maxBytes := range []int{0, 200} {
+		gwClient := newTestGwClient(files)
+		r := newTestResolver(t, ResolverOpt{MaxCommitBodyBytes: maxBytes})
+		d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		Equal(t, []string{"someone-else@example.com", "another@example.com"}, d.GitMetadata.CoAuthors)
+		found := false
+		for _, op := range gwClient.solvedOps(t) {
+			if exec := op.GetExec(); exec != nil {
+				found = true
+				script := exec.Meta.Args[len(exec.Meta.Args)-1]
+				if maxBytes > 0 {
+					Contains(t, script, "git log -1 --format=%b | tail -c 201 >")
+				} else {
+					Contains(t, script, "git log -1 --format=%b >")
+				}
+			}
+		}
+		True(t, found)
+	}
+}
+
+func TestGitMetaScriptMaxBodyBytes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	cmd := exec.Command("git", "init", "--quiet", "--initial-branch=main")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	NoError(t, err, "git init: %s", out)
+	message := "Subject\n\n" + strings.Repeat("A very verbose commit message.\n", 1000) +
+		"\nCo-authored-by: Someone Else <someone-else@example.com>\n"
+	cmd = exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "--allow-empty", "-F", "-")
+	cmd.Dir = repo
+	cmd.Stdin = strings.NewReader(message)
+	out, err = cmd.CombinedOutput()
+	NoError(t, err, "git commit: %s", out)
+
+	runMeta := func(maxBytes int) string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitMetaScript(dest, false, false, false, maxBytes))
+		cmd.Dir = repo
+		_ = cmd.Run()
+		body, err := os.ReadFile(filepath.Join(dest, "git-body"))
+		NoError(t, err)
+		return truncateGitBody(string(body), maxBytes)
+	}
+	body := runMeta(0)
+	False(t, strings.HasPrefix(body, gitBodyTruncatedMarker))
+	Greater(t, len(body), 30000)
+
+	body = runMeta(100)
+	True(t, strings.HasPrefix(body, gitBodyTruncatedMarker), body)
+	LessOrEqual(t, len(body), len(gitBodyTruncatedMarker)+100)
+	Equal(t, []string{"someone-else@example.com"}, gitutil.ParseCoAuthorsFromBody(body))
+}
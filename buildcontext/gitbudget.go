@@ -0,0 +1,112 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+)
+
+// ErrResolveTimeout is returned when the resolution of a remote reference, including all of its
+// steps, has taken longer than the configured maximum duration.
+type ErrResolveTimeout struct {
+	// Ref is the reference being resolved.
+	Ref string
+	// MaxDuration is the budget which has been exhausted.
+	MaxDuration time.Duration
+}
+
+// Error is function required by error interface.
+func (err ErrResolveTimeout) Error() string {
+	return fmt.Sprintf("resolving %s took longer than the maximum of %s", err.Ref, err.MaxDuration)
+}
+
+// Unwrap returns context.DeadlineExceeded, so that the error can be matched as such.
+func (err ErrResolveTimeout) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// ErrBuildDeadlineExceeded is returned when the build-wide deadline carried by the context of a
+// resolution (see WithBuildDeadline) has passed, either before the resolution started, or while it
+// was in flight.
+type ErrBuildDeadlineExceeded struct {
+	// Ref is the reference being resolved.
+	Ref string
+	// Deadline is the deadline of the build.
+	Deadline time.Time
+}
+
+// Error is function required by error interface.
+func (err ErrBuildDeadlineExceeded) Error() string {
+	return fmt.Sprintf("the build deadline of %s has passed, resolving %s", err.Deadline.UTC().Format(time.RFC3339), err.Ref)
+}
+
+// Unwrap returns context.DeadlineExceeded, so that the error can be matched as such.
+func (err ErrBuildDeadlineExceeded) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+type buildDeadlineKey struct{}
+
+// WithBuildDeadline returns a context carrying the deadline of a whole build, which the resolutions
+// made with it honor: once it has passed, they fail fast with ErrBuildDeadlineExceeded instead of
+// starting new clones, and those in flight are cancelled. It complements MaxResolveDuration, which
+// applies to each resolution on its own.
+func WithBuildDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, buildDeadlineKey{}, deadline)
+}
+
+// buildDeadlineFromContext returns the build deadline carried by ctx, if any.
+func buildDeadlineFromContext(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Value(buildDeadlineKey{}).(time.Time)
+	return deadline, ok
+}
+
+// withResolveBudget runs fn with a context which expires once the build deadline carried by ctx has
+// passed, if any, failing fast with ErrBuildDeadlineExceeded when it already has. Failures caused
+// by its expiry are reported as such as well. The budget of the resolution itself applies
+// otherwise (see withRefBudget).
+func (gr *gitResolver) withResolveBudget(ctx context.Context, ref domain.Reference, fn func(ctx context.Context) error) error {
+	deadline, ok := buildDeadlineFromContext(ctx)
+	if !ok {
+		return gr.withRefBudget(ctx, ref, fn)
+	}
+	deadlineErr := ErrBuildDeadlineExceeded{
+		Ref:      ref.String(),
+		Deadline: deadline,
+	}
+	if !time.Now().Before(deadline) {
+		return deadlineErr
+	}
+	deadlineCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	err := gr.withRefBudget(deadlineCtx, ref, fn)
+	if err != nil && deadlineCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return deadlineErr
+	}
+	return err
+}
+
+// withRefBudget runs fn with a context which expires once the maximum resolve duration has
+// elapsed, if one is set. Failures caused by the expiry are reported as ErrResolveTimeout. The
+// context credentials, if any, are checked for expiry beforehand (see freshCredentials).
+func (gr *gitResolver) withRefBudget(ctx context.Context, ref domain.Reference, fn func(ctx context.Context) error) error {
+	ctx, err := gr.freshCredentials(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if gr.maxResolveDuration <= 0 {
+		return fn(ctx)
+	}
+	budgetCtx, cancel := context.WithTimeout(ctx, gr.maxResolveDuration)
+	defer cancel()
+	err = fn(budgetCtx)
+	if err != nil && budgetCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return ErrResolveTimeout{
+			Ref:         ref.String(),
+			MaxDuration: gr.maxResolveDuration,
+		}
+	}
+	return err
+}
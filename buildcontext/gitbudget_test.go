@@ -0,0 +1,94 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+// blockingGwClient is a gateway client whose solves block until their context is done.
+type blockingGwClient struct {
+	*fakeGwClient
+}
+
+func (c *blockingGwClient) Solve(ctx context.Context, req gwclient.SolveRequest) (*gwclient.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResolveMaxDuration(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	// The solves only end once the budget is exhausted.
+	blocking := &blockingGwClient{fakeGwClient: newTestGwClient(files)}
+	r := newTestResolver(t, ResolverOpt{MaxResolveDuration: 50 * time.Millisecond})
+	_, err = r.Resolve(context.Background(), blocking, newTestPlatformResolver(), ref)
+	var timeoutErr ErrResolveTimeout
+	True(t, errors.As(err, &timeoutErr), "unexpected error %v", err)
+	Equal(t, 50*time.Millisecond, timeoutErr.MaxDuration)
+	True(t, errors.Is(err, context.DeadlineExceeded))
+
+	r = newTestResolver(t, ResolverOpt{MaxResolveDuration: 50 * time.Millisecond})
+	_, err = r.ResolveFeatures(context.Background(), blocking, newTestPlatformResolver(), ref)
+	True(t, errors.As(err, &timeoutErr), "unexpected error %v", err)
+
+	// A large enough budget is not in the way.
+	gwClient := newTestGwClient(files)
+	r = newTestResolver(t, ResolverOpt{MaxResolveDuration: time.Minute})
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+
+	// A canceled parent context is not reported as a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = newTestResolver(t, ResolverOpt{MaxResolveDuration: time.Minute})
+	_, err = r.Resolve(ctx, gwClient, newTestPlatformResolver(), ref)
+	Error(t, err)
+	False(t, errors.As(err, &timeoutErr))
+}
+
+func TestResolveBuildDeadline(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	r := newTestResolver(t, ResolverOpt{})
+
+	// The resolution in flight when the deadline passes is cancelled: the client never completes
+	// any solve, so the resolution only ends with the deadline (or, would it start past it, fails
+	// fast with the same error).
+	deadline := time.Now().Add(50 * time.Millisecond)
+	ctx := WithBuildDeadline(context.Background(), deadline)
+	_, err = r.Resolve(ctx, &blockingGwClient{fakeGwClient: newTestGwClient(files)}, newTestPlatformResolver(), ref)
+	var deadlineErr ErrBuildDeadlineExceeded
+	True(t, errors.As(err, &deadlineErr), "unexpected error %v", err)
+	Equal(t, ErrBuildDeadlineExceeded{Ref: ref.String(), Deadline: deadline}, deadlineErr)
+	True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// New resolutions fail fast, without starting any clone.
+	deadline = time.Now().Add(-time.Minute)
+	ctx = WithBuildDeadline(context.Background(), deadline)
+	other, err := domain.ParseTarget("github.com/earthly/other:main+build")
+	NoError(t, err)
+	fakeClient := newTestGwClient(files)
+	_, err = r.Resolve(ctx, fakeClient, newTestPlatformResolver(), other)
+	True(t, errors.As(err, &deadlineErr), "unexpected error %v", err)
+	_, err = r.ResolveFeatures(ctx, fakeClient, newTestPlatformResolver(), other)
+	True(t, errors.As(err, &deadlineErr), "unexpected error %v", err)
+	Empty(t, fakeClient.solves)
+
+	// A deadline yet to pass is not in the way.
+	ctx = WithBuildDeadline(context.Background(), time.Now().Add(time.Minute))
+	_, err = r.Resolve(ctx, newTestGwClient(files), newTestPlatformResolver(), other)
+	NoError(t, err, "Resolve failed")
+}
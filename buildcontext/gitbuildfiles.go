@@ -0,0 +1,98 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/platutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// gitBuildFilesFile holds the build files of the repository, as listed by `git ls-files -z`.
+const gitBuildFilesFile = "git-build-files"
+
+// BuildFiles resolves a remote reference, and returns the paths of all the build files (Earthfile
+// and build.earth) tracked in its repository at the resolved commit, relative to the root of the
+// repository, regardless of the subdirectory of the reference. Build files of submodules are not
+// listed.
+func (r *Resolver) BuildFiles(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference) ([]string, error) {
+	if !ref.IsRemote() {
+		return nil, errors.Errorf("build files can only be listed for remote references, got %s", ref.String())
+	}
+	var files []string
+	err := r.gr.withResolveBudget(ctx, ref, func(ctx context.Context) error {
+		var err error
+		files, err = r.gr.buildFiles(ctx, gwClient, platr, ref)
+		return err
+	})
+	return files, err
+}
+
+func (gr *gitResolver) buildFiles(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference) ([]string, error) {
+	if gr.skipMeta {
+		return nil, errors.New("build files cannot be listed when git metadata is skipped")
+	}
+	rgp, gitURL, _, err := gr.resolveGitProject(ctx, gwClient, platr, ref)
+	if err != nil {
+		return nil, err
+	}
+	vm := &outmon.VertexMeta{
+		TargetName: ref.ProjectCanonical(),
+		Internal:   true,
+	}
+	lsState, _, err := gr.execGitScript(ctx, gwClient, gitURL, rgp.hash, rgp.keyScans, platr, vm, ref,
+		func(gitConfig []string) string {
+			return gitBuildFilesScript(gr.gitMirror.Enabled, gr.gitSrcPath, gr.gitDestPath, gitConfig)
+		},
+		llb.WithCustomNamef("%sGIT LS-FILES %s", vm.ToVertexPrefix(), ref.ProjectCanonical()))
+	if err != nil {
+		return nil, err
+	}
+	noCache := false
+	lsRef, err := llbutil.StateToRef(
+		ctx, gwClient, lsState, noCache,
+		platr.SubResolver(platutil.NativePlatform), nil)
+	if err != nil {
+		return nil, classifyGitError(errors.Wrap(err, "state to ref git ls-files"))
+	}
+	lsBytes, err := lsRef.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: gitBuildFilesFile,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", gitBuildFilesFile)
+	}
+	return parseGitBuildFiles(string(lsBytes)), nil
+}
+
+// gitBuildFilesScript returns the shell script which checks out the commit ($EARTHLY_GIT_REF) as for
+// gitCloneScript, and writes the build files tracked at any depth to destPath, in the format of
+// `git ls-files -z`.
+func gitBuildFilesScript(mirror bool, srcPath, destPath string, gitConfig []string) string {
+	var sb strings.Builder
+	sb.WriteString(gitCloneScript(mirror, true, false, false, false, false, false, false, false, false, 0, 0, 0, "", srcPath, destPath, gitConfig))
+	sb.WriteString("set -e ; ")
+	// A leading **/ matches in all directories, including the root one.
+	sb.WriteString(fmt.Sprintf("git -C %s ls-files -z -- ':(glob)**/Earthfile' ':(glob)**/%s' >%s ; ",
+		shellescape.Quote(srcPath), legacyBuildFileName, shellescape.Quote(path.Join(destPath, gitBuildFilesFile))))
+	return sb.String()
+}
+
+// parseGitBuildFiles parses the output of `git ls-files -z`.
+func parseGitBuildFiles(out string) []string {
+	var files []string
+	for _, f := range strings.Split(out, "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
@@ -0,0 +1,99 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitBuildFilesScript(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		p := filepath.Join(repo, name)
+		NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+	write("Earthfile", "VERSION 0.6\n")
+	write("services/api/Earthfile", "VERSION 0.6\n")
+	write("services/web app/Earthfile", "VERSION 0.6\n")
+	write("legacy/build.earth", "FROM alpine\n")
+	// Neither of these is a build file.
+	write("docs/Earthfile.md", "# Earthfile\n")
+	write("examples/my.Earthfile", "VERSION 0.6\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "build files")
+	hash := git("rev-parse", "HEAD")
+	// Untracked build files are not listed.
+	write("untracked/Earthfile", "VERSION 0.6\n")
+
+	dest := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", gitBuildFilesScript(false, filepath.Join(t.TempDir(), "src"), dest, nil))
+	cmd.Env = append(os.Environ(),
+		"EARTHLY_GIT_URL="+repo,
+		"EARTHLY_GIT_ORIGIN="+repo,
+		"EARTHLY_GIT_REF="+hash,
+	)
+	out, err := cmd.CombinedOutput()
+	NoError(t, err, "git build files script: %s", out)
+	files, err := os.ReadFile(filepath.Join(dest, gitBuildFilesFile))
+	NoError(t, err)
+	Equal(t, []string{
+		"Earthfile",
+		"legacy/build.earth",
+		"services/api/Earthfile",
+		"services/web app/Earthfile",
+	}, parseGitBuildFiles(string(files)))
+}
+
+func TestResolveBuildFiles(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile":   "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitBuildFilesFile: "Earthfile\x00other/build.earth\x00sub/Earthfile\x00",
+	})
+	r := newTestResolver(t, ResolverOpt{})
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	files, err := r.BuildFiles(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "BuildFiles failed")
+	Equal(t, []string{"Earthfile", "other/build.earth", "sub/Earthfile"}, files)
+
+	var lsRuns int
+	for _, op := range gwClient.solvedOps(t) {
+		exec := op.GetExec()
+		if exec == nil || !strings.Contains(exec.Meta.Args[len(exec.Meta.Args)-1], gitBuildFilesFile) {
+			continue
+		}
+		lsRuns++
+		Contains(t, exec.Meta.Env, "EARTHLY_GIT_REF=a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5")
+	}
+	Equal(t, 1, lsRuns)
+
+	local, err := domain.ParseTarget("./sub+build")
+	NoError(t, err)
+	_, err = r.BuildFiles(context.Background(), gwClient, newTestPlatformResolver(), local)
+	Error(t, err)
+}
+
+func TestParseGitBuildFiles(t *testing.T) {
+	Empty(t, parseGitBuildFiles(""))
+	Equal(t, []string{"Earthfile", "with space/Earthfile"}, parseGitBuildFiles("Earthfile\x00with space/Earthfile\x00"))
+}
@@ -0,0 +1,92 @@
+package buildcontext
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// gitDefaultPorts are the ports implied by the schemes of clone urls.
+var gitDefaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ssh":   "22",
+	"git":   "9418",
+}
+
+// cacheKeyURL returns the form of gitURL which the cache keys are made of: with its host
+// normalized, if enabled, and as is otherwise. The clone itself always uses gitURL.
+func (gr *gitResolver) cacheKeyURL(gitURL string) string {
+	if !gr.normalizeCacheHosts {
+		return gitURL
+	}
+	return normalizeGitURLHost(gitURL)
+}
+
+// cacheKeyProject returns the form of the canonical project (or target) of a reference which the
+// cache keys are made of: with its host normalized, if enabled, and as is otherwise.
+func (gr *gitResolver) cacheKeyProject(project string) string {
+	if !gr.normalizeCacheHosts {
+		return project
+	}
+	host, rest, found := strings.Cut(project, "/")
+	if !found {
+		return project
+	}
+	return normalizeHost(host, "") + "/" + rest
+}
+
+// normalizeGitURLHost normalizes the host of a clone url, so that the urls differing only by the
+// case of their host, a trailing dot or an explicit default port are equal, e.g.
+//
+//	https://GitHub.com:443/earthly/earthly.git ---> https://github.com/earthly/earthly.git
+//	git@GitHub.com:earthly/earthly.git         ---> git@github.com:earthly/earthly.git
+//
+// The rest of the url is left untouched. Urls which cannot be parsed are returned as is.
+func normalizeGitURLHost(gitURL string) string {
+	if !strings.Contains(gitURL, "://") {
+		// scp-like syntax: [user@]host:path
+		userHost, path, found := strings.Cut(gitURL, ":")
+		if !found {
+			return gitURL
+		}
+		user, host, found := strings.Cut(userHost, "@")
+		if !found {
+			return normalizeHost(userHost, "") + ":" + path
+		}
+		return user + "@" + normalizeHost(host, "") + ":" + path
+	}
+	u, err := url.Parse(gitURL)
+	if err != nil || u.Host == "" {
+		return gitURL
+	}
+	u.Host = normalizeHost(u.Host, gitDefaultPorts[strings.ToLower(u.Scheme)])
+	return u.String()
+}
+
+// normalizeHost lowercases host and drops its trailing dot, and its port if it is defaultPort.
+func normalizeHost(host, defaultPort string) string {
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	if port == "" || port == defaultPort {
+		if strings.Contains(hostname, ":") {
+			return "[" + hostname + "]" // IPv6
+		}
+		return hostname
+	}
+	return net.JoinHostPort(hostname, port)
+}
+
+// gitKeyEscaper escapes the separators of the components of cache keys within them, along with the
+// escapes themselves.
+var gitKeyEscaper = strings.NewReplacer("%", "%25", "#", "%23", "|", "%7C")
+
+// gitProjectKey returns the key of the project cache entry of a ref of the repository of keyURL.
+// Both are escaped, for no url and ref (which may contain a #) to share the key of another pair.
+// Usual urls and refs are left as they are, e.g. https://github.com/earthly/earthly.git#main.
+func gitProjectKey(keyURL, gitRef string) string {
+	return gitKeyEscaper.Replace(keyURL) + "#" + gitKeyEscaper.Replace(gitRef)
+}
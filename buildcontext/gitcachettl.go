@@ -0,0 +1,71 @@
+package buildcontext
+
+import (
+	"context"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/syncutil/synccache"
+)
+
+const (
+	// DefaultTagCacheTTL is how long the remote projects and build files of tags are cached for,
+	// when no CacheTTL is set. Commits never expire.
+	DefaultTagCacheTTL = 24 * time.Hour
+	// DefaultBranchCacheTTL is how long those of branches (and of the default branch) are cached
+	// for, when no CacheTTL is set.
+	DefaultBranchCacheTTL = 5 * time.Minute
+)
+
+// timeNow is the clock the cache entries are aged with.
+var timeNow = time.Now
+
+// CacheForever is a CacheTTL never expiring the remote projects and build files: each ref then
+// resolves to the same commit for as long as the resolver is in use, e.g. throughout a build.
+func CacheForever(ref domain.Reference) time.Duration {
+	return 0
+}
+
+// cacheTTL returns how long the cache entries of the ref are valid for, 0 meaning forever. rgp is
+// the project the ref has been resolved to, if known, which the default policy tells the kind of
+// the ref out of: when it is not, a ref is taken for a branch unless it is a full commit hash.
+func (gr *gitResolver) cacheTTL(ref domain.Reference, rgp *resolvedGitProject) time.Duration {
+	if gr.cacheTTLFunc != nil {
+		return gr.cacheTTLFunc(ref)
+	}
+	kind := "branch"
+	if rgp != nil {
+		kind = gitRefKind(ref.GetTag(), rgp)
+	} else if gitFullHashRegexp.MatchString(ref.GetTag()) {
+		kind = "commit"
+	}
+	switch kind {
+	case "commit":
+		return 0
+	case "tag":
+		return DefaultTagCacheTTL
+	default:
+		return DefaultBranchCacheTTL
+	}
+}
+
+// cacheExpired returns whether a cache entry of the ref created at cachedAt has expired. The
+// entries of projects imported from a lockfile never expire, the lockfile pinning their commit.
+func (gr *gitResolver) cacheExpired(ref domain.Reference, rgp *resolvedGitProject, cachedAt time.Time) bool {
+	if rgp != nil && rgp.locked {
+		return false
+	}
+	ttl := gr.cacheTTL(ref, rgp)
+	return ttl > 0 && timeNow().Sub(cachedAt) >= ttl
+}
+
+// stampResolvedAt wraps the constructor of a project, for it to record when it was resolved.
+func stampResolvedAt(c synccache.Constructor) synccache.Constructor {
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		v, err := c(ctx, key)
+		if rgp, ok := v.(*resolvedGitProject); ok {
+			rgp.resolvedAt = time.Now()
+		}
+		return v, err
+	}
+}
@@ -0,0 +1,129 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveCacheTTL(t *testing.T) {
+	var mu sync.Mutex
+	var ttlRefs []string
+	cacheTTL := func(ref domain.Reference) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		ttlRefs = append(ttlRefs, ref.GetTag())
+		if ref.GetTag() == "main" {
+			// Expired as soon as cached.
+			return time.Nanosecond
+		}
+		return time.Hour
+	}
+	tests := []struct {
+		target  string
+		expired bool
+	}{
+		{"github.com/earthly/test/sub:main+build", true},
+		{"github.com/earthly/test/sub:v1.0.0+build", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.target, func(t *testing.T) {
+			ttlRefs = nil
+			gwClient := newTestGwClient(map[string]string{
+				"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			})
+			r := newTestResolver(t, ResolverOpt{CacheTTL: cacheTTL})
+			ref, err := domain.ParseTarget(tc.target)
+			NoError(t, err)
+			for i := 0; i < 2; i++ {
+				_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+				NoError(t, err, "Resolve failed")
+			}
+			if tc.expired {
+				Equal(t, 2, gwClient.numMetaRuns(t))
+			} else {
+				Equal(t, 1, gwClient.numMetaRuns(t))
+			}
+			Contains(t, ttlRefs, ref.GetTag())
+		})
+	}
+}
+
+func TestDefaultCacheTTL(t *testing.T) {
+	gr := &gitResolver{}
+	rgp := &resolvedGitProject{
+		hash:     "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5",
+		branches: []string{"main"},
+		tags:     []string{"v1.0.0"},
+	}
+	tests := []struct {
+		ref      string
+		rgp      *resolvedGitProject
+		expected time.Duration
+	}{
+		{"main", rgp, DefaultBranchCacheTTL},
+		{"", rgp, DefaultBranchCacheTTL},
+		{"v1.0.0", rgp, DefaultTagCacheTTL},
+		{"a7b2c4d5", rgp, 0},
+		// Without the project, only full commit hashes are told apart from branches.
+		{"v1.0.0", nil, DefaultBranchCacheTTL},
+		{"a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", nil, 0},
+	}
+	for _, tc := range tests {
+		ref := domain.Target{GitURL: "github.com/earthly/test", Tag: tc.ref}
+		Equal(t, tc.expected, gr.cacheTTL(ref, tc.rgp), "ref %q", tc.ref)
+	}
+	False(t, gr.cacheExpired(domain.Target{GitURL: "github.com/earthly/test", Tag: "v1.0.0"}, rgp, time.Now().Add(-time.Hour)))
+	True(t, gr.cacheExpired(domain.Target{GitURL: "github.com/earthly/test", Tag: "main"}, rgp, time.Now().Add(-time.Hour)))
+}
+
+// advanceClock moves the clock the cache entries are aged with forward by d, for the rest of the
+// test.
+func advanceClock(t *testing.T, d time.Duration) {
+	t.Helper()
+	now := timeNow
+	timeNow = func() time.Time {
+		return now().Add(d)
+	}
+	t.Cleanup(func() {
+		timeNow = now
+	})
+}
+
+func TestResolveCacheTTLExpiry(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	tests := []struct {
+		name       string
+		opt        ResolverOpt
+		lockfile   bool
+		expectRuns int
+	}{
+		{"default", ResolverOpt{}, false, 2},
+		{"forever", ResolverOpt{CacheTTL: CacheForever}, false, 1},
+		// Projects imported from a lockfile are never cloned.
+		{"locked", ResolverOpt{}, true, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gwClient := newTestGwClient(map[string]string{
+				"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			})
+			r := newTestResolver(t, tc.opt)
+			if tc.lockfile {
+				NoError(t, r.ImportLockfile([]byte(testLockfile)), "ImportLockfile failed")
+			}
+			_, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			NoError(t, err, "Resolve failed")
+			// Past the default TTL of branches.
+			advanceClock(t, DefaultBranchCacheTTL+time.Minute)
+			_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			NoError(t, err, "Resolve failed")
+			Equal(t, tc.expectRuns, gwClient.numMetaRuns(t))
+		})
+	}
+}
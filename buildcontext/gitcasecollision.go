@@ -0,0 +1,97 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// ErrCaseCollision is returned, in strict mode, when paths relevant to the detection of the build
+// file of a remote reference differ only by case, so that they collide when checked out on a
+// case-insensitive filesystem (e.g. on macOS or Windows).
+type ErrCaseCollision struct {
+	// Ref is the canonical form of the project reference (repo, subdirectory and ref).
+	Ref string
+	// Collisions are the groups of colliding paths, relative to the root of the repository.
+	Collisions [][]string
+}
+
+// Error is function required by error interface.
+func (err ErrCaseCollision) Error() string {
+	groups := make([]string, 0, len(err.Collisions))
+	for _, paths := range err.Collisions {
+		groups = append(groups, strings.Join(paths, ", "))
+	}
+	return fmt.Sprintf("paths of %s collide on case-insensitive filesystems: %s", err.Ref, strings.Join(groups, "; "))
+}
+
+// caseCollisions returns the groups of entries which differ only by case, within each directory
+// from the root of ref down to dir. The groups are sorted, by their first path.
+func caseCollisions(ctx context.Context, ref gwclient.Reference, dir string) ([][]string, error) {
+	var dirs []string
+	for d := path.Clean(dir); ; d = path.Dir(d) {
+		dirs = append(dirs, d)
+		if d == "." || d == "/" {
+			break
+		}
+	}
+	var collisions [][]string
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fstats, err := ref.ReadDir(ctx, gwclient.ReadDirRequest{
+			Path: dirs[i],
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read dir %s", dirs[i])
+		}
+		byName := make(map[string][]string)
+		for _, fstat := range fstats {
+			name := path.Base(fstat.GetPath())
+			folded := strings.ToLower(name)
+			byName[folded] = append(byName[folded], path.Join(dirs[i], name))
+		}
+		var dirCollisions [][]string
+		for _, paths := range byName {
+			if len(paths) > 1 {
+				sort.Strings(paths)
+				dirCollisions = append(dirCollisions, paths)
+			}
+		}
+		sort.Slice(dirCollisions, func(i, j int) bool {
+			return dirCollisions[i][0] < dirCollisions[j][0]
+		})
+		collisions = append(collisions, dirCollisions...)
+	}
+	return collisions, nil
+}
+
+// checkCaseCollisions checks the directories leading to the build file detected for a remote
+// reference for paths which differ only by case. Collisions are reported as a warning, or as
+// ErrCaseCollision in strict mode.
+func (gr *gitResolver) checkCaseCollisions(ctx context.Context, ref domain.Reference, gitState gwclient.Reference, bf string) error {
+	if !gr.detectCaseCollisions {
+		return nil
+	}
+	collisions, err := caseCollisions(ctx, gitState, path.Dir(bf))
+	if err != nil {
+		return err
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+	collisionErr := ErrCaseCollision{
+		Ref:        ref.ProjectCanonical(),
+		Collisions: collisions,
+	}
+	if gr.strictCaseCollisions {
+		return collisionErr
+	}
+	gr.warn(ref, WarningCaseCollision, "%s", collisionErr.Error())
+	return nil
+}
@@ -0,0 +1,79 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveCaseCollisions(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"README":         "readme\n",
+		"Readme":         "readme\n",
+		"sub/Earthfile":  "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"sub/earthfile":  "VERSION 0.6\n\nbuild:\n\tFROM busybox\n",
+		"sub/EARTHFILE":  "VERSION 0.6\n\nbuild:\n\tFROM busybox\n",
+		"other/a.txt":    "a\n",
+		"other/A.txt":    "a\n",
+		"Sub/Earthfile2": "not a build file\n",
+	}
+	newResolver := func(strict bool) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			DetectCaseCollisions: true,
+			StrictCaseCollisions: strict,
+		}), &buf
+	}
+
+	gwClient := newTestGwClient(files)
+	r, buf := newResolver(false)
+	for i := 0; i < 2; i++ {
+		_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+	// Only the directories leading to the build file are checked, once.
+	Equal(t, 1, strings.Count(buf.String(), "Warning: paths of github.com/earthly/test/sub:main collide on case-insensitive filesystems: README, Readme; Sub, sub; sub/EARTHFILE, sub/Earthfile, sub/earthfile\n"), buf.String())
+
+	r, _ = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	var collisionErr ErrCaseCollision
+	True(t, errors.As(err, &collisionErr), "unexpected error %v", err)
+	Equal(t, ErrCaseCollision{
+		Ref: "github.com/earthly/test/sub:main",
+		Collisions: [][]string{
+			{"README", "Readme"},
+			{"Sub", "sub"},
+			{"sub/EARTHFILE", "sub/Earthfile", "sub/earthfile"},
+		},
+	}, collisionErr)
+
+	// No collisions.
+	r, buf = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(map[string]string{
+		"README":        "readme\n",
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"other/a.txt":   "a\n",
+		"other/A.txt":   "a\n",
+	}), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, buf.String())
+
+	// Not checked unless enabled.
+	r = newTestResolver(t, ResolverOpt{})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+}
@@ -0,0 +1,52 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/util/stringutil"
+)
+
+// gitConfigListFile is the git meta file holding the git configuration in effect in the git meta
+// run, when requested.
+const gitConfigListFile = "git-config"
+
+// GitConfigEntry is an entry of the git configuration in effect when a remote reference was
+// resolved.
+type GitConfigEntry struct {
+	// Origin is where the entry comes from, e.g. "file:/etc/gitconfig", or "command line:" for
+	// the entries passed by the resolver itself.
+	Origin string
+	// Key is the key of the entry, lowercased by git except for its subsection (e.g.
+	// url.https://example.com/.insteadof), and Value its value, empty for boolean entries set
+	// without one. Credentials are scrubbed from both.
+	Key   string
+	Value string
+}
+
+// gitConfigListCommand returns the commands writing the git configuration to file, along with the
+// origin of every entry.
+func gitConfigListCommand(file string) string {
+	return fmt.Sprintf("git config --list --show-origin -z >%s 2>/dev/null || touch %s ; ", file, file)
+}
+
+// parseGitConfigList parses the output of `git config --list --show-origin -z` into the entries
+// of the configuration, in the order git applies them. Credentials are scrubbed from the keys and
+// values, and the values of extra HTTP headers, which typically carry them, are redacted.
+func parseGitConfigList(out string) []GitConfigEntry {
+	// <origin> NUL <key> [LF <value>] NUL
+	fields := strings.Split(out, "\x00")
+	var entries []GitConfigEntry
+	for i := 0; i+1 < len(fields); i += 2 {
+		key, value, _ := strings.Cut(fields[i+1], "\n")
+		if strings.HasSuffix(key, ".extraheader") && value != "" {
+			value = "***"
+		}
+		entries = append(entries, GitConfigEntry{
+			Origin: fields[i],
+			Key:    stringutil.ScrubCredentials(key),
+			Value:  stringutil.ScrubCredentials(value),
+		})
+	}
+	return entries
+}
@@ -0,0 +1,137 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+)
+
+// defaultGitCredentialsUsername is the username used along with context credentials lacking one,
+// which git hosts accept along with a token as password.
+const defaultGitCredentialsUsername = "x-access-token"
+
+// GitCredentials are the credentials used to clone remote repositories over https, for the
+// resolutions made with a context carrying them (see WithGitCredentials).
+type GitCredentials struct {
+	// Username defaults to x-access-token.
+	Username string
+	// Password is the password, or (short-lived) token.
+	Password string
+	// ExpiresAt is when the token expires, if known.
+	ExpiresAt time.Time
+	// Refresh, if set, returns fresh credentials, in place of those expired or about to expire.
+	Refresh func(ctx context.Context) (GitCredentials, error)
+}
+
+// ErrCredentialExpired is returned when the context credentials have expired before a resolution
+// starts, and could not be refreshed.
+type ErrCredentialExpired struct {
+	// Ref is the reference being resolved.
+	Ref string
+	// ExpiresAt is when the credentials expired.
+	ExpiresAt time.Time
+	// RefreshErr is the error refreshing the credentials failed with, if any.
+	RefreshErr error
+}
+
+// Error is function required by error interface.
+func (err ErrCredentialExpired) Error() string {
+	msg := fmt.Sprintf("the git credentials used to resolve %s expired at %s", err.Ref, err.ExpiresAt.UTC().Format(time.RFC3339))
+	if err.RefreshErr != nil {
+		return fmt.Sprintf("%s, and refreshing them failed: %s", msg, err.RefreshErr.Error())
+	}
+	return msg + "; pass fresh credentials (or set GitCredentials.Refresh) and try again"
+}
+
+type gitCredentialsKey struct{}
+
+// WithGitCredentials returns a context carrying the given credentials, which the resolutions made
+// with it use to clone remote repositories over https, in place of any configured for the hosts.
+// Such resolutions are not cached, and the credentials are kept out of the logs and git metadata.
+func WithGitCredentials(ctx context.Context, creds GitCredentials) context.Context {
+	return context.WithValue(ctx, gitCredentialsKey{}, creds)
+}
+
+// gitCredentialsFromContext returns the credentials carried by ctx, if any.
+func gitCredentialsFromContext(ctx context.Context) (GitCredentials, bool) {
+	creds, ok := ctx.Value(gitCredentialsKey{}).(GitCredentials)
+	return creds, ok
+}
+
+// withContextCredentials returns the clone candidates with the https urls using the credentials
+// carried by ctx, if any.
+func withContextCredentials(ctx context.Context, candidates []cloneCandidate) ([]cloneCandidate, bool) {
+	creds, ok := gitCredentialsFromContext(ctx)
+	if !ok {
+		return candidates, false
+	}
+	ret := make([]cloneCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		c.gitURL = creds.cloneURL(c.gitURL)
+		ret = append(ret, c)
+	}
+	return ret, true
+}
+
+// cloneURL returns the given url using the credentials, if it is an https url.
+func (creds GitCredentials) cloneURL(gitURL string) string {
+	if !strings.HasPrefix(gitURL, "https://") {
+		return gitURL
+	}
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return gitURL
+	}
+	username := creds.Username
+	if username == "" {
+		username = defaultGitCredentialsUsername
+	}
+	u.User = url.UserPassword(username, creds.Password)
+	return u.String()
+}
+
+// freshCredentials checks the expiry of the credentials carried by ctx, if any, before resolving
+// the given reference. Credentials which have expired, or expire within the configured margin, are
+// refreshed when possible, the returned context carrying the fresh ones. Credentials about to expire
+// are otherwise warned about, and expired ones fail with ErrCredentialExpired.
+func (gr *gitResolver) freshCredentials(ctx context.Context, ref domain.Reference) (context.Context, error) {
+	creds, ok := gitCredentialsFromContext(ctx)
+	if !ok || creds.ExpiresAt.IsZero() {
+		return ctx, nil
+	}
+	now := time.Now()
+	if creds.ExpiresAt.After(now.Add(gr.credentialExpiryMargin)) {
+		return ctx, nil
+	}
+	var refreshErr error
+	if creds.Refresh != nil {
+		fresh, err := creds.Refresh(ctx)
+		if err == nil {
+			creds = fresh
+			ctx = WithGitCredentials(ctx, creds)
+			if creds.ExpiresAt.IsZero() || creds.ExpiresAt.After(now.Add(gr.credentialExpiryMargin)) {
+				return ctx, nil
+			}
+		} else {
+			refreshErr = err
+		}
+	}
+	if !creds.ExpiresAt.After(now) {
+		return nil, ErrCredentialExpired{
+			Ref:        ref.StringCanonical(),
+			ExpiresAt:  creds.ExpiresAt,
+			RefreshErr: refreshErr,
+		}
+	}
+	var reason string
+	if refreshErr != nil {
+		reason = fmt.Sprintf(" (refreshing them failed: %s)", refreshErr.Error())
+	}
+	gr.warn(ref, WarningCredentialExpiry, "the git credentials used to resolve %s expire in %s%s, which may fail the clone",
+		ref.StringCanonical(), creds.ExpiresAt.Sub(now).Round(time.Second), reason)
+	return ctx, nil
+}
@@ -0,0 +1,106 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/platutil"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+const (
+	// gitDescribeFile is the git meta file holding the output of git describe --tags, empty when no
+	// tag is reachable from the commit.
+	gitDescribeFile = "git-describe"
+	// gitShallowFile is the git meta file holding whether the clone is shallow (true or false), when
+	// MaxDeepenDepth is set.
+	gitShallowFile = "git-shallow"
+)
+
+// gitShallowCommand returns the commands writing whether the clone is shallow to file.
+func gitShallowCommand(file string) string {
+	return fmt.Sprintf("git rev-parse --is-shallow-repository >%s 2>/dev/null || touch %s ; ", file, file)
+}
+
+// gitDeepenScript returns the shell script which fetches the requested ref ($EARTHLY_GIT_REF) into
+// a new repository at srcPath, along with the tags, at a depth doubling from 1 to maxDepth until a
+// tag is reachable from it or its history is complete. It then extracts the metadata of the commit
+// into destPath, as for gitMetaScript, along with whether the clone is still shallow. Every git
+// invocation is passed the given config (key=value) entries.
+func (gr *gitResolver) gitDeepenScript(maxDepth int, srcPath, destPath string, gitConfig []string) string {
+	src := shellescape.Quote(srcPath)
+	var sb strings.Builder
+	sb.WriteString("set -e ; ")
+	sb.WriteString(gitConfigFunc(gitConfig))
+	sb.WriteString(fmt.Sprintf("git init --quiet %s ; git -C %s remote add origin \"$EARTHLY_GIT_URL\" ; ", src, src))
+	sb.WriteString("depth=1 ; while : ; do ")
+	sb.WriteString(fmt.Sprintf("git -C %s fetch --quiet --tags --depth=\"$depth\" origin \"$EARTHLY_GIT_REF\" ; ", src))
+	sb.WriteString(fmt.Sprintf("if git -C %s describe --tags FETCH_HEAD >/dev/null 2>&1 || [ \"$(git -C %s rev-parse --is-shallow-repository)\" = false ] || [ \"$depth\" -ge %d ] ; then break ; fi ; ", src, src, maxDepth))
+	sb.WriteString(fmt.Sprintf("depth=$((depth * 2)) ; if [ \"$depth\" -gt %d ] ; then depth=%d ; fi ; ", maxDepth, maxDepth))
+	sb.WriteString("done ; ")
+	sb.WriteString(gitRecordFetchedRef(src) + " ; ")
+	sb.WriteString(fmt.Sprintf("git -C %s checkout --quiet --force \"$EARTHLY_GIT_REF\" ; ", src))
+	sb.WriteString(fmt.Sprintf("git -C %s remote set-url origin \"$EARTHLY_GIT_ORIGIN\" ; ", src))
+	sb.WriteString(fmt.Sprintf("cd %s ; set +e ; ", src))
+	sb.WriteString(gitMetaScript(destPath, gr.readSigningKey, gr.readSubmoduleStatus(), gr.readRootCommit, gr.maxCommitBodyBytes))
+	if gr.transcodeCommitEncoding {
+		sb.WriteString(gitEncodingCommand(destPath, gr.maxCommitBodyBytes))
+	}
+	sb.WriteString(gitShallowCommand(shellescape.Quote(path.Join(destPath, gitShallowFile))))
+	return sb.String()
+}
+
+// deepenShallowMeta extracts the git metadata anew out of a progressively deepened clone, when
+// MaxDeepenDepth is set and the clone the metadata of gitMetaRef has been extracted out of is
+// shallow and has no tag reachable from the commit. The metadata still lacking tags once deepened
+// is warned about. It returns the reference holding the metadata to use.
+func (gr *gitResolver) deepenShallowMeta(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, clone cloneCandidate, gitRef string, vm *outmon.VertexMeta, gitMetaRef gwclient.Reference) (gwclient.Reference, error) {
+	if gr.maxDeepenDepth <= 0 || gr.useGitExec(ref) {
+		return gitMetaRef, nil
+	}
+	untagged, err := gr.shallowWithoutTags(ctx, gitMetaRef)
+	if err != nil {
+		return nil, err
+	}
+	if !untagged {
+		return gitMetaRef, nil
+	}
+	gr.console.VerbosePrintf("the shallow clone of %s has no tags, deepening it up to %d commits\n", ref.ProjectCanonical(), gr.maxDeepenDepth)
+	deepenState, _, err := gr.execGitScript(ctx, gwClient, clone.gitURL, gitRef, clone.keyScans, platr, vm, ref, func(gitConfig []string) string {
+		return gr.gitDeepenScript(gr.maxDeepenDepth, gr.gitSrcPath, gr.gitDestPath, gitConfig)
+	})
+	if err != nil {
+		return nil, err
+	}
+	deepenRef, err := gr.cloneGitMeta(ctx, gwClient, platr, clone, deepenState)
+	if err != nil {
+		return nil, err
+	}
+	untagged, err = gr.shallowWithoutTags(ctx, deepenRef)
+	if err != nil {
+		return nil, err
+	}
+	if untagged {
+		gr.warn(ref, WarningShallowMetadata, "the clone of %s has no tag within %d commits of history, so its git metadata lacks the tags it derives from", ref.ProjectCanonical(), gr.maxDeepenDepth)
+	}
+	return deepenRef, nil
+}
+
+// shallowWithoutTags returns whether the metadata of gitMetaRef has been extracted out of a shallow
+// clone with no tag reachable from the commit.
+func (gr *gitResolver) shallowWithoutTags(ctx context.Context, gitMetaRef gwclient.Reference) (bool, error) {
+	shallowBytes, err := gr.readGitMeta(ctx, gitMetaRef, gitShallowFile)
+	if err != nil {
+		return false, err
+	}
+	describeBytes, err := gr.readGitMeta(ctx, gitMetaRef, gitDescribeFile)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(shallowBytes)) == "true" && strings.TrimSpace(string(describeBytes)) == "", nil
+}
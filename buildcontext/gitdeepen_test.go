@@ -0,0 +1,118 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitDeepenScript(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	repo := t.TempDir()
+	git(repo, "init", "--quiet", "--initial-branch=main")
+	for i := 0; i < 10; i++ {
+		git(repo, "commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("old commit %d", i))
+	}
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "tagged")
+	git(repo, "tag", "v1.0.0")
+	for i := 0; i < 12; i++ {
+		git(repo, "commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("commit %d", i))
+	}
+	head := git(repo, "rev-parse", "HEAD")
+
+	runDeepen := func(maxDepth int) map[string]string {
+		base := t.TempDir()
+		src, dest := filepath.Join(base, "src"), filepath.Join(base, "dest")
+		NoError(t, os.Mkdir(dest, 0755))
+		gr := &gitResolver{}
+		cmd := exec.Command("/bin/sh", "-c", gr.gitDeepenScript(maxDepth, src, dest, nil))
+		cmd.Dir = base
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL=file://"+repo,
+			"EARTHLY_GIT_REF=main",
+			"EARTHLY_GIT_ORIGIN=file://"+repo)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "script failed: %s", out)
+		meta := make(map[string]string)
+		for _, name := range []string{"git-hash", "git-branch", gitDescribeFile, gitShallowFile} {
+			dt, err := os.ReadFile(filepath.Join(dest, name))
+			NoError(t, err)
+			meta[name] = strings.TrimSpace(string(dt))
+		}
+		return meta
+	}
+
+	// The tag is 12 commits behind: it is reached at a depth of 16, short of the whole history.
+	meta := runDeepen(64)
+	Equal(t, head, meta["git-hash"])
+	Equal(t, "main", meta["git-branch"])
+	Equal(t, "v1.0.0-12-g"+head[:7], meta[gitDescribeFile][:len("v1.0.0-12-g")+7])
+	Equal(t, "true", meta[gitShallowFile])
+
+	// Too deep for the cap.
+	meta = runDeepen(8)
+	Equal(t, head, meta["git-hash"])
+	Empty(t, meta[gitDescribeFile])
+	Equal(t, "true", meta[gitShallowFile])
+}
+
+func TestResolveDeepenShallowMeta(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	deepenScripts := func(gwClient *fakeGwClient) int {
+		n := 0
+		for _, op := range gwClient.solvedOps(t) {
+			if exec := op.GetExec(); exec != nil && strings.Contains(exec.Meta.Args[len(exec.Meta.Args)-1], "fetch --quiet --tags --depth=\"$depth\"") {
+				n++
+			}
+		}
+		return n
+	}
+	tests := []struct {
+		name     string
+		files    map[string]string
+		maxDepth int
+		deepened bool
+	}{
+		{"shallow without tags", map[string]string{gitShallowFile: "true\n", gitDescribeFile: ""}, 64, true},
+		{"shallow with tags", map[string]string{gitShallowFile: "true\n"}, 64, false},
+		{"complete", map[string]string{gitShallowFile: "false\n", gitDescribeFile: ""}, 64, false},
+		{"disabled", map[string]string{gitShallowFile: "true\n", gitDescribeFile: ""}, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			files := map[string]string{
+				"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			}
+			for k, v := range tc.files {
+				files[k] = v
+			}
+			gwClient := newTestGwClient(files)
+			r := newTestResolver(t, ResolverOpt{MaxDeepenDepth: tc.maxDepth})
+			d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			NoError(t, err, "Resolve failed")
+			Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", d.GitMetadata.Hash)
+			if tc.deepened {
+				Equal(t, 1, deepenScripts(gwClient))
+			} else {
+				Equal(t, 0, deepenScripts(gwClient))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+// DefaultBranchOpt determines the checks and fallbacks of the default branches of remote
+// repositories.
+type DefaultBranchOpt struct {
+	// Expected are the default branches expected for remote repositories, keyed by the repository
+	// as in references (e.g. github.com/earthly/earthly). When the actual default branch of a
+	// resolved repository differs, a warning is printed, or ErrDefaultBranchMismatch is returned
+	// when Strict is set.
+	Expected map[string]string
+	Strict   bool
+	// Fallbacks are the branches, in order, the default branch of remote references falls back to,
+	// with a warning, when the HEAD of their repository points at a nonexistent branch (a stale
+	// symref, e.g. left by deleting or renaming the default branch). The first one which can be
+	// resolved is used. By default, main then master are tried. An empty, non-nil slice fails the
+	// resolution of such references instead, with ErrGitRefNoCommits or ErrStaleHead.
+	Fallbacks []string
+}
+
+// gitDefaultBranchFile is the git meta file holding the default branch of the remote repository
+// (the branch its HEAD points to), when detected.
+const gitDefaultBranchFile = "git-default-branch"
+
+// ErrDefaultBranchMismatch is returned, in strict mode, when the default branch of a remote
+// repository differs from the one expected for it. This usually means that the branch has been
+// renamed upstream (e.g. from master to main).
+type ErrDefaultBranchMismatch struct {
+	// Repo is the repository, as in references (e.g. github.com/earthly/earthly).
+	Repo string
+	// Expected is the default branch expected for the repository.
+	Expected string
+	// Actual is the default branch of the remote repository.
+	Actual string
+}
+
+// Error is function required by error interface.
+func (err ErrDefaultBranchMismatch) Error() string {
+	return fmt.Sprintf("the default branch of %s is %s, but %s was expected", err.Repo, err.Actual, err.Expected)
+}
+
+// needsMetaChecks returns whether the git meta run performs checks which are needed even when the
+// git metadata is otherwise skipped.
+func (gr *gitResolver) needsMetaChecks() bool {
+	return gr.protectedBranch != "" || len(gr.defaultBranch.Expected) > 0 || gr.tagChecks.RequireAnnotated || gr.lfs.DetectPointers || gr.lfs.Fetch || gr.maxGitRefs > 0 || gr.refAdvertiseTimeout > 0
+}
+
+// expectedDefaultBranch returns the default branch expected for the repository of the given git
+// url of a reference, with subDir being the subdirectory of the reference within the repository.
+func (gr *gitResolver) expectedDefaultBranch(refGitURL, subDir string) (string, string) {
+	repo := refGitURL
+	if subDir != "." {
+		repo = strings.TrimSuffix(repo, "/"+subDir)
+	}
+	return repo, gr.defaultBranch.Expected[repo]
+}
+
+// checkDefaultBranch compares the default branch detected by the git meta run with the expected one.
+// A mismatch is reported as a warning, or as ErrDefaultBranchMismatch in strict mode.
+func (gr *gitResolver) checkDefaultBranch(ctx context.Context, ref domain.Reference, gitMetaRef gwclient.Reference, repo, expected string) error {
+	dt, err := gr.readGitMeta(ctx, gitMetaRef, gitDefaultBranchFile)
+	if err != nil {
+		return err
+	}
+	actual := strings.TrimSpace(string(dt))
+	if actual == "" {
+		gr.console.VerbosePrintf("unable to detect the default branch of %s\n", repo)
+		return nil
+	}
+	if actual == expected {
+		return nil
+	}
+	mismatchErr := ErrDefaultBranchMismatch{
+		Repo:     repo,
+		Expected: expected,
+		Actual:   actual,
+	}
+	if gr.defaultBranch.Strict {
+		return mismatchErr
+	}
+	gr.warn(ref, WarningDefaultBranchMismatch, "%s", mismatchErr.Error())
+	return nil
+}
@@ -0,0 +1,102 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveExpectedDefaultBranch(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:feature+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile":      "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitDefaultBranchFile: "main\n",
+	}
+	newResolver := func(strict bool) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			DefaultBranch: DefaultBranchOpt{
+				Expected: map[string]string{"github.com/earthly/test": "master"},
+				Strict:   strict,
+			},
+		}), &buf
+	}
+
+	// The default branch has been renamed upstream.
+	gwClient := newTestGwClient(files)
+	r, buf := newResolver(false)
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", d.GitMetadata.Hash)
+	Contains(t, buf.String(), "the default branch of github.com/earthly/test is main, but master was expected")
+	var scripts []string
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			scripts = append(scripts, exec.Meta.Args[len(exec.Meta.Args)-1])
+		}
+	}
+	NotEmpty(t, scripts)
+	for _, script := range scripts {
+		Contains(t, script, "ls-remote --symref")
+	}
+
+	r, _ = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	var mismatchErr ErrDefaultBranchMismatch
+	True(t, errors.As(err, &mismatchErr), "unexpected error %v", err)
+	Equal(t, ErrDefaultBranchMismatch{Repo: "github.com/earthly/test", Expected: "master", Actual: "main"}, mismatchErr)
+
+	// The default branch is the expected one.
+	files[gitDefaultBranchFile] = "master\n"
+	r, buf = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, buf.String())
+
+	// Repositories without an expectation are not checked.
+	other, err := domain.ParseTarget("github.com/earthly/other:main+build")
+	NoError(t, err)
+	files[gitDefaultBranchFile] = "main\n"
+	files["Earthfile"] = files["sub/Earthfile"]
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), other)
+	NoError(t, err, "Resolve failed")
+}
+
+func TestGitCloneScriptDetectDefault(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", "git init --quiet --initial-branch=trunk && git -c user.name=test -c user.email=test@example.com commit --quiet --allow-empty -m init")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	NoError(t, err, "init repo: %s", out)
+
+	dest := t.TempDir()
+	cmd = exec.Command("/bin/sh", "-c", gitCloneScript(false, false, false, true, false, false, false, false, false, false, 0, 0, 0, "", filepath.Join(t.TempDir(), "src"), dest, nil))
+	cmd.Env = append(os.Environ(),
+		"EARTHLY_GIT_URL="+repo,
+		"EARTHLY_GIT_ORIGIN="+repo,
+	)
+	out, err = cmd.CombinedOutput()
+	NoError(t, err, "git clone script: %s", out)
+	dt, err := os.ReadFile(filepath.Join(dest, gitDefaultBranchFile))
+	NoError(t, err)
+	Equal(t, "trunk", strings.TrimSpace(string(dt)))
+}
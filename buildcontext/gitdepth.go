@@ -0,0 +1,17 @@
+package buildcontext
+
+import "strings"
+
+// cloneDepth returns the depth of the clones of the repository of the given git url of a reference
+// (e.g. github.com/earthly/earthly/examples), as per the longest key of GitCloneDepths it is
+// within, or GitCloneDepth when none is. 0 means the full history.
+func (gr *gitResolver) cloneDepth(refGitURL string) int {
+	depth := gr.gitCloneDepth
+	longest := -1
+	for k, d := range gr.gitCloneDepths {
+		if (refGitURL == k || strings.HasPrefix(refGitURL, k+"/")) && len(k) > longest {
+			depth, longest = d, len(k)
+		}
+	}
+	return depth
+}
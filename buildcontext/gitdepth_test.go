@@ -0,0 +1,124 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCloneDepth(t *testing.T) {
+	gr := &gitResolver{
+		gitCloneDepth: 1,
+		gitCloneDepths: map[string]int{
+			"github.com":              10,
+			"github.com/earthly/huge": 50,
+			"gitlab.com/earthly/full": 0,
+		},
+	}
+	tests := []struct {
+		refGitURL string
+		expected  int
+	}{
+		{"github.com/earthly/earthly", 10},
+		{"github.com/earthly/huge", 50},
+		{"github.com/earthly/huge/sub/dir", 50},
+		{"github.com/earthly/huger", 10},
+		{"gitlab.com/earthly/full/sub", 0},
+		{"gitlab.com/earthly/other", 1},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.expected, gr.cloneDepth(tt.refGitURL), tt.refGitURL)
+	}
+}
+
+func TestResolveCloneDepthPerRef(t *testing.T) {
+	tests := []struct {
+		target    string
+		gitSource bool
+	}{
+		{"github.com/earthly/huge/sub:main+build", false},
+		// Neither repos at depth 0 nor those of other hosts need running git in the git image.
+		{"github.com/earthly/full/sub:main+build", true},
+		{"gitlab.com/earthly/test/sub:main+build", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.target, func(t *testing.T) {
+			gwClient := newTestGwClient(map[string]string{
+				"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			})
+			r := newTestResolver(t, ResolverOpt{GitCloneDepths: map[string]int{
+				"github.com/earthly/huge": 1,
+				"github.com/earthly/full": 0,
+			}})
+			ref, err := domain.ParseTarget(tc.target)
+			NoError(t, err)
+			_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			NoError(t, err, "Resolve failed")
+			var gitSource bool
+			for _, op := range gwClient.solvedOps(t) {
+				if src := op.GetSource(); src != nil && strings.HasPrefix(src.Identifier, "git://") {
+					gitSource = true
+				}
+			}
+			Equal(t, tc.gitSource, gitSource)
+		})
+	}
+}
+
+func TestGitCloneScriptDepth(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	git(repo, "init", "--quiet", "--initial-branch=main")
+	for _, msg := range []string{"first", "second", "third"} {
+		git(repo, "commit", "--quiet", "--allow-empty", "-m", msg)
+	}
+	hash := git(repo, "rev-parse", "HEAD")
+
+	// run returns the hash checked out, and the number of commits of the clone.
+	run := func(gitRef string, depth int) (string, string) {
+		src := filepath.Join(t.TempDir(), "src")
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, gitRef != "", false, false, false, false, false, false, false, false, 0, 0, depth, "", src, dest, nil))
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL=file://"+repo,
+			"EARTHLY_GIT_REF="+gitRef,
+			"EARTHLY_GIT_ORIGIN=file://"+repo,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		dt, err := os.ReadFile(filepath.Join(dest, "git-hash"))
+		NoError(t, err)
+		return strings.TrimSpace(string(dt)), git(src, "rev-list", "--count", "HEAD")
+	}
+
+	for _, gitRef := range []string{"", "main", hash} {
+		cloned, commits := run(gitRef, 1)
+		Equal(t, hash, cloned, gitRef)
+		Equal(t, "1", commits, gitRef)
+		cloned, commits = run(gitRef, 2)
+		Equal(t, hash, cloned, gitRef)
+		Equal(t, "2", commits, gitRef)
+		cloned, commits = run(gitRef, 0)
+		Equal(t, hash, cloned, gitRef)
+		Equal(t, "3", commits, gitRef)
+	}
+	// Abbreviated hashes cannot be fetched on their own, and are cloned in full.
+	cloned, commits := run(hash[:8], 1)
+	Equal(t, hash, cloned)
+	Equal(t, "3", commits)
+}
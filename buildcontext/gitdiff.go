@@ -0,0 +1,155 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/platutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// ChangeStatus is the kind of change of a file between two commits, as reported by git.
+type ChangeStatus string
+
+const (
+	// ChangeAdded is a file which has been added.
+	ChangeAdded ChangeStatus = "A"
+	// ChangeModified is a file whose contents have been modified.
+	ChangeModified ChangeStatus = "M"
+	// ChangeDeleted is a file which has been deleted.
+	ChangeDeleted ChangeStatus = "D"
+	// ChangeRenamed is a file which has been renamed (and possibly modified).
+	ChangeRenamed ChangeStatus = "R"
+	// ChangeCopied is a file which has been copied from another file.
+	ChangeCopied ChangeStatus = "C"
+	// ChangeTypeChanged is a file whose type (regular file, symlink, submodule) has changed.
+	ChangeTypeChanged ChangeStatus = "T"
+)
+
+// ChangedFile is a file which differs between two commits.
+type ChangedFile struct {
+	// Status is the kind of change.
+	Status ChangeStatus
+	// Path is the path of the file in the head commit (or in the base commit, for deletions),
+	// relative to the root of the repository.
+	Path string
+	// OldPath is the path of the file in the base commit, for renames and copies.
+	OldPath string
+}
+
+// ChangedFiles resolves two remote references of the same repository, and returns the files which
+// differ between them, within the subdirectory of head. Renames are detected.
+func (r *Resolver) ChangedFiles(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, base, head domain.Reference) ([]ChangedFile, error) {
+	if !base.IsRemote() || !head.IsRemote() {
+		return nil, errors.Errorf("changed files can only be computed between remote references, got %s and %s", base.String(), head.String())
+	}
+	if base.GetGitURL() != head.GetGitURL() {
+		return nil, errors.Errorf("cannot compute changed files between references of different repositories %s and %s", base.ProjectCanonical(), head.ProjectCanonical())
+	}
+	var files []ChangedFile
+	err := r.gr.withResolveBudget(ctx, head, func(ctx context.Context) error {
+		var err error
+		files, err = r.gr.changedFiles(ctx, gwClient, platr, base, head)
+		return err
+	})
+	return files, err
+}
+
+func (gr *gitResolver) changedFiles(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, base, head domain.Reference) ([]ChangedFile, error) {
+	if gr.skipMeta {
+		return nil, errors.New("changed files cannot be computed when git metadata is skipped")
+	}
+	baseRGP, _, _, err := gr.resolveGitProject(ctx, gwClient, platr, base)
+	if err != nil {
+		return nil, err
+	}
+	headRGP, gitURL, subDir, err := gr.resolveGitProject(ctx, gwClient, platr, head)
+	if err != nil {
+		return nil, err
+	}
+	vm := &outmon.VertexMeta{
+		TargetName: head.ProjectCanonical(),
+		Internal:   true,
+	}
+	diffState, _, err := gr.execGitScript(ctx, gwClient, gitURL, headRGP.hash, headRGP.keyScans, platr, vm, head,
+		func(gitConfig []string) string {
+			return gitDiffScript(gr.gitMirror.Enabled, gr.gitSrcPath, gr.gitDestPath, subDir, gitConfig)
+		},
+		llb.AddEnv("EARTHLY_GIT_DIFF_BASE", baseRGP.hash),
+		llb.WithCustomNamef("%sGIT DIFF %s..%s", vm.ToVertexPrefix(), base.ProjectCanonical(), head.ProjectCanonical()))
+	if err != nil {
+		return nil, err
+	}
+	noCache := false
+	diffRef, err := llbutil.StateToRef(
+		ctx, gwClient, diffState, noCache,
+		platr.SubResolver(platutil.NativePlatform), nil)
+	if err != nil {
+		return nil, classifyGitError(errors.Wrap(err, "state to ref git diff"))
+	}
+	diffBytes, err := diffRef.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: "git-diff",
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "read git-diff")
+	}
+	return parseGitDiffNameStatus(string(diffBytes))
+}
+
+// gitDiffScript returns the shell script which checks out the head commit ($EARTHLY_GIT_REF) as for
+// gitCloneScript, and writes the changes from the base commit ($EARTHLY_GIT_DIFF_BASE) within
+// subDir to destPath, in the format of `git diff --name-status -z`.
+func gitDiffScript(mirror bool, srcPath, destPath, subDir string, gitConfig []string) string {
+	src := shellescape.Quote(srcPath)
+	var sb strings.Builder
+	sb.WriteString(gitCloneScript(mirror, true, false, false, false, false, false, false, false, false, 0, 0, 0, "", srcPath, destPath, gitConfig))
+	sb.WriteString("set -e ; ")
+	sb.WriteString(fmt.Sprintf("git -C %s cat-file -e \"$EARTHLY_GIT_DIFF_BASE^{commit}\" 2>/dev/null || git -C %s fetch --quiet -- \"$EARTHLY_GIT_URL\" \"$EARTHLY_GIT_DIFF_BASE\" ; ", src, src))
+	sb.WriteString(fmt.Sprintf("git -C %s diff --name-status -M -z \"$EARTHLY_GIT_DIFF_BASE\" HEAD -- %s >%s ; ",
+		src, shellescape.Quote(subDir), shellescape.Quote(path.Join(destPath, "git-diff"))))
+	return sb.String()
+}
+
+// parseGitDiffNameStatus parses the output of `git diff --name-status -z`. Each entry is made of the
+// status, followed by the path, or by the old and new paths for renames and copies.
+func parseGitDiffNameStatus(out string) ([]ChangedFile, error) {
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	if len(fields) == 1 && fields[0] == "" {
+		return nil, nil
+	}
+	var files []ChangedFile
+	for i := 0; i < len(fields); i++ {
+		status := fields[i]
+		if status == "" {
+			return nil, errors.Errorf("unexpected empty git diff status at entry %d", len(files))
+		}
+		// The status of renames and copies is followed by the similarity score, e.g. R087.
+		cf := ChangedFile{Status: ChangeStatus(status[:1])}
+		switch cf.Status {
+		case ChangeRenamed, ChangeCopied:
+			if i+2 >= len(fields) {
+				return nil, errors.Errorf("truncated git diff entry %q", status)
+			}
+			cf.OldPath = fields[i+1]
+			cf.Path = fields[i+2]
+			i += 2
+		default:
+			if i+1 >= len(fields) {
+				return nil, errors.Errorf("truncated git diff entry %q", status)
+			}
+			cf.Path = fields[i+1]
+			i++
+		}
+		files = append(files, cf)
+	}
+	return files, nil
+}
@@ -0,0 +1,124 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitDiffScript(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		p := filepath.Join(repo, name)
+		NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+	write("sub/a.txt", "a\n")
+	write("sub/b.txt", "b\n")
+	write("sub/c.txt", "some content which is long enough to be detected as renamed\n")
+	write("other/x.txt", "x\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "base")
+	baseHash := git("rev-parse", "HEAD")
+
+	write("sub/a.txt", "a, modified\n")
+	git("rm", "--quiet", "sub/b.txt")
+	git("mv", "sub/c.txt", "sub/d.txt")
+	write("sub/e.txt", "e\n")
+	write("other/x.txt", "x, modified\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "head")
+	headHash := git("rev-parse", "HEAD")
+
+	dest := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", gitDiffScript(false, filepath.Join(t.TempDir(), "src"), dest, "sub", nil))
+	cmd.Env = append(os.Environ(),
+		"EARTHLY_GIT_URL="+repo,
+		"EARTHLY_GIT_ORIGIN="+repo,
+		"EARTHLY_GIT_REF="+headHash,
+		"EARTHLY_GIT_DIFF_BASE="+baseHash,
+	)
+	out, err := cmd.CombinedOutput()
+	NoError(t, err, "git diff script: %s", out)
+	diff, err := os.ReadFile(filepath.Join(dest, "git-diff"))
+	NoError(t, err)
+	files, err := parseGitDiffNameStatus(string(diff))
+	NoError(t, err)
+	Equal(t, []ChangedFile{
+		{Status: ChangeModified, Path: "sub/a.txt"},
+		{Status: ChangeDeleted, Path: "sub/b.txt"},
+		{Status: ChangeRenamed, Path: "sub/d.txt", OldPath: "sub/c.txt"},
+		{Status: ChangeAdded, Path: "sub/e.txt"},
+	}, files)
+}
+
+func TestResolveChangedFiles(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"git-diff":      "M\x00sub/main.go\x00R095\x00sub/old.go\x00sub/new.go\x00D\x00sub/gone.go\x00",
+	})
+	r := newTestResolver(t, ResolverOpt{})
+	base, err := domain.ParseTarget("github.com/earthly/test/sub:v1.0.0+build")
+	NoError(t, err)
+	head, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	files, err := r.ChangedFiles(context.Background(), gwClient, newTestPlatformResolver(), base, head)
+	NoError(t, err, "ChangedFiles failed")
+	Equal(t, []ChangedFile{
+		{Status: ChangeModified, Path: "sub/main.go"},
+		{Status: ChangeRenamed, Path: "sub/new.go", OldPath: "sub/old.go"},
+		{Status: ChangeDeleted, Path: "sub/gone.go"},
+	}, files)
+
+	var diffRuns int
+	for _, op := range gwClient.solvedOps(t) {
+		exec := op.GetExec()
+		if exec == nil || !strings.Contains(exec.Meta.Args[len(exec.Meta.Args)-1], "git-diff") {
+			continue
+		}
+		diffRuns++
+		Contains(t, exec.Meta.Env, "EARTHLY_GIT_DIFF_BASE=a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5")
+		Contains(t, exec.Meta.Env, "EARTHLY_GIT_REF=a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5")
+	}
+	Equal(t, 1, diffRuns)
+
+	other, err := domain.ParseTarget("github.com/earthly/other:main+build")
+	NoError(t, err)
+	_, err = r.ChangedFiles(context.Background(), gwClient, newTestPlatformResolver(), other, head)
+	Error(t, err)
+}
+
+func TestParseGitDiffNameStatus(t *testing.T) {
+	files, err := parseGitDiffNameStatus("")
+	NoError(t, err)
+	Empty(t, files)
+
+	files, err = parseGitDiffNameStatus("A\x00with spaces.txt\x00C100\x00a\x00b\x00")
+	NoError(t, err)
+	Equal(t, []ChangedFile{
+		{Status: ChangeAdded, Path: "with spaces.txt"},
+		{Status: ChangeCopied, Path: "b", OldPath: "a"},
+	}, files)
+
+	_, err = parseGitDiffNameStatus("R100\x00a\x00")
+	Error(t, err)
+}
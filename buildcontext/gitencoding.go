@@ -0,0 +1,71 @@
+package buildcontext
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// gitEncodingFile is the git meta file holding the encoding declared in the commit, empty when none
+// is (i.e. for UTF-8), when TranscodeCommitEncoding is set.
+const gitEncodingFile = "git-encoding"
+
+// gitEncodingCommand returns the commands writing the encoding declared in the commit into destPath,
+// and, when one is declared, writing the author and the body of the commit anew, as they are stored
+// in the commit rather than re-encoded by git (which depends on the iconv of the git image).
+// maxBodyBytes caps the body as for gitMetaScript.
+func gitEncodingCommand(destPath string, maxBodyBytes int) string {
+	dest := func(name string) string {
+		return shellescape.Quote(path.Join(destPath, name))
+	}
+	// git log outputs the commit as is when asked for the encoding it declares.
+	return fmt.Sprintf("enc=$(git log -1 --format=%%e 2>/dev/null) ; printf '%%s' \"$enc\" >%s ; ", dest(gitEncodingFile)) +
+		"if [ -n \"$enc\" ] ; then " +
+		fmt.Sprintf("git log -1 --encoding=\"$enc\" --format=%%ae >%s || touch %s ; ", dest("git-author"), dest("git-author")) +
+		gitBodyCommand(dest("git-body"), " --encoding=\"$enc\"", maxBodyBytes) +
+		"fi ; "
+}
+
+// commitEncoding returns the encoding of the given name, as declared in a commit, or nil when the
+// text of the commit is UTF-8.
+func commitEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// transcodeCommit decodes the author and the body of a commit out of the encoding it declares into
+// UTF-8. Unknown encodings are warned about, and the text left as is.
+func (gr *gitResolver) transcodeCommit(ref domain.Reference, encName string, author, body []byte) ([]byte, []byte) {
+	encName = strings.TrimSpace(encName)
+	enc, err := commitEncoding(encName)
+	if err == nil && enc == nil {
+		return author, body
+	}
+	if err == nil {
+		var decodedAuthor, decodedBody []byte
+		decodedAuthor, err = enc.NewDecoder().Bytes(author)
+		if err == nil {
+			decodedBody, err = enc.NewDecoder().Bytes(body)
+		}
+		if err == nil {
+			return decodedAuthor, decodedBody
+		}
+	}
+	gr.warn(ref, WarningCommitEncoding, "unable to decode the commit of %s out of its declared encoding %s: %s; using it as is", ref.ProjectCanonical(), encName, err.Error())
+	return author, body
+}
@@ -0,0 +1,97 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitEncodingCommand(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(env []string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+	}
+	runMeta := func() map[string]string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitMetaScript(dest, false, false, false, 0)+gitEncodingCommand(dest, 0))
+		cmd.Dir = repo
+		_ = cmd.Run()
+		files := make(map[string]string)
+		for _, name := range []string{gitEncodingFile, "git-author", "git-body"} {
+			dt, err := os.ReadFile(filepath.Join(dest, name))
+			NoError(t, err)
+			files[name] = string(dt)
+		}
+		return files
+	}
+	git(nil, "init", "--quiet")
+
+	// A Latin-1 commit is read as it is stored.
+	latin1 := []string{"GIT_AUTHOR_EMAIL=jos\xe9@example.com"}
+	git(latin1, "-c", "i18n.commitEncoding=ISO-8859-1", "commit", "--quiet", "--allow-empty", "-m", "Caf\xe9\n\nCo-authored-by: J\xf6rg <j\xf6rg@example.com>")
+	files := runMeta()
+	Equal(t, "ISO-8859-1", files[gitEncodingFile])
+	Equal(t, "jos\xe9@example.com\n", files["git-author"])
+	Contains(t, files["git-body"], "<j\xf6rg@example.com>")
+
+	// UTF-8 commits declare no encoding.
+	git([]string{"GIT_AUTHOR_EMAIL=josé@example.com"}, "commit", "--quiet", "--allow-empty", "-m", "Café")
+	files = runMeta()
+	Equal(t, "", files[gitEncodingFile])
+	Equal(t, "josé@example.com\n", files["git-author"])
+}
+
+func TestResolveCommitEncoding(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	newGwClient := func(encoding string) *fakeGwClient {
+		return newTestGwClient(map[string]string{
+			"Earthfile":     "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			gitEncodingFile: encoding,
+			"git-author":    "jos\xe9@example.com\n",
+			"git-body":      "R\xe9sum\xe9\n\nCo-authored-by: J\xf6rg <j\xf6rg@example.com>\n",
+		})
+	}
+
+	r := newTestResolver(t, ResolverOpt{TranscodeCommitEncoding: true})
+	d, err := r.Resolve(context.Background(), newGwClient("ISO-8859-1"), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "ISO-8859-1", d.GitMetadata.Encoding)
+	Equal(t, "josé@example.com", d.GitMetadata.Author)
+	Equal(t, []string{"jörg@example.com"}, d.GitMetadata.CoAuthors)
+
+	// Unknown encodings are warned about, and the text used as is.
+	var warnings []Warning
+	r = newTestResolver(t, ResolverOpt{
+		TranscodeCommitEncoding: true,
+		OnWarning: func(w Warning) {
+			warnings = append(warnings, w)
+		},
+	})
+	d, err = r.Resolve(context.Background(), newGwClient("x-unknown"), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "x-unknown", d.GitMetadata.Encoding)
+	Equal(t, "jos\xe9@example.com", d.GitMetadata.Author)
+	if Len(t, warnings, 1) {
+		Equal(t, WarningCommitEncoding, warnings[0].Code)
+	}
+
+	// Left to git unless enabled.
+	r = newTestResolver(t, ResolverOpt{})
+	d, err = r.Resolve(context.Background(), newGwClient("ISO-8859-1"), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.GitMetadata.Encoding)
+	Equal(t, "jos\xe9@example.com", d.GitMetadata.Author)
+}
@@ -0,0 +1,128 @@
+package buildcontext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/util/stringutil"
+)
+
+// ErrGitAuthSSO is returned when a git server rejects a clone because the credentials used have not
+// been authorized for the SAML SSO of the organization owning the repository, or because the account
+// requires two-factor authentication and a password was used.
+type ErrGitAuthSSO struct {
+	// Org is the organization enforcing SSO, if known.
+	Org string
+	// TwoFactor is set when the rejection is due to two-factor authentication rather than SSO.
+	TwoFactor bool
+	// Detail is the message returned by the git server, with credentials scrubbed.
+	Detail string
+	// Err is the underlying error of the rejected clone. It is left out of the message, as it may
+	// hold credentials.
+	Err error
+}
+
+// Error is function required by error interface.
+func (err ErrGitAuthSSO) Error() string {
+	var msg string
+	switch {
+	case err.TwoFactor:
+		msg = "git authentication failed: the account requires two-factor authentication; use a personal access token or an ssh key instead of a password"
+	case err.Org != "":
+		msg = fmt.Sprintf("git authentication failed: the token or ssh key used is not authorized for the SAML SSO of organization %s; authorize it for %s (e.g. under https://github.com/settings/tokens) and try again", err.Org, err.Org)
+	default:
+		msg = "git authentication failed: the token or ssh key used is not authorized for the SAML SSO of the organization owning the repository; authorize it for the organization and try again"
+	}
+	if err.Detail != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, err.Detail)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (err ErrGitAuthSSO) Unwrap() error {
+	return err.Err
+}
+
+// ErrGitRefNoCommits is returned when a remote reference resolves to a branch which has no commits
+// yet, in a repository which otherwise has some (see ErrEmptyRepository).
+type ErrGitRefNoCommits struct {
+	// Ref is the canonical form of the reference.
+	Ref string
+	// Branch is the unborn branch HEAD points to.
+	Branch string
+}
+
+// Error is function required by error interface.
+func (err ErrGitRefNoCommits) Error() string {
+	return fmt.Sprintf("%s has no commits: branch %s is unborn", err.Ref, err.Branch)
+}
+
+// ErrEmptyRepository is returned when a remote reference points to a repository which has no commits
+// at all, such as a freshly created one.
+type ErrEmptyRepository struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+}
+
+// Error is function required by error interface.
+func (err ErrEmptyRepository) Error() string {
+	return fmt.Sprintf("repository %s is empty: push a first commit to it before referencing it", err.Repo)
+}
+
+var gitEmptyRepoRegexp = regexp.MustCompile(`(?i)(you appear to have cloned an empty repository|couldn't find remote ref HEAD\b)`)
+
+// isEmptyRepositoryError returns whether err is the failure of buildkit to clone the default branch
+// of an empty repository, which has no HEAD to fetch.
+func isEmptyRepositoryError(err error) bool {
+	return gitEmptyRepoRegexp.MatchString(err.Error())
+}
+
+var (
+	gitSSOOrgRegexp   = regexp.MustCompile(`The '([^']+)' organization has enabled or enforced SAML SSO`)
+	gitSSORegexp      = regexp.MustCompile(`(?i)(SAML SSO|single sign-on)`)
+	gitTwoFactorRegex = regexp.MustCompile(`(?i)(two-factor authentication|2FA enabled|Support for password authentication was removed)`)
+)
+
+// classifyGitError maps errors of a rejected clone to more actionable typed errors, based on the
+// stderr of git as embedded in the error. Errors which aren't recognized are returned as is.
+func classifyGitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if m := gitSSOOrgRegexp.FindStringSubmatch(msg); m != nil {
+		return ErrGitAuthSSO{
+			Org:    m[1],
+			Detail: gitServerLine(msg, m[0]),
+			Err:    err,
+		}
+	}
+	if m := gitSSORegexp.FindString(msg); m != "" {
+		return ErrGitAuthSSO{
+			Detail: gitServerLine(msg, m),
+			Err:    err,
+		}
+	}
+	if m := gitTwoFactorRegex.FindString(msg); m != "" {
+		return ErrGitAuthSSO{
+			TwoFactor: true,
+			Detail:    gitServerLine(msg, m),
+			Err:       err,
+		}
+	}
+	return err
+}
+
+// gitServerLine returns the scrubbed line of msg containing the given match.
+func gitServerLine(msg, match string) string {
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.Contains(line, match) {
+			line = strings.TrimSpace(line)
+			line = strings.TrimPrefix(line, "remote: ")
+			return stringutil.ScrubCredentials(line)
+		}
+	}
+	return ""
+}
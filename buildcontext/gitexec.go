@@ -0,0 +1,348 @@
+package buildcontext
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	gitMirrorDir      = "/git-mirror"
+	gitKnownHostsDir  = "/git-known-hosts"
+	gitObjectStoreDir = "/git-objects"
+
+	// gitProtectedAncestorFile holds the exit code of git merge-base --is-ancestor, when checking
+	// the resolved commit against the protected branch.
+	gitProtectedAncestorFile = "git-protected-ancestor"
+
+	// gitSparsePatternsFile is the file of remote repositories listing the paths to check out, with
+	// RepoSparsePatterns.
+	gitSparsePatternsFile = ".earthly-sparse"
+)
+
+// useGitExec returns whether the remote reference is cloned by running git in the git image, rather
+// than via the buildkit git source.
+func (gr *gitResolver) useGitExec(ref domain.Reference) bool {
+	return gr.gitMirror.Enabled || gr.cloneInGitImage(ref) || gr.needsMetaChecks()
+}
+
+// cloneInGitImage returns whether every clone of the remote reference, including those made straight
+// at the requested ref, must be made by running git in the git image, as the buildkit git source
+// lacks the needed setup.
+func (gr *gitResolver) cloneInGitImage(ref domain.Reference) bool {
+	return gr.hasGitTLS() || len(gr.gitExtraHosts) > 0 || gr.gitTransfer.isSet() || gr.repoSparsePatterns || len(gr.gitObjectStores) > 0 || gr.maxGitRefs > 0 || gr.refAdvertiseTimeout > 0 || gr.cloneDepth(ref.GetGitURL()) > 0 || gr.lfs.Fetch
+}
+
+// execGitMeta returns the git meta state and the build context state of a remote reference, both
+// produced by a single run of git in the git image. With the mirror cache, the repository is
+// fetched into a bare mirror kept in a persistent cache mount, on every build, and the mirror
+// maintained on schedule.
+func (gr *gitResolver) execGitMeta(ctx context.Context, gwClient gwclient.Client, gitURL, gitRef string, keyScans []string, platr *platutil.Resolver, vm *outmon.VertexMeta, ref domain.Reference) (pllb.State, pllb.State, error) {
+	return gr.execGitScript(ctx, gwClient, gitURL, gitRef, keyScans, platr, vm, ref, func(gitConfig []string) string {
+		var script string
+		if gr.refAdvertiseTimeout > 0 {
+			script = gitAdvertiseCheck(gr.refAdvertiseTimeout, gr.gitDestPath, gitConfig)
+		}
+		script += gitCloneScript(gr.gitMirror.Enabled, gitRef != "", gr.protectedBranch != "", len(gr.defaultBranch.Expected) > 0, gr.tagChecks.RequireAnnotated, gr.lfs.DetectPointers, gr.readSigningKey, gr.readSubmoduleStatus(), gr.readRootCommit, gr.repoSparsePatterns, gr.maxCommitBodyBytes, gr.maxGitRefs, gr.cloneDepth(ref.GetGitURL()), gr.objectStoreDir(gitURL), gr.gitSrcPath, gr.gitDestPath, gitConfig)
+		if gr.readGitConfig {
+			script += gitConfigListCommand(shellescape.Quote(path.Join(gr.gitDestPath, gitConfigListFile)))
+		}
+		if gr.transcodeCommitEncoding {
+			script += gitEncodingCommand(gr.gitDestPath, gr.maxCommitBodyBytes)
+		}
+		if gr.lfs.Fetch {
+			script += gitLFSObjectsCommand(gr.gitDestPath)
+		}
+		if gr.gitMirror.Enabled && gr.gitMirror.MaintenanceInterval > 0 {
+			script += gitMirrorMaintenanceScript(gitMirrorDir, gr.gitMirror.MaintenanceInterval, gr.gitMirror.MaintenanceTimeout)
+		}
+		return script
+	})
+}
+
+// execGitScript runs the script returned by makeScript in the git image, with access to the
+// repository (and the mirror cache, if enabled) set up as for execGitMeta. It returns the states of
+// the dest and src mounts. Additional run options (e.g. env vars used by the script) may be passed.
+func (gr *gitResolver) execGitScript(ctx context.Context, gwClient gwclient.Client, gitURL, gitRef string, keyScans []string, platr *platutil.Resolver, vm *outmon.VertexMeta, ref domain.Reference, makeScript func(gitConfig []string) string, extraOpts ...llb.RunOption) (pllb.State, pllb.State, error) {
+	tlsOpts, gitConfig, err := gr.gitTLSRunOpts(ctx)
+	if err != nil {
+		return pllb.State{}, pllb.State{}, err
+	}
+	transferConfig, err := gr.gitTransfer.gitConfig()
+	if err != nil {
+		return pllb.State{}, pllb.State{}, err
+	}
+	gitConfig = append(gitConfig, transferConfig...)
+	opImg, err := gr.gitImageState(ctx, gwClient, platr)
+	if err != nil {
+		return pllb.State{}, pllb.State{}, err
+	}
+	script := makeScript(gitConfig)
+	gr.logGitCommand(ref, gitURL, gitRef, script, false)
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", script}),
+		llb.AddEnv("EARTHLY_GIT_URL", gitURL),
+		llb.AddEnv("EARTHLY_GIT_REF", gitRef),
+		llb.AddEnv("EARTHLY_GIT_ORIGIN", stripGitURLCredentials(gitURL)),
+		llb.AddEnv(gitVerbosityEnv, gr.gitCloneVerbosity.flags()),
+	}
+	if gr.protectedBranch != "" {
+		runOpts = append(runOpts, llb.AddEnv("EARTHLY_GIT_PROTECTED_BRANCH", gr.protectedBranch))
+	}
+	runOpts = append(runOpts, tlsOpts...)
+	extraHostOpts, err := gitExtraHostRunOpts(gr.gitExtraHosts)
+	if err != nil {
+		return pllb.State{}, pllb.State{}, err
+	}
+	runOpts = append(runOpts, extraHostOpts...)
+	if upstream, ok := gr.gitObjectStores[stripGitURLCredentials(gitURL)]; ok {
+		runOpts = append(runOpts,
+			pllb.AddMount(gitObjectStoreDir, pllb.Scratch(),
+				llb.AsPersistentCacheDir(gitObjectStoreCacheID(upstream), llb.CacheMountLocked)))
+	}
+	if gr.gitMirror.Enabled {
+		runOpts = append(runOpts,
+			pllb.AddMount(gitMirrorDir, pllb.Scratch(),
+				llb.AsPersistentCacheDir(gitMirrorCacheID(gitURL), llb.CacheMountLocked)),
+			llb.IgnoreCache,
+			llb.WithCustomNamef("%sGIT MIRROR FETCH %s", vm.ToVertexPrefix(), ref.ProjectCanonical()))
+	} else {
+		runOpts = append(runOpts,
+			llb.WithCustomNamef("%sGIT CLONE %s", vm.ToVertexPrefix(), ref.ProjectCanonical()))
+	}
+	runOpts = append(runOpts, gitSSHRunOpts(gitURL, keyScans)...)
+	runOpts = append(runOpts, extraOpts...)
+	cloneOp := opImg.Run(runOpts...)
+	gitMetaState := cloneOp.AddMount(gr.gitDestPath, platr.Scratch())
+	gitSrcState := cloneOp.AddMount(gr.gitSrcPath, pllb.Scratch())
+	return gitMetaState, gitSrcState, nil
+}
+
+// gitSSHRunOpts returns the run options giving git access to the ssh agent, and the known hosts
+// of the given key scans, for ssh git urls.
+func gitSSHRunOpts(gitURL string, keyScans []string) []llb.RunOption {
+	if !isSSHGitURL(gitURL) {
+		return nil
+	}
+	var runOpts []llb.RunOption
+	sshCommand := "ssh -o StrictHostKeyChecking=no"
+	if len(keyScans) > 0 {
+		knownHosts := pllb.Scratch().File(
+			pllb.Mkfile("known_hosts", 0644, []byte(strings.Join(keyScans, "\n")+"\n")))
+		runOpts = append(runOpts, pllb.AddMount(gitKnownHostsDir, knownHosts, llb.Readonly))
+		sshCommand = fmt.Sprintf("ssh -o UserKnownHostsFile=%s/known_hosts", gitKnownHostsDir)
+	}
+	return append(runOpts,
+		llb.AddSSHSocket(),
+		llb.AddEnv("GIT_SSH_COMMAND", sshCommand))
+}
+
+// gitCloneScript returns the shell script which clones the repository (bringing the mirror up to
+// date, or creating it, when mirror is set), checks out the requested ref into srcPath and extracts
+// its metadata into destPath. When checkProtected is set, the exit code of checking whether the
+// checked out commit is an ancestor of the protected branch is written to destPath as well. When
+// detectDefault is set, so is the default branch of the remote repository. When tagKinds is set, so
+// are the object types of the tags pointing at the checked out commit. When lfsPointers is set, so
+// are the paths of the git LFS pointer files checked out. When signingKey is set, so is the key the
+// commit is signed with. When submoduleStatus is set, so are the commits the submodules are pinned
+// to. When rootCommit is set, so are the root commits of its history. When objectStore is set, the
+// objects of the shared object store at that path are reused by the clone, and the store updated
+// with those of the repository. When sparse is set, and the
+// checked out commit has a gitSparsePatternsFile, only the paths it matches are checked out.
+// maxBodyBytes, if set, caps the commit message body. maxRefs, if set, is the number of refs of the
+// repository past which the script stops, recording it in destPath, unless the requested ref can be
+// fetched on its own. depth, if set, is the depth of the history cloned, unless mirror is set: the
+// requested ref is then fetched on its own, at that depth, falling back to a full clone when it
+// cannot be (e.g. an abbreviated commit hash). The remote clones and fetches are as verbose as the flags of
+// $EARTHLY_GIT_VERBOSITY. Every git invocation is passed the given config (key=value) entries.
+func gitCloneScript(mirror, hasRef, checkProtected, detectDefault, tagKinds, lfsPointers, signingKey, submoduleStatus, rootCommit, sparse bool, maxBodyBytes, maxRefs, depth int, objectStore, srcPath, destPath string, gitConfig []string) string {
+	src := shellescape.Quote(srcPath)
+	// The checkout is deferred until the sparse patterns are known.
+	noCheckout := hasRef || sparse
+	var sb strings.Builder
+	sb.WriteString("set -e ; ")
+	sb.WriteString(gitConfigFunc(gitConfig))
+	if objectStore != "" {
+		store := shellescape.Quote(objectStore)
+		sb.WriteString(fmt.Sprintf("[ -f %s/HEAD ] || git init --quiet --bare %s ; ", store, store))
+	}
+	if maxRefs > 0 {
+		sb.WriteString(fmt.Sprintf(": >%s ; ", shellescape.Quote(path.Join(destPath, gitTooManyRefsFile))))
+	}
+	if mirror {
+		if maxRefs > 0 {
+			// The mirror holds every branch and tag.
+			sb.WriteString(gitRefCountCheck(maxRefs, destPath))
+		}
+		sb.WriteString(fmt.Sprintf("if [ -f %s/HEAD ]; then ", gitMirrorDir))
+		sb.WriteString(fmt.Sprintf("git -C %s fetch $EARTHLY_GIT_VERBOSITY --prune --force -- \"$EARTHLY_GIT_URL\" '+refs/heads/*:refs/heads/*' '+refs/tags/*:refs/tags/*' ; ", gitMirrorDir))
+		sb.WriteString("else ")
+		// The mirror is created without a remote url, so that credentials are not persisted in the cache.
+		sb.WriteString(fmt.Sprintf("%s && git -C %s config --unset remote.origin.url ; ", gitCloneRemote(" --bare", gitMirrorDir, objectStore), gitMirrorDir))
+		sb.WriteString("fi ; ")
+		if hasRef {
+			// The ref may be a commit which is not reachable from any branch or tag.
+			sb.WriteString(fmt.Sprintf("git -C %s cat-file -e \"$EARTHLY_GIT_REF^{commit}\" 2>/dev/null || git -C %s fetch $EARTHLY_GIT_VERBOSITY -- \"$EARTHLY_GIT_URL\" \"$EARTHLY_GIT_REF\" ; ", gitMirrorDir, gitMirrorDir))
+		}
+		if noCheckout {
+			sb.WriteString(fmt.Sprintf("git clone --quiet --no-checkout %s %s ; ", gitMirrorDir, src))
+		} else {
+			sb.WriteString(fmt.Sprintf("git clone --quiet %s %s ; ", gitMirrorDir, src))
+		}
+	} else {
+		targeted := (maxRefs > 0 || depth > 0) && hasRef
+		if targeted {
+			sb.WriteString(fmt.Sprintf("if %s ; then : ; else rm -rf %s ; ", gitTargetedFetch(src, depth), src))
+		}
+		if maxRefs > 0 {
+			sb.WriteString(gitRefCountCheck(maxRefs, destPath))
+		}
+		var flags string
+		if noCheckout {
+			flags = " --no-checkout"
+		}
+		if depth > 0 && !hasRef {
+			flags += fmt.Sprintf(" --depth=%d", depth)
+		}
+		sb.WriteString(gitCloneRemote(flags, src, objectStore) + " ; ")
+		if hasRef {
+			sb.WriteString(fmt.Sprintf("git -C %s cat-file -e \"$EARTHLY_GIT_REF^{commit}\" 2>/dev/null || git -C %s fetch $EARTHLY_GIT_VERBOSITY -- \"$EARTHLY_GIT_URL\" \"$EARTHLY_GIT_REF\" ; ", src, src))
+		}
+		if targeted {
+			sb.WriteString("fi ; ")
+		}
+	}
+	rev := "HEAD"
+	if hasRef {
+		rev = "\"$EARTHLY_GIT_REF\""
+	}
+	if sparse {
+		patterns := shellescape.Quote(gitSparsePatternsFile)
+		sb.WriteString(fmt.Sprintf("if git -C %s cat-file -e %s:%s 2>/dev/null ; then ", src, rev, patterns))
+		sb.WriteString(fmt.Sprintf("mkdir -p %s/.git/info && git -C %s show %s:%s >%s/.git/info/sparse-checkout && git -C %s config core.sparseCheckout true ; ", src, src, rev, patterns, src, src))
+		sb.WriteString("fi ; ")
+	}
+	if noCheckout {
+		sb.WriteString(fmt.Sprintf("git -C %s checkout --quiet --force %s ; ", src, rev))
+	}
+	if checkProtected {
+		remote := "\"$EARTHLY_GIT_URL\""
+		if mirror {
+			remote = gitMirrorDir
+		}
+		sb.WriteString(fmt.Sprintf("git -C %s fetch --quiet -- %s \"refs/heads/$EARTHLY_GIT_PROTECTED_BRANCH\" ; ", src, remote))
+		sb.WriteString(fmt.Sprintf("rc=0 ; git -C %s merge-base --is-ancestor HEAD FETCH_HEAD || rc=$? ; echo $rc >%s ; ",
+			src, shellescape.Quote(path.Join(destPath, gitProtectedAncestorFile))))
+	}
+	if detectDefault {
+		// The branch HEAD points to, out of e.g. "ref: refs/heads/main<tab>HEAD".
+		sb.WriteString(fmt.Sprintf("git ls-remote --symref -- \"$EARTHLY_GIT_URL\" HEAD | awk '$1 == \"ref:\" && $3 == \"HEAD\" { sub(\"^refs/heads/\", \"\", $2) ; print $2 }' >%s ; ",
+			shellescape.Quote(path.Join(destPath, gitDefaultBranchFile))))
+	}
+	if tagKinds {
+		sb.WriteString(fmt.Sprintf("git -C %s for-each-ref --points-at HEAD --format='%%(refname:strip=2) %%(objecttype)' refs/tags >%s ; ",
+			src, shellescape.Quote(path.Join(destPath, gitTagKindsFile))))
+	}
+	if lfsPointers {
+		var patterns strings.Builder
+		for _, pattern := range gitLFSPointerPatterns {
+			patterns.WriteString(" -e " + shellescape.Quote(pattern))
+		}
+		// git grep fails when nothing matches.
+		sb.WriteString(fmt.Sprintf("{ git -C %s grep -l -z -I --full-name --all-match -E%s || true ; } >%s ; ",
+			src, patterns.String(), shellescape.Quote(path.Join(destPath, gitLFSPointersFile))))
+	}
+	if objectStore != "" {
+		// The refs of each repository are kept in a namespace of their own, for their objects not to
+		// be pruned from the store.
+		sb.WriteString("ns=$(printf %s \"$EARTHLY_GIT_ORIGIN\" | git hash-object --stdin) ; ")
+		sb.WriteString(fmt.Sprintf("git -C %s fetch --quiet --no-tags -- %s \"+refs/remotes/origin/*:refs/earthly/$ns/heads/*\" \"+refs/tags/*:refs/earthly/$ns/tags/*\" \"+HEAD:refs/earthly/$ns/HEAD\" || true ; ",
+			shellescape.Quote(objectStore), src))
+	}
+	sb.WriteString(fmt.Sprintf("git -C %s remote set-url origin \"$EARTHLY_GIT_ORIGIN\" ; ", src))
+	sb.WriteString(fmt.Sprintf("cd %s ; set +e ; ", src))
+	sb.WriteString(gitMetaScript(destPath, signingKey, submoduleStatus, rootCommit, maxBodyBytes))
+	return sb.String()
+}
+
+// gitConfigFunc returns the shell function passing the given config (key=value) entries to every
+// git invocation of a script, if any.
+func gitConfigFunc(gitConfig []string) string {
+	if len(gitConfig) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("git() { command git")
+	for _, c := range gitConfig {
+		sb.WriteString(fmt.Sprintf(" -c %s", shellescape.Quote(c)))
+	}
+	sb.WriteString(" \"$@\" ; } ; ")
+	return sb.String()
+}
+
+var gitHostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// gitExtraHostRunOpts returns the run options adding the given host -> IP entries to /etc/hosts.
+func gitExtraHostRunOpts(extraHosts map[string]string) ([]llb.RunOption, error) {
+	hosts := make([]string, 0, len(extraHosts))
+	for host := range extraHosts {
+		hosts = append(hosts, host)
+	}
+	// Sorted, for the runs to be cached.
+	sort.Strings(hosts)
+	var runOpts []llb.RunOption
+	for _, host := range hosts {
+		if len(host) > 253 || !gitHostnameRegexp.MatchString(host) {
+			return nil, errors.Errorf("invalid git extra host name %q", host)
+		}
+		ip := net.ParseIP(extraHosts[host])
+		if ip == nil {
+			return nil, errors.Errorf("invalid IP address %q for git extra host %s", extraHosts[host], host)
+		}
+		runOpts = append(runOpts, llb.AddExtraHost(host, ip))
+	}
+	return runOpts, nil
+}
+
+// gitMirrorCacheID returns the id of the cache mount holding the mirror of the given repository.
+func gitMirrorCacheID(gitURL string) string {
+	return fmt.Sprintf("earthly-git-mirror-%x", sha256.Sum256([]byte(stripGitURLCredentials(gitURL))))
+}
+
+// stripGitURLCredentials removes any user info from an http(s) git url.
+func stripGitURLCredentials(gitURL string) string {
+	if !isHTTPGitURL(gitURL) {
+		return gitURL
+	}
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return gitURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+func isHTTPGitURL(gitURL string) bool {
+	return strings.HasPrefix(gitURL, "https://") || strings.HasPrefix(gitURL, "http://")
+}
+
+// isSSHGitURL returns whether the git url is cloned over ssh. The anonymous git:// protocol needs
+// neither an ssh agent nor known hosts.
+func isSSHGitURL(gitURL string) bool {
+	return !isHTTPGitURL(gitURL) && !strings.HasPrefix(gitURL, "git://")
+}
@@ -0,0 +1,234 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveGitExtraHosts(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	extraHosts := map[string]string{
+		"github.com":          "10.0.0.2",
+		"git.internal.corp":   "10.0.0.3",
+		"ipv6.internal.corp":  "fd00::4",
+		"single-label-host-1": "10.0.0.5",
+	}
+	expected := []*pb.HostIP{
+		{Host: "git.internal.corp", IP: "10.0.0.3"},
+		{Host: "github.com", IP: "10.0.0.2"},
+		{Host: "ipv6.internal.corp", IP: "fd00::4"},
+		{Host: "single-label-host-1", IP: "10.0.0.5"},
+	}
+
+	for _, skipMeta := range []bool{false, true} {
+		gwClient := newTestGwClient(files)
+		r := newTestResolver(t, ResolverOpt{GitExtraHosts: extraHosts, SkipGitMetadata: skipMeta})
+		_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		_, err = r.ResolveFeatures(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "ResolveFeatures failed")
+
+		// The clones are all made by the git image, with the entries.
+		runs := 0
+		for _, op := range gwClient.solvedOps(t) {
+			if src := op.GetSource(); src != nil {
+				NotContains(t, src.Identifier, "git://", "unexpected git source clone")
+			}
+			if exec := op.GetExec(); exec != nil {
+				runs++
+				Equal(t, expected, exec.Meta.ExtraHosts)
+			}
+		}
+		NotZero(t, runs)
+	}
+
+	for _, invalid := range []map[string]string{
+		{"github.com": "not-an-ip"},
+		{"github.com": ""},
+		{"bad host": "10.0.0.2"},
+		{"-github.com": "10.0.0.2"},
+		{"github.com:443": "10.0.0.2"},
+	} {
+		r := newTestResolver(t, ResolverOpt{GitExtraHosts: invalid})
+		_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		Error(t, err, "%v", invalid)
+	}
+}
+
+func TestResolveGitTransfer(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	gwClient := newTestGwClient(files)
+	r := newTestResolver(t, ResolverOpt{GitTransfer: GitTransferOpt{
+		PackWindowMemory:  "64m",
+		HTTPLowSpeedLimit: 100,
+		HTTPLowSpeedTime:  90500 * time.Millisecond,
+		HTTPVersion:       "HTTP/2",
+		HTTPMaxRequests:   16,
+	}})
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	runs := 0
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			runs++
+			script := exec.Meta.Args[len(exec.Meta.Args)-1]
+			Contains(t, script, "git() { command git -c pack.windowMemory=64m -c http.lowSpeedLimit=100 -c http.lowSpeedTime=91 -c http.version=HTTP/2 -c http.maxRequests=16 \"$@\" ; }")
+		}
+	}
+	NotZero(t, runs)
+
+	for _, invalid := range []GitTransferOpt{
+		{PackWindowMemory: "64 MB"},
+		{PackWindowMemory: "64m; rm -rf /"},
+		{HTTPLowSpeedLimit: -1},
+		{HTTPLowSpeedTime: -time.Second},
+		{HTTPVersion: "HTTP/3"},
+		{HTTPVersion: "HTTP/2 -c core.sshCommand=x"},
+		{HTTPMaxRequests: -1},
+	} {
+		r = newTestResolver(t, ResolverOpt{GitTransfer: invalid})
+		_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		Error(t, err, "%+v", invalid)
+	}
+}
+
+func TestGitCloneScriptTransfer(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", "git init --quiet --initial-branch=main && git -c user.name=test -c user.email=test@example.com commit --quiet --allow-empty -m init && git rev-parse HEAD")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	NoError(t, err, "init repo: %s", out)
+	hash := strings.TrimSpace(string(out))
+
+	gitConfig, err := GitTransferOpt{
+		HTTPLowSpeedLimit: 100,
+		HTTPLowSpeedTime:  time.Minute,
+		HTTPVersion:       "HTTP/2",
+		HTTPMaxRequests:   16,
+	}.gitConfig()
+	NoError(t, err)
+	for _, hasRef := range []bool{false, true} {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, hasRef, false, false, false, false, false, false, false, false, 0, 0, 0, "", filepath.Join(t.TempDir(), "src"), dest, gitConfig))
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL="+repo,
+			"EARTHLY_GIT_REF=main",
+			"EARTHLY_GIT_ORIGIN="+repo,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		gitHash, err := os.ReadFile(filepath.Join(dest, "git-hash"))
+		NoError(t, err)
+		Equal(t, hash+"\n", string(gitHash))
+	}
+}
+
+func TestGitCloneScriptSparse(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	newRepo := func(files map[string]string) string {
+		repo := t.TempDir()
+		for name, content := range files {
+			p := filepath.Join(repo, name)
+			NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+			NoError(t, os.WriteFile(p, []byte(content), 0644))
+		}
+		cmd := exec.Command("/bin/sh", "-c", "git init --quiet --initial-branch=main && git add -A && git -c user.name=test -c user.email=test@example.com commit --quiet -m init && git tag v1")
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "init repo: %s", out)
+		return repo
+	}
+	checkout := func(repo string, hasRef bool) []string {
+		src := filepath.Join(t.TempDir(), "src")
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, hasRef, false, false, false, false, false, false, false, true, 0, 0, 0, "", src, t.TempDir(), nil))
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL="+repo,
+			"EARTHLY_GIT_REF=v1",
+			"EARTHLY_GIT_ORIGIN="+repo,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		var files []string
+		err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() {
+				rel, err := filepath.Rel(src, p)
+				NoError(t, err)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		NoError(t, err)
+		return files
+	}
+
+	files := map[string]string{
+		"Earthfile":          "VERSION 0.6\n",
+		"service/Earthfile":  "VERSION 0.6\n",
+		"service/main.go":    "package main\n",
+		"service/testdata/a": "a\n",
+		"docs/index.md":      "# Docs\n",
+		"assets/logo.png":    "png\n",
+	}
+	full := []string{"Earthfile", "assets/logo.png", "docs/index.md", "service/Earthfile", "service/main.go", "service/testdata/a"}
+	repo := newRepo(files)
+	for _, hasRef := range []bool{false, true} {
+		Equal(t, full, checkout(repo, hasRef))
+	}
+
+	files[gitSparsePatternsFile] = "# What builds need.\n/Earthfile\n/service/\n!/service/testdata/\n"
+	repo = newRepo(files)
+	for _, hasRef := range []bool{false, true} {
+		Equal(t, []string{"Earthfile", "service/Earthfile", "service/main.go"}, checkout(repo, hasRef))
+	}
+}
+
+func TestResolveRepoSparsePatterns(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	r := newTestResolver(t, ResolverOpt{RepoSparsePatterns: true})
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	runs := 0
+	for _, op := range gwClient.solvedOps(t) {
+		if src := op.GetSource(); src != nil {
+			NotContains(t, src.Identifier, "git://", "unexpected git source clone")
+		}
+		if exec := op.GetExec(); exec != nil {
+			runs++
+			Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "core.sparseCheckout true")
+		}
+	}
+	NotZero(t, runs)
+}
@@ -0,0 +1,63 @@
+package buildcontext
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/gitutil"
+)
+
+// FutureTimestampPolicy determines how the commits of remote references dated in the future (e.g.
+// because of the clock skew of their author) are handled, as they break the assumptions of
+// reproducible builds on timestamps.
+type FutureTimestampPolicy int
+
+const (
+	// IgnoreFutureTimestamps leaves the timestamps of such commits as they are. This is the default.
+	IgnoreFutureTimestamps FutureTimestampPolicy = iota
+	// WarnFutureTimestamps prints a warning about such commits.
+	WarnFutureTimestamps
+	// ClampFutureTimestamps prints a warning about such commits, and reports the time of their
+	// resolution as their Timestamp, the recorded one being kept as their RawTimestamp.
+	ClampFutureTimestamps
+)
+
+// defaultFutureTimestampTolerance is how far in the future a commit may be dated without being
+// considered as such, unless configured otherwise.
+const defaultFutureTimestampTolerance = 5 * time.Minute
+
+// checkFutureTimestamp applies the future timestamp policy to the git metadata of a remote
+// reference. Commits are warned about once per resolved project.
+func (gr *gitResolver) checkFutureTimestamp(ref domain.Reference, rgp *resolvedGitProject, gitMeta *gitutil.GitMetadata) {
+	if gr.futureTimestampPolicy == IgnoreFutureTimestamps || gitMeta.Timestamp == "" {
+		return
+	}
+	ts, err := strconv.ParseInt(gitMeta.Timestamp, 10, 64)
+	if err != nil {
+		gr.console.VerbosePrintf("unable to parse the commit timestamp %q of %s: %s\n", gitMeta.Timestamp, ref.ProjectCanonical(), err.Error())
+		return
+	}
+	tolerance := gr.futureTsTolerance
+	if tolerance == 0 {
+		tolerance = defaultFutureTimestampTolerance
+	}
+	now := time.Now()
+	commitTime := time.Unix(ts, 0)
+	if !commitTime.After(now.Add(tolerance)) {
+		return
+	}
+	clamp := gr.futureTimestampPolicy == ClampFutureTimestamps
+	rgp.futureTsWarnOnce.Do(func() {
+		var action string
+		if clamp {
+			action = "; using the current time instead"
+		}
+		gr.warn(ref, WarningFutureTimestamp, "the commit %s of %s is dated %s, %s in the future%s",
+			gitMeta.Hash, ref.ProjectCanonical(), commitTime.UTC().Format(time.RFC3339), commitTime.Sub(now).Round(time.Second), action)
+	})
+	if clamp {
+		gitMeta.RawTimestamp = gitMeta.Timestamp
+		gitMeta.Timestamp = strconv.FormatInt(now.Unix(), 10)
+	}
+}
@@ -0,0 +1,78 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveFutureTimestamp(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	const futureTs = "4102444800" // 2100-01-01
+	newResolver := func(policy FutureTimestampPolicy, tolerance time.Duration) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			FutureTimestampPolicy:    policy,
+			FutureTimestampTolerance: tolerance,
+		}), &buf
+	}
+	files := func(ts string) map[string]string {
+		return map[string]string{
+			"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			"git-ts":    ts + "\n",
+		}
+	}
+	const warning = "Warning: the commit a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5 of github.com/earthly/test:main is dated 2100-01-01T00:00:00Z"
+
+	r, buf := newResolver(IgnoreFutureTimestamps, 0)
+	d, err := r.Resolve(context.Background(), newTestGwClient(files(futureTs)), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, futureTs, d.GitMetadata.Timestamp)
+	Empty(t, d.GitMetadata.RawTimestamp)
+	Empty(t, buf.String())
+
+	r, buf = newResolver(WarnFutureTimestamps, 0)
+	gwClient := newTestGwClient(files(futureTs))
+	for i := 0; i < 2; i++ {
+		d, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		Equal(t, futureTs, d.GitMetadata.Timestamp)
+		Empty(t, d.GitMetadata.RawTimestamp)
+	}
+	Equal(t, 1, strings.Count(buf.String(), warning), buf.String())
+
+	r, buf = newResolver(ClampFutureTimestamps, 0)
+	before := time.Now().Unix()
+	d, err = r.Resolve(context.Background(), newTestGwClient(files(futureTs)), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, futureTs, d.GitMetadata.RawTimestamp)
+	ts, err := strconv.ParseInt(d.GitMetadata.Timestamp, 10, 64)
+	NoError(t, err)
+	GreaterOrEqual(t, ts, before)
+	LessOrEqual(t, ts, time.Now().Unix())
+	Contains(t, buf.String(), "; using the current time instead\n")
+
+	// Commits dated in the past, or within the tolerance, are left as they are.
+	for _, ts := range []string{"1665000000", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)} {
+		r, buf = newResolver(ClampFutureTimestamps, 2*time.Hour)
+		d, err = r.Resolve(context.Background(), newTestGwClient(files(ts)), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		Equal(t, ts, d.GitMetadata.Timestamp)
+		Empty(t, d.GitMetadata.RawTimestamp)
+		Empty(t, buf.String())
+	}
+}
@@ -0,0 +1,95 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrHostKeyChanged is returned, when host keys are pinned, when the known host keys of a git host
+// include one which was not among those first seen for the host, which may be the sign of a
+// man-in-the-middle attack.
+type ErrHostKeyChanged struct {
+	// Host is the host, as in known_hosts files (e.g. github.com, or [git.example.com]:2222).
+	Host string
+	// Fingerprint is the SHA256 fingerprint of the key which was not first seen.
+	Fingerprint string
+	// Pinned are the fingerprints of the keys first seen for the host.
+	Pinned []string
+}
+
+// Error is function required by error interface.
+func (err ErrHostKeyChanged) Error() string {
+	return fmt.Sprintf("the host key of %s has changed: %s is not among the keys first seen for it (%s); someone could be eavesdropping (man-in-the-middle attack), or the host key has just been changed",
+		err.Host, err.Fingerprint, strings.Join(err.Pinned, ", "))
+}
+
+// hostKeyFingerprints returns the SHA256 fingerprints of the given keyscans (known_hosts lines),
+// sorted and keyed by their host.
+func hostKeyFingerprints(keyScans []string) map[string][]string {
+	fingerprints := make(map[string][]string)
+	for _, keyScan := range keyScans {
+		_, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(keyScan))
+		if err != nil || len(hosts) == 0 {
+			continue
+		}
+		for _, host := range hosts {
+			fingerprints[host] = append(fingerprints[host], ssh.FingerprintSHA256(key))
+		}
+	}
+	for host, fps := range fingerprints {
+		sort.Strings(fps)
+		fingerprints[host] = fps
+	}
+	return fingerprints
+}
+
+// hostKeysKey returns the key of the record cache under which the fingerprints of the host keys
+// first seen for a host are recorded. Like those of tags, such keys are made of three components,
+// the first of which tells them apart, and are not removed by InvalidateRepo.
+func (gr *gitResolver) hostKeysKey(host string) string {
+	return gr.namespacedKey("hostkey#" + gitKeyEscaper.Replace(host) + "#")
+}
+
+// checkHostKeys records the fingerprints of the host keys of the given clone candidates in the
+// record cache, for the hosts they are first seen for, and returns ErrHostKeyChanged when those of a
+// host include one which was not first seen.
+func (gr *gitResolver) checkHostKeys(ctx context.Context, candidates []cloneCandidate) error {
+	if !gr.pinHostKeys {
+		return nil
+	}
+	for _, c := range candidates {
+		for host, fps := range hostKeyFingerprints(c.keyScans) {
+			current := strings.Join(fps, ",")
+			v, err := gr.recordCache.Do(ctx, gr.hostKeysKey(host), func(ctx context.Context, _ interface{}) (interface{}, error) {
+				return current, nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "read the pinned host keys of %s", host)
+			}
+			pinnedValue, _ := v.(string)
+			if pinnedValue == current {
+				continue
+			}
+			pinned := strings.Split(pinnedValue, ",")
+			pinnedSet := make(map[string]bool, len(pinned))
+			for _, fp := range pinned {
+				pinnedSet[fp] = true
+			}
+			for _, fp := range fps {
+				if !pinnedSet[fp] {
+					return ErrHostKeyChanged{
+						Host:        host,
+						Fingerprint: fp,
+						Pinned:      pinned,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
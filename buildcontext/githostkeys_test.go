@@ -0,0 +1,77 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolvePinHostKeys(t *testing.T) {
+	const oldFingerprint = "SHA256:0000000000000000000000000000000000000000000"
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	newGwClient := func() *fakeGwClient {
+		return newTestGwClient(map[string]string{
+			"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		})
+	}
+	newResolver := func(projectCache, recordCache Cache, pin bool) *Resolver {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info)
+		gitLookup := NewGitLookup(console, "")
+		err := gitLookup.AddMatcher("github.com", "github.com/[^/]+/[^/]+", "", "git", "", "", ".git", "ssh", "", false, 0)
+		NoError(t, err)
+		return NewResolver("", cleanCollection, gitLookup, console, "", ResolverOpt{
+			ProjectCache: projectCache,
+			RecordCache:  recordCache,
+			PinHostKeys:  pin,
+		})
+	}
+
+	// The host keys are pinned when first seen, and accepted afterwards.
+	projectCache := newFakeCache()
+	recordCache := newFakeCache()
+	for i := 0; i < 2; i++ {
+		_, err = newResolver(projectCache, recordCache, true).Resolve(context.Background(), newGwClient(), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+	pinned, err := recordCache.Do(context.Background(), "hostkey#github.com#", nil)
+	NoError(t, err)
+	fps, _ := pinned.(string)
+	NotEmpty(t, fps)
+	for _, fp := range strings.Split(fps, ",") {
+		True(t, strings.HasPrefix(fp, "SHA256:"), fp)
+	}
+	// The project cache holds nothing but projects.
+	for key, e := range projectCache.entries {
+		IsType(t, &resolvedGitProject{}, e.value, "unexpected value of %v", key)
+	}
+
+	// The host keys have been pinned by an earlier resolver sharing the cache, and have changed since.
+	recordCache = newFakeCache()
+	NoError(t, recordCache.Add(context.Background(), "hostkey#github.com#", oldFingerprint, nil))
+	gwClient := newGwClient()
+	_, err = newResolver(nil, recordCache, true).Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	var changedErr ErrHostKeyChanged
+	True(t, errors.As(err, &changedErr), "unexpected error %v", err)
+	Equal(t, "github.com", changedErr.Host)
+	Equal(t, []string{oldFingerprint}, changedErr.Pinned)
+	Contains(t, fps, changedErr.Fingerprint)
+	// Nothing is cloned.
+	Equal(t, 0, gwClient.numMetaRuns(t))
+
+	// Not checked unless enabled.
+	recordCache = newFakeCache()
+	NoError(t, recordCache.Add(context.Background(), "hostkey#github.com#", oldFingerprint, nil))
+	_, err = newResolver(nil, recordCache, false).Resolve(context.Background(), newGwClient(), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+}
@@ -0,0 +1,158 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// GitImageOpt determines the image git is run in for remote references.
+type GitImageOpt struct {
+	// Image is the image. Defaults to alpine/git.
+	Image string
+	// Digest is the expected digest of Image. When set, the image is resolved before use and
+	// pinned to its digest, and resolution fails with ErrGitImageDigestMismatch if the digest
+	// differs.
+	Digest string
+	// PullTimeout caps the duration of resolving Image from its registry. When set, the image is
+	// resolved before use and pinned to its digest, so that a stalling registry fails with
+	// ErrGitImagePullTimeout instead of surfacing as a slow clone. Other failures to resolve the
+	// image are reported as ErrGitImagePull. 0 means unlimited.
+	PullTimeout time.Duration
+}
+
+// ErrGitImageDigestMismatch is returned when the git image resolves to a digest other than the
+// expected one.
+type ErrGitImageDigestMismatch struct {
+	Image    string
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+// Error is function required by error interface.
+func (err ErrGitImageDigestMismatch) Error() string {
+	return fmt.Sprintf("git image %s resolved to digest %s, but %s was expected", err.Image, err.Actual, err.Expected)
+}
+
+// ErrGitImagePull is returned when the git image cannot be resolved from its registry, as opposed
+// to the repository failing to clone.
+type ErrGitImagePull struct {
+	Image string
+	Err   error
+}
+
+// Error is function required by error interface.
+func (err ErrGitImagePull) Error() string {
+	return fmt.Sprintf("pull git image %s: %s", err.Image, err.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (err ErrGitImagePull) Unwrap() error {
+	return err.Err
+}
+
+// ErrGitImagePullTimeout is returned when resolving the git image from its registry has taken
+// longer than the configured timeout.
+type ErrGitImagePullTimeout struct {
+	Image   string
+	Timeout time.Duration
+}
+
+// Error is function required by error interface.
+func (err ErrGitImagePullTimeout) Error() string {
+	return fmt.Sprintf("pull git image %s took longer than the maximum of %s", err.Image, err.Timeout)
+}
+
+// Unwrap returns context.DeadlineExceeded, so that the error can be matched as such.
+func (err ErrGitImagePullTimeout) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// gitImageState returns the state of the image used to run git. When an expected digest or a pull
+// timeout is configured, the image is resolved first, and pinned to its digest (if it matches the
+// expected one).
+func (gr *gitResolver) gitImageState(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver) (pllb.State, error) {
+	imageName := gr.gitImage.Image
+	if gr.gitImage.Digest != "" || gr.gitImage.PullTimeout > 0 {
+		v, err := gr.gitImageCache.Do(ctx, gr.gitImage.Image, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return gr.verifyGitImage(ctx, gwClient, platr)
+		})
+		if err != nil {
+			return pllb.State{}, err
+		}
+		imageName = v.(string)
+	}
+	return pllb.Image(
+		imageName, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(platr.LLBNative())), nil
+}
+
+// verifyGitImage resolves the git image and compares its digest to the expected one, if any. It
+// returns the image reference pinned to the digest.
+func (gr *gitResolver) verifyGitImage(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver) (string, error) {
+	var expected digest.Digest
+	if gr.gitImage.Digest != "" {
+		var err error
+		expected, err = digest.Parse(gr.gitImage.Digest)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse expected git image digest %s", gr.gitImage.Digest)
+		}
+	}
+	ref, err := reference.ParseNormalizedNamed(gr.gitImage.Image)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse normalized named %s", gr.gitImage.Image)
+	}
+	actual, err := gr.resolveGitImage(ctx, gwClient, platr, reference.TagNameOnly(ref).String())
+	if err != nil {
+		return "", err
+	}
+	if expected != "" && actual != expected {
+		return "", ErrGitImageDigestMismatch{
+			Image:    gr.gitImage.Image,
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(ref), actual)
+	if err != nil {
+		return "", errors.Wrapf(err, "reference add digest %v for %s", actual, gr.gitImage.Image)
+	}
+	return pinned.String(), nil
+}
+
+// resolveGitImage resolves the digest of the git image from its registry, within the pull timeout
+// if one is set.
+func (gr *gitResolver) resolveGitImage(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, imageRef string) (digest.Digest, error) {
+	pullCtx := ctx
+	if gr.gitImage.PullTimeout > 0 {
+		var cancel context.CancelFunc
+		pullCtx, cancel = context.WithTimeout(ctx, gr.gitImage.PullTimeout)
+		defer cancel()
+	}
+	platform := platr.LLBNative()
+	dgst, _, err := gwClient.ResolveImageConfig(pullCtx, imageRef, llb.ResolveImageConfigOpt{
+		Platform:    &platform,
+		ResolveMode: llb.ResolveModeDefault.String(),
+	})
+	if err != nil {
+		if pullCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return "", ErrGitImagePullTimeout{
+				Image:   gr.gitImage.Image,
+				Timeout: gr.gitImage.PullTimeout,
+			}
+		}
+		return "", ErrGitImagePull{
+			Image: gr.gitImage.Image,
+			Err:   errors.Wrap(err, "resolve image config"),
+		}
+	}
+	return dgst, nil
+}
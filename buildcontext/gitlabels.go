@@ -0,0 +1,68 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/pkg/errors"
+)
+
+// GitLabelPrefix prefixes the names of the git labels. Each label is named after the GitMetadata
+// field it holds, in kebab case:
+//
+//	org.earthly.git.url                      RemoteURL, without credentials
+//	org.earthly.git.subdir                   RelDir
+//	org.earthly.git.commit                   Hash
+//	org.earthly.git.short-commit             ShortHash
+//	org.earthly.git.branch                   Branch, comma-separated
+//	org.earthly.git.tags                     Tags, comma-separated
+//	org.earthly.git.timestamp                Timestamp
+//	org.earthly.git.author                   Author
+//	org.earthly.git.co-authors               CoAuthors, comma-separated
+//	org.earthly.git.tree                     SubtreeHash
+//	org.earthly.git.signing-key-fingerprint  SigningKeyFingerprint
+//	org.earthly.git.signing-key-id           SigningKeyID
+//	org.earthly.git.root-commit              RootCommit
+//
+// Labels of empty fields are omitted.
+const GitLabelPrefix = "org.earthly.git."
+
+// gitLabelsKey is the key of the git labels among the values of a build context state.
+type gitLabelsKey struct{}
+
+// GitLabels returns the labels holding the given git metadata.
+func GitLabels(gitMeta *gitutil.GitMetadata) map[string]string {
+	labels := make(map[string]string)
+	add := func(name, value string) {
+		if value != "" {
+			labels[GitLabelPrefix+name] = value
+		}
+	}
+	add("url", stripGitURLCredentials(gitMeta.RemoteURL))
+	add("subdir", gitMeta.RelDir)
+	add("commit", gitMeta.Hash)
+	add("short-commit", gitMeta.ShortHash)
+	add("branch", strings.Join(gitMeta.Branch, ","))
+	add("tags", strings.Join(gitMeta.Tags, ","))
+	add("timestamp", gitMeta.Timestamp)
+	add("author", gitMeta.Author)
+	add("co-authors", strings.Join(gitMeta.CoAuthors, ","))
+	add("tree", gitMeta.SubtreeHash)
+	add("signing-key-fingerprint", gitMeta.SigningKeyFingerprint)
+	add("signing-key-id", gitMeta.SigningKeyID)
+	add("root-commit", gitMeta.RootCommit)
+	return labels
+}
+
+// ContextGitLabels returns the git labels attached to a build context state by a resolver created
+// with AttachGitLabels, if any.
+func ContextGitLabels(ctx context.Context, st pllb.State) (map[string]string, error) {
+	v, err := st.Value(ctx, gitLabelsKey{})
+	if err != nil {
+		return nil, errors.Wrap(err, "read git labels of build context")
+	}
+	labels, _ := v.(map[string]string)
+	return labels, nil
+}
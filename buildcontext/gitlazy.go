@@ -0,0 +1,119 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+var _ llbfactory.FallibleFactory = &LazyFactory{}
+
+// LazyFactory is the build context factory of remote targets resolved lazily. The project is only
+// cloned and its git metadata extracted upon the first construction of the factory, the outcome of
+// which is kept for subsequent constructions.
+type LazyFactory struct {
+	once    sync.Once
+	resolve func() (pllb.State, *gitutil.GitMetadata, error)
+
+	state   pllb.State
+	gitMeta *gitutil.GitMetadata
+	err     error
+}
+
+func (f *LazyFactory) do() {
+	f.once.Do(func() {
+		f.state, f.gitMeta, f.err = f.resolve()
+	})
+}
+
+// ConstructErr resolves the project if not done yet, and returns the build context.
+func (f *LazyFactory) ConstructErr() (pllb.State, error) {
+	f.do()
+	return f.state, f.err
+}
+
+// Construct is like ConstructErr, but panics if the resolution failed, rather than building against
+// an empty context. Use llbfactory.Construct to get the error.
+func (f *LazyFactory) Construct() pllb.State {
+	state, err := f.ConstructErr()
+	if err != nil {
+		panic(fmt.Sprintf("construct the lazily resolved build context without llbfactory.Construct: %s", err.Error()))
+	}
+	return state
+}
+
+// GitMetadata resolves the project if not done yet, and returns its git metadata.
+func (f *LazyFactory) GitMetadata() (*gitutil.GitMetadata, error) {
+	f.do()
+	return f.gitMeta, f.err
+}
+
+// resolveEarthProjectLazy is like resolveEarthProject, for targets, but only the build file is
+// resolved (within ctx). The resolution of the project is deferred to the first construction of
+// the build context factory, and is made within deferCtx.
+func (gr *gitResolver) resolveEarthProjectLazy(ctx, deferCtx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, contextPlatform platutil.Platform, featureFlagOverrides string) (*Data, error) {
+	gitURL, subDir, _, err := gr.gitLookup.GetCloneURL(ref.GetGitURL())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get url for cloning")
+	}
+	localBuildFile, err := gr.resolveRefBuildFile(ctx, gwClient, platr, ref, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	err = gr.verifyBuildFileDigest(ref, localBuildFile.path)
+	if err != nil {
+		return nil, err
+	}
+	factory := &LazyFactory{
+		resolve: func() (pllb.State, *gitutil.GitMetadata, error) {
+			var state pllb.State
+			var gitMeta *gitutil.GitMetadata
+			err := gr.withResolveBudget(deferCtx, ref, func(ctx context.Context) error {
+				rgp, gitURL, subDir, err := gr.resolveGitProject(ctx, gwClient, platr, ref)
+				if err != nil {
+					return err
+				}
+				err = checkSubDir(rgp, ref.ProjectCanonical(), subDir)
+				if err != nil {
+					return err
+				}
+				state, err = gr.buildContextState(ctx, gwClient, platr, ref, rgp, subDir, contextPlatform, patternsOf(gr.contextExcludePatterns(localBuildFile, subDir)))
+				if err != nil {
+					return err
+				}
+				err = gr.checkLFSPointers(rgp, ref, subDir)
+				if err != nil {
+					return err
+				}
+				gitMeta, err = gr.gitMetadata(ctx, gwClient, platr, ref, rgp, gitURL, subDir)
+				return err
+			})
+			if err != nil {
+				return pllb.State{}, nil, errors.Wrapf(err, "lazily resolve %s", ref.String())
+			}
+			return state, gitMeta, nil
+		},
+	}
+	return &Data{
+		BuildFilePath:       localBuildFile.path,
+		BuildFileLocation:   localBuildFile.location,
+		BuildContextFactory: factory,
+		// The actual metadata is only known once the factory is constructed.
+		GitMetadata: &gitutil.GitMetadata{
+			RelDir:      subDir,
+			RemoteURL:   gitURL,
+			Unpopulated: true,
+		},
+		Features:        localBuildFile.ftrs,
+		ExcludePatterns: gr.contextExcludePatterns(localBuildFile, subDir),
+	}, nil
+}
@@ -0,0 +1,76 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveLazy(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	r := newTestResolver(t, ResolverOpt{LazyResolve: true})
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	NotEmpty(t, d.Earthfile.Targets)
+	True(t, d.GitMetadata.Unpopulated)
+	Equal(t, "https://github.com/earthly/test.git", d.GitMetadata.RemoteURL)
+	Equal(t, "sub", d.GitMetadata.RelDir)
+	// Only the build file has been read: no git meta run yet.
+	Equal(t, 0, gwClient.numMetaRuns(t))
+	factory, ok := d.BuildContextFactory.(*LazyFactory)
+	True(t, ok, "unexpected factory %T", d.BuildContextFactory)
+
+	for i := 0; i < 2; i++ {
+		_, err = llbfactory.Construct(factory)
+		NoError(t, err, "Construct failed")
+		Equal(t, 1, gwClient.numMetaRuns(t))
+	}
+	gitMeta, err := factory.GitMetadata()
+	NoError(t, err)
+	Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", gitMeta.Hash)
+	Equal(t, []string{"main"}, gitMeta.Branch)
+	False(t, gitMeta.Unpopulated)
+}
+
+func TestResolveLazyError(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	// The git meta run fails.
+	gwClient.solveErr = func(def *pb.Definition) error {
+		for _, dt := range def.Def {
+			var op pb.Op
+			NoError(t, op.Unmarshal(dt), "unmarshal op")
+			if op.GetExec() != nil {
+				return errors.New("exit code: 128")
+			}
+		}
+		return nil
+	}
+	r := newTestResolver(t, ResolverOpt{LazyResolve: true})
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	// The failure is only reported once the build context is used.
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	_, err = llbfactory.Construct(d.BuildContextFactory)
+	Error(t, err)
+	Contains(t, err.Error(), "exit code: 128")
+	// Constructing it as a plain factory does not yield an empty build context.
+	PanicsWithValue(t, "construct the lazily resolved build context without llbfactory.Construct: "+err.Error(), func() {
+		d.BuildContextFactory.Construct()
+	})
+	_, err = d.BuildContextFactory.(*LazyFactory).GitMetadata()
+	Error(t, err)
+}
@@ -0,0 +1,216 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// LFSOpt determines how the git LFS files of remote repositories are handled.
+type LFSOpt struct {
+	// DetectPointers looks for git LFS pointer files (files stored with git LFS, which are checked
+	// out without their content) in the build contexts of remote targets. When the build context of
+	// a target has any, a warning listing them is printed, or ErrLFSPointers is returned when
+	// StrictPointers is set.
+	DetectPointers bool
+	StrictPointers bool
+	// Fetch fetches the git LFS objects of remote repositories, replacing their pointer files in the
+	// build contexts of their targets with their content. The objects are fetched by running git lfs
+	// in Image, and kept in a cache addressed by their ids, shared across repositories, so that each
+	// object is only downloaded once.
+	Fetch bool
+	// Image is the image used to fetch git LFS objects. It must come with git-lfs. Defaults to
+	// alpine/git (in a version shipping git-lfs).
+	Image string
+}
+
+const (
+	// gitLFSPointersFile is the git meta file holding the paths of the git LFS pointer files checked
+	// out, NUL-separated, when detected.
+	gitLFSPointersFile = "git-lfs-pointers"
+	// gitLFSObjectsFile is the git meta file holding the ids of the git LFS objects of the pointer
+	// files checked out, one per line, sorted, when they are to be fetched.
+	gitLFSObjectsFile = "git-lfs-objects"
+
+	// gitLFSStorageDir is where the git LFS objects are kept, in a persistent cache mount.
+	gitLFSStorageDir = "/git-lfs-objects"
+	// gitLFSCacheID is the id of the cache mount of the git LFS objects. The objects are addressed
+	// by their ids, which are the sha256 of their content, so that it is shared by all repositories.
+	gitLFSCacheID = "earthly-git-lfs-objects"
+)
+
+// gitLFSPointerPatterns are the lines all of which a file must have to be taken for a git LFS pointer
+// (see https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md).
+var gitLFSPointerPatterns = []string{
+	`^version https://git-lfs\.github\.com/spec/v1$`,
+	`^oid sha256:[0-9a-f]{64}$`,
+	`^size [0-9]+$`,
+}
+
+// ErrLFSPointers is returned, in strict mode, when the build context of a remote target contains git
+// LFS pointer files, i.e. files whose content is stored with git LFS, but which are checked out
+// without it.
+type ErrLFSPointers struct {
+	// Ref is the project of the target (e.g. github.com/earthly/earthly/examples/go:main).
+	Ref string
+	// Paths are the paths of the pointer files within the repository, sorted.
+	Paths []string
+}
+
+// Error is function required by error interface.
+func (err ErrLFSPointers) Error() string {
+	return fmt.Sprintf("the build context of %s contains git LFS pointer files rather than their content: %s",
+		err.Ref, strings.Join(err.Paths, ", "))
+}
+
+// readLFSPointers reads the paths of the git LFS pointer files of the project, out of the git meta
+// run.
+func (gr *gitResolver) readLFSPointers(ctx context.Context, gitMetaRef gwclient.Reference) ([]string, error) {
+	dt, err := gr.readGitMeta(ctx, gitMetaRef, gitLFSPointersFile)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, p := range strings.Split(string(dt), "\x00") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// checkLFSPointers reports the git LFS pointer files within the build context of a remote target
+// (living in subDir of the project) as a warning, once per project and subdirectory, or as
+// ErrLFSPointers in strict mode.
+func (gr *gitResolver) checkLFSPointers(rgp *resolvedGitProject, ref domain.Reference, subDir string) error {
+	if !gr.lfs.DetectPointers {
+		return nil
+	}
+	subDir = path.Clean(subDir)
+	var paths []string
+	for _, p := range rgp.lfsPointers {
+		if subDir == "." || strings.HasPrefix(p, subDir+"/") {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	lfsErr := ErrLFSPointers{
+		Ref:   ref.ProjectCanonical(),
+		Paths: paths,
+	}
+	if gr.lfs.StrictPointers {
+		return lfsErr
+	}
+	rgp.lfsWarnedMu.Lock()
+	defer rgp.lfsWarnedMu.Unlock()
+	if rgp.lfsWarned == nil {
+		rgp.lfsWarned = make(map[string]bool)
+	}
+	if !rgp.lfsWarned[subDir] {
+		rgp.lfsWarned[subDir] = true
+		gr.warn(ref, WarningLFSPointers, "%s", lfsErr.Error())
+	}
+	return nil
+}
+
+// gitLFSObjectsCommand returns the command writing the ids of the git LFS objects of the pointer
+// files of the checkout (the current directory) to destPath.
+func gitLFSObjectsCommand(destPath string) string {
+	var patterns strings.Builder
+	for _, pattern := range gitLFSPointerPatterns {
+		patterns.WriteString(" -e " + shellescape.Quote(pattern))
+	}
+	// git grep fails when nothing matches.
+	return fmt.Sprintf("{ git grep -h -I --all-match -E%s || true ; } | sed -n 's/^oid sha256://p' | sort -u >%s ; ",
+		patterns.String(), shellescape.Quote(path.Join(destPath, gitLFSObjectsFile)))
+}
+
+// readLFSObjects reads the ids of the git LFS objects of the project, out of the git meta run.
+func (gr *gitResolver) readLFSObjects(ctx context.Context, gitMetaRef gwclient.Reference) ([]string, error) {
+	dt, err := gr.readGitMeta(ctx, gitMetaRef, gitLFSObjectsFile)
+	if err != nil {
+		return nil, err
+	}
+	var oids []string
+	for _, oid := range strings.Split(string(dt), "\n") {
+		if oid = strings.TrimSpace(oid); oid != "" {
+			oids = append(oids, oid)
+		}
+	}
+	return oids, nil
+}
+
+// lfsFetchState returns the state of the checkout of the project (srcState) with its git LFS pointer
+// files replaced by the content of their objects, fetched by running git lfs in the git LFS image.
+// The objects are kept in a persistent cache mount, in which they are addressed by their ids: those
+// fetched before, for any repository, are not downloaded again. The run is keyed on the ids of the
+// objects, so that it is cached for as long as they are the same. srcState is returned as is when
+// the project has no git LFS objects.
+func (gr *gitResolver) lfsFetchState(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, clone cloneCandidate, vm *outmon.VertexMeta, gitMetaRef gwclient.Reference, srcState pllb.State) (pllb.State, error) {
+	oids, err := gr.readLFSObjects(ctx, gitMetaRef)
+	if err != nil {
+		return pllb.State{}, err
+	}
+	if len(oids) == 0 {
+		return srcState, nil
+	}
+	tlsOpts, gitConfig, err := gr.gitTLSRunOpts(ctx)
+	if err != nil {
+		return pllb.State{}, err
+	}
+	transferConfig, err := gr.gitTransfer.gitConfig()
+	if err != nil {
+		return pllb.State{}, err
+	}
+	gitConfig = append(gitConfig, transferConfig...)
+	gitConfig = append(gitConfig, "lfs.storage="+gitLFSStorageDir)
+	script := gitLFSFetchScript(gr.gitSrcPath, gitConfig)
+	gr.logGitCommand(ref, clone.gitURL, ref.GetTag(), script, false)
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", script}),
+		llb.AddEnv("EARTHLY_GIT_URL", clone.gitURL),
+		// The digest of the ids, as there may be too many of them for an env var.
+		llb.AddEnv("EARTHLY_GIT_LFS_OBJECTS_DIGEST", digest.FromString(strings.Join(oids, "\n")).String()),
+		pllb.AddMount(gitLFSStorageDir, pllb.Scratch(),
+			llb.AsPersistentCacheDir(gitLFSCacheID, llb.CacheMountShared)),
+		llb.WithCustomNamef("%sGIT LFS FETCH %s", vm.ToVertexPrefix(), ref.ProjectCanonical()),
+	}
+	runOpts = append(runOpts, tlsOpts...)
+	extraHostOpts, err := gitExtraHostRunOpts(gr.gitExtraHosts)
+	if err != nil {
+		return pllb.State{}, err
+	}
+	runOpts = append(runOpts, extraHostOpts...)
+	runOpts = append(runOpts, gitSSHRunOpts(clone.gitURL, clone.keyScans)...)
+	opImg := pllb.Image(
+		gr.lfs.Image, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(platr.LLBNative()))
+	fetchOp := opImg.Run(runOpts...)
+	return fetchOp.AddMount(gr.gitSrcPath, srcState), nil
+}
+
+// gitLFSFetchScript returns the shell script which fetches the git LFS objects of the checkout at
+// srcPath from the remote repository ($EARTHLY_GIT_URL), and checks them out in place of their
+// pointer files. Every git invocation is passed the given config (key=value) entries.
+func gitLFSFetchScript(srcPath string, gitConfig []string) string {
+	var sb strings.Builder
+	sb.WriteString("set -e ; ")
+	sb.WriteString(gitConfigFunc(gitConfig))
+	// The origin of the checkout is stripped of its credentials.
+	sb.WriteString(fmt.Sprintf("git -C %s -c remote.origin.url=\"$EARTHLY_GIT_URL\" lfs pull ; ", shellescape.Quote(srcPath)))
+	return sb.String()
+}
@@ -0,0 +1,210 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testLFSPointer = "version https://git-lfs.github.com/spec/v1\n" +
+	"oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n" +
+	"size 12345\n"
+
+func TestResolveLFSPointers(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile":    "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitLFSPointersFile: "other/model.bin\x00sub/assets/logo.png\x00sub/data.bin\x00",
+	}
+	newResolver := func(strict bool) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			LFS: LFSOpt{DetectPointers: true, StrictPointers: strict},
+		}), &buf
+	}
+
+	gwClient := newTestGwClient(files)
+	r, buf := newResolver(false)
+	for i := 0; i < 2; i++ {
+		_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+	// Only the pointers within the build context are listed, once.
+	Equal(t, 1, strings.Count(buf.String(), "Warning: the build context of github.com/earthly/test/sub:main contains git LFS pointer files rather than their content: sub/assets/logo.png, sub/data.bin\n"), buf.String())
+	var scripts []string
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			scripts = append(scripts, exec.Meta.Args[len(exec.Meta.Args)-1])
+		}
+	}
+	NotEmpty(t, scripts)
+	for _, script := range scripts {
+		Contains(t, script, "grep -l -z -I --full-name --all-match")
+	}
+
+	r, _ = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	var lfsErr ErrLFSPointers
+	True(t, errors.As(err, &lfsErr), "unexpected error %v", err)
+	Equal(t, ErrLFSPointers{Ref: "github.com/earthly/test/sub:main", Paths: []string{"sub/assets/logo.png", "sub/data.bin"}}, lfsErr)
+
+	// No pointers within the build context.
+	files[gitLFSPointersFile] = "other/model.bin\x00"
+	r, buf = newResolver(true)
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, buf.String())
+}
+
+func TestGitCloneScriptLFSPointers(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+	}
+	write := func(name, content string) {
+		p := filepath.Join(repo, name)
+		NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	runClone := func() string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, false, false, false, false, true, false, false, false, false, 0, 0, 0, "", filepath.Join(t.TempDir(), "src"), dest, nil))
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL="+repo,
+			"EARTHLY_GIT_ORIGIN="+repo,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		pointers, err := os.ReadFile(filepath.Join(dest, gitLFSPointersFile))
+		NoError(t, err)
+		return string(pointers)
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+	write("Earthfile", "VERSION 0.6\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "no pointers")
+	Empty(t, runClone())
+
+	write(".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	write("assets/model.bin", testLFSPointer)
+	write("sub dir/data.bin", testLFSPointer)
+	// Mentioning the spec is not enough to be taken for a pointer.
+	write("README.md", "version https://git-lfs.github.com/spec/v1\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "pointers")
+	Equal(t, "assets/model.bin\x00sub dir/data.bin\x00", runClone())
+}
+
+func TestGitLFSObjectsCommand(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(repo, name)
+		NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+	}
+	git("init", "--quiet")
+	runCommand := func() string {
+		// git grep searches the tracked files.
+		git("add", ".")
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitLFSObjectsCommand(dest))
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git lfs objects command: %s", out)
+		oids, err := os.ReadFile(filepath.Join(dest, gitLFSObjectsFile))
+		NoError(t, err)
+		return string(oids)
+	}
+
+	write("Earthfile", "VERSION 0.6\n")
+	Empty(t, runCommand())
+
+	other := strings.Replace(testLFSPointer, "4d7a2146", "0a7a2146", 1)
+	write("assets/model.bin", testLFSPointer)
+	write("assets/copy.bin", testLFSPointer)
+	write("sub dir/data.bin", other)
+	// Not a pointer, without its size.
+	write("README.md", "version https://git-lfs.github.com/spec/v1\noid sha256:1d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n")
+	// Listed once each, sorted.
+	Equal(t, "0a7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n"+
+		"4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n", runCommand())
+}
+
+func TestResolveFetchLFS(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"Earthfile":        "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitLFSPointersFile: "assets/model.bin\x00",
+		gitLFSObjectsFile:  "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n",
+	}
+	lfsFetches := func(opt ResolverOpt) []*pb.ExecOp {
+		r := newTestResolver(t, opt)
+		d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		def, err := d.BuildContextFactory.Construct().Marshal(context.Background())
+		NoError(t, err, "marshal build context")
+		var fetches []*pb.ExecOp
+		for _, dt := range def.Def {
+			var op pb.Op
+			NoError(t, op.Unmarshal(dt), "unmarshal op")
+			if exec := op.GetExec(); exec != nil && strings.Contains(exec.Meta.Args[len(exec.Meta.Args)-1], "lfs pull") {
+				fetches = append(fetches, exec)
+			}
+		}
+		return fetches
+	}
+
+	fetches := lfsFetches(ResolverOpt{LFS: LFSOpt{Fetch: true, DetectPointers: true}})
+	if Len(t, fetches, 1) {
+		var cacheIDs []string
+		for _, m := range fetches[0].Mounts {
+			if m.CacheOpt != nil {
+				cacheIDs = append(cacheIDs, m.CacheOpt.ID)
+			}
+		}
+		Equal(t, []string{gitLFSCacheID}, cacheIDs)
+		Contains(t, fetches[0].Meta.Args[len(fetches[0].Meta.Args)-1], "lfs.storage="+gitLFSStorageDir)
+	}
+
+	// Nothing to fetch.
+	files[gitLFSObjectsFile] = ""
+	Empty(t, lfsFetches(ResolverOpt{LFS: LFSOpt{Fetch: true}}))
+
+	// Not fetched unless enabled.
+	files[gitLFSObjectsFile] = "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n"
+	Empty(t, lfsFetches(ResolverOpt{LFS: LFSOpt{DetectPointers: true}}))
+}
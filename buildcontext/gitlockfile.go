@@ -0,0 +1,153 @@
+package buildcontext
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/earthly/earthly/util/syncutil/synccache"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// Lockfile maps the refs of remote repositories to the commits they have been resolved to, along
+// with their metadata. See Resolver.ImportLockfile.
+type Lockfile struct {
+	Refs []LockfileRef `json:"refs"`
+}
+
+// LockfileRef is the resolution of a ref of a remote repository recorded in a Lockfile.
+type LockfileRef struct {
+	// Repo is the repository, as in references (e.g. github.com/earthly/earthly), and Ref the
+	// branch, tag or commit of it.
+	Repo string `json:"repo"`
+	Ref  string `json:"ref"`
+	// Hash is the full hash of the commit Ref resolves to. The other fields are the metadata of the
+	// commit, as in gitutil.GitMetadata.
+	Hash         string   `json:"hash"`
+	ShortHash    string   `json:"shortHash,omitempty"`
+	Branches     []string `json:"branches,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Timestamp    string   `json:"timestamp,omitempty"`
+	Author       string   `json:"author,omitempty"`
+	CoAuthors    []string `json:"coAuthors,omitempty"`
+	ParentHashes []string `json:"parentHashes,omitempty"`
+}
+
+// ErrLockfileMismatch is returned, when VerifyLockfile is set, when a ref imported from a lockfile
+// now resolves to another commit than the one recorded.
+type ErrLockfileMismatch struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	Ref  string
+	// LockedCommit is the commit recorded in the lockfile, and Commit the one Ref resolves to.
+	LockedCommit string
+	Commit       string
+}
+
+// Error is function required by error interface.
+func (err ErrLockfileMismatch) Error() string {
+	return fmt.Sprintf("the ref %s of %s resolves to commit %s, rather than %s as per the lockfile", err.Ref, err.Repo, err.Commit, err.LockedCommit)
+}
+
+var gitFullHashRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ImportLockfile populates the cache of remote projects out of a JSON-encoded Lockfile, so that
+// resolving the refs it holds skips cloning their repository: the build files and build contexts
+// are fetched straight at the recorded commits. The imported projects are trusted, unless
+// VerifyLockfile is set. Refs already resolved are replaced.
+func (r *Resolver) ImportLockfile(data []byte) error {
+	var lockfile Lockfile
+	err := json.Unmarshal(data, &lockfile)
+	if err != nil {
+		return errors.Wrap(err, "parse lockfile")
+	}
+	for i, lr := range lockfile.Refs {
+		err := r.gr.importLockfileRef(lr)
+		if err != nil {
+			return errors.Wrapf(err, "import lockfile entry %d", i)
+		}
+	}
+	return nil
+}
+
+// importLockfileRef adds the project of a lockfile entry to the project cache, under the key its
+// resolution would have.
+func (gr *gitResolver) importLockfileRef(lr LockfileRef) error {
+	if lr.Repo == "" || lr.Ref == "" {
+		return errors.New("missing repo or ref")
+	}
+	if !gitFullHashRegexp.MatchString(lr.Hash) {
+		return errors.Errorf("invalid commit hash %q of %s:%s", lr.Hash, lr.Repo, lr.Ref)
+	}
+	ref := domain.Target{GitURL: lr.Repo, Tag: lr.Ref}
+	err := checkGitRefLength(ref)
+	if err != nil {
+		return err
+	}
+	candidates, _, err := gr.gitLookup.getCloneURLs(lr.Repo)
+	if err != nil {
+		return errors.Wrap(err, "failed to get url for cloning")
+	}
+	clone := candidates[0]
+	shortHash := lr.ShortHash
+	if shortHash == "" {
+		shortHash = lr.Hash[:8]
+	}
+	rgp := &resolvedGitProject{
+		hash:       lr.Hash,
+		shortHash:  shortHash,
+		branches:   lr.Branches,
+		tags:       lr.Tags,
+		ts:         lr.Timestamp,
+		author:     lr.Author,
+		coAuthors:  lr.CoAuthors,
+		parents:    lr.ParentHashes,
+		gitURL:     clone.gitURL,
+		keyScans:   clone.keyScans,
+		state:      pllb.Git(clone.gitURL, lr.Hash, contextGitOpts(clone.gitURL, ref, clone.keyScans)...),
+		locked:     true,
+		resolvedAt: time.Now(),
+	}
+	keyURL := gr.cacheKeyURL(clone.gitURL)
+	cacheKey := gr.namespacedKey(gitProjectKey(keyURL, lr.Ref))
+	gr.repoKeys.addProject(keyURL, cacheKey)
+	gr.projectCache.Delete(cacheKey)
+	return gr.projectCache.Add(context.Background(), cacheKey, rgp, nil)
+}
+
+// verifyLockedProject checks, when VerifyLockfile is set, that the ref of a project imported from a
+// lockfile still resolves to the recorded commit, by resolving it afresh. The outcome is kept,
+// unless the resolution failed.
+func (gr *gitResolver) verifyLockedProject(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, rgp *resolvedGitProject) error {
+	if !rgp.locked || !gr.verifyLockfile {
+		return nil
+	}
+	rgp.lockMu.Lock()
+	defer rgp.lockMu.Unlock()
+	if rgp.lockVerified {
+		return rgp.lockErr
+	}
+	fresh := *gr
+	fresh.projectCache = synccache.New()
+	resolved, _, _, err := fresh.resolveGitProject(ctx, gwClient, platr, ref)
+	if err != nil {
+		return errors.Wrapf(err, "verify the lockfile commit of %s", ref.ProjectCanonical())
+	}
+	rgp.lockVerified = true
+	if resolved.hash != rgp.hash {
+		rgp.lockErr = ErrLockfileMismatch{
+			Repo:         stringutil.ScrubCredentials(rgp.gitURL),
+			Ref:          ref.GetTag(),
+			LockedCommit: rgp.hash,
+			Commit:       resolved.hash,
+		}
+	}
+	return rgp.lockErr
+}
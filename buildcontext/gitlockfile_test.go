@@ -0,0 +1,115 @@
+package buildcontext
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testLockfile = `{
+	"refs": [
+		{
+			"repo": "github.com/earthly/test",
+			"ref": "main",
+			"hash": "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c",
+			"branches": ["main"],
+			"timestamp": "1660000000",
+			"author": "locked@example.com"
+		}
+	]
+}`
+
+func TestResolveImportLockfile(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	r := newTestResolver(t, ResolverOpt{})
+	err := r.ImportLockfile([]byte(testLockfile))
+	NoError(t, err, "ImportLockfile failed")
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c", d.GitMetadata.Hash)
+	Equal(t, "0f1e2d3c", d.GitMetadata.ShortHash)
+	Equal(t, []string{"main"}, d.GitMetadata.Branch)
+	Equal(t, "1660000000", d.GitMetadata.Timestamp)
+	Equal(t, "locked@example.com", d.GitMetadata.Author)
+	NotNil(t, d.BuildContextFactory)
+
+	// The repository is not cloned: the build file is fetched at the locked commit.
+	Equal(t, 0, gwClient.numMetaRuns(t))
+	var sources []string
+	for _, op := range gwClient.solvedOps(t) {
+		if src := op.GetSource(); src != nil && strings.HasPrefix(src.Identifier, "git://") {
+			sources = append(sources, src.Identifier)
+		}
+	}
+	Equal(t, []string{"git://github.com/earthly/test.git#0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c"}, sources)
+
+	// Other refs are resolved as usual.
+	ref, err = domain.ParseTarget("github.com/earthly/test/sub:v1.0.0+build")
+	NoError(t, err)
+	d, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", d.GitMetadata.Hash)
+	Equal(t, 1, gwClient.numMetaRuns(t))
+}
+
+func TestResolveImportLockfileInvalid(t *testing.T) {
+	r := newTestResolver(t, ResolverOpt{})
+	for _, lockfile := range []string{
+		`{"refs": [`,
+		`{"refs": [{"repo": "github.com/earthly/test", "hash": "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c"}]}`,
+		`{"refs": [{"repo": "github.com/earthly/test", "ref": "main", "hash": "0f1e2d3c"}]}`,
+	} {
+		Error(t, r.ImportLockfile([]byte(lockfile)), "lockfile %s", lockfile)
+	}
+}
+
+func TestResolveVerifyLockfile(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	tests := []struct {
+		name     string
+		hash     string
+		mismatch bool
+	}{
+		{"up to date", "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", false},
+		{"moved", "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gwClient := newTestGwClient(map[string]string{
+				"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			})
+			r := newTestResolver(t, ResolverOpt{VerifyLockfile: true})
+			err := r.ImportLockfile([]byte(strings.ReplaceAll(testLockfile, "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c", tc.hash)))
+			NoError(t, err, "ImportLockfile failed")
+			for i := 0; i < 2; i++ {
+				d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+				if tc.mismatch {
+					var mismatchErr ErrLockfileMismatch
+					True(t, errors.As(err, &mismatchErr), "unexpected error %v", err)
+					Equal(t, ErrLockfileMismatch{
+						Repo:         "https://github.com/earthly/test.git",
+						Ref:          "main",
+						LockedCommit: tc.hash,
+						Commit:       "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5",
+					}, mismatchErr)
+				} else {
+					NoError(t, err, "Resolve failed")
+					Equal(t, tc.hash, d.GitMetadata.Hash)
+					Equal(t, "locked@example.com", d.GitMetadata.Author)
+				}
+			}
+			// The ref is verified once.
+			Equal(t, 1, gwClient.numMetaRuns(t))
+		})
+	}
+}
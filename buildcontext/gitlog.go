@@ -0,0 +1,33 @@
+package buildcontext
+
+import (
+	"fmt"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/stringutil"
+)
+
+// logGitCommand logs the command run in the git image for the given ref, including the
+// environment it is run with, with credentials scrubbed. When the clone is performed by buildkit
+// (clonedByBuildkit), it is logged as well.
+func (gr *gitResolver) logGitCommand(ref domain.Reference, gitURL, gitRef, script string, clonedByBuildkit bool) {
+	var msg string
+	if clonedByBuildkit {
+		msg = fmt.Sprintf("git commands for %s: buildkit git clone %s at %q, then: /bin/sh -c %s",
+			ref.ProjectCanonical(), stripGitURLCredentials(gitURL), gitRef, shellescape.Quote(script))
+	} else {
+		msg = fmt.Sprintf("git commands for %s: EARTHLY_GIT_URL=%s EARTHLY_GIT_REF=%s /bin/sh -c %s",
+			ref.ProjectCanonical(), shellescape.Quote(stripGitURLCredentials(gitURL)), shellescape.Quote(gitRef), shellescape.Quote(script))
+	}
+	msg = stringutil.ScrubCredentials(msg)
+	switch gr.gitCommandLogLevel {
+	case conslogging.Info:
+		gr.console.Printf("%s\n", msg)
+	case conslogging.Verbose:
+		gr.console.VerbosePrintf("%s\n", msg)
+	default:
+		gr.console.DebugPrintf("%s\n", msg)
+	}
+}
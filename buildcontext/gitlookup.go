@@ -2,11 +2,13 @@ package buildcontext
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -22,6 +24,8 @@ import (
 
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/util/fileutil"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/earthly/earthly/util/syncutil/synccache"
 
 	"github.com/jdxcode/netrc"
 	"github.com/moby/buildkit/util/gitutil"
@@ -48,8 +52,16 @@ const (
 	sshProtocol   gitProtocol = "ssh"
 	httpProtocol  gitProtocol = "http"
 	httpsProtocol gitProtocol = "https"
+	// gitAnonProtocol is the anonymous (and insecure) git:// protocol.
+	gitAnonProtocol gitProtocol = "git"
 )
 
+// cloneCandidate is a url a repository may be cloned from.
+type cloneCandidate struct {
+	gitURL   string
+	keyScans []string
+}
+
 // GitLookup looksup gits
 type GitLookup struct {
 	mu            sync.Mutex
@@ -59,6 +71,14 @@ type GitLookup struct {
 	sshAuthSock   string
 	keyScans      []string
 	console       conslogging.ConsoleLogger
+
+	anonymousFallback bool
+	probePublic       func(httpsURL string) (bool, error)
+	publicRepos       *synccache.SyncCache // anonymous https url -> whether it can be cloned
+	sshAuthOnce       sync.Once
+	sshAuth           bool
+
+	protocolPreferences map[string][]gitProtocol // host -> protocols to attempt, in order
 }
 
 var defaultKeyScans = []string{
@@ -89,10 +109,38 @@ func NewGitLookup(console conslogging.ConsoleLogger, sshAuthSock string) *GitLoo
 		autoProtocols: map[string]gitProtocol{},
 		sshAuthSock:   sshAuthSock,
 		console:       console,
+		probePublic:   probePublicHTTPSRepo,
+		publicRepos:   synccache.New(),
+
+		protocolPreferences: map[string][]gitProtocol{},
 	}
 	return gl
 }
 
+// SetProtocolPreference makes the repos of the given host be cloned via the first of the given
+// protocols (git, http, https or ssh) which succeeds, attempted in order. This is typically used to
+// try the faster, but insecure, anonymous git protocol of public mirrors first, falling back to https.
+func (gl *GitLookup) SetProtocolPreference(host string, protocols ...string) error {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if len(protocols) == 0 {
+		delete(gl.protocolPreferences, host)
+		return nil
+	}
+	var prefs []gitProtocol
+	for _, protocol := range protocols {
+		p := gitProtocol(protocol)
+		switch p {
+		case gitAnonProtocol, httpProtocol, httpsProtocol, sshProtocol:
+		default:
+			return errors.Errorf("unsupported git protocol %q for %s", protocol, host)
+		}
+		prefs = append(prefs, p)
+	}
+	gl.protocolPreferences[host] = prefs
+	return nil
+}
+
 // ErrNoMatch occurs when no git matcher is found
 var ErrNoMatch = errors.Errorf("no git match found")
 
@@ -110,6 +158,83 @@ func (gl *GitLookup) DisableSSH() {
 	}
 }
 
+// EnableAnonymousFallback makes repos configured for ssh be cloned anonymously over https when no
+// ssh credentials are available, provided the repo can be cloned anonymously. Repos which require
+// authentication still fail.
+func (gl *GitLookup) EnableAnonymousFallback() {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.anonymousFallback = true
+}
+
+// sshAuthAvailable returns whether the ssh agent is reachable and holds at least one key. The agent
+// is only checked the first time.
+func (gl *GitLookup) sshAuthAvailable() bool {
+	gl.sshAuthOnce.Do(func() {
+		if gl.sshAuthSock == "" {
+			return
+		}
+		sshAgent, err := net.Dial("unix", gl.sshAuthSock)
+		if err != nil {
+			return
+		}
+		defer sshAgent.Close()
+		keys, err := agent.NewClient(sshAgent).List()
+		gl.sshAuth = err == nil && len(keys) > 0
+	})
+	return gl.sshAuth
+}
+
+// isPublicRepo returns whether the https repo can be cloned without authentication. The outcome is
+// cached, for each repo to be probed once, unless the probe fails.
+func (gl *GitLookup) isPublicRepo(httpsURL string) (bool, error) {
+	v, err := gl.publicRepos.Do(context.Background(), httpsURL, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return gl.probePublic(httpsURL)
+	})
+	if err != nil {
+		gl.publicRepos.Delete(httpsURL)
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// withAnonymousFallback returns the candidate to clone from anonymously over https (anonURL) instead
+// of the given one, when the given one is cloned over ssh while no ssh credentials are available,
+// provided the repo can be cloned anonymously. It may dial the ssh agent and probe the repo, and is
+// thus not to be called with gl.mu held.
+func (gl *GitLookup) withAnonymousFallback(c cloneCandidate, anonURL string) (cloneCandidate, error) {
+	if _, protocol := gitutil.ParseProtocol(c.gitURL); protocol != gitutil.SSHProtocol || gl.sshAuthAvailable() {
+		return c, nil
+	}
+	public, err := gl.isPublicRepo(anonURL)
+	if err != nil {
+		return cloneCandidate{}, err
+	}
+	if !public {
+		return cloneCandidate{}, errors.Errorf("authentication is required to clone %s, but no ssh credentials are available", anonURL)
+	}
+	gl.console.VerbosePrintf("no ssh credentials available for %s; falling back to an anonymous https clone", anonURL)
+	return cloneCandidate{gitURL: anonURL}, nil
+}
+
+// probePublicHTTPSRepo returns whether the https repo can be cloned without authentication.
+func probePublicHTTPSRepo(httpsURL string) (bool, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(httpsURL + "/info/refs?service=git-upload-pack")
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to probe %s", httpsURL)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf("unexpected status %d probing %s", resp.StatusCode, httpsURL)
+	}
+}
+
 func knownHostsToKeyScans(knownHosts string) []string {
 	knownHosts = strings.ReplaceAll(knownHosts, "\r\n", "\n")
 	var keyScans []string
@@ -533,6 +658,12 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 			userAndPass = url.QueryEscape(user) + ":" + url.QueryEscape(password) + "@"
 		}
 		gitURL = "https://" + userAndPass + host + "/" + gitPath
+	case gitAnonProtocol:
+		var portStr string
+		if m.port != 0 {
+			portStr = fmt.Sprintf(":%d", m.port)
+		}
+		gitURL = "git://" + host + portStr + "/" + strings.TrimPrefix(gitPath, "/")
 	default:
 		return "", nil, errors.Errorf("unsupported protocol: %s", configuredProtocol)
 	}
@@ -547,11 +678,50 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 //
 // Additionally a ssh keyscan might be returned (or an empty string indicating none was configured)
 func (gl *GitLookup) GetCloneURL(path string) (string, string, []string, error) {
+	candidates, subPath, err := gl.getCloneURLs(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return candidates[0].gitURL, subPath, candidates[0].keyScans, nil
+}
+
+// getCloneURLs is like GetCloneURL, but returns all the urls to attempt cloning from, in order of
+// preference. There is more than one only when a protocol preference is set for the host.
+func (gl *GitLookup) getCloneURLs(path string) ([]cloneCandidate, string, error) {
+	candidates, subPath, anonURL, err := gl.matchCloneURLs(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if anonURL == "" {
+		return candidates, subPath, nil
+	}
+	var fallbacks []cloneCandidate
+	var firstErr error
+	for _, c := range candidates {
+		fallback, err := gl.withAnonymousFallback(c, anonURL)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			gl.console.VerbosePrintf("unable to clone %s: %s", stringutil.ScrubCredentials(c.gitURL), err.Error())
+			continue
+		}
+		fallbacks = append(fallbacks, fallback)
+	}
+	if len(fallbacks) == 0 {
+		return nil, "", firstErr
+	}
+	return fallbacks, subPath, nil
+}
+
+// matchCloneURLs returns the urls to attempt cloning from, along with the url to clone from
+// anonymously should the ssh ones not be usable, when the anonymous fallback is enabled.
+func (gl *GitLookup) matchCloneURLs(path string) ([]cloneCandidate, string, string, error) {
 	gl.mu.Lock()
 	defer gl.mu.Unlock()
 	match, m, err := gl.getGitMatcherByPath(path)
 	if err != nil {
-		return "", "", nil, err
+		return nil, "", "", err
 	}
 
 	n := len(match)
@@ -565,7 +735,7 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, []string, error)
 
 	if m.sub != "" {
 		if !m.re.MatchString(path) {
-			return "", "", nil, errors.Errorf("failed to determine git path to clone for %q", path)
+			return nil, "", "", errors.Errorf("failed to determine git path to clone for %q", path)
 		}
 		gitURL := m.re.ReplaceAllString(path, m.sub)
 		var keyScans []string
@@ -574,20 +744,42 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, []string, error)
 			subHost := remote[:strings.IndexByte(remote, '/')]
 			_, keyScans, err = gl.getHostKeyAlgorithms(subHost)
 			if err != nil {
-				return "", "", nil, err
+				return nil, "", "", err
 			}
 			if len(keyScans) == 0 && m.strictHostKeyChecking {
-				return "", "", nil, errors.Errorf("no known_hosts entries exist for substituted host %s", subHost)
+				return nil, "", "", errors.Errorf("no known_hosts entries exist for substituted host %s", subHost)
 			}
 		}
-		return gitURL, subPath, keyScans, nil
+		return []cloneCandidate{{gitURL: gitURL, keyScans: keyScans}}, subPath, "", nil
 	}
 
-	gitURL, keyScans, err := gl.makeCloneURL(m, host, gitPath)
-	if err != nil {
-		return "", "", nil, err
+	var anonURL string
+	if gl.anonymousFallback {
+		anonURL = "https://" + host + "/" + strings.TrimPrefix(gitPath, "/")
+	}
+	prefs, ok := gl.protocolPreferences[host]
+	if !ok {
+		gitURL, keyScans, err := gl.makeCloneURL(m, host, gitPath)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return []cloneCandidate{{gitURL: gitURL, keyScans: keyScans}}, subPath, anonURL, nil
 	}
-	return gitURL, subPath, keyScans, nil
+	var candidates []cloneCandidate
+	for i, p := range prefs {
+		pm := *m
+		pm.protocol = p
+		gitURL, keyScans, err := gl.makeCloneURL(&pm, host, gitPath)
+		if err != nil {
+			if len(candidates) == 0 && i == len(prefs)-1 {
+				return nil, "", "", err
+			}
+			gl.console.VerbosePrintf("unable to clone %s over %s: %s", host, p, err.Error())
+			continue
+		}
+		candidates = append(candidates, cloneCandidate{gitURL: gitURL, keyScans: keyScans})
+	}
+	return candidates, subPath, anonURL, nil
 }
 
 // ConvertCloneURL takes a url such as https://github.com/user/repo.git or git@github.com:user/repo.git
@@ -653,9 +845,16 @@ func (gl *GitLookup) ConvertCloneURL(inURL string) (string, []string, error) {
 		return gitURL, keyScans, nil
 	}
 
-	return gl.makeCloneURL(m, host,
-		m.prefix+gitPath, // Note that inURL already contains the suffix
-	)
+	gitPath = m.prefix + gitPath // Note that inURL already contains the suffix
+	gitURL, keyScans, err := gl.makeCloneURL(m, host, gitPath)
+	if err != nil || !gl.anonymousFallback {
+		return gitURL, keyScans, err
+	}
+	c, err := gl.withAnonymousFallback(cloneCandidate{gitURL: gitURL, keyScans: keyScans}, "https://"+host+"/"+strings.TrimPrefix(gitPath, "/"))
+	if err != nil {
+		return "", nil, err
+	}
+	return c.gitURL, c.keyScans, nil
 }
 
 func loadKnownHostsFromPath(path string) ([]string, error) {
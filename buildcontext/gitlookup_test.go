@@ -2,7 +2,12 @@ package buildcontext
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/earthly/earthly/conslogging"
 
 	. "github.com/stretchr/testify/assert"
 )
@@ -120,3 +125,119 @@ func Test_parseKeyScanIfHostMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCloneURLAnonymousFallback(t *testing.T) {
+	gl := NewGitLookup(conslogging.Current(conslogging.NoColor, 0, conslogging.Info), "")
+	err := gl.AddMatcher("git.example.com", "git.example.com/[^/]+/[^/]+", "", "git", "", "", ".git", "ssh", "", false, 0)
+	NoError(t, err)
+	var probed []string
+	gl.probePublic = func(httpsURL string) (bool, error) {
+		probed = append(probed, httpsURL)
+		return httpsURL == "https://git.example.com/earthly/public.git", nil
+	}
+
+	// Without the fallback, the ssh url is used even though no credentials are available.
+	gitURL, _, _, err := gl.GetCloneURL("git.example.com/earthly/public")
+	NoError(t, err)
+	Equal(t, "git@git.example.com:earthly/public.git", gitURL)
+	Empty(t, probed)
+
+	gl.EnableAnonymousFallback()
+	gitURL, subDir, keyScans, err := gl.GetCloneURL("git.example.com/earthly/public/sub")
+	NoError(t, err)
+	Equal(t, "https://git.example.com/earthly/public.git", gitURL)
+	Equal(t, "sub", subDir)
+	Empty(t, keyScans)
+
+	// Auth is required but missing.
+	_, _, _, err = gl.GetCloneURL("git.example.com/earthly/private")
+	Error(t, err)
+	Contains(t, err.Error(), "authentication is required")
+	Equal(t, []string{"https://git.example.com/earthly/public.git", "https://git.example.com/earthly/private.git"}, probed)
+}
+
+func TestGetCloneURLAnonymousFallbackProbeOnce(t *testing.T) {
+	gl := NewGitLookup(conslogging.Current(conslogging.NoColor, 0, conslogging.Info), "")
+	err := gl.AddMatcher("git.example.com", "git.example.com/[^/]+/[^/]+", "", "git", "", "", ".git", "ssh", "", false, 0)
+	NoError(t, err)
+	gl.EnableAnonymousFallback()
+	var probes int32
+	var startedOnce sync.Once
+	started := make(chan struct{})
+	release := make(chan struct{})
+	gl.probePublic = func(httpsURL string) (bool, error) {
+		atomic.AddInt32(&probes, 1)
+		startedOnce.Do(func() {
+			close(started)
+		})
+		<-release
+		return true, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gitURL, _, _, err := gl.GetCloneURL("git.example.com/earthly/public")
+			NoError(t, err)
+			Equal(t, "https://git.example.com/earthly/public.git", gitURL)
+		}()
+	}
+	<-started
+	// Other lookups are not held up by the ongoing probe.
+	looked := make(chan struct{})
+	go func() {
+		defer close(looked)
+		gitURL, _, _, err := gl.GetCloneURL("github.com/earthly/earthly")
+		NoError(t, err)
+		Equal(t, "https://github.com/earthly/earthly.git", gitURL)
+	}()
+	select {
+	case <-looked:
+	case <-time.After(10 * time.Second):
+		Fail(t, "lookup held up by the probe")
+	}
+	close(release)
+	wg.Wait()
+	Equal(t, int32(1), atomic.LoadInt32(&probes))
+
+	// The outcome is kept for later lookups of the repo.
+	gitURL, _, _, err := gl.GetCloneURL("git.example.com/earthly/public/sub")
+	NoError(t, err)
+	Equal(t, "https://git.example.com/earthly/public.git", gitURL)
+	Equal(t, int32(1), atomic.LoadInt32(&probes))
+}
+
+func TestGetCloneURLsProtocolPreference(t *testing.T) {
+	gl := NewGitLookup(conslogging.Current(conslogging.NoColor, 0, conslogging.Info), "")
+	err := gl.SetProtocolPreference("github.com", "git", "https")
+	NoError(t, err)
+
+	candidates, subDir, err := gl.getCloneURLs("github.com/earthly/earthly/examples")
+	NoError(t, err)
+	Equal(t, "examples", subDir)
+	Equal(t, []cloneCandidate{
+		{gitURL: "git://github.com/earthly/earthly.git"},
+		{gitURL: "https://github.com/earthly/earthly.git"},
+	}, candidates)
+
+	// The most preferred url is the one returned by GetCloneURL.
+	gitURL, _, keyScans, err := gl.GetCloneURL("github.com/earthly/earthly")
+	NoError(t, err)
+	Equal(t, "git://github.com/earthly/earthly.git", gitURL)
+	Empty(t, keyScans)
+
+	// Other hosts are not affected.
+	candidates, _, err = gl.getCloneURLs("gitlab.com/earthly/earthly")
+	NoError(t, err)
+	Len(t, candidates, 1)
+
+	err = gl.SetProtocolPreference("github.com", "ftp")
+	Error(t, err)
+	err = gl.SetProtocolPreference("github.com")
+	NoError(t, err)
+	candidates, _, err = gl.getCloneURLs("github.com/earthly/earthly")
+	NoError(t, err)
+	Equal(t, []cloneCandidate{{gitURL: "https://github.com/earthly/earthly.git"}}, candidates)
+}
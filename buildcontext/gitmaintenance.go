@@ -0,0 +1,52 @@
+package buildcontext
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/alessio/shellescape"
+)
+
+// GitMirrorOpt determines whether remote repositories are kept as mirrors, and how the mirrors are
+// maintained.
+type GitMirrorOpt struct {
+	// Enabled keeps a bare mirror of each remote repository in a persistent buildkit cache mount,
+	// which is fetched into on every resolution, instead of cloning the repository afresh. When no
+	// mirror exists yet for a repository, it is cloned into a new one. Access to the mirror of a
+	// given repository is serialized.
+	Enabled bool
+	// MaintenanceInterval, if set, runs git maintenance (repacking the objects and pruning the
+	// unreachable ones) on the mirrors after they are fetched into, at most once per interval for
+	// each. Runs taking longer than MaintenanceTimeout, if set, are stopped.
+	MaintenanceInterval time.Duration
+	MaintenanceTimeout  time.Duration
+}
+
+// gitMaintenanceStampFile is the file of the mirror holding the time (in unix seconds) its
+// maintenance was last started at.
+const gitMaintenanceStampFile = "earthly-last-maintenance"
+
+// gitMirrorMaintenanceScript returns the shell script running maintenance (repacking the objects
+// and pruning the unreachable ones) on the mirror at mirrorDir, unless it was last started less
+// than interval ago. The run is stopped after timeout, if set. Its time is recorded beforehand, so
+// that a failing or stopped run is not retried on every build. Failures are ignored, the mirror
+// remaining usable.
+func gitMirrorMaintenanceScript(mirrorDir string, interval, timeout time.Duration) string {
+	mirror := shellescape.Quote(mirrorDir)
+	stamp := shellescape.Quote(mirrorDir + "/" + gitMaintenanceStampFile)
+	// Running git from timeout bypasses the git function of the script, if any; the config it
+	// passes only matters to remote operations.
+	var limit string
+	if timeout > 0 {
+		limit = fmt.Sprintf("timeout %d ", int64(math.Ceil(timeout.Seconds())))
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("last=$(cat %s 2>/dev/null || echo 0) ; now=$(date +%%s) ; ", stamp))
+	sb.WriteString(fmt.Sprintf("if [ $((now - last)) -ge %d ] ; then ", int64(interval.Seconds())))
+	sb.WriteString(fmt.Sprintf("echo $now >%s ; ", stamp))
+	sb.WriteString(fmt.Sprintf("%sgit -C %s maintenance run --quiet --task=gc || true ; ", limit, mirror))
+	sb.WriteString("fi ; ")
+	return sb.String()
+}
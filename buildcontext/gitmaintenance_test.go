@@ -0,0 +1,109 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitMirrorMaintenanceScript(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	repo := t.TempDir()
+	git(repo, "init", "--quiet", "--initial-branch=main")
+	commit := func(msg string) {
+		git(repo, "commit", "--quiet", "--allow-empty", "-m", msg)
+	}
+	commit("first")
+	mirror := filepath.Join(t.TempDir(), "mirror")
+	git(repo, "clone", "--quiet", "--bare", repo, mirror)
+	// Fetched objects are loose, below the unpack limit.
+	fetch := func() {
+		commit("next")
+		git(mirror, "-c", "fetch.unpackLimit=1000", "fetch", "--quiet", repo, "+refs/heads/*:refs/heads/*")
+	}
+	looseObjects := func() int {
+		for _, line := range strings.Split(git(mirror, "count-objects", "-v"), "\n") {
+			if strings.HasPrefix(line, "count: ") {
+				count, err := strconv.Atoi(strings.TrimPrefix(line, "count: "))
+				NoError(t, err)
+				return count
+			}
+		}
+		t.Fatal("no count of loose objects")
+		return 0
+	}
+	runMaintenance := func() {
+		cmd := exec.Command("/bin/sh", "-c", gitMirrorMaintenanceScript(mirror, time.Hour, time.Minute))
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "maintenance script: %s", out)
+	}
+	stamp := filepath.Join(mirror, gitMaintenanceStampFile)
+
+	fetch()
+	Greater(t, looseObjects(), 0)
+	runMaintenance()
+	Equal(t, 0, looseObjects())
+	_, err := os.Stat(stamp)
+	NoError(t, err)
+
+	// Not run again within the interval.
+	fetch()
+	runMaintenance()
+	Greater(t, looseObjects(), 0)
+
+	// Run again once the interval has elapsed.
+	NoError(t, os.WriteFile(stamp, []byte(strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)+"\n"), 0644))
+	runMaintenance()
+	Equal(t, 0, looseObjects())
+}
+
+func TestResolveGitMirrorMaintenance(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	for _, opt := range []ResolverOpt{
+		{GitMirror: GitMirrorOpt{Enabled: true}},
+		{GitMirror: GitMirrorOpt{MaintenanceInterval: time.Hour}},
+		{GitMirror: GitMirrorOpt{Enabled: true, MaintenanceInterval: 24 * time.Hour, MaintenanceTimeout: 90 * time.Second}},
+	} {
+		gwClient := newTestGwClient(map[string]string{
+			"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		})
+		r := newTestResolver(t, opt)
+		_, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		var scripts []string
+		for _, op := range gwClient.solvedOps(t) {
+			if exec := op.GetExec(); exec != nil {
+				scripts = append(scripts, exec.Meta.Args[len(exec.Meta.Args)-1])
+			}
+		}
+		if opt.GitMirror.Enabled {
+			NotEmpty(t, scripts)
+		}
+		for _, script := range scripts {
+			if opt.GitMirror.Enabled && opt.GitMirror.MaintenanceInterval > 0 {
+				Contains(t, script, "-ge 86400 ] ; then ")
+				Contains(t, script, "timeout 90 git -C /git-mirror maintenance run --quiet --task=gc")
+			} else {
+				NotContains(t, script, "maintenance run")
+			}
+		}
+	}
+}
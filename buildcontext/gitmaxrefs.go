@@ -0,0 +1,95 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// gitTooManyRefsFile is the git meta file holding the number of refs found, with MaxGitRefs, when
+// the remote repository has more refs than allowed. It is empty otherwise.
+const gitTooManyRefsFile = "git-too-many-refs"
+
+// ErrTooManyRefs is returned when a remote repository has more refs than MaxGitRefs, and the
+// requested ref could not be fetched on its own.
+type ErrTooManyRefs struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	// Refs is the number of refs of the repository, and MaxRefs the maximum allowed.
+	Refs    int
+	MaxRefs int
+}
+
+// Error is function required by error interface.
+func (err ErrTooManyRefs) Error() string {
+	return fmt.Sprintf("%s has %d refs, more than the maximum of %d, and the requested ref cannot be fetched on its own", err.Repo, err.Refs, err.MaxRefs)
+}
+
+// gitRefCountCheck returns the commands stopping the script, after recording their number in
+// destPath, should the remote repository have more than maxRefs refs. They are counted out of their
+// advertisement, before anything is fetched.
+func gitRefCountCheck(maxRefs int, destPath string) string {
+	return fmt.Sprintf("n=$(git ls-remote --refs -- \"$EARTHLY_GIT_URL\" | wc -l) ; if [ \"$n\" -gt %d ] ; then echo \"$n\" >%s ; exit 0 ; fi ; ",
+		maxRefs, shellescape.Quote(path.Join(destPath, gitTooManyRefsFile)))
+}
+
+// gitTargetedFetch returns the command fetching only the requested ref ($EARTHLY_GIT_REF) of the
+// remote repository into a new repository at src, without enumerating the other refs (which
+// protocol v2 lets the server filter out). The fetched branch or tag is recorded under its own
+// name, for the metadata to reflect it. depth, if set, is the depth of the history fetched. The
+// command fails when the ref cannot be fetched this way, e.g. an abbreviated commit hash.
+func gitTargetedFetch(src string, depth int) string {
+	var depthFlag string
+	if depth > 0 {
+		depthFlag = fmt.Sprintf(" --depth=%d", depth)
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("{ git init --quiet %s && git -C %s remote add origin \"$EARTHLY_GIT_URL\" && ", src, src))
+	sb.WriteString(fmt.Sprintf("git -C %s -c protocol.version=2 fetch --quiet --no-tags%s origin \"$EARTHLY_GIT_REF\" 2>/dev/null && ", src, depthFlag))
+	sb.WriteString(gitRecordFetchedRef(src))
+	sb.WriteString(" ; }")
+	return sb.String()
+}
+
+// gitRecordFetchedRef returns the command recording the requested ref ($EARTHLY_GIT_REF), fetched
+// on its own into the repository at src, under its own name, as per FETCH_HEAD.
+func gitRecordFetchedRef(src string) string {
+	var sb strings.Builder
+	// e.g. "<hash><tab><tab>branch 'main' of <url>"
+	sb.WriteString(fmt.Sprintf("kind=$(awk -F '\\t' 'NR == 1 { split($3, a, \" \") ; print a[1] }' %s/.git/FETCH_HEAD) && ", src))
+	sb.WriteString("case \"$kind\" in ")
+	sb.WriteString(fmt.Sprintf("branch) git -C %s update-ref \"refs/remotes/origin/$EARTHLY_GIT_REF\" FETCH_HEAD && git -C %s update-ref \"refs/heads/$EARTHLY_GIT_REF\" FETCH_HEAD ;; ", src, src))
+	sb.WriteString(fmt.Sprintf("tag) git -C %s update-ref \"refs/tags/$EARTHLY_GIT_REF\" FETCH_HEAD ;; ", src))
+	// Commits need no ref, but other refs (e.g. refs/pull/1/head) do, to be checked out.
+	sb.WriteString(fmt.Sprintf("*) git -C %s cat-file -e \"$EARTHLY_GIT_REF^{commit}\" 2>/dev/null || git -C %s update-ref \"$EARTHLY_GIT_REF\" FETCH_HEAD ;; ", src, src))
+	sb.WriteString("esac")
+	return sb.String()
+}
+
+// checkTooManyRefs checks whether the git meta run stopped, the remote repository having more
+// refs than MaxGitRefs.
+func (gr *gitResolver) checkTooManyRefs(ctx context.Context, gitMetaRef gwclient.Reference, repo string) error {
+	dt, err := gr.readGitMeta(ctx, gitMetaRef, gitTooManyRefsFile)
+	if err != nil {
+		return err
+	}
+	n := strings.TrimSpace(string(dt))
+	if n == "" {
+		return nil
+	}
+	refs, err := strconv.Atoi(n)
+	if err != nil {
+		return errors.Wrapf(err, "parse the number of refs of %s", repo)
+	}
+	return ErrTooManyRefs{
+		Repo:    repo,
+		Refs:    refs,
+		MaxRefs: gr.maxGitRefs,
+	}
+}
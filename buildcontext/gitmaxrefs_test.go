@@ -0,0 +1,116 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitCloneScriptMaxRefs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(stdin string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	git("", "init", "--quiet", "--initial-branch=main")
+	git("", "commit", "--quiet", "--allow-empty", "-m", "first")
+	git("", "tag", "-a", "-m", "v1.0.0", "v1.0.0")
+	git("", "commit", "--quiet", "--allow-empty", "-m", "second")
+	hash := git("", "rev-parse", "HEAD")
+	// A huge number of refs, to be left alone.
+	var refs strings.Builder
+	for i := 0; i < 5000; i++ {
+		refs.WriteString(fmt.Sprintf("create refs/heads/huge-%d %s\n", i, hash))
+	}
+	git(refs.String(), "update-ref", "--stdin")
+
+	const maxRefs = 100
+	run := func(gitRef string, maxRefs int) (map[string]string, int) {
+		dest := t.TempDir()
+		trace := filepath.Join(t.TempDir(), "trace")
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, gitRef != "", false, false, false, false, false, false, false, false, 0, maxRefs, 0, "", filepath.Join(t.TempDir(), "src"), dest, nil))
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL=file://"+repo,
+			"EARTHLY_GIT_REF="+gitRef,
+			"EARTHLY_GIT_ORIGIN=file://"+repo,
+			"GIT_TRACE_PACKET="+trace,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		files := make(map[string]string)
+		for _, name := range []string{gitTooManyRefsFile, "git-hash", "git-branch", "git-tags"} {
+			dt, err := os.ReadFile(filepath.Join(dest, name))
+			if err == nil {
+				files[name] = strings.TrimSpace(string(dt))
+			}
+		}
+		// The number of refs of the repository enumerated.
+		dt, err := os.ReadFile(trace)
+		NoError(t, err)
+		return files, strings.Count(string(dt), "refs/heads/huge-")
+	}
+
+	// The requested ref is fetched on its own.
+	files, enumerated := run("main", maxRefs)
+	Equal(t, map[string]string{gitTooManyRefsFile: "", "git-hash": hash, "git-branch": "main", "git-tags": ""}, files)
+	Zero(t, enumerated)
+	files, enumerated = run("v1.0.0", maxRefs)
+	Equal(t, "", files[gitTooManyRefsFile])
+	Equal(t, "v1.0.0", files["git-tags"])
+	Zero(t, enumerated)
+	files, enumerated = run(hash, maxRefs)
+	Equal(t, "", files[gitTooManyRefsFile])
+	Equal(t, hash, files["git-hash"])
+	Zero(t, enumerated)
+
+	// Otherwise, the refs are counted, and nothing is fetched.
+	for _, gitRef := range []string{"", hash[:8]} {
+		files, _ = run(gitRef, maxRefs)
+		Equal(t, map[string]string{gitTooManyRefsFile: "5002"}, files, gitRef)
+	}
+	files, _ = run("", 10000)
+	Equal(t, map[string]string{gitTooManyRefsFile: "", "git-hash": hash, "git-branch": "main", "git-tags": ""}, files)
+}
+
+func TestResolveMaxGitRefs(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"Earthfile":        "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitTooManyRefsFile: "",
+	}
+	r := newTestResolver(t, ResolverOpt{MaxGitRefs: 100})
+	gwClient := newTestGwClient(files)
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	found := false
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			found = true
+			Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "-c protocol.version=2 fetch --quiet --no-tags origin \"$EARTHLY_GIT_REF\"")
+		}
+	}
+	True(t, found)
+
+	files[gitTooManyRefsFile] = "5002\n"
+	r = newTestResolver(t, ResolverOpt{MaxGitRefs: 100, SkipGitMetadata: true})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	var refsErr ErrTooManyRefs
+	True(t, errors.As(err, &refsErr), "unexpected error %v", err)
+	Equal(t, ErrTooManyRefs{Repo: "https://github.com/earthly/test.git", Refs: 5002, MaxRefs: 100}, refsErr)
+}
@@ -0,0 +1,20 @@
+package buildcontext
+
+// GitMetaCacheStrategy determines whether the git meta runs of remote references (and the clones
+// they are made out of) are cached by buildkit, trading the cache space they take for the time it
+// takes to clone repositories anew.
+type GitMetaCacheStrategy int
+
+const (
+	// CacheGitMeta lets buildkit cache the git meta runs, so that the repositories which have not
+	// changed are not cloned again by later resolutions, in this build or others. The clones and the
+	// metadata extracted out of them then take space in the cache of buildkit, until pruned by its
+	// garbage collection. This is the default.
+	CacheGitMeta GitMetaCacheStrategy = iota
+	// EphemeralGitMeta solves the git meta runs with the cache of buildkit ignored, so that every
+	// resolution (that the project cache of the resolver does not serve) clones the repository anew.
+	// Their results are not reused, and are left for the garbage collection of buildkit to prune,
+	// rather than being kept alive by later resolutions. This suits embedders concerned with the
+	// growth of the cache of buildkit more than with the time it takes to resolve references.
+	EphemeralGitMeta
+)
@@ -0,0 +1,66 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveGitMetaCacheStrategy(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"Earthfile":        "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitTooManyRefsFile: "",
+	}
+	// ignoresCache returns whether the git meta run (the first definition solved running git), and
+	// the sources of the definitions not running git, solved by gwClient ignore the cache of buildkit.
+	ignoresCache := func(gwClient *fakeGwClient) (meta, others bool) {
+		gwClient.mu.Lock()
+		defer gwClient.mu.Unlock()
+		var metaSeen bool
+		for _, def := range gwClient.solves {
+			var isMeta, ignored bool
+			for _, dt := range def.Def {
+				var op pb.Op
+				NoError(t, op.Unmarshal(dt), "unmarshal op")
+				if op.GetExec() != nil || op.GetSource() != nil {
+					isMeta = isMeta || op.GetExec() != nil
+					ignored = ignored || def.Metadata[digest.FromBytes(dt)].IgnoreCache
+				}
+			}
+			if isMeta {
+				if !metaSeen {
+					meta = ignored
+					metaSeen = true
+				}
+			} else {
+				others = others || ignored
+			}
+		}
+		return meta, others
+	}
+
+	// Whether the clone is made by buildkit, or by running git in the git image.
+	for _, opt := range []ResolverOpt{{}, {MaxGitRefs: 1000}} {
+		gwClient := newTestGwClient(files)
+		_, err = newTestResolver(t, opt).Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		meta, others := ignoresCache(gwClient)
+		False(t, meta)
+		False(t, others)
+
+		opt.GitMetaCacheStrategy = EphemeralGitMeta
+		gwClient = newTestGwClient(files)
+		_, err = newTestResolver(t, opt).Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		meta, others = ignoresCache(gwClient)
+		True(t, meta)
+		// The build context is not affected.
+		False(t, others)
+	}
+}
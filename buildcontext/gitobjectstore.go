@@ -0,0 +1,43 @@
+package buildcontext
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+// gitObjectStoreCacheID returns the id of the cache mount holding the object store shared by the
+// repositories of the given upstream.
+func gitObjectStoreCacheID(upstream string) string {
+	return fmt.Sprintf("earthly-git-objects-%x", sha256.Sum256([]byte(upstream)))
+}
+
+// objectStoreDir returns the path of the shared object store the repository of the given git url is
+// cloned with, if any.
+func (gr *gitResolver) objectStoreDir(gitURL string) string {
+	if _, ok := gr.gitObjectStores[stripGitURLCredentials(gitURL)]; !ok {
+		return ""
+	}
+	return gitObjectStoreDir
+}
+
+// gitCloneRemote returns the command cloning the remote repository ($EARTHLY_GIT_URL) into dest,
+// with the given extra flags. With a shared object store, the objects the store already has are not
+// downloaded again, but copied into the clone, so that it does not depend on the store. Should the
+// store be unusable, it is reset and the repository cloned without it.
+func gitCloneRemote(flags, dest, objectStore string) string {
+	clone := fmt.Sprintf("git clone $EARTHLY_GIT_VERBOSITY%s -- \"$EARTHLY_GIT_URL\" %s", flags, dest)
+	if objectStore == "" {
+		return clone
+	}
+	store := shellescape.Quote(objectStore)
+	// Failed bare clones are cleaned up by git, but not those whose checkout failed.
+	var cleanup string
+	if !strings.Contains(flags, "--bare") {
+		cleanup = fmt.Sprintf("rm -rf %s ; ", dest)
+	}
+	return fmt.Sprintf("{ git clone $EARTHLY_GIT_VERBOSITY%s --reference-if-able %s --dissociate -- \"$EARTHLY_GIT_URL\" %s || { echo 'the shared git object store is unusable, resetting it' >&2 ; %sfind %s -mindepth 1 -delete ; git init --quiet --bare %s ; %s ; } ; }",
+		flags, store, dest, cleanup, store, store, clone)
+}
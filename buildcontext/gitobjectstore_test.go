@@ -0,0 +1,151 @@
+package buildcontext
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitCloneScriptObjectStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	tmp := t.TempDir()
+	// The size of the packs sent by the remotes is logged by a pack-objects hook, which git only
+	// accepts from the global config.
+	packLog := filepath.Join(tmp, "packs.log")
+	hook := filepath.Join(tmp, "pack-objects-hook")
+	NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\nout=$(mktemp) ; \"$@\" >\"$out\" ; rc=$? ; wc -c <\"$out\" >>"+packLog+" ; cat \"$out\" ; rm -f \"$out\" ; exit $rc\n"), 0755))
+	globalConfig := filepath.Join(tmp, "gitconfig")
+	NoError(t, os.WriteFile(globalConfig, []byte("[uploadpack]\n\tpackObjectsHook = "+hook+"\n[user]\n\tname = test\n\temail = test@example.com\n"), 0644))
+	env := append(os.Environ(), "GIT_CONFIG_GLOBAL="+globalConfig, "GIT_CONFIG_NOSYSTEM=1")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	lastPackSize := func() int {
+		dt, err := os.ReadFile(packLog)
+		NoError(t, err)
+		lines := strings.Fields(string(dt))
+		size, err := strconv.Atoi(lines[len(lines)-1])
+		NoError(t, err)
+		return size
+	}
+
+	upstream := filepath.Join(tmp, "upstream")
+	NoError(t, os.MkdirAll(upstream, 0755))
+	git(upstream, "init", "--quiet", "--initial-branch=main")
+	// Random content, for it not to be compressed away.
+	blob := make([]byte, 512*1024)
+	_, err := rand.Read(blob)
+	NoError(t, err)
+	NoError(t, os.WriteFile(filepath.Join(upstream, "data.bin"), blob, 0644))
+	git(upstream, "add", ".")
+	git(upstream, "commit", "--quiet", "-m", "upstream")
+	forks := make([]string, 2)
+	for i := range forks {
+		forks[i] = filepath.Join(tmp, "fork"+strconv.Itoa(i))
+		git(tmp, "clone", "--quiet", "--no-local", upstream, forks[i])
+		NoError(t, os.WriteFile(filepath.Join(forks[i], "fork.txt"), []byte(forks[i]+"\n"), 0644))
+		git(forks[i], "add", ".")
+		git(forks[i], "commit", "--quiet", "-m", "fork")
+	}
+
+	store := filepath.Join(tmp, "store")
+	NoError(t, os.MkdirAll(store, 0755))
+	clone := func(fork string) string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, false, false, false, false, false, false, false, false, false, 0, 0, 0, store, filepath.Join(t.TempDir(), "src"), dest, nil))
+		cmd.Env = append(env,
+			"EARTHLY_GIT_URL=file://"+fork,
+			"EARTHLY_GIT_ORIGIN=file://"+fork,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		hash, err := os.ReadFile(filepath.Join(dest, "git-hash"))
+		NoError(t, err)
+		return strings.TrimSpace(string(hash))
+	}
+
+	Equal(t, git(forks[0], "rev-parse", "HEAD"), clone(forks[0]))
+	firstSize := lastPackSize()
+	Greater(t, firstSize, len(blob))
+	// The objects of the upstream are not downloaded again for the second fork.
+	Equal(t, git(forks[1], "rev-parse", "HEAD"), clone(forks[1]))
+	secondSize := lastPackSize()
+	Less(t, secondSize, len(blob)/10, "pack sizes %d then %d", firstSize, secondSize)
+
+	// A store which has lost its objects is reset.
+	NoError(t, os.RemoveAll(filepath.Join(store, "objects")))
+	NoError(t, os.MkdirAll(filepath.Join(store, "objects"), 0755))
+	Equal(t, git(forks[1], "rev-parse", "HEAD"), clone(forks[1]))
+	Equal(t, git(forks[0], "rev-parse", "HEAD"), clone(forks[0]))
+	Less(t, lastPackSize(), len(blob)/10)
+}
+
+func TestResolveGitObjectStores(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	gwClient := newTestGwClient(files)
+	r := newTestResolver(t, ResolverOpt{GitObjectStores: map[string]string{
+		"https://github.com/earthly/test.git":  "github.com/earthly/upstream",
+		"https://github.com/earthly/other.git": "github.com/earthly/upstream",
+	}})
+	for _, target := range []string{"github.com/earthly/test/sub:main+build", "github.com/earthly/other/sub:main+build", "github.com/earthly/unrelated/sub:main+build"} {
+		ref, err := domain.ParseTarget(target)
+		NoError(t, err)
+		_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+
+	// Whether each repository has been cloned with a store.
+	withStore := make(map[string]bool)
+	for _, op := range gwClient.solvedOps(t) {
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		script := exec.Meta.Args[len(exec.Meta.Args)-1]
+		var gitURL string
+		for _, env := range exec.Meta.Env {
+			if strings.HasPrefix(env, "EARTHLY_GIT_URL=") {
+				gitURL = strings.TrimPrefix(env, "EARTHLY_GIT_URL=")
+			}
+		}
+		var cacheIDs []string
+		for _, m := range exec.Mounts {
+			if m.MountType == pb.MountType_CACHE {
+				Equal(t, gitObjectStoreDir, m.Dest)
+				Equal(t, pb.CacheSharingOpt_LOCKED, m.CacheOpt.Sharing)
+				cacheIDs = append(cacheIDs, m.CacheOpt.ID)
+			}
+		}
+		withStore[gitURL] = len(cacheIDs) > 0
+		if len(cacheIDs) == 0 {
+			NotContains(t, script, "--reference-if-able")
+			continue
+		}
+		// Both forks share the store of their upstream.
+		Equal(t, []string{gitObjectStoreCacheID("github.com/earthly/upstream")}, cacheIDs)
+		Contains(t, script, "--reference-if-able /git-objects --dissociate")
+	}
+	Equal(t, map[string]bool{
+		"https://github.com/earthly/test.git":      true,
+		"https://github.com/earthly/other.git":     true,
+		"https://github.com/earthly/unrelated.git": false,
+	}, withStore)
+}
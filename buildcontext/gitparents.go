@@ -0,0 +1,25 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gitParentsFile is the git meta file holding the parent commits of the commit, one per line.
+const gitParentsFile = "git-parents"
+
+// gitParentsCommand returns the commands writing the parent commits to file. They are read out of
+// the commit object itself rather than its history, as shallow clones are cut short of the parents.
+func gitParentsCommand(file string) string {
+	return fmt.Sprintf("git cat-file commit HEAD 2>/dev/null | sed -n -e '/^$/q' -e 's/^parent //p' >%s || touch %s ; ", file, file)
+}
+
+// parseGitParents parses the content of gitParentsFile into the parent commits, in the order they
+// are recorded in the commit (the first parent first).
+func parseGitParents(out string) []string {
+	parents := strings.Fields(out)
+	if len(parents) == 0 {
+		return nil
+	}
+	return parents
+}
@@ -0,0 +1,84 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitMetaScriptParents(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	runMeta := func(repo string) []string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitMetaScript(dest, false, false, false, 0))
+		cmd.Dir = repo
+		_ = cmd.Run()
+		out, err := os.ReadFile(filepath.Join(dest, gitParentsFile))
+		NoError(t, err)
+		return parseGitParents(string(out))
+	}
+
+	repo := t.TempDir()
+	git(repo, "init", "--quiet", "--initial-branch=main")
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "first")
+	Empty(t, runMeta(repo))
+	first := git(repo, "rev-parse", "HEAD")
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "second")
+	Equal(t, []string{first}, runMeta(repo))
+	second := git(repo, "rev-parse", "HEAD")
+
+	git(repo, "checkout", "--quiet", "-b", "feature", first)
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "feature")
+	feature := git(repo, "rev-parse", "HEAD")
+	git(repo, "checkout", "--quiet", "main")
+	git(repo, "merge", "--quiet", "--no-ff", "-m", "merge", "feature")
+	Equal(t, []string{second, feature}, runMeta(repo))
+
+	// The parents are known even though shallow clones are cut short of them.
+	shallow := filepath.Join(t.TempDir(), "shallow")
+	git(repo, "clone", "--quiet", "--depth=1", "file://"+repo, shallow)
+	Equal(t, []string{second, feature}, runMeta(shallow))
+}
+
+func TestResolveIsMerge(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	tests := []struct {
+		name    string
+		parents string
+		isMerge bool
+	}{
+		{"normal commit", "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c\n", false},
+		{"merge commit", "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c\n1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d\n", true},
+		{"root commit", "", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gwClient := newTestGwClient(map[string]string{
+				"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+				gitParentsFile:  tc.parents,
+			})
+			r := newTestResolver(t, ResolverOpt{})
+			d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			NoError(t, err, "Resolve failed")
+			Equal(t, parseGitParents(tc.parents), d.GitMetadata.ParentHashes)
+			Equal(t, tc.isMerge, d.GitMetadata.IsMerge)
+			Equal(t, tc.isMerge, d.GitMetadata.Clone().IsMerge)
+		})
+	}
+}
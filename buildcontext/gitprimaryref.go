@@ -0,0 +1,78 @@
+package buildcontext
+
+import (
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/gitutil"
+)
+
+// PrimaryRefPolicy determines the primary ref of the commit of a remote reference, when it is both
+// the tip of a branch and tagged: the ref the reference is reported with (as the Ref of its Data),
+// and under which its project is additionally cached, so that it is shared with later references
+// to that ref.
+type PrimaryRefPolicy int
+
+const (
+	// PreferRequested reports references with the ref they request or, when they request none (the
+	// default branch), with the first tag of the commit, else its first branch. The project is
+	// additionally cached under both the first branch and the first tag. This is the default.
+	PreferRequested PrimaryRefPolicy = iota
+	// PreferTag reports references with the first tag of the commit, and additionally caches the
+	// project under it only. Commits without tags are handled as with PreferRequested.
+	PreferTag
+	// PreferBranch reports references with the first branch of the commit, and additionally caches
+	// the project under it only. Commits without branches are handled as with PreferRequested.
+	PreferBranch
+)
+
+// preferredRef returns the ref the primary ref policy prefers among the branches and tags of a
+// commit, if any.
+func (gr *gitResolver) preferredRef(branches, tags []string) string {
+	var refs []string
+	switch gr.primaryRefPolicy {
+	case PreferTag:
+		refs = tags
+	case PreferBranch:
+		refs = branches
+	}
+	if len(refs) == 0 {
+		return ""
+	}
+	return refs[0]
+}
+
+// secondaryRefs returns the refs of a commit under which its project is additionally cached.
+func (gr *gitResolver) secondaryRefs(branches, tags []string) []string {
+	if preferred := gr.preferredRef(branches, tags); preferred != "" {
+		return []string{preferred}
+	}
+	var refs []string
+	if len(branches) > 0 {
+		refs = append(refs, branches[0])
+	}
+	if len(tags) > 0 {
+		refs = append(refs, tags[0])
+	}
+	return refs
+}
+
+// withPrimaryRef returns the remote reference with the ref preferred by the primary ref policy
+// among those of its resolved commit, if any, in place of the requested one.
+func (gr *gitResolver) withPrimaryRef(ref domain.Reference, gitMeta *gitutil.GitMetadata) domain.Reference {
+	if gitMeta == nil {
+		return ref
+	}
+	preferred := gr.preferredRef(gitMeta.Branch, gitMeta.Tags)
+	if preferred == "" {
+		return ref
+	}
+	switch r := ref.(type) {
+	case domain.Target:
+		r.Tag = preferred
+		return r
+	case domain.Command:
+		r.Tag = preferred
+		return r
+	default:
+		return ref
+	}
+}
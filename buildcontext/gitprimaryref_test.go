@@ -0,0 +1,63 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolvePrimaryRefPolicy(t *testing.T) {
+	const hash = "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5"
+	files := map[string]string{
+		"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	// The commit is both the tip of main and tagged v1.0.0.
+	for _, tc := range []struct {
+		policy   PrimaryRefPolicy
+		byHash   string
+		byBranch string
+		shared   map[string]bool
+	}{
+		{PreferRequested, hash, "main", map[string]bool{"main": true, "v1.0.0": true}},
+		{PreferTag, "v1.0.0", "v1.0.0", map[string]bool{"v1.0.0": true}},
+		{PreferBranch, "main", "main", map[string]bool{"main": true}},
+	} {
+		gwClient := newTestGwClient(files)
+		r := newTestResolver(t, ResolverOpt{PrimaryRefPolicy: tc.policy})
+		resolve := func(target string) string {
+			ref, err := domain.ParseTarget(target)
+			NoError(t, err)
+			d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			NoError(t, err, "Resolve failed")
+			Equal(t, "build", d.Ref.GetName())
+			return d.Ref.GetTag()
+		}
+
+		Equal(t, tc.byHash, resolve("github.com/earthly/test:"+hash+"+build"), "policy %d", tc.policy)
+		Eventually(t, func() bool { return r.gr.secondaryKeys.len() == len(tc.shared) }, 5*time.Second, time.Millisecond)
+		// The project is shared with the secondary refs only.
+		for _, gitRef := range []string{"main", "v1.0.0"} {
+			runs := gwClient.numMetaRuns(t)
+			resolve("github.com/earthly/test:" + gitRef + "+build")
+			if tc.shared[gitRef] {
+				Equal(t, runs, gwClient.numMetaRuns(t), "policy %d, ref %s", tc.policy, gitRef)
+			} else {
+				Equal(t, runs+1, gwClient.numMetaRuns(t), "policy %d, ref %s", tc.policy, gitRef)
+			}
+		}
+		Equal(t, tc.byBranch, resolve("github.com/earthly/test:main+build"), "policy %d", tc.policy)
+	}
+
+	// Commits without tags are reported with the requested ref.
+	files["git-tags"] = ""
+	r := newTestResolver(t, ResolverOpt{PrimaryRefPolicy: PreferTag})
+	ref, err := domain.ParseTarget("github.com/earthly/test:" + hash + "+build")
+	NoError(t, err)
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, hash, d.Ref.GetTag())
+	Eventually(t, func() bool { return r.gr.secondaryKeys.len() == 1 }, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,53 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// ErrGitRefNotReachable is returned when the commit a remote reference resolves to is not reachable
+// from the protected branch.
+type ErrGitRefNotReachable struct {
+	// Ref is the canonical form of the reference.
+	Ref string
+	// Hash is the commit the reference resolved to.
+	Hash string
+	// ProtectedBranch is the branch the commit is required to be reachable from.
+	ProtectedBranch string
+}
+
+// Error is function required by error interface.
+func (err ErrGitRefNotReachable) Error() string {
+	return fmt.Sprintf("commit %s of %s is not reachable from protected branch %s", err.Hash, err.Ref, err.ProtectedBranch)
+}
+
+// checkProtectedAncestor checks the outcome of the git merge-base --is-ancestor run of the git meta
+// step, which exits with 1 when the commit is not an ancestor of the protected branch, and with
+// another non-zero code on errors.
+func (gr *gitResolver) checkProtectedAncestor(ctx context.Context, gitMetaRef gwclient.Reference, ref domain.Reference) error {
+	rcBytes, err := gr.readGitMeta(ctx, gitMetaRef, gitProtectedAncestorFile)
+	if err != nil {
+		return err
+	}
+	switch rc := strings.TrimSpace(string(rcBytes)); rc {
+	case "0":
+		return nil
+	case "1":
+		gitHashBytes, err := gr.readGitMeta(ctx, gitMetaRef, "git-hash")
+		if err != nil {
+			return err
+		}
+		return ErrGitRefNotReachable{
+			Ref:             ref.ProjectCanonical(),
+			Hash:            strings.TrimSpace(string(gitHashBytes)),
+			ProtectedBranch: gr.protectedBranch,
+		}
+	default:
+		return errors.Errorf("check whether %s is reachable from protected branch %s: git merge-base exited with %q", ref.ProjectCanonical(), gr.protectedBranch, rc)
+	}
+}
@@ -0,0 +1,63 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveProtectedBranch(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:feature+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+
+	// The commit is an ancestor of the protected branch.
+	files[gitProtectedAncestorFile] = "0\n"
+	gwClient := newTestGwClient(files)
+	r := newTestResolver(t, ResolverOpt{ProtectedBranch: "main"})
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", d.GitMetadata.Hash)
+	var scripts []string
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			scripts = append(scripts, exec.Meta.Args[len(exec.Meta.Args)-1])
+			Contains(t, exec.Meta.Env, "EARTHLY_GIT_PROTECTED_BRANCH=main")
+		}
+	}
+	NotEmpty(t, scripts)
+	for _, script := range scripts {
+		Contains(t, script, "fetch --quiet -- \"$EARTHLY_GIT_URL\" \"refs/heads/$EARTHLY_GIT_PROTECTED_BRANCH\"")
+		Contains(t, script, "merge-base --is-ancestor HEAD FETCH_HEAD")
+	}
+
+	// The commit is not an ancestor of the protected branch.
+	files[gitProtectedAncestorFile] = "1\n"
+	for _, skipMeta := range []bool{false, true} {
+		r = newTestResolver(t, ResolverOpt{ProtectedBranch: "main", SkipGitMetadata: skipMeta})
+		_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		var notReachableErr ErrGitRefNotReachable
+		True(t, errors.As(err, &notReachableErr), "unexpected error %v", err)
+		Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", notReachableErr.Hash)
+		Equal(t, "main", notReachableErr.ProtectedBranch)
+		Equal(t, "github.com/earthly/test/sub:feature", notReachableErr.Ref)
+	}
+
+	// The check itself failed.
+	files[gitProtectedAncestorFile] = "128\n"
+	r = newTestResolver(t, ResolverOpt{ProtectedBranch: "main"})
+	_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	Error(t, err)
+	False(t, errors.As(err, &ErrGitRefNotReachable{}))
+}
+
+func TestGitCloneScriptProtectedMirror(t *testing.T) {
+	script := gitCloneScript(true, true, true, false, false, false, false, false, false, false, 0, 0, 0, "", defaultGitSrcPath, defaultGitDestPath, nil)
+	Contains(t, script, "fetch --quiet -- /git-mirror \"refs/heads/$EARTHLY_GIT_PROTECTED_BRANCH\"")
+	Contains(t, script, "echo $rc >/dest/git-protected-ancestor")
+}
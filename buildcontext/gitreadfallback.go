@@ -0,0 +1,130 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TarExporter exports the output of a definition as a tar stream, e.g. by solving it with the tar
+// exporter of a buildkit client.
+type TarExporter func(ctx context.Context, def *llb.Definition) (io.ReadCloser, error)
+
+// isReadFileUnsupported returns whether err is the rejection of ReadFile by the gateway.
+func isReadFileUnsupported(err error) bool {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	return errors.As(err, &grpcErr) && grpcErr.GRPCStatus().Code() == codes.Unimplemented
+}
+
+// withReadFallback returns the reference of the solved state, wrapped so that its files are read
+// out of the tar export of the state when the gateway does not support reading them, if a tar
+// exporter is set.
+func (gr *gitResolver) withReadFallback(ref gwclient.Reference, state pllb.State, platr *platutil.Resolver) gwclient.Reference {
+	if gr.tarExporter == nil {
+		return ref
+	}
+	return &tarFallbackRef{
+		Reference: ref,
+		export: func(ctx context.Context) (io.ReadCloser, error) {
+			platform := platr.SubPlatform(platr.Current())
+			def, err := state.Marshal(ctx, llb.Platform(platr.ToLLBPlatform(platform)))
+			if err != nil {
+				return nil, errors.Wrap(err, "marshal state")
+			}
+			return gr.tarExporter(ctx, def)
+		},
+	}
+}
+
+// tarFallbackRef is a reference reading its files out of a tar export, once ReadFile has been
+// rejected.
+type tarFallbackRef struct {
+	gwclient.Reference
+	export func(ctx context.Context) (io.ReadCloser, error)
+
+	mu    sync.Mutex
+	files map[string][]byte // nil until exported
+}
+
+func (r *tarFallbackRef) ReadFile(ctx context.Context, req gwclient.ReadRequest) ([]byte, error) {
+	r.mu.Lock()
+	exported := r.files != nil
+	r.mu.Unlock()
+	if !exported {
+		dt, err := r.Reference.ReadFile(ctx, req)
+		if err == nil || !isReadFileUnsupported(err) {
+			return dt, err
+		}
+	}
+	files, err := r.exportedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dt, ok := files[cleanTarPath(req.Filename)]
+	if !ok {
+		return nil, errors.Errorf("open %s: no such file in the exported state", req.Filename)
+	}
+	if req.Range != nil {
+		start := req.Range.Offset
+		if start > len(dt) {
+			start = len(dt)
+		}
+		end := len(dt)
+		if req.Range.Length > 0 && start+req.Range.Length < end {
+			end = start + req.Range.Length
+		}
+		dt = dt[start:end]
+	}
+	return dt, nil
+}
+
+// exportedFiles exports the state, once, and returns its regular files keyed by their path.
+func (r *tarFallbackRef) exportedFiles(ctx context.Context) (map[string][]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.files != nil {
+		return r.files, nil
+	}
+	rc, err := r.export(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "export state to tar")
+	}
+	defer rc.Close()
+	files := make(map[string][]byte)
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read exported tar")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		dt, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s of exported tar", hdr.Name)
+		}
+		files[cleanTarPath(hdr.Name)] = dt
+	}
+	r.files = files
+	return files, nil
+}
+
+func cleanTarPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
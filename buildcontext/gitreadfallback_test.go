@@ -0,0 +1,77 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResolveTarFallback(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	const earthfile = "VERSION 0.6\n\nbuild:\n\tFROM alpine\n"
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": earthfile,
+	})
+	// The gateway rejects every read.
+	gwClient.readErrs = make(map[string]error)
+	for name := range gwClient.files {
+		gwClient.readErrs[name] = status.Error(codes.Unimplemented, "unknown method ReadFile for service moby.buildkit.v1.frontend.LLBBridge")
+	}
+
+	_, err = newTestResolver(t, ResolverOpt{}).Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	Error(t, err)
+	True(t, isReadFileUnsupported(err), "unexpected error %v", err)
+
+	var mu sync.Mutex
+	exports := 0
+	exporter := func(ctx context.Context, def *llb.Definition) (io.ReadCloser, error) {
+		mu.Lock()
+		exports++
+		mu.Unlock()
+		NotEmpty(t, def.Def)
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		NoError(t, tw.WriteHeader(&tar.Header{Name: "./sub/", Typeflag: tar.TypeDir, Mode: 0755}))
+		for name, content := range gwClient.files {
+			NoError(t, tw.WriteHeader(&tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+			_, err := tw.Write([]byte(content))
+			NoError(t, err)
+		}
+		NoError(t, tw.Close())
+		return io.NopCloser(&buf), nil
+	}
+	d, err := newTestResolver(t, ResolverOpt{TarExporter: exporter}).Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", d.GitMetadata.Hash)
+	Equal(t, "a7b2c4d5", d.GitMetadata.ShortHash)
+	Equal(t, []string{"main"}, d.GitMetadata.Branch)
+	Equal(t, []string{"v1.0.0"}, d.GitMetadata.Tags)
+	Equal(t, "someone@example.com", d.GitMetadata.Author)
+	bf, err := os.ReadFile(d.BuildFilePath)
+	NoError(t, err)
+	Equal(t, earthfile, string(bf))
+	// Once for the git meta state, and once for the build file.
+	Equal(t, 2, exports)
+
+	// Other read failures are not recovered from.
+	for name := range gwClient.readErrs {
+		gwClient.readErrs[name] = errors.New("permission denied")
+	}
+	exports = 0
+	_, err = newTestResolver(t, ResolverOpt{TarExporter: exporter}).Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	Error(t, err)
+	Contains(t, err.Error(), "permission denied")
+	Equal(t, 0, exports)
+}
@@ -0,0 +1,65 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+)
+
+const (
+	// maxGitRefComponentBytes is the maximum length of each /-separated component of a ref name, as
+	// git stores refs as files (loose refs), whose names are limited to 255 bytes.
+	maxGitRefComponentBytes = 255
+	// maxGitRefBytes is the maximum length of a full ref name (e.g. refs/heads/<branch>), as the
+	// paths of the files git stores refs as are limited to 4096 bytes.
+	maxGitRefBytes = 4096
+	// gitRefPrefix is the longest of the prefixes of the full names of branches and tags.
+	gitRefPrefix = "refs/heads/"
+)
+
+// ErrGitRefTooLong is returned when the ref of a remote reference exceeds the limits of git on the
+// length of ref names.
+type ErrGitRefTooLong struct {
+	// Ref is the canonical form of the reference.
+	Ref string
+	// Component is the /-separated component of the ref which is too long, if the ref is not too
+	// long as a whole.
+	Component string
+	// Length is the length of the ref, or of its component, and MaxLength the maximum allowed, in
+	// bytes.
+	Length    int
+	MaxLength int
+}
+
+// Error is function required by error interface.
+func (err ErrGitRefTooLong) Error() string {
+	if err.Component != "" {
+		return fmt.Sprintf("the ref of %s has a component %d bytes long, exceeding the git limit of %d bytes: %s", err.Ref, err.Length, err.MaxLength, err.Component)
+	}
+	return fmt.Sprintf("the ref of %s is %d bytes long, exceeding the git limit of %d bytes", err.Ref, err.Length, err.MaxLength)
+}
+
+// checkGitRefLength checks that the ref of a remote reference fits the limits of git on the length
+// of ref names.
+func checkGitRefLength(ref domain.Reference) error {
+	gitRef := ref.GetTag()
+	if maxLength := maxGitRefBytes - len(gitRefPrefix); len(gitRef) > maxLength {
+		return ErrGitRefTooLong{
+			Ref:       ref.StringCanonical(),
+			Length:    len(gitRef),
+			MaxLength: maxLength,
+		}
+	}
+	for _, component := range strings.Split(gitRef, "/") {
+		if len(component) > maxGitRefComponentBytes {
+			return ErrGitRefTooLong{
+				Ref:       ref.StringCanonical(),
+				Component: component,
+				Length:    len(component),
+				MaxLength: maxGitRefComponentBytes,
+			}
+		}
+	}
+	return nil
+}
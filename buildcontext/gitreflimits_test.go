@@ -0,0 +1,96 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+// longGitBranch returns a branch name of the given length, made of components of the maximum
+// length, the last one ending with last.
+func longGitBranch(length int, last string) string {
+	var sb strings.Builder
+	for sb.Len() < length {
+		if sb.Len() > 0 {
+			sb.WriteString("/")
+		}
+		n := length - sb.Len()
+		if n > maxGitRefComponentBytes {
+			n = maxGitRefComponentBytes
+		}
+		sb.WriteString(strings.Repeat("b", n))
+	}
+	branch := sb.String()
+	return branch[:len(branch)-len(last)] + last
+}
+
+func TestResolveLongBranch(t *testing.T) {
+	maxLength := maxGitRefBytes - len(gitRefPrefix)
+	branch := longGitBranch(maxLength, "1")
+	other := longGitBranch(maxLength, "2")
+	Len(t, branch, maxLength)
+	ref, err := domain.ParseTarget("github.com/earthly/test:" + branch + "+build")
+	NoError(t, err)
+	True(t, ref.GetTag() == branch, "unexpected ref %.100s", ref.GetTag())
+	otherRef, err := domain.ParseTarget("github.com/earthly/test:" + other + "+build")
+	NoError(t, err)
+
+	cleanCollection := cleanup.NewCollection()
+	defer cleanCollection.Close()
+	var buf bytes.Buffer
+	console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+	r := NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+		GitCommandLogLevel: conslogging.Info,
+	})
+	gwClient := newTestGwClient(map[string]string{
+		"Earthfile":          "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"git-branch":         branch + "\n",
+		"git-branch-current": branch + "\n",
+	})
+	for i := 0; i < 2; i++ {
+		d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		True(t, len(d.GitMetadata.Branch) == 1 && d.GitMetadata.Branch[0] == branch, "unexpected branches")
+		True(t, d.Ref.GetTag() == branch, "unexpected ref %.100s", d.Ref.GetTag())
+		True(t, d.ShortID() == "earthly/test@a7b2c4d5 ("+branch+")", "unexpected short id %.100s", d.ShortID())
+	}
+	Equal(t, 1, gwClient.numMetaRuns(t))
+	True(t, strings.Contains(buf.String(), "git commands for github.com/earthly/test:"+branch+": "), "the ref is not logged in full")
+	// The branches differing only by their last byte are cached separately.
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), otherRef)
+	NoError(t, err, "Resolve failed")
+	Equal(t, 2, gwClient.numMetaRuns(t))
+}
+
+func TestResolveTooLongRef(t *testing.T) {
+	tooLong := longGitBranch(maxGitRefBytes-len(gitRefPrefix)+1, "b")
+	component := strings.Repeat("c", maxGitRefComponentBytes+1)
+	for _, tc := range []struct {
+		gitRef   string
+		expected ErrGitRefTooLong
+	}{
+		{tooLong, ErrGitRefTooLong{Ref: "github.com/earthly/test:" + tooLong + "+build", Length: len(tooLong), MaxLength: maxGitRefBytes - len(gitRefPrefix)}},
+		{"feature/" + component, ErrGitRefTooLong{Ref: "github.com/earthly/test:feature/" + component + "+build", Component: component, Length: len(component), MaxLength: maxGitRefComponentBytes}},
+	} {
+		ref, err := domain.ParseTarget("github.com/earthly/test:" + tc.gitRef + "+build")
+		NoError(t, err)
+		for _, opt := range []ResolverOpt{{}, {LazyResolve: true}} {
+			gwClient := newTestGwClient(map[string]string{
+				"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			})
+			r := newTestResolver(t, opt)
+			_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			var tooLongErr ErrGitRefTooLong
+			True(t, errors.As(err, &tooLongErr), "unexpected error %.200v", err)
+			True(t, tc.expected == tooLongErr, "unexpected error %.200v", tooLongErr)
+			Empty(t, gwClient.solvedOps(t))
+		}
+	}
+}
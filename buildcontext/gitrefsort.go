@@ -0,0 +1,116 @@
+package buildcontext
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortGitBranches sorts branch names lexically.
+func sortGitBranches(branches []string) {
+	sort.Strings(branches)
+}
+
+// sortGitTags sorts tag names with version tags (e.g. v1.2.3 or 1.2.3-rc.1) first, in ascending
+// semver precedence, followed by the other tags, lexically. Version tags of equal precedence (e.g.
+// 1.2.3 and v1.2.3) are sorted lexically.
+func sortGitTags(tags []string) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		return gitTagLess(tags[i], tags[j])
+	})
+}
+
+func gitTagLess(a, b string) bool {
+	va, aok := parseVersionTag(a)
+	vb, bok := parseVersionTag(b)
+	switch {
+	case aok && bok:
+		if c := va.compare(vb); c != 0 {
+			return c < 0
+		}
+		return a < b
+	case aok != bok:
+		return aok
+	default:
+		return a < b
+	}
+}
+
+var versionTagRegexp = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// versionTag is a tag following semver, with an optional v prefix.
+type versionTag struct {
+	core       [3]uint64
+	prerelease []string
+}
+
+func parseVersionTag(tag string) (versionTag, bool) {
+	m := versionTagRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return versionTag{}, false
+	}
+	var v versionTag
+	for i := range v.core {
+		n, err := strconv.ParseUint(m[i+1], 10, 64)
+		if err != nil {
+			return versionTag{}, false
+		}
+		v.core[i] = n
+	}
+	if m[4] != "" {
+		v.prerelease = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v has a lower, equal or higher semver precedence than other.
+func (v versionTag) compare(other versionTag) int {
+	for i := range v.core {
+		if v.core[i] != other.core[i] {
+			return cmpUint(v.core[i], other.core[i])
+		}
+	}
+	// A pre-release has a lower precedence than the release.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		na, aErr := strconv.ParseUint(a, 10, 64)
+		nb, bErr := strconv.ParseUint(b, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return cmpUint(na, nb)
+		case aErr == nil:
+			// Numeric identifiers have a lower precedence than alphanumeric ones.
+			return -1
+		case bErr == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return cmpUint(uint64(len(v.prerelease)), uint64(len(other.prerelease)))
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,73 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSortGitTags(t *testing.T) {
+	expected := []string{
+		"0.9.0",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"1.0.0",
+		"v1.0.0",
+		"v1.0.0+build.5",
+		"v1.2.0",
+		"v1.10.0",
+		"latest",
+		"release-2022",
+		"v01.2.3",
+		"v1.0",
+	}
+	for _, order := range [][]int{
+		{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+		{3, 14, 0, 9, 12, 5, 16, 1, 7, 10, 2, 13, 8, 4, 11, 6, 15},
+	} {
+		tags := make([]string, len(order))
+		for i, j := range order {
+			tags[i] = expected[j]
+		}
+		sortGitTags(tags)
+		Equal(t, expected, tags)
+	}
+}
+
+func TestResolveGitRefOrder(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"git-branch":    "release\nmain\n",
+		"git-version":   "git version 2.20.0\n",
+		"git-tags":      "v1.10.0\nnightly\nv1.2.0\n",
+	}
+	resolve := func(opt ResolverOpt) ([]string, []string) {
+		d, err := newTestResolver(t, opt).Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		return d.GitMetadata.Branch, d.GitMetadata.Tags
+	}
+
+	branches, tags := resolve(ResolverOpt{})
+	Equal(t, []string{"main", "release"}, branches)
+	Equal(t, []string{"v1.2.0", "v1.10.0", "nightly"}, tags)
+
+	// The order does not depend on that of git.
+	files["git-branch"] = "main\nrelease\n"
+	files["git-tags"] = "nightly\nv1.2.0\nv1.10.0\n"
+	branches, tags = resolve(ResolverOpt{})
+	Equal(t, []string{"main", "release"}, branches)
+	Equal(t, []string{"v1.2.0", "v1.10.0", "nightly"}, tags)
+
+	branches, tags = resolve(ResolverOpt{PreserveGitRefOrder: true})
+	Equal(t, []string{"main", "release"}, branches)
+	Equal(t, []string{"nightly", "v1.2.0", "v1.10.0"}, tags)
+}
@@ -0,0 +1,27 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gitRootCommitFile is the git meta file holding the root commits of the history of the commit, when
+// requested. It is empty when the history is not available, i.e. in shallow clones.
+const gitRootCommitFile = "git-root-commit"
+
+// gitRootCommitCommand returns the commands writing the root commits to file. Shallow clones are
+// cut short of the root commits, and their boundary commits would be listed in their place.
+func gitRootCommitCommand(file string) string {
+	return fmt.Sprintf("if [ \"$(git rev-parse --is-shallow-repository 2>/dev/null)\" = false ] ; then git rev-list --max-parents=0 HEAD >%s || touch %s ; else touch %s ; fi ; ", file, file, file)
+}
+
+// parseGitRootCommit parses the content of gitRootCommitFile into the root commit. When the history
+// has several (e.g. unrelated histories merged), the oldest, listed last, is returned. It is empty
+// when the history was not available.
+func parseGitRootCommit(out string) string {
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
@@ -0,0 +1,95 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitMetaScriptRootCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	runMeta := func(repo string) string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitMetaScript(dest, false, false, true, 0))
+		cmd.Dir = repo
+		_ = cmd.Run()
+		out, err := os.ReadFile(filepath.Join(dest, gitRootCommitFile))
+		NoError(t, err)
+		return parseGitRootCommit(string(out))
+	}
+
+	repo := t.TempDir()
+	git(repo, "init", "--quiet", "--initial-branch=main")
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "first")
+	root := git(repo, "rev-parse", "HEAD")
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "second")
+	git(repo, "commit", "--quiet", "--allow-empty", "-m", "third")
+	Equal(t, root, runMeta(repo))
+
+	// The history of shallow clones is cut short of the root commit.
+	shallow := filepath.Join(t.TempDir(), "shallow")
+	git(repo, "clone", "--quiet", "--depth=1", "file://"+repo, shallow)
+	Empty(t, runMeta(shallow))
+}
+
+func TestParseGitRootCommit(t *testing.T) {
+	Empty(t, parseGitRootCommit(""))
+	Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", parseGitRootCommit("a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5\n"))
+	// The oldest of several root commits is listed last.
+	Equal(t, "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c", parseGitRootCommit("a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5\n0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c\n"))
+}
+
+func TestResolveReadRootCommit(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+
+	files[gitRootCommitFile] = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c\n"
+	gwClient := newTestGwClient(files)
+	r := newTestResolver(t, ResolverOpt{ReadRootCommit: true})
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c", d.GitMetadata.RootCommit)
+	False(t, d.GitMetadata.RootCommitUnavailable)
+	found := false
+	for _, op := range gwClient.solvedOps(t) {
+		if exec := op.GetExec(); exec != nil {
+			found = true
+			Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "git rev-list --max-parents=0 HEAD")
+		}
+	}
+	True(t, found)
+
+	// The history was not available.
+	files[gitRootCommitFile] = ""
+	r = newTestResolver(t, ResolverOpt{ReadRootCommit: true})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.GitMetadata.RootCommit)
+	True(t, d.GitMetadata.RootCommitUnavailable)
+
+	// Not read unless enabled.
+	files[gitRootCommitFile] = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c\n"
+	r = newTestResolver(t, ResolverOpt{})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.GitMetadata.RootCommit)
+	False(t, d.GitMetadata.RootCommitUnavailable)
+}
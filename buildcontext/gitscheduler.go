@@ -0,0 +1,161 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// gitScheduler bounds the number of remote projects being resolved at once, overall and per git
+// host. Waiting resolutions are granted in turn across hosts (and in order within a host), so that
+// no host starves the others.
+type gitScheduler struct {
+	max        int // 0 means unlimited
+	maxPerHost int // 0 means unlimited
+
+	mu           sync.Mutex
+	active       int
+	activeByHost map[string]int
+	waiting      map[string][]chan struct{} // host -> waiters, in order of arrival
+	hosts        []string                   // hosts with waiters, in turn order
+	next         int                        // index in hosts of the next host to be granted
+}
+
+func newGitScheduler(max, maxPerHost int) *gitScheduler {
+	return &gitScheduler{
+		max:          max,
+		maxPerHost:   maxPerHost,
+		activeByHost: make(map[string]int),
+		waiting:      make(map[string][]chan struct{}),
+	}
+}
+
+// gitHost returns the host of a remote reference's git url (e.g. github.com/earthly/earthly).
+func gitHost(gitURL string) string {
+	return strings.SplitN(gitURL, "/", 2)[0]
+}
+
+// acquire waits for a slot for resolving a project of the given host, and returns the func which
+// gives it back.
+func (s *gitScheduler) acquire(ctx context.Context, host string) (func(), error) {
+	release := func() {
+		s.release(host)
+	}
+	s.mu.Lock()
+	if len(s.waiting[host]) == 0 && s.canRun(host) {
+		s.start(host)
+		s.mu.Unlock()
+		return release, nil
+	}
+	granted := make(chan struct{})
+	if len(s.waiting[host]) == 0 {
+		s.hosts = append(s.hosts, host)
+	}
+	s.waiting[host] = append(s.waiting[host], granted)
+	s.mu.Unlock()
+
+	select {
+	case <-granted:
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-granted:
+			// Granted in the meantime: give it to the next one.
+			s.stop(host)
+			s.dispatch()
+		default:
+			s.removeWaiter(host, granted)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *gitScheduler) release(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop(host)
+	s.dispatch()
+}
+
+func (s *gitScheduler) canRun(host string) bool {
+	return (s.max <= 0 || s.active < s.max) &&
+		(s.maxPerHost <= 0 || s.activeByHost[host] < s.maxPerHost)
+}
+
+func (s *gitScheduler) start(host string) {
+	s.active++
+	s.activeByHost[host]++
+}
+
+func (s *gitScheduler) stop(host string) {
+	s.active--
+	s.activeByHost[host]--
+	if s.activeByHost[host] == 0 {
+		delete(s.activeByHost, host)
+	}
+}
+
+// dispatch grants the waiters which can run, taking the hosts in turn.
+func (s *gitScheduler) dispatch() {
+	for len(s.hosts) > 0 && (s.max <= 0 || s.active < s.max) {
+		granted := false
+		for i := 0; i < len(s.hosts); i++ {
+			idx := (s.next + i) % len(s.hosts)
+			host := s.hosts[idx]
+			if !s.canRun(host) {
+				continue
+			}
+			waiter := s.waiting[host][0]
+			s.waiting[host] = s.waiting[host][1:]
+			s.start(host)
+			close(waiter)
+			if len(s.waiting[host]) == 0 {
+				delete(s.waiting, host)
+				s.hosts = append(s.hosts[:idx], s.hosts[idx+1:]...)
+				s.next = idx
+			} else {
+				s.next = idx + 1
+			}
+			if len(s.hosts) > 0 {
+				s.next %= len(s.hosts)
+			} else {
+				s.next = 0
+			}
+			granted = true
+			break
+		}
+		if !granted {
+			return
+		}
+	}
+}
+
+func (s *gitScheduler) removeWaiter(host string, waiter chan struct{}) {
+	waiters := s.waiting[host]
+	for i, w := range waiters {
+		if w == waiter {
+			s.waiting[host] = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(s.waiting[host]) > 0 {
+		return
+	}
+	delete(s.waiting, host)
+	for i, h := range s.hosts {
+		if h == host {
+			s.hosts = append(s.hosts[:i], s.hosts[i+1:]...)
+			if s.next > i {
+				s.next--
+			}
+			break
+		}
+	}
+	if len(s.hosts) > 0 {
+		s.next %= len(s.hosts)
+	} else {
+		s.next = 0
+	}
+}
@@ -0,0 +1,185 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
+)
+
+// numWaiting returns the number of acquisitions waiting for a slot.
+func (s *gitScheduler) numWaiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, waiters := range s.waiting {
+		n += len(waiters)
+	}
+	return n
+}
+
+func TestGitSchedulerFairness(t *testing.T) {
+	s := newGitScheduler(2, 0)
+	ctx := context.Background()
+	type grant struct {
+		host    string
+		release func()
+	}
+	grants := make(chan grant, 10)
+	acquire := func(host string) {
+		release, err := s.acquire(ctx, host)
+		NoError(t, err)
+		grants <- grant{host: host, release: release}
+	}
+	waitFor := func(n int) {
+		Eventually(t, func() bool { return s.numWaiting() == n }, 5*time.Second, time.Millisecond)
+	}
+
+	// github.com takes both slots, and queues up 4 more resolutions before gitlab.com does.
+	acquire("github.com")
+	acquire("github.com")
+	for i := 1; i <= 4; i++ {
+		go acquire("github.com")
+		waitFor(i)
+	}
+	go acquire("gitlab.com")
+	waitFor(5)
+	go acquire("gitlab.com")
+	waitFor(6)
+
+	var order []string
+	running := []grant{<-grants, <-grants}
+	for i := 0; i < 6; i++ {
+		running[0].release()
+		g := <-grants
+		order = append(order, g.host)
+		running = append(running[1:], g)
+	}
+	for _, g := range running {
+		g.release()
+	}
+	// The hosts are granted in turn, until gitlab.com has no more waiting.
+	Equal(t, []string{"github.com", "gitlab.com", "github.com", "gitlab.com", "github.com", "github.com"}, order)
+	Equal(t, 0, s.active)
+	Empty(t, s.activeByHost)
+}
+
+func TestGitSchedulerPerHost(t *testing.T) {
+	s := newGitScheduler(0, 1)
+	ctx := context.Background()
+	releaseA, err := s.acquire(ctx, "github.com")
+	NoError(t, err)
+	// Other hosts are not held up.
+	releaseB, err := s.acquire(ctx, "gitlab.com")
+	NoError(t, err)
+
+	granted := make(chan func())
+	go func() {
+		release, err := s.acquire(ctx, "github.com")
+		NoError(t, err)
+		granted <- release
+	}()
+	Eventually(t, func() bool { return s.numWaiting() == 1 }, 5*time.Second, time.Millisecond)
+	releaseB()
+	select {
+	case <-granted:
+		t.Fatal("granted beyond the per host limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	releaseA()
+	(<-granted)()
+
+	// Waiting acquisitions can be canceled.
+	releaseA, err = s.acquire(ctx, "github.com")
+	NoError(t, err)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	errs := make(chan error)
+	go func() {
+		_, err := s.acquire(cancelCtx, "github.com")
+		errs <- err
+	}()
+	Eventually(t, func() bool { return s.numWaiting() == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+	ErrorIs(t, <-errs, context.Canceled)
+	Equal(t, 0, s.numWaiting())
+	releaseA()
+	Equal(t, 0, s.active)
+}
+
+func TestResolveMaxConcurrentResolves(t *testing.T) {
+	targets := []string{
+		"github.com/earthly/a:main+build",
+		"github.com/earthly/b:main+build",
+		"gitlab.com/earthly/c:main+build",
+	}
+	// resolveAll resolves the targets concurrently, their git meta runs blocking until released.
+	resolveAll := func(r *Resolver) (gwClient *fakeGwClient, entered chan struct{}, release chan struct{}, maxRunning *int32, wait func()) {
+		gwClient = newTestGwClient(map[string]string{
+			"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		})
+		entered = make(chan struct{}, len(targets))
+		release = make(chan struct{})
+		var running int32
+		maxRunning = new(int32)
+		gwClient.solveErr = func(def *pb.Definition) error {
+			isMetaRun := false
+			for _, dt := range def.Def {
+				var op pb.Op
+				NoError(t, op.Unmarshal(dt), "unmarshal op")
+				isMetaRun = isMetaRun || op.GetExec() != nil
+			}
+			if !isMetaRun {
+				return nil
+			}
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(maxRunning, m, n) {
+					break
+				}
+			}
+			entered <- struct{}{}
+			<-release
+			return nil
+		}
+		var wg sync.WaitGroup
+		for _, target := range targets {
+			ref, err := domain.ParseTarget(target)
+			NoError(t, err)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+				NoError(t, err, "Resolve failed")
+			}()
+		}
+		return gwClient, entered, release, maxRunning, wg.Wait
+	}
+
+	// Without a limit, the git meta runs are all in flight at once.
+	_, entered, release, maxRunning, wait := resolveAll(newTestResolver(t, ResolverOpt{}))
+	for range targets {
+		<-entered
+	}
+	close(release)
+	wait()
+	Equal(t, int32(len(targets)), *maxRunning)
+
+	// With the limit, the other resolutions wait for the one in flight.
+	r := newTestResolver(t, ResolverOpt{MaxConcurrentResolves: 1})
+	gwClient, entered, release, maxRunning, wait := resolveAll(r)
+	for i := range targets {
+		<-entered
+		Eventually(t, func() bool { return r.gr.scheduler.numWaiting() == len(targets)-1-i }, 5*time.Second, time.Millisecond)
+		release <- struct{}{}
+	}
+	wait()
+	Equal(t, len(targets), gwClient.numMetaRuns(t))
+	Equal(t, int32(1), *maxRunning)
+}
@@ -0,0 +1,49 @@
+package buildcontext
+
+import (
+	"fmt"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/stringutil"
+)
+
+// gitShortHashLength is the length of the short hashes of the git metadata, as requested from git
+// rev-parse --short. git lengthens those which would be ambiguous.
+const gitShortHashLength = 8
+
+// ErrAmbiguousShortHash is returned, when StrictShortHash is set, when the short hash of the commit
+// of a remote reference is shared with other objects of its repository.
+type ErrAmbiguousShortHash struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	// ShortHash is the ambiguous short hash, Hash the full hash of the commit, and UniqueShortHash
+	// the shortest unambiguous prefix of it.
+	ShortHash       string
+	Hash            string
+	UniqueShortHash string
+}
+
+// Error is function required by error interface.
+func (err ErrAmbiguousShortHash) Error() string {
+	return fmt.Sprintf("the short hash %s of commit %s of %s is ambiguous, the commit needing %s to be told apart", err.ShortHash, err.Hash, err.Repo, err.UniqueShortHash)
+}
+
+// checkShortHash checks whether git had to lengthen the short hash of the commit of ref, for it to
+// be unambiguous among the objects of the clone. The lengthened short hash is kept, unless
+// StrictShortHash is set, in which case ErrAmbiguousShortHash is returned.
+func (gr *gitResolver) checkShortHash(ref domain.Reference, gitURL, hash, shortHash string) error {
+	if len(shortHash) <= gitShortHashLength || len(hash) < gitShortHashLength {
+		return nil
+	}
+	ambiguousErr := ErrAmbiguousShortHash{
+		Repo:            stringutil.ScrubCredentials(gitURL),
+		ShortHash:       hash[:gitShortHashLength],
+		Hash:            hash,
+		UniqueShortHash: shortHash,
+	}
+	if gr.strictShortHash {
+		return ambiguousErr
+	}
+	gr.console.VerbosePrintf("%s; %s uses %s instead\n", ambiguousErr.Error(), ref.ProjectCanonical(), shortHash)
+	return nil
+}
@@ -0,0 +1,48 @@
+package buildcontext
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveAmbiguousShortHash(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	newGwClient := func() *fakeGwClient {
+		return newTestGwClient(map[string]string{
+			"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			// Lengthened by git, as another object starts with a7b2c4d5.
+			"git-short-hash": "a7b2c4d5e6\n",
+		})
+	}
+
+	// The unambiguous short hash is used by default.
+	r := newTestResolver(t, ResolverOpt{})
+	d, err := r.Resolve(context.Background(), newGwClient(), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "a7b2c4d5e6", d.GitMetadata.ShortHash)
+
+	r = newTestResolver(t, ResolverOpt{StrictShortHash: true})
+	_, err = r.Resolve(context.Background(), newGwClient(), newTestPlatformResolver(), ref)
+	var ambiguousErr ErrAmbiguousShortHash
+	True(t, errors.As(err, &ambiguousErr), "unexpected error %v", err)
+	Equal(t, ErrAmbiguousShortHash{
+		Repo:            "https://github.com/earthly/test.git",
+		ShortHash:       "a7b2c4d5",
+		Hash:            "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5",
+		UniqueShortHash: "a7b2c4d5e6",
+	}, ambiguousErr)
+
+	// Unambiguous short hashes are fine.
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	r = newTestResolver(t, ResolverOpt{StrictShortHash: true})
+	d, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "a7b2c4d5", d.GitMetadata.ShortHash)
+}
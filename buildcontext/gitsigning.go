@@ -0,0 +1,21 @@
+package buildcontext
+
+import "strings"
+
+// gitSigningKeyFile is the git meta file holding the fingerprint and the ID of the key the commit is
+// signed with, on separate lines, when requested.
+const gitSigningKeyFile = "git-signing-key"
+
+// parseGitSigningKey parses the content of gitSigningKeyFile into the fingerprint and the ID of the
+// signing key. Both are empty for unsigned commits.
+func parseGitSigningKey(out string) (string, string) {
+	lines := strings.SplitN(out, "\n", 3)
+	var fingerprint, keyID string
+	if len(lines) > 0 {
+		fingerprint = strings.TrimSpace(lines[0])
+	}
+	if len(lines) > 1 {
+		keyID = strings.TrimSpace(lines[1])
+	}
+	return fingerprint, keyID
+}
@@ -0,0 +1,99 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitMetaScriptSigningKey(t *testing.T) {
+	for _, bin := range []string{"git", "gpg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not available for tests, skipping", bin)
+		}
+	}
+	gnupgHome, err := os.MkdirTemp("", "earthly-gnupg") // Short, for the gpg-agent socket path.
+	NoError(t, err)
+	defer os.RemoveAll(gnupgHome)
+	env := append(os.Environ(), "GNUPGHOME="+gnupgHome)
+	run := func(dir, name string, args ...string) string {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "%s %v: %s", name, args, out)
+		return string(out)
+	}
+	defer run("", "gpgconf", "--kill", "gpg-agent")
+	run("", "gpg", "--batch", "--passphrase", "", "--quick-generate-key", "Test <test@example.com>", "ed25519", "sign", "never")
+	var fingerprint string
+	for _, line := range strings.Split(run("", "gpg", "--batch", "--with-colons", "--list-keys"), "\n") {
+		if strings.HasPrefix(line, "fpr:") {
+			fingerprint = strings.Split(line, ":")[9]
+			break
+		}
+	}
+	NotEmpty(t, fingerprint)
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		run(repo, "git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "user.signingkey=" + fingerprint}, args...)...)
+	}
+	runMeta := func() (string, string) {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitMetaScript(dest, true, false, false, 0))
+		cmd.Dir = repo
+		cmd.Env = env
+		_ = cmd.Run()
+		out, err := os.ReadFile(filepath.Join(dest, gitSigningKeyFile))
+		NoError(t, err)
+		return parseGitSigningKey(string(out))
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+	git("commit", "--quiet", "--allow-empty", "-m", "unsigned")
+	actualFingerprint, keyID := runMeta()
+	Empty(t, actualFingerprint)
+	Empty(t, keyID)
+
+	git("commit", "--quiet", "--allow-empty", "-S", "-m", "signed")
+	actualFingerprint, keyID = runMeta()
+	Equal(t, fingerprint, actualFingerprint)
+	True(t, strings.HasSuffix(fingerprint, keyID), "%s is not the ID of %s", keyID, fingerprint)
+}
+
+func TestResolveSigningKey(t *testing.T) {
+	files := map[string]string{
+		"Earthfile":       "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitSigningKeyFile: "4E1F799AA4FF2279BDA9C8C45A7E0C1F1A8D3C2B\n5A7E0C1F1A8D3C2B\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	for _, useGitExec := range []bool{false, true} {
+		gwClient := newTestGwClient(files)
+		r := newTestResolver(t, ResolverOpt{ReadSigningKey: true, GitMirror: GitMirrorOpt{Enabled: useGitExec}})
+		d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		Equal(t, "4E1F799AA4FF2279BDA9C8C45A7E0C1F1A8D3C2B", d.GitMetadata.SigningKeyFingerprint)
+		Equal(t, "5A7E0C1F1A8D3C2B", d.GitMetadata.SigningKeyID)
+		for _, op := range gwClient.solvedOps(t) {
+			if exec := op.GetExec(); exec != nil {
+				Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "--format=%GF%n%GK")
+			}
+		}
+	}
+
+	// Unsigned commits.
+	files[gitSigningKeyFile] = "\n\n"
+	r := newTestResolver(t, ResolverOpt{ReadSigningKey: true})
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Empty(t, d.GitMetadata.SigningKeyFingerprint)
+	Empty(t, d.GitMetadata.SigningKeyID)
+}
@@ -0,0 +1,108 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/earthly/earthly/util/syncutil/synccache"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// defaultBranchFallbacks are the branches tried, in order, when the HEAD of a remote repository
+// points at a nonexistent branch and DefaultBranch.Fallbacks is not set.
+var defaultBranchFallbacks = []string{"main", "master"}
+
+// ErrStaleHead is returned when the HEAD of a remote repository points at a nonexistent branch (a
+// stale symref, e.g. left by deleting its default branch), so that its default branch cannot be
+// resolved, and none of the fallback branches exist either.
+type ErrStaleHead struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	// Fallbacks are the branches which have been tried instead.
+	Fallbacks []string
+}
+
+// Error is function required by error interface.
+func (err ErrStaleHead) Error() string {
+	msg := fmt.Sprintf("the HEAD of repository %s points at no existing branch", err.Repo)
+	if len(err.Fallbacks) > 0 {
+		msg += fmt.Sprintf(", and none of the fallback branches %v exist", err.Fallbacks)
+	}
+	return msg
+}
+
+var gitNoDefaultBranchRegexp = regexp.MustCompile(`could not find default branch for repository`)
+
+// isNoDefaultBranchError returns whether err is the failure of buildkit to find out the default
+// branch of a repository, which it does out of the branch its HEAD points at.
+func isNoDefaultBranchError(err error) bool {
+	return gitNoDefaultBranchRegexp.MatchString(err.Error())
+}
+
+// staleHeadBranch returns whether err is the failure to resolve the default branch of a remote
+// repository whose HEAD points at a nonexistent branch, along with that branch, when known.
+func staleHeadBranch(err error) (string, bool) {
+	var noCommitsErr ErrGitRefNoCommits
+	if errors.As(err, &noCommitsErr) {
+		// A remote branch with no commits does not exist.
+		return noCommitsErr.Branch, true
+	}
+	if errors.As(err, &ErrStaleHead{}) {
+		return "", true
+	}
+	return "", false
+}
+
+// withStaleHeadFallback wraps the constructor of the project of a remote reference, so that when
+// the reference is of the default branch, and the HEAD of the repository turns out to point at a
+// nonexistent branch, the first of the fallback branches which can be resolved is used instead, with
+// a warning. Otherwise, the error of the constructor is returned.
+func (gr *gitResolver) withStaleHeadFallback(gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, c synccache.Constructor) synccache.Constructor {
+	return func(ctx context.Context, key interface{}) (interface{}, error) {
+		v, err := c(ctx, key)
+		if err == nil || ref.GetTag() != "" {
+			return v, err
+		}
+		stale, ok := staleHeadBranch(err)
+		if !ok {
+			return v, err
+		}
+		fallbacks := gr.defaultBranch.Fallbacks
+		if fallbacks == nil {
+			fallbacks = defaultBranchFallbacks
+		}
+		var tried []string
+		for _, branch := range fallbacks {
+			if branch == stale {
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			tried = append(tried, branch)
+			fallbackRef := domain.Target{GitURL: ref.GetGitURL(), Tag: branch}
+			rgp, _, _, fallbackErr := gr.resolveGitProject(ctx, gwClient, platr, fallbackRef)
+			if fallbackErr != nil {
+				gr.console.VerbosePrintf("unable to fall back to branch %s of %s: %s\n", branch, ref.ProjectCanonical(), fallbackErr.Error())
+				continue
+			}
+			if stale == "" {
+				gr.warn(ref, WarningStaleHead, "the HEAD of %s points at no existing branch; falling back to branch %s", stringutil.ScrubCredentials(rgp.gitURL), branch)
+			} else {
+				gr.warn(ref, WarningStaleHead, "the HEAD of %s points at branch %s, which does not exist; falling back to branch %s", stringutil.ScrubCredentials(rgp.gitURL), stale, branch)
+			}
+			return rgp, nil
+		}
+		var staleErr ErrStaleHead
+		if errors.As(err, &staleErr) {
+			staleErr.Fallbacks = tried
+			return nil, staleErr
+		}
+		return nil, err
+	}
+}
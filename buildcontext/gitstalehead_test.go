@@ -0,0 +1,191 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitCloneScriptStaleHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "--quiet", "--bare")
+	work := t.TempDir()
+	git("init", "--quiet", work)
+	git("-C", work, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	hash := git("-C", work, "rev-parse", "HEAD")
+	git("-C", work, "push", "--quiet", repo, "HEAD:refs/heads/main")
+	// The default branch has been deleted.
+	git("symbolic-ref", "HEAD", "refs/heads/gone")
+
+	run := func(gitRef string) map[string]string {
+		dest := t.TempDir()
+		cmd := exec.Command("/bin/sh", "-c", gitCloneScript(false, gitRef != "", false, false, false, false, false, false, false, false, 0, 0, 0, "", filepath.Join(t.TempDir(), "src"), dest, nil))
+		cmd.Env = append(os.Environ(),
+			"EARTHLY_GIT_URL=file://"+repo,
+			"EARTHLY_GIT_REF="+gitRef,
+			"EARTHLY_GIT_ORIGIN=file://"+repo,
+		)
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git clone script: %s", out)
+		files := make(map[string]string)
+		for _, name := range []string{"git-unborn", "git-refs", "git-hash"} {
+			dt, err := os.ReadFile(filepath.Join(dest, name))
+			NoError(t, err)
+			files[name] = strings.TrimSpace(string(dt))
+		}
+		return files
+	}
+
+	// The dangling HEAD is detected as the unborn branch of a repository which has refs.
+	files := run("")
+	Equal(t, "gone", files["git-unborn"])
+	NotEmpty(t, files["git-refs"])
+	// The fallback branch resolves.
+	files = run("main")
+	Equal(t, "", files["git-unborn"])
+	Equal(t, hash, files["git-hash"])
+}
+
+// solvedGitRef returns the git ref the git meta run of the definition clones, if any.
+func solvedGitRef(t *testing.T, def *pb.Definition) (string, bool) {
+	for _, dt := range def.Def {
+		var op pb.Op
+		NoError(t, op.Unmarshal(dt), "unmarshal op")
+		if src := op.GetSource(); src != nil && strings.HasPrefix(src.Identifier, "git://") {
+			_, gitRef, _ := strings.Cut(src.Identifier, "#")
+			return gitRef, true
+		}
+		if exec := op.GetExec(); exec != nil {
+			for _, env := range exec.Meta.Env {
+				if gitRef := strings.TrimPrefix(env, "EARTHLY_GIT_REF="); gitRef != env {
+					return gitRef, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func TestResolveStaleHead(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test+build")
+	NoError(t, err)
+	newResolver := func(opt ResolverOpt) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", opt), &buf
+	}
+	// Only the given branches exist.
+	newGwClient := func(branches ...string) *fakeGwClient {
+		gwClient := newTestGwClient(map[string]string{
+			"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		})
+		exists := func(gitRef string) bool {
+			if gitFullHashRegexp.MatchString(gitRef) {
+				// The build context is cloned at the resolved commit.
+				return true
+			}
+			for _, branch := range branches {
+				if gitRef == branch {
+					return true
+				}
+			}
+			return false
+		}
+		gwClient.solveErr = func(def *pb.Definition) error {
+			gitRef, ok := solvedGitRef(t, def)
+			if !ok || exists(gitRef) {
+				return nil
+			}
+			if gitRef == "" {
+				return errors.New("could not find default branch for repository: https://github.com/earthly/test.git")
+			}
+			return errors.Errorf("failed to fetch remote https://github.com/earthly/test.git: git stderr:\nfatal: couldn't find remote ref %s\n: exit status 128", gitRef)
+		}
+		return gwClient
+	}
+
+	// Detected out of the failure of buildkit to find out the default branch.
+	var warnings []Warning
+	r, buf := newResolver(ResolverOpt{
+		OnWarning: func(w Warning) {
+			warnings = append(warnings, w)
+		},
+	})
+	gwClient := newGwClient("master")
+	for i := 0; i < 2; i++ {
+		d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		if NoError(t, err, "Resolve failed") {
+			Equal(t, "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5", d.GitMetadata.Hash)
+		}
+	}
+	Equal(t, 1, strings.Count(buf.String(), "Warning: the HEAD of https://github.com/earthly/test.git points at no existing branch; falling back to branch master\n"), buf.String())
+	if Len(t, warnings, 1) {
+		Equal(t, WarningStaleHead, warnings[0].Code)
+		Equal(t, ref, warnings[0].Ref)
+	}
+
+	// Detected by the git meta run, which names the branch HEAD points at.
+	r, buf = newResolver(ResolverOpt{GitMirror: GitMirrorOpt{Enabled: true}})
+	gwClient = newGwClient()
+	gwClient.solveErr = nil
+	gwClient.solveFiles = func(def *pb.Definition) map[string]string {
+		files := make(map[string]string)
+		for k, v := range gwClient.files {
+			files[k] = v
+		}
+		if gitRef, _ := solvedGitRef(t, def); gitRef == "" {
+			files["git-unborn"] = "gone\n"
+			files["git-refs"] = "refs/remotes/origin/main\n"
+		}
+		return files
+	}
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Contains(t, buf.String(), "Warning: the HEAD of https://github.com/earthly/test.git points at branch gone, which does not exist; falling back to branch main\n")
+
+	// Configured fallbacks, none of which exist.
+	r, _ = newResolver(ResolverOpt{DefaultBranch: DefaultBranchOpt{Fallbacks: []string{"trunk", "develop"}}})
+	_, err = r.Resolve(context.Background(), newGwClient("main"), newTestPlatformResolver(), ref)
+	var staleErr ErrStaleHead
+	True(t, errors.As(err, &staleErr), "unexpected error %v", err)
+	Equal(t, ErrStaleHead{Repo: "https://github.com/earthly/test.git", Fallbacks: []string{"trunk", "develop"}}, staleErr)
+
+	// Falling back disabled.
+	r, _ = newResolver(ResolverOpt{DefaultBranch: DefaultBranchOpt{Fallbacks: []string{}}})
+	_, err = r.Resolve(context.Background(), newGwClient("main"), newTestPlatformResolver(), ref)
+	True(t, errors.As(err, &staleErr), "unexpected error %v", err)
+	Empty(t, staleErr.Fallbacks)
+
+	// References of a branch are not affected.
+	r, _ = newResolver(ResolverOpt{})
+	branchRef, err := domain.ParseTarget("github.com/earthly/test:gone+build")
+	NoError(t, err)
+	_, err = r.Resolve(context.Background(), newGwClient("main"), newTestPlatformResolver(), branchRef)
+	Error(t, err)
+	False(t, errors.As(err, &ErrStaleHead{}))
+}
@@ -0,0 +1,89 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/earthly/earthly/util/stringutil"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+// GitMetaStats are the measurements of the git meta run of a remote project. The CPU and memory
+// used by the run are not part of them, as buildkit does not report them to its clients.
+type GitMetaStats struct {
+	// Repo is the url the project has been cloned from, with credentials scrubbed.
+	Repo string
+	// Ref is the requested git ref (empty for the default branch).
+	Ref string
+	// RunDuration is the wall time of solving the git meta run, which includes cloning the
+	// repository (and pulling the git image, if need be).
+	RunDuration time.Duration
+	// ReadDuration is the wall time of reading the metadata files the run has written.
+	ReadDuration time.Duration
+	// Files is the number of metadata files read, and Bytes their total size.
+	Files int
+	Bytes int64
+}
+
+// gitMetaStatsRecorder records the stats of a git meta run, if enabled.
+type gitMetaStatsRecorder struct {
+	report func(GitMetaStats)
+
+	mu        sync.Mutex
+	stats     GitMetaStats
+	readStart time.Time
+}
+
+func (gr *gitResolver) newGitMetaStatsRecorder(gitRef string) *gitMetaStatsRecorder {
+	if gr.onGitMetaStats == nil {
+		return nil
+	}
+	return &gitMetaStatsRecorder{
+		report: gr.onGitMetaStats,
+		stats: GitMetaStats{
+			Ref: gitRef,
+		},
+	}
+}
+
+// ran records the run which cloned gitURL, and returns the reference of its output, wrapped so that
+// the reads out of it are recorded.
+func (rec *gitMetaStatsRecorder) ran(gitURL string, gitMetaRef gwclient.Reference, d time.Duration) gwclient.Reference {
+	if rec == nil {
+		return gitMetaRef
+	}
+	rec.stats.Repo = stringutil.ScrubCredentials(gitURL)
+	rec.stats.RunDuration = d
+	rec.readStart = time.Now()
+	return &statsRef{Reference: gitMetaRef, rec: rec}
+}
+
+// done reports the stats recorded so far, if the run has completed.
+func (rec *gitMetaStatsRecorder) done() {
+	if rec == nil || rec.readStart.IsZero() {
+		return
+	}
+	rec.mu.Lock()
+	stats := rec.stats
+	rec.mu.Unlock()
+	stats.ReadDuration = time.Since(rec.readStart)
+	rec.report(stats)
+}
+
+// statsRef is a reference recording the files read out of it.
+type statsRef struct {
+	gwclient.Reference
+	rec *gitMetaStatsRecorder
+}
+
+func (r *statsRef) ReadFile(ctx context.Context, req gwclient.ReadRequest) ([]byte, error) {
+	dt, err := r.Reference.ReadFile(ctx, req)
+	if err == nil {
+		r.rec.mu.Lock()
+		r.rec.stats.Files++
+		r.rec.stats.Bytes += int64(len(dt))
+		r.rec.mu.Unlock()
+	}
+	return dt, err
+}
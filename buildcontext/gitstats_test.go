@@ -0,0 +1,48 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveGitMetaStats(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	var mu sync.Mutex
+	var reported []GitMetaStats
+	r := newTestResolver(t, ResolverOpt{OnGitMetaStats: func(stats GitMetaStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, stats)
+	}})
+	for i := 0; i < 2; i++ {
+		_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+
+	// The second resolution is served by the cache.
+	mu.Lock()
+	defer mu.Unlock()
+	if !Len(t, reported, 1) {
+		return
+	}
+	stats := reported[0]
+	Equal(t, "https://github.com/earthly/test.git", stats.Repo)
+	Equal(t, "main", stats.Ref)
+	True(t, stats.RunDuration > 0, "run duration not recorded")
+	True(t, stats.ReadDuration > 0, "read duration not recorded")
+	var metaBytes int64
+	for _, content := range testGitMetaFiles {
+		metaBytes += int64(len(content))
+	}
+	Equal(t, len(testGitMetaFiles), stats.Files)
+	Equal(t, metaBytes, stats.Bytes)
+}
@@ -0,0 +1,208 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/stringutil"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+// gitMetaRun is the resolution of a project afresh, threaded through its steps.
+type gitMetaRun struct {
+	gwClient gwclient.Client
+	platr    *platutil.Resolver
+	ref      domain.Reference
+	gitRef   string
+	subDir   string
+	// keyURL is the url the project is cached under.
+	keyURL string
+	// candidates are the urls the project may be cloned from, in order of preference. ctxCreds is
+	// set when they carry the credentials of the context, in which case the project is not cached.
+	candidates []cloneCandidate
+	ctxCreds   bool
+	vm         *outmon.VertexMeta
+
+	// clone is the candidate the project has been cloned from, and metaRef the outcome of its git
+	// meta run. execState is the state of the clone made by running git in the git image.
+	clone     cloneCandidate
+	metaRef   gwclient.Reference
+	execState pllb.State
+}
+
+// gitResolveStep is a step of the resolution of a project afresh. Steps which do not apply, as per
+// the options of the resolver, return right away.
+type gitResolveStep func(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error
+
+// gitMetaSteps returns the steps run, in order, against the git meta run of a project, before its
+// git metadata is read. They check the clone, and read the metadata of optional features into the
+// project, which only holds the url it is cloned from yet.
+func (gr *gitResolver) gitMetaSteps() []gitResolveStep {
+	return []gitResolveStep{
+		gr.checkAdvertiseTimeoutStep,
+		gr.checkTooManyRefsStep,
+		gr.deepenShallowMetaStep,
+		gr.checkUnbornStep,
+		gr.checkProtectedBranchStep,
+		gr.checkDefaultBranchStep,
+		gr.readTagKindsStep,
+		gr.readLFSPointersStep,
+		gr.fetchLFSStep,
+	}
+}
+
+// gitProjectSteps returns the steps run, in order, against a project once its git metadata has
+// been read.
+func (gr *gitResolver) gitProjectSteps() []gitResolveStep {
+	return []gitResolveStep{
+		gr.checkShortHashStep,
+		gr.checkMovedTagsStep,
+		gr.addSecondaryProjectsStep,
+	}
+}
+
+// runGitResolveSteps runs the steps in turn, stopping at the first failure.
+func runGitResolveSteps(ctx context.Context, steps []gitResolveStep, run *gitMetaRun, rgp *resolvedGitProject) error {
+	for _, step := range steps {
+		err := step(ctx, run, rgp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gr *gitResolver) checkAdvertiseTimeoutStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if gr.refAdvertiseTimeout <= 0 {
+		return nil
+	}
+	return gr.checkAdvertiseTimeout(ctx, run.metaRef, stringutil.ScrubCredentials(run.clone.gitURL))
+}
+
+func (gr *gitResolver) checkTooManyRefsStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if gr.maxGitRefs <= 0 {
+		return nil
+	}
+	return gr.checkTooManyRefs(ctx, run.metaRef, stringutil.ScrubCredentials(run.clone.gitURL))
+}
+
+func (gr *gitResolver) deepenShallowMetaStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	metaRef, err := gr.deepenShallowMeta(ctx, run.gwClient, run.platr, run.ref, run.clone, run.gitRef, run.vm, run.metaRef)
+	if err != nil {
+		return err
+	}
+	run.metaRef = metaRef
+	return nil
+}
+
+// checkUnbornStep fails the resolution of refs which have no commits, such as the default branch
+// of an empty repository.
+func (gr *gitResolver) checkUnbornStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	gitUnbornBytes, err := gr.readGitMeta(ctx, run.metaRef, "git-unborn")
+	if err != nil {
+		return err
+	}
+	unborn := strings.TrimSpace(string(gitUnbornBytes))
+	if unborn == "" {
+		return nil
+	}
+	gitRefsBytes, err := gr.readGitMeta(ctx, run.metaRef, "git-refs")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(gitRefsBytes)) == "" {
+		return ErrEmptyRepository{
+			Repo: stringutil.ScrubCredentials(run.clone.gitURL),
+		}
+	}
+	return ErrGitRefNoCommits{
+		Ref:    run.ref.ProjectCanonical(),
+		Branch: unborn,
+	}
+}
+
+func (gr *gitResolver) checkProtectedBranchStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if gr.protectedBranch == "" {
+		return nil
+	}
+	return gr.checkProtectedAncestor(ctx, run.metaRef, run.ref)
+}
+
+func (gr *gitResolver) checkDefaultBranchStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	repo, expected := gr.expectedDefaultBranch(run.ref.GetGitURL(), run.subDir)
+	if expected == "" {
+		return nil
+	}
+	return gr.checkDefaultBranch(ctx, run.ref, run.metaRef, repo, expected)
+}
+
+func (gr *gitResolver) readTagKindsStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if !gr.tagChecks.RequireAnnotated {
+		return nil
+	}
+	tagKinds, err := gr.readTagKinds(ctx, run.metaRef)
+	if err != nil {
+		return err
+	}
+	rgp.tagKinds = tagKinds
+	return nil
+}
+
+func (gr *gitResolver) readLFSPointersStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if !gr.lfs.DetectPointers {
+		return nil
+	}
+	lfsPointers, err := gr.readLFSPointers(ctx, run.metaRef)
+	if err != nil {
+		return err
+	}
+	rgp.lfsPointers = lfsPointers
+	return nil
+}
+
+func (gr *gitResolver) fetchLFSStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if !gr.lfs.Fetch {
+		return nil
+	}
+	execState, err := gr.lfsFetchState(ctx, run.gwClient, run.platr, run.ref, run.clone, run.vm, run.metaRef, run.execState)
+	if err != nil {
+		return err
+	}
+	run.execState = execState
+	// The pointer files are replaced by their content.
+	rgp.lfsPointers = nil
+	return nil
+}
+
+func (gr *gitResolver) checkShortHashStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	return gr.checkShortHash(run.ref, run.clone.gitURL, rgp.hash, rgp.shortHash)
+}
+
+func (gr *gitResolver) checkMovedTagsStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if run.ctxCreds {
+		// Nothing resolved with the context credentials is recorded.
+		return nil
+	}
+	return gr.checkMovedTags(ctx, run.ref, run.keyURL, rgp)
+}
+
+// addSecondaryProjectsStep adds cache entries for the branch and for the tag (if any) of the
+// project, as per the primary ref policy, in the background.
+func (gr *gitResolver) addSecondaryProjectsStep(ctx context.Context, run *gitMetaRun, rgp *resolvedGitProject) error {
+	if run.ctxCreds {
+		// The project is not cached.
+		return nil
+	}
+	go func() {
+		for _, secondaryRef := range gr.secondaryRefs(rgp.branches, rgp.tags) {
+			secondaryKey := gr.namespacedKey(gitProjectKey(run.keyURL, secondaryRef))
+			gr.addSecondaryProject(ctx, secondaryKey, rgp)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,104 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// ErrSubDirNotFound is returned when the subdirectory of a remote reference does not exist in its
+// repository at the resolved commit.
+type ErrSubDirNotFound struct {
+	// Ref is the project of the reference (e.g. github.com/earthly/earthly/examples/go:main).
+	Ref string
+	// SubDir is the subdirectory of the reference within the repository.
+	SubDir string
+	// TopLevelDirs are the directories at the root of the repository, sorted.
+	TopLevelDirs []string
+}
+
+// Error is function required by error interface.
+func (err ErrSubDirNotFound) Error() string {
+	msg := fmt.Sprintf("%s: directory %s does not exist in the repository", err.Ref, err.SubDir)
+	if len(err.TopLevelDirs) == 0 {
+		return msg + ", which has no directories"
+	}
+	return fmt.Sprintf("%s; its top-level directories are: %s", msg, strings.Join(err.TopLevelDirs, ", "))
+}
+
+// checkSubDir checks that the subdirectory of a reference exists in the trees of the resolved
+// project, when they are known.
+func checkSubDir(rgp *resolvedGitProject, ref, subDir string) error {
+	subDir = path.Clean(subDir)
+	if subDir == "." || len(rgp.treeHashes) == 0 {
+		return nil
+	}
+	if _, ok := rgp.treeHashes[subDir]; ok {
+		return nil
+	}
+	topLevelDirs := []string{}
+	for p := range rgp.treeHashes {
+		if p != "." && !strings.Contains(p, "/") {
+			topLevelDirs = append(topLevelDirs, p)
+		}
+	}
+	sort.Strings(topLevelDirs)
+	return ErrSubDirNotFound{
+		Ref:          ref,
+		SubDir:       subDir,
+		TopLevelDirs: topLevelDirs,
+	}
+}
+
+// checkSubDirInRef checks that the subdirectory of a reference exists in the given git state, for
+// when the trees of the project are not known.
+func checkSubDirInRef(ctx context.Context, ref gwclient.Reference, project, subDir string) error {
+	subDir = path.Clean(subDir)
+	if subDir == "." {
+		return nil
+	}
+	parent := "."
+	for _, name := range strings.Split(subDir, "/") {
+		dirs, err := readDirs(ctx, ref, parent)
+		if err != nil {
+			return err
+		}
+		if i := sort.SearchStrings(dirs, name); i == len(dirs) || dirs[i] != name {
+			topLevelDirs, err := readDirs(ctx, ref, ".")
+			if err != nil {
+				return err
+			}
+			return ErrSubDirNotFound{
+				Ref:          project,
+				SubDir:       subDir,
+				TopLevelDirs: topLevelDirs,
+			}
+		}
+		parent = path.Join(parent, name)
+	}
+	return nil
+}
+
+// readDirs returns the directories within dir of the given git state, sorted, leaving out .git.
+func readDirs(ctx context.Context, ref gwclient.Reference, dir string) ([]string, error) {
+	fstats, err := ref.ReadDir(ctx, gwclient.ReadDirRequest{
+		Path: dir,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read dir %s", dir)
+	}
+	dirs := []string{}
+	for _, fstat := range fstats {
+		name := path.Base(fstat.GetPath())
+		if fstat.IsDir() && name != ".git" {
+			dirs = append(dirs, name)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
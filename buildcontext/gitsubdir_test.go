@@ -0,0 +1,46 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveSubDirNotFound(t *testing.T) {
+	files := map[string]string{
+		"service/Earthfile":     "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"service/api/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"docs/index.md":         "# Docs\n",
+		"sub/Earthfile":         "VERSION 0.6\n",
+	}
+	for target, subDir := range map[string]string{
+		"github.com/earthly/test/srvice:main+build":      "srvice",
+		"github.com/earthly/test/service/apj:main+build": "service/apj",
+	} {
+		ref, err := domain.ParseTarget(target)
+		NoError(t, err)
+		for _, lazy := range []bool{false, true} {
+			r := newTestResolver(t, ResolverOpt{LazyResolve: lazy})
+			_, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+			var notFoundErr ErrSubDirNotFound
+			True(t, errors.As(err, &notFoundErr), "unexpected error %v", err)
+			Equal(t, subDir, notFoundErr.SubDir)
+			Equal(t, []string{"docs", "service", "sub"}, notFoundErr.TopLevelDirs)
+			Contains(t, err.Error(), "its top-level directories are: docs, service, sub")
+		}
+	}
+
+	// Existing subdirectories pass.
+	for _, target := range []string{
+		"github.com/earthly/test/service:main+build",
+		"github.com/earthly/test/service/api:main+build",
+	} {
+		ref, err := domain.ParseTarget(target)
+		NoError(t, err)
+		_, err = newTestResolver(t, ResolverOpt{}).Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve %s failed", target)
+	}
+}
@@ -0,0 +1,186 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/outmon"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/stringutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+const gitModulesFile = ".gitmodules"
+
+// gitSubmoduleStatusFile is the git meta file holding the output of `git submodule status --cached`,
+// when requested.
+const gitSubmoduleStatusFile = "git-submodule-status"
+
+// submodules returns the submodules declared in the .gitmodules file of the project, if any. They
+// are read once per project (failures are not kept, as they may be due to ctx).
+func (gr *gitResolver) submodules(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, rgp *resolvedGitProject) ([]gitutil.Submodule, error) {
+	rgp.submodulesMu.Lock()
+	defer rgp.submodulesMu.Unlock()
+	if rgp.submodules == nil {
+		submodules, err := gr.readSubmodulesOf(ctx, gwClient, platr, rgp)
+		if err != nil {
+			return nil, err
+		}
+		rgp.submodules = submodules
+	}
+	return rgp.submodules, nil
+}
+
+func (gr *gitResolver) readSubmodulesOf(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, rgp *resolvedGitProject) ([]gitutil.Submodule, error) {
+	gitState, err := llbutil.StateToRef(
+		ctx, gwClient, rgp.state, false,
+		platr.SubResolver(platutil.NativePlatform), nil)
+	if err != nil {
+		return nil, classifyGitError(errors.Wrap(err, "state to ref git submodules"))
+	}
+	exists, err := fileExists(ctx, gitState, gitModulesFile)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []gitutil.Submodule{}, nil
+	}
+	dt, err := gitState.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: gitModulesFile,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", gitModulesFile)
+	}
+	submodules, err := gitutil.ParseGitModules(string(dt))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s of %s", gitModulesFile, rgp.hash)
+	}
+	return submodules, nil
+}
+
+// parseGitSubmoduleStatus parses the output of `git submodule status --cached` into the commits the
+// submodules are pinned to, keyed by their path. Each line is made of a state flag (space when the
+// submodule is checked out at the commit, - when it is not initialized, + when it is checked out at
+// another commit, U when it has merge conflicts), the commit, the path and, for initialized
+// submodules, the description of the commit in parentheses. The state flag is irrelevant here.
+func parseGitSubmoduleStatus(out string) map[string]string {
+	commits := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		commit, p, ok := strings.Cut(line[1:], " ")
+		if !ok || commit == "" {
+			continue
+		}
+		if strings.HasSuffix(p, ")") {
+			if i := strings.LastIndex(p, " ("); i >= 0 {
+				p = p[:i]
+			}
+		}
+		commits[p] = commit
+	}
+	return commits
+}
+
+// withSubmoduleCommits returns a copy of submodules, which are shared with the project cache, with
+// their commits set out of the given ones, if any.
+func withSubmoduleCommits(submodules []gitutil.Submodule, commits map[string]string) []gitutil.Submodule {
+	if len(commits) == 0 {
+		return submodules
+	}
+	ret := make([]gitutil.Submodule, len(submodules))
+	for i, submodule := range submodules {
+		submodule.Commit = commits[submodule.Path]
+		ret[i] = submodule
+	}
+	return ret
+}
+
+// readSubmoduleStatus returns whether the git meta run reads the commits the submodules are pinned
+// to.
+func (gr *gitResolver) readSubmoduleStatus() bool {
+	return gr.readSubmodules || gr.initSubmodules
+}
+
+// contextState returns the state of the project of a remote target, the build context is made out
+// of. With InitSubmodules, the submodules of the projects cloned by running git in the git image
+// (which, as opposed to the buildkit git source, leaves them out) are checked out into it.
+func (gr *gitResolver) contextState(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, rgp *resolvedGitProject) (pllb.State, error) {
+	if !gr.initSubmodules || !gr.useGitExec(ref) {
+		return rgp.state, nil
+	}
+	submodules, err := gr.submodules(ctx, gwClient, platr, rgp)
+	if err != nil {
+		return pllb.State{}, err
+	}
+	vm := &outmon.VertexMeta{
+		TargetName: ref.String(),
+		Internal:   true,
+	}
+	state := rgp.state
+	for _, submodule := range submodules {
+		commit := rgp.submoduleCommits[submodule.Path]
+		if commit == "" {
+			// Declared, but not part of the tree.
+			continue
+		}
+		submoduleURL, err := resolveSubmoduleURL(rgp.gitURL, submodule.URL)
+		if err != nil {
+			return pllb.State{}, err
+		}
+		cloneURL, keyScans, err := gr.gitLookup.ConvertCloneURL(submoduleURL)
+		if err != nil {
+			return pllb.State{}, errors.Wrapf(err, "failed to get url for cloning submodule %s", submodule.Path)
+		}
+		// Cloned at the pinned commit on its own, for the clone to be cached per submodule commit.
+		// The buildkit git source initializes its own submodules, recursively.
+		gitOpts := []llb.GitOption{
+			llb.WithCustomNamef("[context %s] git submodule %s of %s", stringutil.ScrubCredentials(cloneURL), submodule.Path, ref.StringCanonical()),
+		}
+		if len(keyScans) > 0 {
+			gitOpts = append(gitOpts, llb.KnownSSHHosts(strings.Join(keyScans, "\n")))
+		}
+		submoduleState := pllb.Git(cloneURL, commit, gitOpts...)
+		state = llbutil.CopyDirContentsOp(submoduleState, ".", state, submodule.Path, "root:root", nil,
+			llb.WithCustomNamef("%sCOPY git submodule %s", vm.ToVertexPrefix(), submodule.Path))
+	}
+	return state, nil
+}
+
+// resolveSubmoduleURL resolves the url of a submodule, as declared in the .gitmodules file of its
+// superproject, against the url of the superproject, when relative to it (i.e. starting with ./ or
+// ../), as git does: ../other.git is a sibling of the superproject.
+func resolveSubmoduleURL(superURL, submoduleURL string) (string, error) {
+	if !strings.HasPrefix(submoduleURL, "./") && !strings.HasPrefix(submoduleURL, "../") {
+		return submoduleURL, nil
+	}
+	base := strings.TrimSuffix(superURL, "/")
+	sep := "/"
+	rel := submoduleURL
+	for {
+		if strings.HasPrefix(rel, "./") {
+			rel = rel[2:]
+			continue
+		}
+		if !strings.HasPrefix(rel, "../") {
+			break
+		}
+		rel = rel[3:]
+		// The path of scp-like urls (e.g. git@github.com:earthly/earthly.git) starts after the colon.
+		i := strings.LastIndexAny(base, "/:")
+		if i <= 0 || base[i-1] == '/' {
+			return "", errors.Errorf("submodule url %s is out of the host of %s", submoduleURL, stringutil.ScrubCredentials(superURL))
+		}
+		sep = base[i : i+1]
+		base = base[:i]
+	}
+	return base + sep + rel, nil
+}
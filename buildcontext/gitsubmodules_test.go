@@ -0,0 +1,158 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+
+	"github.com/moby/buildkit/solver/pb"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGitMetaScriptSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+
+	lib := t.TempDir()
+	git(lib, "init", "--quiet", "--initial-branch=main")
+	git(lib, "commit", "--quiet", "--allow-empty", "-m", "first")
+	pinned := git(lib, "rev-parse", "HEAD")
+	git(lib, "commit", "--quiet", "--allow-empty", "-m", "second")
+	git(lib, "tag", "lib-v2")
+	detached := git(lib, "rev-parse", "HEAD")
+
+	repo := t.TempDir()
+	git(repo, "init", "--quiet", "--initial-branch=main")
+	git(repo, "submodule", "--quiet", "add", lib, "vendor/lib")
+	git(filepath.Join(repo, "vendor/lib"), "checkout", "--quiet", "--detach", pinned)
+	git(repo, "add", "vendor/lib")
+	git(repo, "commit", "--quiet", "-m", "pin lib")
+	git(repo, "tag", "v1.0.0")
+	hash := git(repo, "rev-parse", "HEAD")
+	// The checkout of the submodule is detached at another commit than the pinned one.
+	git(filepath.Join(repo, "vendor/lib"), "checkout", "--quiet", "--detach", detached)
+
+	dest := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", gitMetaScript(dest, false, true, false, 0))
+	cmd.Dir = repo
+	_ = cmd.Run()
+	read := func(name string) string {
+		out, err := os.ReadFile(filepath.Join(dest, name))
+		NoError(t, err)
+		return string(out)
+	}
+	Equal(t, hash+"\n", read("git-hash"))
+	Equal(t, "main\n", read("git-branch"))
+	Equal(t, "v1.0.0\n", read("git-tags"))
+	Equal(t, map[string]string{"vendor/lib": pinned}, parseGitSubmoduleStatus(read(gitSubmoduleStatusFile)))
+}
+
+func TestParseGitSubmoduleStatus(t *testing.T) {
+	Equal(t, map[string]string{
+		"vendor/lib":      "0c2d8f1e3a4b5c6d7e8f90a1b2c3d4e5f6a7b8c9",
+		"docs":            "5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f",
+		"with space":      "9a0b1c2d3e4f5e6f7a8b9c0d1e2f3a4b5c6d7e8f",
+		"tools (pinned)":  "1c2d3e4f5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b",
+		"conflicted/path": "7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5e6f",
+	}, parseGitSubmoduleStatus(
+		" 0c2d8f1e3a4b5c6d7e8f90a1b2c3d4e5f6a7b8c9 vendor/lib (v2.1.0)\n"+
+			"-5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f docs\n"+
+			"+9a0b1c2d3e4f5e6f7a8b9c0d1e2f3a4b5c6d7e8f with space (heads/main)\n"+
+			" 1c2d3e4f5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b tools (pinned) (v1.0.0-2-g1c2d3e4)\n"+
+			"U7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5e6f conflicted/path\n"))
+	Empty(t, parseGitSubmoduleStatus(""))
+}
+
+func TestResolveSubmoduleURL(t *testing.T) {
+	for _, tc := range []struct {
+		superURL string
+		url      string
+		expected string
+		err      bool
+	}{
+		{"https://github.com/earthly/test.git", "https://github.com/earthly/lib.git", "https://github.com/earthly/lib.git", false},
+		{"https://github.com/earthly/test.git", "../docs.git", "https://github.com/earthly/docs.git", false},
+		{"https://github.com/earthly/test.git", "./docs.git", "https://github.com/earthly/test.git/docs.git", false},
+		{"https://github.com/earthly/test.git/", "../docs.git", "https://github.com/earthly/docs.git", false},
+		{"https://github.com/earthly/test.git", "../../other/docs.git", "https://github.com/other/docs.git", false},
+		{"git@github.com:earthly/test.git", "../docs.git", "git@github.com:earthly/docs.git", false},
+		{"git@github.com:earthly/test.git", "../../other/docs.git", "git@github.com:other/docs.git", false},
+		{"https://github.com/earthly/test.git", "../../../docs.git", "", true},
+		{"git@github.com:earthly/test.git", "../../../docs.git", "", true},
+	} {
+		actual, err := resolveSubmoduleURL(tc.superURL, tc.url)
+		if tc.err {
+			Error(t, err, tc.url)
+			continue
+		}
+		NoError(t, err, tc.url)
+		Equal(t, tc.expected, actual, tc.url)
+	}
+}
+
+func TestResolveInitSubmodules(t *testing.T) {
+	files := map[string]string{
+		"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		".gitmodules": "[submodule \"lib\"]\n\tpath = vendor/lib\n\turl = https://github.com/earthly/lib.git\n" +
+			"[submodule \"docs\"]\n\tpath = docs\n\turl = ../docs.git\n" +
+			"[submodule \"gone\"]\n\tpath = gone\n\turl = ../gone.git\n",
+		gitSubmoduleStatusFile: "+0c2d8f1e3a4b5c6d7e8f90a1b2c3d4e5f6a7b8c9 vendor/lib (v2.1.0)\n" +
+			"-5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f docs\n",
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	// With the project cloned in the git image.
+	r := newTestResolver(t, ResolverOpt{InitSubmodules: true, GitCloneDepth: 1})
+	d, err := r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	// The submodule status is read, without being part of the metadata.
+	Nil(t, d.GitMetadata.Submodules)
+
+	def, err := d.BuildContextFactory.Construct().Marshal(context.Background())
+	NoError(t, err, "marshal build context")
+	var cloned []string
+	for _, dt := range def.Def {
+		var op pb.Op
+		NoError(t, op.Unmarshal(dt), "unmarshal op")
+		if src := op.GetSource(); src != nil && strings.HasPrefix(src.Identifier, "git://") {
+			cloned = append(cloned, src.Identifier)
+		}
+	}
+	// Each submodule of the tree is cloned at its commit, relative urls against the superproject.
+	if Len(t, cloned, 2) {
+		var libFound, docsFound bool
+		for _, id := range cloned {
+			libFound = libFound || strings.HasSuffix(id, "earthly/lib.git#0c2d8f1e3a4b5c6d7e8f90a1b2c3d4e5f6a7b8c9")
+			docsFound = docsFound || strings.HasSuffix(id, "earthly/docs.git#5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f")
+		}
+		True(t, libFound, "lib submodule not cloned: %v", cloned)
+		True(t, docsFound, "docs submodule not cloned: %v", cloned)
+	}
+
+	// Not checked out unless enabled.
+	r = newTestResolver(t, ResolverOpt{GitCloneDepth: 1})
+	d, err = r.Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	def, err = d.BuildContextFactory.Construct().Marshal(context.Background())
+	NoError(t, err, "marshal build context")
+	for _, dt := range def.Def {
+		var op pb.Op
+		NoError(t, op.Unmarshal(dt), "unmarshal op")
+		if src := op.GetSource(); src != nil {
+			False(t, strings.HasPrefix(src.Identifier, "git://"), "unexpected git source %s", src.Identifier)
+		}
+	}
+}
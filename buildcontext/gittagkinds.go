@@ -0,0 +1,84 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+// TagChecksOpt determines the checks the tags remote references are resolved at are subject to.
+type TagChecksOpt struct {
+	// RequireAnnotated fails the resolution of remote references whose ref is a lightweight tag
+	// with ErrLightweightTag, so that only annotated (and possibly signed) tags can be built from.
+	// Refs which are not tags are not affected.
+	RequireAnnotated bool
+	// DetectMoved records the commits the tags of remote repositories are resolved to in the
+	// RecordCache (so across resolvers when it is persistent), and checks, whenever a tag is
+	// resolved afresh (e.g. after InvalidateRepo), that it still points at the recorded commit. A
+	// tag which has moved is reported as a warning, and its new commit recorded. When StrictMoved
+	// is set, it is reported as ErrTagMoved instead, and the recorded commit is kept, so that the
+	// tag keeps failing until it is resolved without StrictMoved. Resolutions using context
+	// credentials are neither recorded nor checked.
+	DetectMoved bool
+	StrictMoved bool
+}
+
+// gitTagKindsFile is the git meta file holding the tags pointing at the checked out commit, along
+// with their object types, one "<name> <type>" per line.
+const gitTagKindsFile = "git-tag-kinds"
+
+// ErrLightweightTag is returned, when annotated tags are required, when the ref of a remote
+// reference is a lightweight tag.
+type ErrLightweightTag struct {
+	// Ref is the project of the reference (e.g. github.com/earthly/earthly:v0.6.0).
+	Ref string
+	// Tag is the lightweight tag.
+	Tag string
+}
+
+// Error is function required by error interface.
+func (err ErrLightweightTag) Error() string {
+	return fmt.Sprintf("%s: tag %s is a lightweight tag, but only annotated tags are allowed", err.Ref, err.Tag)
+}
+
+// readTagKinds reads the object types of the tags pointing at the commit, out of the git meta run.
+func (gr *gitResolver) readTagKinds(ctx context.Context, gitMetaRef gwclient.Reference) (map[string]string, error) {
+	dt, err := gr.readGitMeta(ctx, gitMetaRef, gitTagKindsFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitTagKinds(string(dt)), nil
+}
+
+// parseGitTagKinds parses the output of `git for-each-ref --format='%(refname:strip=2) %(objecttype)'`
+// into the object types of the tags, keyed by their name.
+func parseGitTagKinds(out string) map[string]string {
+	kinds := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		name, kind, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || name == "" {
+			continue
+		}
+		kinds[name] = kind
+	}
+	return kinds
+}
+
+// checkTagKind returns ErrLightweightTag when annotated tags are required and gitRef is a
+// lightweight tag of the project. Refs which are not tags pass.
+func (gr *gitResolver) checkTagKind(rgp *resolvedGitProject, ref, gitRef string) error {
+	if !gr.tagChecks.RequireAnnotated {
+		return nil
+	}
+	tag := strings.TrimPrefix(gitRef, "refs/tags/")
+	kind, ok := rgp.tagKinds[tag]
+	if !ok || kind == "tag" {
+		return nil
+	}
+	return ErrLightweightTag{
+		Ref: ref,
+		Tag: tag,
+	}
+}
@@ -0,0 +1,83 @@
+package buildcontext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveRequireAnnotatedTags(t *testing.T) {
+	files := map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitTagKindsFile: "v1.0.0 tag\nv1.0.0-rc1 commit\n",
+	}
+	for _, skipMeta := range []bool{false, true} {
+		for target, lightweight := range map[string]bool{
+			"github.com/earthly/test/sub:v1.0.0+build":     false,
+			"github.com/earthly/test/sub:v1.0.0-rc1+build": true,
+			"github.com/earthly/test/sub:main+build":       false,
+		} {
+			ref, err := domain.ParseTarget(target)
+			NoError(t, err)
+			gwClient := newTestGwClient(files)
+			r := newTestResolver(t, ResolverOpt{TagChecks: TagChecksOpt{RequireAnnotated: true}, SkipGitMetadata: skipMeta})
+			_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+			if !lightweight {
+				NoError(t, err, "Resolve %s failed", target)
+				continue
+			}
+			var lightweightErr ErrLightweightTag
+			True(t, errors.As(err, &lightweightErr), "unexpected error %v", err)
+			Equal(t, ErrLightweightTag{Ref: "github.com/earthly/test/sub:v1.0.0-rc1", Tag: "v1.0.0-rc1"}, lightweightErr)
+
+			// The tag kinds are read by the git image clone.
+			runs := 0
+			for _, op := range gwClient.solvedOps(t) {
+				if exec := op.GetExec(); exec != nil {
+					runs++
+					Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "for-each-ref --points-at HEAD")
+				}
+			}
+			NotZero(t, runs)
+		}
+	}
+
+	// Lightweight tags are allowed by default.
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:v1.0.0-rc1+build")
+	NoError(t, err)
+	_, err = newTestResolver(t, ResolverOpt{}).Resolve(context.Background(), newTestGwClient(files), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+}
+
+func TestGitCloneScriptTagKinds(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available for tests, skipping")
+	}
+	repo := t.TempDir()
+	NoError(t, os.WriteFile(filepath.Join(repo, "Earthfile"), []byte("VERSION 0.6\n"), 0644))
+	cmd := exec.Command("/bin/sh", "-c", "git init --quiet --initial-branch=main && git add -A && "+
+		"git -c user.name=test -c user.email=test@example.com commit --quiet -m init && "+
+		"git -c user.name=test -c user.email=test@example.com tag -a -m release v1 && git tag v1-light")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	NoError(t, err, "init repo: %s", out)
+
+	dest := t.TempDir()
+	cmd = exec.Command("/bin/sh", "-c", gitCloneScript(false, true, false, false, true, false, false, false, false, false, 0, 0, 0, "", filepath.Join(t.TempDir(), "src"), dest, nil))
+	cmd.Env = append(os.Environ(),
+		"EARTHLY_GIT_URL="+repo,
+		"EARTHLY_GIT_REF=v1-light",
+		"EARTHLY_GIT_ORIGIN="+repo,
+	)
+	out, err = cmd.CombinedOutput()
+	NoError(t, err, "git clone script: %s", out)
+	dt, err := os.ReadFile(filepath.Join(dest, gitTagKindsFile))
+	NoError(t, err)
+	Equal(t, map[string]string{"v1": "tag", "v1-light": "commit"}, parseGitTagKinds(string(dt)))
+}
@@ -0,0 +1,82 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/pkg/errors"
+)
+
+// ErrTagMoved is returned, in strict mode, when a tag of a remote repository points at another
+// commit than the one it has previously been resolved to.
+type ErrTagMoved struct {
+	// Repo is the url of the repository, with credentials scrubbed.
+	Repo string
+	// Tag is the tag which has moved.
+	Tag string
+	// OldCommit is the commit the tag has previously been resolved to, and NewCommit the one it
+	// now points at.
+	OldCommit string
+	NewCommit string
+}
+
+// Error is function required by error interface.
+func (err ErrTagMoved) Error() string {
+	return fmt.Sprintf("the tag %s of %s has moved from commit %s to %s", err.Tag, err.Repo, err.OldCommit, err.NewCommit)
+}
+
+// tagCommitKey returns the key of the record cache under which the commit a tag of the repository
+// of keyURL has been resolved to is recorded. Such keys are not removed by InvalidateRepo.
+func (gr *gitResolver) tagCommitKey(keyURL, tag string) string {
+	// Like those of host keys, they are made of three components, the first of which tells them
+	// apart.
+	return gr.namespacedKey("tag#" + gitProjectKey(keyURL, tag))
+}
+
+// checkMovedTags records the commit the tags of a freshly resolved project point at in the record
+// cache, and compares it with the one previously recorded, if any. A tag which has moved is
+// reported as a warning, and its record updated to the new commit. In strict mode, it is reported
+// as ErrTagMoved instead, and its record is kept, so that the tag keeps failing to resolve (e.g.
+// after InvalidateRepo, or in other resolvers sharing the record cache) until it is resolved
+// without TagChecks.StrictMoved.
+func (gr *gitResolver) checkMovedTags(ctx context.Context, ref domain.Reference, keyURL string, rgp *resolvedGitProject) error {
+	if !gr.tagChecks.DetectMoved {
+		return nil
+	}
+	var movedErr error
+	for _, tag := range rgp.tags {
+		key := gr.tagCommitKey(keyURL, tag)
+		v, err := gr.recordCache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return rgp.hash, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "read the recorded commit of tag %s", tag)
+		}
+		oldCommit, _ := v.(string)
+		if oldCommit == "" || oldCommit == rgp.hash {
+			continue
+		}
+		tagErr := ErrTagMoved{
+			Repo:      stringutil.ScrubCredentials(rgp.gitURL),
+			Tag:       tag,
+			OldCommit: oldCommit,
+			NewCommit: rgp.hash,
+		}
+		if gr.tagChecks.StrictMoved {
+			if movedErr == nil {
+				movedErr = tagErr
+			}
+			continue
+		}
+		gr.recordCache.Delete(key)
+		err = gr.recordCache.Add(ctx, key, rgp.hash, nil)
+		if err != nil {
+			// Recorded concurrently.
+			gr.console.VerbosePrintf("failed to record the commit of tag %s: %s\n", tag, err.Error())
+		}
+		gr.warn(ref, WarningTagMoved, "%s; tags are expected never to move, so builds relying on it may not be reproducible", tagErr.Error())
+	}
+	return movedErr
+}
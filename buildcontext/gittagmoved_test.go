@@ -0,0 +1,103 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveMovedTag(t *testing.T) {
+	const (
+		oldHash = "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5"
+		newHash = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c"
+	)
+	ref, err := domain.ParseTarget("github.com/earthly/test:v1.0.0+build")
+	NoError(t, err)
+	newGwClient := func(hash string) *fakeGwClient {
+		return newTestGwClient(map[string]string{
+			"Earthfile":      "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+			"git-hash":       hash + "\n",
+			"git-short-hash": hash[:8] + "\n",
+		})
+	}
+	newResolver := func(recordCache Cache, strict bool) (*Resolver, *bytes.Buffer) {
+		cleanCollection := cleanup.NewCollection()
+		t.Cleanup(func() {
+			cleanCollection.Close()
+		})
+		var buf bytes.Buffer
+		console := conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf)
+		return NewResolver("", cleanCollection, NewGitLookup(console, ""), console, "", ResolverOpt{
+			RecordCache: recordCache,
+			TagChecks:   TagChecksOpt{DetectMoved: true, StrictMoved: strict},
+		}), &buf
+	}
+	const warning = "Warning: the tag v1.0.0 of https://github.com/earthly/test.git has moved from commit " + oldHash + " to " + newHash
+
+	r, buf := newResolver(newFakeCache(), false)
+	d, err := r.Resolve(context.Background(), newGwClient(oldHash), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, oldHash, d.GitMetadata.Hash)
+	Empty(t, buf.String())
+
+	// The tag is moved, and resolved afresh.
+	r.InvalidateRepo("https://github.com/earthly/test.git")
+	d, err = r.Resolve(context.Background(), newGwClient(newHash), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, newHash, d.GitMetadata.Hash)
+	Equal(t, 1, strings.Count(buf.String(), warning), buf.String())
+
+	// The new commit is recorded.
+	r.InvalidateRepo("https://github.com/earthly/test.git")
+	_, err = r.Resolve(context.Background(), newGwClient(newHash), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, 1, strings.Count(buf.String(), warning), buf.String())
+
+	// The commit of the tag has been recorded by an earlier resolver sharing the cache.
+	recordCache := newFakeCache()
+	NoError(t, recordCache.Add(context.Background(), "tag#https://github.com/earthly/test.git#v1.0.0", oldHash, nil))
+	r, _ = newResolver(recordCache, true)
+	gwClient := newGwClient(newHash)
+	requireMoved := func(r *Resolver, gwClient *fakeGwClient) {
+		t.Helper()
+		_, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		var movedErr ErrTagMoved
+		True(t, errors.As(err, &movedErr), "unexpected error %v", err)
+		Equal(t, ErrTagMoved{Repo: "https://github.com/earthly/test.git", Tag: "v1.0.0", OldCommit: oldHash, NewCommit: newHash}, movedErr)
+	}
+	for i := 0; i < 2; i++ {
+		requireMoved(r, gwClient)
+	}
+	// The failure is cached along with the project.
+	Equal(t, 1, gwClient.numMetaRuns(t))
+	// The recorded commit is kept: the tag keeps failing once resolved afresh, and in other
+	// resolvers sharing the cache.
+	r.InvalidateRepo("https://github.com/earthly/test.git")
+	gwClient = newGwClient(newHash)
+	requireMoved(r, gwClient)
+	Equal(t, 1, gwClient.numMetaRuns(t))
+	r, _ = newResolver(recordCache, true)
+	requireMoved(r, newGwClient(newHash))
+	// Until the tag is resolved without strict mode, which records the new commit.
+	r, buf = newResolver(recordCache, false)
+	_, err = r.Resolve(context.Background(), newGwClient(newHash), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, 1, strings.Count(buf.String(), warning), buf.String())
+	r, _ = newResolver(recordCache, true)
+	_, err = r.Resolve(context.Background(), newGwClient(newHash), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+
+	// Not checked unless enabled.
+	recordCache = newFakeCache()
+	NoError(t, recordCache.Add(context.Background(), "tag#https://github.com/earthly/test.git#v1.0.0", oldHash, nil))
+	r = newTestResolver(t, ResolverOpt{RecordCache: recordCache})
+	_, err = r.Resolve(context.Background(), newGwClient(newHash), newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+}
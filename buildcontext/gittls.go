@@ -0,0 +1,94 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+// GitTLSOpt holds the TLS material presented to git servers over https, as paths on the host.
+type GitTLSOpt struct {
+	// ClientCert and ClientKey are a client certificate and its key.
+	ClientCert string
+	ClientKey  string
+	// CACert is a CA bundle to verify git servers with.
+	CACert string
+}
+
+// gitTLSDir is where the client certificate, key and CA bundle are mounted in the git image.
+const gitTLSDir = "/run/secrets/earthly-git-tls"
+
+// gitTLSFile is a piece of TLS material presented to the git server.
+type gitTLSFile struct {
+	// name is the name of the file in gitTLSDir, also used to derive the secret id.
+	name string
+	// hostPath is the path of the file on the host.
+	hostPath string
+	// configKey is the git config key pointing to the file.
+	configKey string
+}
+
+func (gr *gitResolver) hasGitTLS() bool {
+	return gr.gitTLS.ClientCert != "" || gr.gitTLS.ClientKey != "" || gr.gitTLS.CACert != ""
+}
+
+func (gr *gitResolver) gitTLSFiles() []gitTLSFile {
+	var files []gitTLSFile
+	if gr.gitTLS.ClientCert != "" {
+		files = append(files, gitTLSFile{name: "client-cert", hostPath: gr.gitTLS.ClientCert, configKey: "http.sslCert"})
+	}
+	if gr.gitTLS.ClientKey != "" {
+		files = append(files, gitTLSFile{name: "client-key", hostPath: gr.gitTLS.ClientKey, configKey: "http.sslKey"})
+	}
+	if gr.gitTLS.CACert != "" {
+		files = append(files, gitTLSFile{name: "ca", hostPath: gr.gitTLS.CACert, configKey: "http.sslCAInfo"})
+	}
+	return files
+}
+
+// gitTLSRunOpts returns the run options mounting the TLS material into the git image, along with
+// the git config entries pointing to it. The material is passed along as internal secrets, so
+// that it never makes it into the LLB definition, and is mounted readable by root only.
+func (gr *gitResolver) gitTLSRunOpts(ctx context.Context) ([]llb.RunOption, []string, error) {
+	if !gr.hasGitTLS() {
+		return nil, nil, nil
+	}
+	if (gr.gitTLS.ClientCert == "") != (gr.gitTLS.ClientKey == "") {
+		return nil, nil, errors.New("a git client certificate requires both a certificate and a key")
+	}
+	if gr.internalSecretStore == nil {
+		return nil, nil, errors.New("git client certificates require an internal secret store")
+	}
+	var runOpts []llb.RunOption
+	var gitConfig []string
+	for _, f := range gr.gitTLSFiles() {
+		secretID := fmt.Sprintf("earthly-git-tls-%s", f.name)
+		_, err := gr.gitTLSCache.Do(ctx, secretID, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			dt, err := os.ReadFile(f.hostPath)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read git %s", f.name)
+			}
+			err = gr.internalSecretStore.SetSecret(ctx, secretID, dt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "set git %s secret", f.name)
+			}
+			gr.cleanCollection.Add(func() error {
+				return gr.internalSecretStore.DeleteSecret(context.TODO(), secretID)
+			})
+			return nil, nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		mountPath := path.Join(gitTLSDir, f.name)
+		runOpts = append(runOpts, llb.AddSecret(mountPath,
+			llb.SecretID(secretID),
+			llb.SecretFileOpt(0, 0, 0400)))
+		gitConfig = append(gitConfig, fmt.Sprintf("%s=%s", f.configKey, mountPath))
+	}
+	return runOpts, gitConfig, nil
+}
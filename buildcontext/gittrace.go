@@ -0,0 +1,67 @@
+package buildcontext
+
+import (
+	"context"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// Tracer starts the spans covering the resolution of remote references. It is the small subset of
+// an OpenTelemetry tracer that is needed, so that one can be adapted without buildcontext depending
+// on OpenTelemetry.
+type Tracer interface {
+	// Start starts a span, as a child of the span carried by ctx if any, and returns a context
+	// carrying the new span.
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span.
+	SetAttribute(key, value string)
+	// End completes the span.
+	End()
+}
+
+// The names of the spans.
+const (
+	spanResolveEarthProject = "buildcontext.resolveEarthProject"
+	spanResolveGitProject   = "buildcontext.resolveGitProject"
+	spanGitClone            = "buildcontext.gitClone"
+	spanReadGitMeta         = "buildcontext.readGitMeta"
+)
+
+// The attributes of the spans. Urls are always scrubbed of their credentials.
+const (
+	spanAttrURL   = "git.url"
+	spanAttrRef   = "git.ref"
+	spanAttrHash  = "git.hash"
+	spanAttrCache = "git.cache"
+	spanAttrFile  = "git.meta.file"
+)
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) End()                           {}
+
+// readGitMeta reads one of the files written by the git meta run, within its own span.
+func (gr *gitResolver) readGitMeta(ctx context.Context, gitMetaRef gwclient.Reference, filename string) ([]byte, error) {
+	ctx, span := gr.tracer.Start(ctx, spanReadGitMeta)
+	defer span.End()
+	span.SetAttribute(spanAttrFile, filename)
+	dt, err := gitMetaRef.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: filename,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", filename)
+	}
+	return dt, nil
+}
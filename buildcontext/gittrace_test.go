@@ -0,0 +1,125 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+type fakeSpanKey struct{}
+
+// fakeSpan is a span recorded by fakeTracer.
+type fakeSpan struct {
+	tracer *fakeTracer
+
+	name   string
+	parent *fakeSpan
+	attrs  map[string]string
+	ended  bool
+}
+
+func (s *fakeSpan) SetAttribute(key, value string) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *fakeSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+// fakeTracer records all the spans started, with their parents taken out of the context.
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(fakeSpanKey{}).(*fakeSpan)
+	span := &fakeSpan{
+		tracer: t,
+		name:   spanName,
+		parent: parent,
+		attrs:  make(map[string]string),
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, fakeSpanKey{}, span), span
+}
+
+func (t *fakeTracer) spansNamed(name string) []*fakeSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var spans []*fakeSpan
+	for _, span := range t.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestResolveTracing(t *testing.T) {
+	tracer := &fakeTracer{}
+	r := newTestResolver(t, ResolverOpt{Tracer: tracer})
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	// The spans are children of the incoming one.
+	ctx, root := tracer.Start(context.Background(), "build")
+	for i := 0; i < 2; i++ {
+		_, err = r.Resolve(ctx, gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+	root.End()
+
+	const hash = "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5"
+	earthSpans := tracer.spansNamed(spanResolveEarthProject)
+	projectSpans := tracer.spansNamed(spanResolveGitProject)
+	cloneSpans := tracer.spansNamed(spanGitClone)
+	readSpans := tracer.spansNamed(spanReadGitMeta)
+	Len(t, earthSpans, 2)
+	Len(t, projectSpans, 2)
+	// The second resolution is served from the cache.
+	Len(t, cloneSpans, 1)
+	Len(t, readSpans, len(testGitMetaFiles))
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	for i, cache := range []string{"miss", "hit"} {
+		Same(t, root, earthSpans[i].parent)
+		Equal(t, map[string]string{
+			spanAttrURL:  "https://github.com/earthly/test.git",
+			spanAttrRef:  "main",
+			spanAttrHash: hash,
+		}, earthSpans[i].attrs)
+		Same(t, earthSpans[i], projectSpans[i].parent)
+		Equal(t, map[string]string{
+			spanAttrURL:   "https://github.com/earthly/test.git",
+			spanAttrRef:   "main",
+			spanAttrHash:  hash,
+			spanAttrCache: cache,
+		}, projectSpans[i].attrs)
+	}
+	Same(t, projectSpans[0], cloneSpans[0].parent)
+	Equal(t, "https://github.com/earthly/test.git", cloneSpans[0].attrs[spanAttrURL])
+	var files []string
+	for _, span := range readSpans {
+		Same(t, projectSpans[0], span.parent)
+		files = append(files, span.attrs[spanAttrFile])
+	}
+	Contains(t, files, "git-hash")
+	Contains(t, files, "git-trees")
+	for _, span := range tracer.spans {
+		True(t, span.ended, "span %s not ended", span.name)
+	}
+}
@@ -0,0 +1,74 @@
+package buildcontext
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GitTransferOpt tunes the git transfer parameters of the clones, e.g. so that slow but alive links
+// are not given up on by git. Unset parameters are left to the defaults of git.
+type GitTransferOpt struct {
+	// PackWindowMemory is the pack.windowMemory of git, the memory limit (e.g. "256m") of the delta
+	// search window used to pack objects.
+	PackWindowMemory string
+	// HTTPLowSpeedLimit and HTTPLowSpeedTime are the http.lowSpeedLimit and http.lowSpeedTime of git:
+	// transfers slower than HTTPLowSpeedLimit bytes per second for longer than HTTPLowSpeedTime are
+	// aborted. HTTPLowSpeedTime is rounded up to the second.
+	HTTPLowSpeedLimit int
+	HTTPLowSpeedTime  time.Duration
+	// HTTPVersion is the http.version of git, the HTTP version ("HTTP/2" or "HTTP/1.1") used by the
+	// clones over HTTPS. With HTTP/2, the requests of a clone share a single connection to the host,
+	// sparing TLS handshakes. Connections are not reused across clones though, as each runs its own
+	// git process.
+	HTTPVersion string
+	// HTTPMaxRequests is the http.maxRequests of git, the number of HTTP requests a clone may have
+	// in flight at once.
+	HTTPMaxRequests int
+}
+
+func (opt GitTransferOpt) isSet() bool {
+	return opt != GitTransferOpt{}
+}
+
+var gitConfigSizeRegexp = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// gitConfig returns the git config (key=value) entries of the set parameters.
+func (opt GitTransferOpt) gitConfig() ([]string, error) {
+	var gitConfig []string
+	if opt.PackWindowMemory != "" {
+		if !gitConfigSizeRegexp.MatchString(opt.PackWindowMemory) {
+			return nil, errors.Errorf("invalid git pack window memory %q", opt.PackWindowMemory)
+		}
+		gitConfig = append(gitConfig, fmt.Sprintf("pack.windowMemory=%s", opt.PackWindowMemory))
+	}
+	if opt.HTTPLowSpeedLimit < 0 {
+		return nil, errors.Errorf("invalid git http low speed limit %d", opt.HTTPLowSpeedLimit)
+	}
+	if opt.HTTPLowSpeedLimit > 0 {
+		gitConfig = append(gitConfig, fmt.Sprintf("http.lowSpeedLimit=%d", opt.HTTPLowSpeedLimit))
+	}
+	if opt.HTTPLowSpeedTime < 0 {
+		return nil, errors.Errorf("invalid git http low speed time %s", opt.HTTPLowSpeedTime)
+	}
+	if opt.HTTPLowSpeedTime > 0 {
+		seconds := (opt.HTTPLowSpeedTime + time.Second - 1) / time.Second
+		gitConfig = append(gitConfig, fmt.Sprintf("http.lowSpeedTime=%d", seconds))
+	}
+	switch opt.HTTPVersion {
+	case "":
+	case "HTTP/2", "HTTP/1.1":
+		gitConfig = append(gitConfig, fmt.Sprintf("http.version=%s", opt.HTTPVersion))
+	default:
+		return nil, errors.Errorf("invalid git http version %q", opt.HTTPVersion)
+	}
+	if opt.HTTPMaxRequests < 0 {
+		return nil, errors.Errorf("invalid git http max requests %d", opt.HTTPMaxRequests)
+	}
+	if opt.HTTPMaxRequests > 0 {
+		gitConfig = append(gitConfig, fmt.Sprintf("http.maxRequests=%d", opt.HTTPMaxRequests))
+	}
+	return gitConfig, nil
+}
@@ -0,0 +1,67 @@
+package buildcontext
+
+import (
+	"os"
+	"strconv"
+)
+
+// GitCloneVerbosity determines how verbose the clones and fetches of the runs of git in the git
+// image are, trading the noise of the logs of CI systems for the feedback of interactive use.
+type GitCloneVerbosity int
+
+const (
+	// AutoGitCloneVerbosity is QuietGitClone when running in CI (as detected from the environment),
+	// and NormalGitClone otherwise. This is the default.
+	AutoGitCloneVerbosity GitCloneVerbosity = iota
+	// QuietGitClone runs git with --quiet, leaving errors only.
+	QuietGitClone
+	// NormalGitClone runs git with its default verbosity.
+	NormalGitClone
+	// VerboseGitClone runs git with --verbose, and reports its progress.
+	VerboseGitClone
+)
+
+// gitVerbosityEnv is the env var passed to the runs of git in the git image, holding the flags of
+// the verbosity of their clones and fetches.
+const gitVerbosityEnv = "EARTHLY_GIT_VERBOSITY"
+
+// ciEnvVars are the env vars set by CI systems.
+var ciEnvVars = []string{
+	"GITHUB_WORKFLOW",
+	"CIRCLECI",
+	"JENKINS_HOME",
+	"BUILDKITE",
+	"DRONE_BRANCH",
+	"TRAVIS",
+	"GITLAB_CI",
+}
+
+// detectCI returns whether running in CI, as per the (non-empty) env vars of CI systems, or CI
+// being true.
+func detectCI() bool {
+	for _, k := range ciEnvVars {
+		if os.Getenv(k) != "" {
+			return true
+		}
+	}
+	isCI, err := strconv.ParseBool(os.Getenv("CI"))
+	return err == nil && isCI
+}
+
+// flags returns the flags of git clone and git fetch for the verbosity.
+func (v GitCloneVerbosity) flags() string {
+	if v == AutoGitCloneVerbosity {
+		v = NormalGitClone
+		if detectCI() {
+			v = QuietGitClone
+		}
+	}
+	switch v {
+	case QuietGitClone:
+		return "--quiet"
+	case VerboseGitClone:
+		return "--verbose --progress"
+	default:
+		return ""
+	}
+}
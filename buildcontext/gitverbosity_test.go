@@ -0,0 +1,75 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+// setCI sets the env vars of CI systems for the duration of the test, clearing all but the given
+// one, if any.
+func setCI(t *testing.T, k, v string) {
+	for _, ciVar := range append([]string{"CI"}, ciEnvVars...) {
+		t.Setenv(ciVar, "")
+	}
+	if k != "" {
+		t.Setenv(k, v)
+	}
+}
+
+func TestGitCloneVerbosityFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		verbosity GitCloneVerbosity
+		ciVar     string
+		ciValue   string
+		expected  string
+	}{
+		{"auto", AutoGitCloneVerbosity, "", "", ""},
+		{"auto in CI", AutoGitCloneVerbosity, "CI", "true", "--quiet"},
+		{"auto in github", AutoGitCloneVerbosity, "GITHUB_WORKFLOW", "build", "--quiet"},
+		{"auto with CI false", AutoGitCloneVerbosity, "CI", "false", ""},
+		{"quiet", QuietGitClone, "", "", "--quiet"},
+		{"normal in CI", NormalGitClone, "CI", "true", ""},
+		{"verbose in CI", VerboseGitClone, "CI", "true", "--verbose --progress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCI(t, tt.ciVar, tt.ciValue)
+			Equal(t, tt.expected, tt.verbosity.flags())
+		})
+	}
+}
+
+func TestResolveGitCloneVerbosity(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test:main+build")
+	NoError(t, err)
+	files := map[string]string{
+		"Earthfile":             "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		gitAdvertiseTimeoutFile: "",
+	}
+	resolveEnv := func(verbosity GitCloneVerbosity) []string {
+		r := newTestResolver(t, ResolverOpt{GitCloneVerbosity: verbosity, RefAdvertisementTimeout: time.Minute})
+		gwClient := newTestGwClient(files)
+		_, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+		for _, op := range gwClient.solvedOps(t) {
+			if exec := op.GetExec(); exec != nil {
+				Contains(t, exec.Meta.Args[len(exec.Meta.Args)-1], "$EARTHLY_GIT_VERBOSITY")
+				return exec.Meta.Env
+			}
+		}
+		t.Fatal("no git run")
+		return nil
+	}
+
+	setCI(t, "CI", "true")
+	Contains(t, resolveEnv(AutoGitCloneVerbosity), gitVerbosityEnv+"=--quiet")
+	Contains(t, resolveEnv(VerboseGitClone), gitVerbosityEnv+"=--verbose --progress")
+	setCI(t, "", "")
+	Contains(t, resolveEnv(AutoGitCloneVerbosity), gitVerbosityEnv+"=")
+	NotContains(t, resolveEnv(AutoGitCloneVerbosity), gitVerbosityEnv+"=--quiet")
+}
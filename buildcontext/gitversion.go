@@ -0,0 +1,62 @@
+package buildcontext
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gitVersion is the version of the git binary of the git image.
+type gitVersion struct {
+	major, minor, patch int
+}
+
+// gitShowCurrentVersion is the first git version supporting `git branch --show-current`.
+var gitShowCurrentVersion = gitVersion{2, 22, 0}
+
+var gitVersionRegexp = regexp.MustCompile(`^git version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseGitVersion parses the output of `git version`, e.g. "git version 2.30.1".
+func parseGitVersion(out string) (gitVersion, bool) {
+	m := gitVersionRegexp.FindStringSubmatch(strings.TrimSpace(out))
+	if m == nil {
+		return gitVersion{}, false
+	}
+	var v gitVersion
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+	}
+	return v, true
+}
+
+func (v gitVersion) atLeast(other gitVersion) bool {
+	if v.major != other.major {
+		return v.major > other.major
+	}
+	if v.minor != other.minor {
+		return v.minor > other.minor
+	}
+	return v.patch >= other.patch
+}
+
+func (v gitVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+// detectGitBranch picks the branch output of the git meta run which is reliable for the git version
+// of the image. Gits supporting `git branch --show-current` use it, unless HEAD is detached (in which
+// case it outputs nothing). Older or unrecognized gits fall back to `git rev-parse --abbrev-ref HEAD`.
+func (gr *gitResolver) detectGitBranch(versionOut, abbrevRefOut, showCurrentOut string) string {
+	v, ok := parseGitVersion(versionOut)
+	if !ok {
+		gr.console.Warnf("unable to determine the git version of image %s (%q); falling back to git rev-parse for branch detection", gr.gitImage.Image, strings.TrimSpace(versionOut))
+		return abbrevRefOut
+	}
+	if v.atLeast(gitShowCurrentVersion) && strings.TrimSpace(showCurrentOut) != "" {
+		return showCurrentOut
+	}
+	return abbrevRefOut
+}
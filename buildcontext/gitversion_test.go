@@ -0,0 +1,50 @@
+package buildcontext
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/earthly/earthly/conslogging"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	v, ok := parseGitVersion("git version 2.30.1\n")
+	True(t, ok)
+	Equal(t, gitVersion{2, 30, 1}, v)
+	v, ok = parseGitVersion("git version 2.39.2.windows.1")
+	True(t, ok)
+	Equal(t, gitVersion{2, 39, 2}, v)
+	v, ok = parseGitVersion("git version 3.0")
+	True(t, ok)
+	Equal(t, gitVersion{3, 0, 0}, v)
+	_, ok = parseGitVersion("")
+	False(t, ok)
+	_, ok = parseGitVersion("sh: git: not found")
+	False(t, ok)
+
+	True(t, gitVersion{2, 22, 0}.atLeast(gitShowCurrentVersion))
+	True(t, gitVersion{3, 0, 0}.atLeast(gitShowCurrentVersion))
+	False(t, gitVersion{2, 21, 9}.atLeast(gitShowCurrentVersion))
+	False(t, gitVersion{1, 99, 0}.atLeast(gitShowCurrentVersion))
+}
+
+func TestDetectGitBranch(t *testing.T) {
+	var buf bytes.Buffer
+	gr := &gitResolver{
+		console:  conslogging.Current(conslogging.NoColor, 0, conslogging.Info).WithWriter(&buf),
+		gitImage: GitImageOpt{Image: "alpine/git:custom"},
+	}
+
+	// Old git: show-current is not supported and outputs nothing.
+	Equal(t, "main\n", gr.detectGitBranch("git version 2.17.1\n", "main\n", ""))
+	// New git: show-current is preferred.
+	Equal(t, "feature/x\n", gr.detectGitBranch("git version 2.40.0\n", "heads/feature/x\n", "feature/x\n"))
+	// New git, detached HEAD: show-current outputs nothing.
+	Equal(t, "HEAD\n", gr.detectGitBranch("git version 2.40.0\n", "HEAD\n", ""))
+	Empty(t, buf.String())
+
+	// Unknown version: fall back to rev-parse, with a warning.
+	Equal(t, "main\n", gr.detectGitBranch("", "main\n", "main\n"))
+	Contains(t, buf.String(), "unable to determine the git version of image alpine/git:custom")
+}
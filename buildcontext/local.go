@@ -23,6 +23,8 @@ type localResolver struct {
 	sessionID      string
 	buildFileCache *synccache.SyncCache
 	console        conslogging.ConsoleLogger
+	buildFileNames []string
+	onWarning      func(Warning)
 }
 
 func (lr *localResolver) resolveLocal(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*Data, error) {
@@ -42,7 +44,7 @@ func (lr *localResolver) resolveLocal(ctx context.Context, gwClient gwclient.Cli
 				// Keep going anyway. Either not a git dir, or git not installed, or
 				// remote not detected.
 				if errors.Is(err, gitutil.ErrNoGitBinary) {
-					lr.console.Warnf("Warning: %s\n", err.Error())
+					lr.warn(ref, WarningNoGitBinary, "%s", err.Error())
 				}
 			} else {
 				return nil, err
@@ -55,6 +57,41 @@ func (lr *localResolver) resolveLocal(ctx context.Context, gwClient gwclient.Cli
 	}
 	metadata := metadataValue.(*gitutil.GitMetadata)
 
+	bf, err := lr.resolveBuildFile(ctx, ref, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	var buildContextFactory llbfactory.Factory
+	var excludes []ExcludePattern
+	if _, isTarget := ref.(domain.Target); isTarget {
+		noImplicitIgnore := bf.ftrs != nil && bf.ftrs.NoImplicitIgnore
+		excludes, err = readExcludePatterns(ref.GetLocalPath(), noImplicitIgnore)
+		if err != nil {
+			return nil, err
+		}
+		buildContextFactory = llbfactory.Local(
+			ref.GetLocalPath(),
+			llb.ExcludePatterns(patternsOf(excludes)),
+			llb.SessionID(lr.sessionID),
+			llb.Platform(platr.LLBNative()),
+			llb.WithCustomNamef("[context %s] local context %s", ref.GetLocalPath(), ref.GetLocalPath()),
+		)
+	}
+	// Else not needed: Commands don't come with a build context.
+
+	return &Data{
+		BuildFilePath:       bf.path,
+		BuildContextFactory: buildContextFactory,
+		GitMetadata:         metadata,
+		Features:            bf.ftrs,
+		ExcludePatterns:     excludes,
+	}, nil
+}
+
+// resolveBuildFile detects the build file of the given ref and parses its features. The result
+// is cached per local path.
+func (lr *localResolver) resolveBuildFile(ctx context.Context, ref domain.Reference, featureFlagOverrides string) (*buildFile, error) {
 	localPath := filepath.FromSlash(ref.GetLocalPath())
 	key := localPath
 	isDockerfile := strings.HasPrefix(ref.GetName(), DockerfileMetaTarget)
@@ -63,15 +100,21 @@ func (lr *localResolver) resolveLocal(ctx context.Context, gwClient gwclient.Cli
 		key = ref.String()
 	}
 	buildFileValue, err := lr.buildFileCache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
-		buildFilePath, err := detectBuildFile(ref, localPath)
+		buildFilePath, found, err := detectBuildFile(ref, localPath, lr.buildFileNames)
 		if err != nil {
 			return nil, err
 		}
+		if len(found) > 1 {
+			lr.warn(ref, WarningMultipleBuildFiles, "%s", multipleBuildFilesWarning(ref.GetLocalPath(), found))
+		}
+		if fsys, root, rel, err := rootFS(buildFilePath); err == nil {
+			lr.checkBuildFileCasing(ref, fsys, root, rel)
+		}
 		var ftrs *features.Features
 		if isDockerfile {
 			ftrs = new(features.Features)
 		} else {
-			ftrs, err = parseFeatures(buildFilePath, featureFlagOverrides, ref.GetLocalPath(), lr.console)
+			ftrs, err = parseFeatures(osBuildFileFS{}, buildFilePath, featureFlagOverrides, ref.GetLocalPath(), lr.console)
 			if err != nil {
 				return nil, err
 			}
@@ -84,29 +127,5 @@ func (lr *localResolver) resolveLocal(ctx context.Context, gwClient gwclient.Cli
 	if err != nil {
 		return nil, err
 	}
-	bf := buildFileValue.(*buildFile)
-
-	var buildContextFactory llbfactory.Factory
-	if _, isTarget := ref.(domain.Target); isTarget {
-		noImplicitIgnore := bf.ftrs != nil && bf.ftrs.NoImplicitIgnore
-		excludes, err := readExcludes(ref.GetLocalPath(), noImplicitIgnore)
-		if err != nil {
-			return nil, err
-		}
-		buildContextFactory = llbfactory.Local(
-			ref.GetLocalPath(),
-			llb.ExcludePatterns(excludes),
-			llb.SessionID(lr.sessionID),
-			llb.Platform(platr.LLBNative()),
-			llb.WithCustomNamef("[context %s] local context %s", ref.GetLocalPath(), ref.GetLocalPath()),
-		)
-	}
-	// Else not needed: Commands don't come with a build context.
-
-	return &Data{
-		BuildFilePath:       bf.path,
-		BuildContextFactory: buildContextFactory,
-		GitMetadata:         metadata,
-		Features:            bf.ftrs,
-	}, nil
+	return buildFileValue.(*buildFile), nil
 }
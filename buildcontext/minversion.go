@@ -0,0 +1,63 @@
+package buildcontext
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/earthly/earthly/features"
+	"github.com/pkg/errors"
+)
+
+// ErrVersionTooOld is returned when the Earthfile of a remote reference declares an older VERSION
+// than the minimum required.
+type ErrVersionTooOld struct {
+	// Ref is the canonical form of the project reference (repo, subdirectory and ref).
+	Ref string
+	// Version is the VERSION declared by the Earthfile (0.5 when it declares none).
+	Version string
+	// MinVersion is the minimum VERSION required.
+	MinVersion string
+}
+
+// Error is function required by error interface.
+func (err ErrVersionTooOld) Error() string {
+	return fmt.Sprintf("the Earthfile of %s declares VERSION %s, but at least %s is required", err.Ref, err.Version, err.MinVersion)
+}
+
+// parseEarthfileVersion parses a <major>.<minor> Earthfile version.
+func parseEarthfileVersion(version string) (major, minor int, err error) {
+	majorStr, minorStr, ok := strings.Cut(version, ".")
+	if !ok {
+		return 0, 0, errors.Errorf("invalid Earthfile version %q", version)
+	}
+	major, err = strconv.Atoi(majorStr)
+	if err != nil || major < 0 {
+		return 0, 0, errors.Errorf("invalid Earthfile version %q", version)
+	}
+	minor, err = strconv.Atoi(minorStr)
+	if err != nil || minor < 0 {
+		return 0, 0, errors.Errorf("invalid Earthfile version %q", version)
+	}
+	return major, minor, nil
+}
+
+// checkMinEarthfileVersion returns ErrVersionTooOld if the features of the Earthfile of the given
+// project are those of an older VERSION than the minimum required, if any.
+func (gr *gitResolver) checkMinEarthfileVersion(projectRef string, ftrs *features.Features) error {
+	if gr.minEarthfileVersion == "" {
+		return nil
+	}
+	major, minor, err := parseEarthfileVersion(gr.minEarthfileVersion)
+	if err != nil {
+		return errors.Wrap(err, "minimum Earthfile version")
+	}
+	if ftrs.Major > major || (ftrs.Major == major && ftrs.Minor >= minor) {
+		return nil
+	}
+	return ErrVersionTooOld{
+		Ref:        projectRef,
+		Version:    ftrs.Version(),
+		MinVersion: fmt.Sprintf("%d.%d", major, minor),
+	}
+}
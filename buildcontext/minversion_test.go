@@ -0,0 +1,48 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveMinEarthfileVersion(t *testing.T) {
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+	resolve := func(earthfile, minVersion string) error {
+		r := newTestResolver(t, ResolverOpt{MinEarthfileVersion: minVersion})
+		_, err := r.Resolve(context.Background(), newTestGwClient(map[string]string{
+			"sub/Earthfile": earthfile,
+		}), newTestPlatformResolver(), ref)
+		return err
+	}
+
+	for _, tc := range []struct {
+		earthfile  string
+		minVersion string
+		version    string
+	}{
+		{"VERSION 0.6\n\nbuild:\n\tFROM alpine\n", "0.7", "0.6"},
+		{"VERSION --use-cache-command 0.6\n\nbuild:\n\tFROM alpine\n", "1.0", "0.6"},
+		{"VERSION 0.5\n\nbuild:\n\tFROM alpine\n", "0.6", "0.5"},
+		// No VERSION implies 0.5.
+		{"build:\n\tFROM alpine\n", "0.6", "0.5"},
+	} {
+		err := resolve(tc.earthfile, tc.minVersion)
+		var versionErr ErrVersionTooOld
+		True(t, errors.As(err, &versionErr), "unexpected error %v", err)
+		Equal(t, ErrVersionTooOld{Ref: "github.com/earthly/test/sub:main", Version: tc.version, MinVersion: tc.minVersion}, versionErr)
+	}
+
+	for _, minVersion := range []string{"", "0.6", "0.5", "0.06"} {
+		NoError(t, resolve("VERSION 0.6\n\nbuild:\n\tFROM alpine\n", minVersion), "min version %s", minVersion)
+	}
+	NoError(t, resolve("VERSION 0.5\n\nbuild:\n\tFROM alpine\n", "0.5"))
+
+	for _, invalid := range []string{"0", "v0.6", "0.x", "-1.0"} {
+		Error(t, resolve("VERSION 0.6\n\nbuild:\n\tFROM alpine\n", invalid), "min version %s", invalid)
+	}
+}
@@ -0,0 +1,51 @@
+package buildcontext
+
+import (
+	"context"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+// ociResolver resolves the references to OCI images (e.g.
+// oci://registry.example.com/platform/earthfiles:v1+target), whose filesystem holds the build file
+// and makes up the build context, rather than a git repository. This allows build definitions to be
+// published as immutable images, which are pulled with the registry credentials of the session.
+type ociResolver struct {
+	*stateSource
+}
+
+// resolveImage resolves an OCI image reference. The image comes with no git metadata.
+func (ocr *ociResolver) resolveImage(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, contextPlatform platutil.Platform, featureFlagOverrides string) (*Data, error) {
+	ri, err := domain.ParseRemoteImage(ref.GetGitURL())
+	if err != nil {
+		return nil, err
+	}
+	return ocr.resolve(ctx, gwClient, platr, ref, ocr.imageState(ri, platr), ri.SubDir, "image "+ri.Image, contextPlatform, featureFlagOverrides)
+}
+
+// resolveFeatures returns the features of the build file of an OCI image reference.
+func (ocr *ociResolver) resolveFeatures(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*features.Features, error) {
+	ri, err := domain.ParseRemoteImage(ref.GetGitURL())
+	if err != nil {
+		return nil, err
+	}
+	bf, err := ocr.resolveBuildFile(ctx, gwClient, platr, ref, ocr.imageState(ri, platr), ri.SubDir, "image "+ri.Image, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	return bf.ftrs, nil
+}
+
+// imageState returns the state of the filesystem of the image, for the native platform: build
+// definitions are expected to be platform independent.
+func (ocr *ociResolver) imageState(ri domain.RemoteImage, platr *platutil.Resolver) pllb.State {
+	return pllb.Image(ri.Image,
+		llb.Platform(platr.LLBNative()),
+		llb.WithCustomNamef("[context %s] image", ri.Image))
+}
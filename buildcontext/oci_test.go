@@ -0,0 +1,52 @@
+package buildcontext
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveImage(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"go/Earthfile":      "VERSION --use-cache-command 0.6\n\nlint:\n\tFROM golang\n",
+		"go/.earthlyignore": "vendor\n",
+	})
+	r := newTestResolver(t, ResolverOpt{})
+	ref, err := domain.ParseTarget("oci://registry.example.com/platform/earthfiles:v1//go+lint")
+	NoError(t, err)
+
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	Equal(t, "go/Earthfile", d.BuildFileLocation)
+	True(t, d.Features.UseCacheCommand)
+	Nil(t, d.GitMetadata)
+	var patterns []string
+	for _, p := range d.ExcludePatterns {
+		patterns = append(patterns, p.Pattern)
+	}
+	Contains(t, patterns, "vendor")
+
+	// The build file is read out of the image; no git is involved.
+	var pulled bool
+	for _, op := range gwClient.solvedOps(t) {
+		if src := op.GetSource(); src != nil {
+			False(t, strings.HasPrefix(src.Identifier, "git://"), "unexpected git source %s", src.Identifier)
+			pulled = pulled || src.Identifier == "docker-image://registry.example.com/platform/earthfiles:v1"
+		}
+		Nil(t, op.GetExec(), "unexpected exec op")
+	}
+	True(t, pulled, "image not pulled")
+
+	_, err = d.BuildContextFactory.Construct().Marshal(context.Background())
+	NoError(t, err, "marshal build context")
+
+	// The build file is cached per project.
+	ftrs, err := r.ResolveFeatures(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "ResolveFeatures failed")
+	True(t, ftrs.UseCacheCommand)
+	Len(t, gwClient.solves, 1)
+}
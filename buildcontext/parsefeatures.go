@@ -1,18 +1,34 @@
 package buildcontext
 
 import (
+	"bytes"
+	"time"
+
 	"github.com/earthly/earthly/ast"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/features"
+	"github.com/pkg/errors"
 )
 
 type buildFile struct {
 	path string
-	ftrs *features.Features
+	// location is the path of the build file of a remote reference within its repository.
+	location string
+	ftrs     *features.Features
+	// excludes are the exclude patterns of the build context of a remote reference, out of its
+	// ignore file, with RemoteIgnoreFiles or for remote archives.
+	excludes []ExcludePattern
+	// cachedAt is when the build file of a remote reference was read, for its cache entry to
+	// expire.
+	cachedAt time.Time
 }
 
-func parseFeatures(buildFilePath string, featureFlagOverrides string, projectRef string, console conslogging.ConsoleLogger) (*features.Features, error) {
-	version, err := ast.ParseVersion(buildFilePath, false)
+func parseFeatures(fsys BuildFileFS, buildFilePath string, featureFlagOverrides string, projectRef string, console conslogging.ConsoleLogger) (*features.Features, error) {
+	content, err := fsys.ReadFile(buildFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open %q", buildFilePath)
+	}
+	version, err := ast.ParseVersionReader(bytes.NewReader(content), buildFilePath, false)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,66 @@
+package buildcontext
+
+import (
+	"sync"
+
+	"github.com/earthly/earthly/util/gitutil"
+)
+
+// repoKeys tracks the keys of the project and build file cache entries of each repository, so that
+// they can be invalidated together. Repositories are identified by their url without protocol,
+// credentials nor .git suffix (e.g. github.com/earthly/earthly), so that all the urls of a
+// repository share their entries.
+type repoKeys struct {
+	mu         sync.Mutex
+	projects   map[string]map[string]struct{} // repo -> project cache keys
+	buildFiles map[string]map[string]struct{} // repo -> build file cache keys
+}
+
+func newRepoKeys() *repoKeys {
+	return &repoKeys{
+		projects:   make(map[string]map[string]struct{}),
+		buildFiles: make(map[string]map[string]struct{}),
+	}
+}
+
+func repoID(gitURL string) string {
+	id, _ := gitutil.ParseGitRemoteURL(gitURL)
+	return id
+}
+
+func (rk *repoKeys) addProject(gitURL, key string) {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	addRepoKey(rk.projects, repoID(gitURL), key)
+}
+
+func (rk *repoKeys) addBuildFile(gitURL, key string) {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	addRepoKey(rk.buildFiles, repoID(gitURL), key)
+}
+
+// remove stops tracking the keys of the repository of gitURL, and returns them.
+func (rk *repoKeys) remove(gitURL string) (projectKeys []string, buildFileKeys []string) {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	id := repoID(gitURL)
+	for key := range rk.projects[id] {
+		projectKeys = append(projectKeys, key)
+	}
+	for key := range rk.buildFiles[id] {
+		buildFileKeys = append(buildFileKeys, key)
+	}
+	delete(rk.projects, id)
+	delete(rk.buildFiles, id)
+	return projectKeys, buildFileKeys
+}
+
+func addRepoKey(m map[string]map[string]struct{}, id, key string) {
+	keys, ok := m[id]
+	if !ok {
+		keys = make(map[string]struct{})
+		m[id] = keys
+	}
+	keys[key] = struct{}{}
+}
@@ -0,0 +1,86 @@
+package buildcontext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestInvalidateRepo(t *testing.T) {
+	gwClient := newTestGwClient(map[string]string{
+		"Earthfile":     "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"sub/Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+	})
+	r := newTestResolver(t, ResolverOpt{})
+	nr := r.WithCacheNamespace("tenant")
+	resolve := func(r *Resolver, target string) {
+		ref, err := domain.ParseTarget(target)
+		NoError(t, err)
+		_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+	// cached returns whether the cache has an entry for the key, leaving the cache as it was.
+	cached := func(cache Cache, key string) bool {
+		hit := true
+		_, err := cache.Do(context.Background(), key, func(ctx context.Context, k interface{}) (interface{}, error) {
+			hit = false
+			return nil, nil
+		})
+		NoError(t, err)
+		if !hit {
+			cache.Delete(key)
+		}
+		return hit
+	}
+
+	resolve(r, "github.com/earthly/test:main+build")
+	resolve(r, "github.com/earthly/test/sub:feature+build")
+	resolve(r, "github.com/earthly/other:main+build")
+	resolve(nr, "github.com/earthly/test:main+build")
+	// The tag of the commits is added as secondary entries, in the background.
+	Eventually(t, func() bool { return r.gr.secondaryKeys.len() == 3 }, 5*time.Second, time.Millisecond)
+	invalidated := []string{
+		"https://github.com/earthly/test.git#main",
+		"https://github.com/earthly/test.git#feature",
+		"https://github.com/earthly/test.git#v1.0.0",
+		"tenant|https://github.com/earthly/test.git#main",
+		"tenant|https://github.com/earthly/test.git#v1.0.0",
+	}
+	invalidatedBuildFiles := []string{
+		"github.com/earthly/test:main",
+		"github.com/earthly/test/sub:feature",
+		"tenant|github.com/earthly/test:main",
+	}
+	kept := []string{
+		"https://github.com/earthly/other.git#main",
+		"https://github.com/earthly/other.git#v1.0.0",
+	}
+	for _, key := range append(invalidated, kept...) {
+		True(t, cached(r.gr.projectCache, key), key)
+	}
+	for _, key := range append(invalidatedBuildFiles, "github.com/earthly/other:main") {
+		True(t, cached(r.gr.buildFileCache, key), key)
+	}
+
+	// Whichever url the repo is invalidated by.
+	r.InvalidateRepo("git@github.com:earthly/test.git")
+	for _, key := range invalidated {
+		False(t, cached(r.gr.projectCache, key), key)
+	}
+	for _, key := range invalidatedBuildFiles {
+		False(t, cached(r.gr.buildFileCache, key), key)
+	}
+	for _, key := range kept {
+		True(t, cached(r.gr.projectCache, key), key)
+	}
+	True(t, cached(r.gr.buildFileCache, "github.com/earthly/other:main"))
+	Equal(t, 1, r.gr.secondaryKeys.len())
+
+	// The repo is resolved afresh.
+	runs := gwClient.numMetaRuns(t)
+	resolve(nr, "github.com/earthly/test:main+build")
+	Equal(t, runs+1, gwClient.numMetaRuns(t))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/earthly/earthly/ast"
 	"github.com/earthly/earthly/ast/spec"
@@ -13,10 +14,12 @@ import (
 	"github.com/earthly/earthly/features"
 	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/earthly/earthly/util/llbutil/secretprovider"
 	"github.com/earthly/earthly/util/platutil"
 	"github.com/earthly/earthly/util/syncutil/synccache"
 
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
@@ -30,6 +33,9 @@ type Data struct {
 	Earthfile spec.Earthfile
 	// BuildFilePath is the local path where the Earthfile or Dockerfile can be found.
 	BuildFilePath string
+	// BuildFileLocation is the path of the build file of remote targets within their repository,
+	// telling which of the BuildFileLocations of the resolver it has been found in.
+	BuildFileLocation string
 	// BuildContext is the state to use for the build.
 	BuildContextFactory llbfactory.Factory
 	// GitMetadata contains git metadata information.
@@ -40,56 +46,582 @@ type Data struct {
 	LocalDirs map[string]string
 	// Features holds the feature state for the build context
 	Features *features.Features
+	// BaseImages holds the images referenced by FROM commands of the Earthfile. Only populated
+	// when the resolver is created with ExtractBaseImages.
+	BaseImages []BaseImage
+	// ContextDigest summarizes the inputs of the build context of remote targets: the commit, the
+	// tree of the subdirectory and the exclude patterns applied to it. Identical digests mean
+	// identical build contexts. Only populated when the resolver is created with
+	// ComputeContextDigest, and when the git metadata is not skipped.
+	ContextDigest digest.Digest
+	// ExcludePatterns are the effective exclude patterns applied to the build context of targets,
+	// along with their source, for debugging files missing from it. The ignore files of remote
+	// git targets are only applied with RemoteIgnoreFiles, while those of remote archives and OCI
+	// images always are.
+	ExcludePatterns []ExcludePattern
+	// GitConfig is the git configuration in effect when the metadata of a remote reference was
+	// extracted, including the entries passed by the resolver, with credentials scrubbed. Only
+	// populated when the resolver is created with ReadGitConfig, and when the reference is not
+	// resolved lazily.
+	GitConfig []GitConfigEntry
+}
+
+// ShortID returns a short, human-readable identifier of the resolved reference, meant for logging,
+// such as org/repo@abc1234 (main). It falls back to the reference itself when no git metadata is
+// available.
+func (d *Data) ShortID() string {
+	if d.GitMetadata == nil || (d.GitMetadata.GitURL == "" && d.GitMetadata.RemoteURL == "") {
+		return d.Ref.StringCanonical()
+	}
+	return d.GitMetadata.ShortID(d.Ref.GetTag())
+}
+
+// ResolverOpt holds optional settings for a Resolver.
+type ResolverOpt struct {
+	// SkipGitMetadata disables the git meta step for remote references. The build context is
+	// then cloned straight at the requested ref, and the resulting GitMetadata is left empty
+	// (with Unpopulated set). Note that because the commit hash is never resolved in this mode,
+	// resolutions are not cached by commit: refs pointing to the same commit are each cloned
+	// separately.
+	SkipGitMetadata bool
+	// GitMirror keeps bare mirrors of the remote repositories, fetched into on every resolution,
+	// instead of cloning them afresh. See GitMirrorOpt.
+	GitMirror GitMirrorOpt
+	// ExcludeGitDirs leaves any .git directory (such as those of submodules) out of the build
+	// context of remote targets living in a subdirectory of their repository. The git metadata is
+	// unaffected, as it is extracted separately.
+	ExcludeGitDirs bool
+	// RemoteIgnoreFiles leaves the paths matching the .earthignore (or .earthlyignore) file of the
+	// build context of remote targets out of it, along with the implicit excludes (such as the
+	// build file itself), as is the case for local targets. The ignore file is read from the
+	// directory of the target within its repository, along with the build file.
+	RemoteIgnoreFiles bool
+	// GitSrcPath is the absolute path at which the git meta run mounts the clone of a remote
+	// reference. Defaults to /git-src.
+	GitSrcPath string
+	// GitDestPath is the absolute path to which the git meta run writes the metadata files.
+	// It must not overlap with GitSrcPath. Defaults to /dest.
+	GitDestPath string
+	// GitImage determines the image used to run git for remote references. See GitImageOpt.
+	GitImage GitImageOpt
+	// MetadataTransform, if set, is invoked with the git metadata of every resolved remote
+	// reference, before it is used. It may modify the metadata, or reject it by returning an
+	// error, which aborts the resolution.
+	MetadataTransform func(*gitutil.GitMetadata) error
+	// GitTLS is the TLS material presented to git servers over https. When any is set, remote
+	// references are cloned by running git in the git image, and InternalSecretStore must be set.
+	GitTLS GitTLSOpt
+	// InternalSecretStore is the secret store used to pass sensitive material along to the git
+	// image.
+	InternalSecretStore *secretprovider.MutableMapStore
+	// ExtractBaseImages populates the BaseImages of resolved Data, out of the parsed Earthfile.
+	ExtractBaseImages bool
+	// MaxSecondaryGitEntries caps the number of cached projects keyed by the branch or tag
+	// resolved for another ref (as opposed to the ref requested). The least recently used of
+	// them are evicted first. 0 means unlimited.
+	MaxSecondaryGitEntries int
+	// GitCommandLogLevel is the console log level (Info, Verbose or Debug) at which the commands
+	// run in the git image are logged, with credentials scrubbed. Defaults to Debug.
+	GitCommandLogLevel conslogging.LogLevel
+	// CoAuthorTrailerKeys are the commit message trailer keys (e.g. "Reviewed-by") parsed into the
+	// co-authors of remote references. Defaults to gitutil.DefaultCoAuthorTrailerKeys.
+	CoAuthorTrailerKeys []string
+	// MaxCommitBodyBytes, if set, caps the commit message bodies of remote references captured for
+	// parsing their co-authors. Longer bodies are truncated from their beginning, so that their
+	// trailers are kept. Uncapped by default.
+	MaxCommitBodyBytes int
+	// ProtectedBranch, if set, is a branch which every resolved remote commit must be reachable
+	// from (i.e. be an ancestor of). Resolving a commit which isn't fails with
+	// ErrGitRefNotReachable. The branch is fetched along with the requested ref.
+	ProtectedBranch string
+	// Analytics customizes the analytics reported when resolving remote references.
+	Analytics AnalyticsOpt
+	// CacheNamespace prefixes the keys of the cached remote projects and build files, isolating
+	// them from those of other namespaces sharing the same caches (see WithCacheNamespace).
+	// Defaults to the shared empty namespace.
+	CacheNamespace string
+	// MaxResolveDuration caps the total duration of resolving a remote reference, across all of
+	// its steps (lookups, clones, the git meta run and reading the build file). Exceeding it fails
+	// with ErrResolveTimeout. 0 means unlimited.
+	MaxResolveDuration time.Duration
+	// Tracer, if set, receives spans covering the resolution of remote references: the project,
+	// the clone, and each read of the git metadata. They are children of the span carried by the
+	// context of the resolution, if any.
+	Tracer Tracer
+	// DefaultBranch determines the checks and fallbacks of the default branches of remote
+	// repositories. When any expected default branch is set, remote references are cloned by
+	// running git in the git image. See DefaultBranchOpt.
+	DefaultBranch DefaultBranchOpt
+	// LazyResolve defers the resolution of the project of remote targets (cloning it and extracting
+	// its git metadata) until their build context is first used, reading only their build file
+	// upfront. The BuildContextFactory of the resulting Data is then a *LazyFactory, out of which
+	// the git metadata is available once resolved; the GitMetadata of the Data itself is left
+	// unpopulated. Commands are not affected.
+	LazyResolve bool
+	// GitExtraHosts are host -> IP entries added to /etc/hosts of the git image runs, so that git
+	// hosts can be reached without a working DNS. When any is set, remote references are cloned by
+	// running git in the git image. Invalid host names or IP addresses fail the resolution.
+	GitExtraHosts map[string]string
+	// BuildFileDigests are the digests (e.g. sha256:...) expected for the content of the build
+	// files of remote references, keyed by the canonical project reference, i.e. the repo,
+	// subdirectory and ref (e.g. github.com/earthly/earthly/examples/go:v0.6.0). A build file
+	// whose content differs fails the resolution with ErrBuildFileDigestMismatch.
+	BuildFileDigests map[string]digest.Digest
+	// GitTransfer tunes the git transfer parameters of the clones, e.g. for slow links or to spare
+	// connections over HTTPS. When any is set, remote references are cloned by running git in the
+	// git image.
+	GitTransfer GitTransferOpt
+	// SearchParentBuildFiles makes remote references whose subdirectory has no build file use the
+	// build file of the closest parent directory that has one, up to the root of the repository.
+	// The build context remains restricted to the subdirectory of the reference.
+	SearchParentBuildFiles bool
+	// BuildFileLocations are the directories the build files of remote references are looked for
+	// in, in order, the first holding one winning. They are relative to the subdirectory of the
+	// reference or, when starting with a /, to the root of the repository, e.g. ".", ".earthly" and
+	// "/" for the subdirectory, its .earthly directory, then the root. SearchParentBuildFiles
+	// applies once none matched. Defaults to the subdirectory only. The build context remains
+	// restricted to the subdirectory of the reference.
+	BuildFileLocations []string
+	// ReadSubmodules populates the Submodules of the git metadata of remote references, out of
+	// their .gitmodules file, along with the commits they are pinned to (as reported by
+	// `git submodule status --cached`). The rest of the git metadata is that of the superproject
+	// regardless. It has no effect with SkipGitMetadata.
+	ReadSubmodules bool
+	// InitSubmodules checks out the submodules of remote repositories, recursively, into the build
+	// contexts of their targets, which are otherwise incomplete when cloned by running git in the
+	// git image (the buildkit git source checks them out on its own). Each submodule is cloned on
+	// its own, at the commit it is pinned to, so that its clone is cached per submodule commit,
+	// with the ssh keys of its host scanned as for remote references. Submodule urls relative to
+	// the superproject (e.g. ../other.git) are resolved against its url.
+	InitSubmodules bool
+	// PreserveBuildFileLineEndings keeps the CRLF line endings of the Earthfiles of remote
+	// references as they are. By default, they are converted to LF before parsing (and before
+	// verifying BuildFileDigests).
+	PreserveBuildFileLineEndings bool
+	// ReadSigningKey populates the SigningKeyFingerprint and SigningKeyID of the git metadata of
+	// remote references, out of the signature of their commit. The fingerprint requires the
+	// signer's public key to be in the keyring of the git image, and gpg to be available in it.
+	ReadSigningKey bool
+	// ProjectCache and BuildFileCache hold the resolved remote projects and build files,
+	// respectively. They default to new in-memory caches, private to the resolver (and to those
+	// derived from it with WithCacheNamespace).
+	ProjectCache   Cache
+	BuildFileCache Cache
+	// RecordCache holds the records outliving the resolved projects, which InvalidateRepo does not
+	// remove: the fingerprints of the host keys first seen for git hosts, with PinHostKeys, and
+	// the commits the tags of remote repositories are resolved to, with TagChecks.DetectMoved. Its
+	// values are strings, whereas those of ProjectCache are resolved projects. It defaults to a new
+	// in-memory cache, private to the resolver (and to those derived from it with
+	// WithCacheNamespace).
+	RecordCache Cache
+	// RepoSparsePatterns checks out only the paths matched by the .earthly-sparse file of remote
+	// repositories (in the non-cone sparse-checkout pattern format of git), for those shipping one
+	// at the resolved commit. The patterns must match the build files of the references as well.
+	// Repositories without the file are checked out in full. When set, remote references are
+	// cloned by running git in the git image.
+	RepoSparsePatterns bool
+	// ForbidLegacyBuildFile fails the resolution of remote references whose build file has the
+	// deprecated build.earth name with ErrLegacyBuildFile, instead of printing a warning.
+	ForbidLegacyBuildFile bool
+	// MaxConcurrentResolves caps the number of remote projects being resolved (cloned and their
+	// git metadata extracted) at once, and MaxConcurrentResolvesPerHost the number of those of a
+	// single git host. Resolutions waiting for a slot are granted in turn across hosts, so that
+	// the resolutions of a host do not starve those of others. 0 means unlimited.
+	MaxConcurrentResolves        int
+	MaxConcurrentResolvesPerHost int
+	// TagChecks determines the checks the tags remote references are resolved at are subject to.
+	// With RequireAnnotated, remote references are cloned by running git in the git image. See
+	// TagChecksOpt.
+	TagChecks TagChecksOpt
+	// ComputeContextDigest populates the ContextDigest of resolved Data.
+	ComputeContextDigest bool
+	// OnGitMetaStats, if set, receives the stats of every git meta run, once the project it
+	// resolves is done being constructed (successfully or not). Projects resolved out of the cache,
+	// or without a git meta run, are not reported.
+	OnGitMetaStats func(GitMetaStats)
+	// AttachGitLabels attaches the git metadata of remote targets to their build context state, as
+	// labels following the GitLabelPrefix convention. They can be read with ContextGitLabels.
+	AttachGitLabels bool
+	// PreserveGitRefOrder keeps the branches and tags of the git metadata of remote references in
+	// the order git outputs them, which may vary across git versions. By default, branches are
+	// sorted lexically, and tags with the version tags (e.g. v1.2.3) first, in ascending semver
+	// precedence, followed by the others, lexically. Either way, the project is additionally cached
+	// under the first branch and the first tag.
+	PreserveGitRefOrder bool
+	// TarExporter, if set, is used to read the git metadata and the build files of remote
+	// references when the gateway does not support reading files out of solved states: the states
+	// are then exported as tar streams, and the files read out of them.
+	TarExporter TarExporter
+	// NormalizeCacheHosts normalizes the hosts of remote references and of their clone urls in the
+	// keys of the project and build file caches, so that e.g. GitHub.com:443 and github.com share
+	// their entries: hosts are lowercased, and default ports and trailing dots dropped. The
+	// repositories are still cloned from the urls as configured.
+	NormalizeCacheHosts bool
+	// LFS determines how the git LFS files of remote repositories are handled. When pointer files
+	// are detected or fetched, remote references are cloned by running git in the git image. See
+	// LFSOpt.
+	LFS LFSOpt
+	// BuildFileFS, if set, is the filesystem the build files of remote references are written to,
+	// rather than temp dirs of the OS filesystem, e.g. to keep them in memory. The BuildFilePath of
+	// the Data of remote references is then a path within it, which consumers reading the build
+	// file (e.g. that of a Dockerfile target) must read out of it too.
+	BuildFileFS BuildFileFS
+	// MinEarthfileVersion, if set, is the minimum VERSION (e.g. "0.7") the Earthfiles of remote
+	// references must declare. Those declaring an older one (or none, which implies 0.5) fail the
+	// resolution with ErrVersionTooOld.
+	MinEarthfileVersion string
+	// DetectCaseCollisions checks the directories leading to the build files of remote references
+	// (from the root of the repository) for entries which differ only by case, e.g. Earthfile and
+	// earthfile, as they collide when checked out on case-insensitive filesystems. Collisions are
+	// reported as a warning naming the colliding paths, or as ErrCaseCollision when
+	// StrictCaseCollisions is set.
+	DetectCaseCollisions bool
+	StrictCaseCollisions bool
+	// ReadRootCommit populates the RootCommit of the git metadata of remote references, the root
+	// commit of the history of their commit, which may serve as a stable identifier of their
+	// repository. The history is only available when the repository is fully cloned, by running git
+	// in the git image (e.g. with GitMirror): otherwise, RootCommitUnavailable is set.
+	ReadRootCommit bool
+	// PrimaryRefPolicy determines which of the branches and tags of the commit of a remote
+	// reference it is reported with, and under which its project is additionally cached. It
+	// defaults to PreferRequested.
+	PrimaryRefPolicy PrimaryRefPolicy
+	// GitObjectStores share git object stores among remote repositories with a common upstream, e.g.
+	// forks and mirrors of a project, so that the objects they have in common are downloaded once.
+	// They are keyed by the url the repositories are cloned from, without credentials (e.g.
+	// https://github.com/alice/earthly.git), the value being an identity of their upstream (e.g.
+	// github.com/earthly/earthly): repositories with the same upstream identity share a store, kept
+	// in a persistent buildkit cache mount. The store is referenced by the clones (git clone
+	// --reference) and updated with their objects. Clones copy the objects they reuse, and thus do
+	// not depend on the store afterwards, and a store which turns out to be unusable is reset.
+	// Access to a given store is serialized. When any is set, remote references are cloned by
+	// running git in the git image.
+	GitObjectStores map[string]string
+	// FutureTimestampPolicy determines how the commits of remote references dated further in the
+	// future than FutureTimestampTolerance (5 minutes by default) are handled: their timestamp is
+	// left as it is, warned about, or clamped to the time of the resolution.
+	FutureTimestampPolicy    FutureTimestampPolicy
+	FutureTimestampTolerance time.Duration
+	// MaxGitRefs, if set, protects against remote repositories with a huge number of refs, which
+	// are slow to enumerate. The requested ref of remote references is then fetched on its own,
+	// without enumerating the others, when possible (i.e. it is a branch, a tag, another full ref
+	// name or a full commit hash, and the server supports git protocol v2). Otherwise (e.g. with
+	// GitMirror, or when no ref is requested), resolving a reference to a repository with more
+	// refs than MaxGitRefs fails with ErrTooManyRefs. When set, remote references are cloned by
+	// running git in the git image.
+	MaxGitRefs int
+	// RefAdvertisementTimeout, if set, caps the duration of the remote repositories advertising
+	// their refs, ahead of their clone. A server slow to list its refs then fails resolving with
+	// ErrRefAdvertisementTimeout, as opposed to one slow to transfer objects. When set, remote
+	// references are cloned by running git in the git image.
+	RefAdvertisementTimeout time.Duration
+	// CredentialExpiryMargin is how long before their expiry the context credentials (see
+	// WithGitCredentials) are considered about to expire, when a resolution starts. Such credentials
+	// are refreshed, if they can be, and warned about otherwise. Expired credentials which cannot be
+	// refreshed fail the resolution with ErrCredentialExpired, regardless of the margin.
+	CredentialExpiryMargin time.Duration
+	// VerifyLockfile makes the refs imported with ImportLockfile be resolved afresh upon their first
+	// use, which then fails with ErrLockfileMismatch if they no longer resolve to the commit recorded
+	// in the lockfile. By default, the lockfile is trusted, and the repositories are not cloned.
+	VerifyLockfile bool
+	// CacheTTL, if set, returns how long the remote projects and build files resolved for a
+	// reference remain cached, 0 meaning for as long as the resolver lives. Expired entries are
+	// resolved afresh upon their next use. By default, commits never expire, while tags expire after
+	// DefaultTagCacheTTL and branches after DefaultBranchCacheTTL. CacheForever keeps every entry.
+	// The projects imported with ImportLockfile never expire.
+	CacheTTL func(ref domain.Reference) time.Duration
+	// ReadGitConfig populates the GitConfig of resolved Data with the git configuration (git config
+	// --list --show-origin) in effect in the git meta run of remote references: that of the git
+	// image, along with the entries passed by the resolver (e.g. GitTransfer).
+	ReadGitConfig bool
+	// StrictShortHash fails the resolution of remote references with ErrAmbiguousShortHash when the
+	// 8-character short hash of their commit is shared with other objects of the repository, rather
+	// than using a longer, unambiguous one. Only the objects of the clone are considered, which may
+	// not be all those of the repository in shallow clones.
+	StrictShortHash bool
+	// BuildFileNames are the names of the build files of targets, in order of precedence: when the
+	// directory of a target holds several of them, the first one is used, and the others warned
+	// about. By default, an Earthfile takes precedence over a build.earth file. Dockerfile meta
+	// targets are not affected.
+	BuildFileNames []string
+	// MaxDeepenDepth, if set, makes the git metadata of remote references which is extracted out of
+	// a shallow clone with no tag reachable from the commit (i.e. an empty Describe) be extracted
+	// anew, by running git in the git image, out of a clone fetched along with the tags, at a depth
+	// doubling from 1 until a tag is reachable, the history is complete, or the depth reaches
+	// MaxDeepenDepth commits. This trades time for complete metadata.
+	MaxDeepenDepth int
+	// CacheKeyHash, if set, hashes the keys of the entries of ProjectCache, BuildFileCache and
+	// RecordCache, e.g. for a Cache backed by a store limiting their length. It must be
+	// collision-resistant (e.g. SHA-256), as entries with the same key are deemed the same.
+	CacheKeyHash func(key string) string
+	// OnWarning, if set, receives the warnings of resolutions (e.g. a moved tag, a deprecated
+	// build file name, git LFS pointers), as they are logged to the console. It may be called
+	// concurrently. Warnings are emitted as the conditions are met, so that resolutions out of the
+	// cache do not repeat them.
+	OnWarning func(Warning)
+	// TranscodeCommitEncoding decodes the author and the commit message body (and thus the
+	// co-authors) of the git metadata of remote references into UTF-8, out of the encoding their
+	// commit declares (e.g. ISO-8859-1), if any, rather than relying on git to. The declared
+	// encoding is exposed as the Encoding of the git metadata. Commits declaring an unknown encoding
+	// are warned about, and their text left as is.
+	TranscodeCommitEncoding bool
+	// GitMetaCacheStrategy determines whether the git meta runs of remote references are cached by
+	// buildkit (the default), or solved with its cache ignored, which saves cache space at the cost
+	// of cloning repositories anew. See GitMetaCacheStrategy.
+	GitMetaCacheStrategy GitMetaCacheStrategy
+	// GitCloneVerbosity determines how verbose the clones and fetches of remote references made by
+	// running git in the git image are. By default, they are quiet in CI (as detected from the
+	// environment), for their output not to flood its logs, and as verbose as git is by default
+	// otherwise. See GitCloneVerbosity.
+	GitCloneVerbosity GitCloneVerbosity
+	// GitCloneDepth, if set, is the depth of the history of the clones of remote repositories, for
+	// those of huge repositories not to fetch all of it just to resolve a reference. The requested
+	// ref is then fetched on its own at that depth, falling back to a full clone when it cannot be
+	// (e.g. an abbreviated commit hash). GitCloneDepths overrides it for the hosts or repositories
+	// (as in references, e.g. github.com or github.com/earthly/earthly) it is keyed by, the longest
+	// key matching winning, 0 meaning the full history. Remote references cloned at a depth are
+	// cloned by running git in the git image. It has no effect with GitMirror, the mirror
+	// holding the full history. The git metadata derived from the history (e.g. the parents, the
+	// root commit and the closest tag) is then that of the shallow clone.
+	GitCloneDepth  int
+	GitCloneDepths map[string]int
+	// PinHostKeys records the fingerprints of the ssh host keys of git hosts the first time they
+	// are seen, in the RecordCache, and fails resolving remote references with ErrHostKeyChanged
+	// when the known host keys of their host later include another one (trust on first use).
+	PinHostKeys bool
 }
 
 // Resolver is a build context resolver.
 type Resolver struct {
 	gr *gitResolver
 	lr *localResolver
+	ar *archiveResolver
+	or *ociResolver
 
 	parseCache *synccache.SyncCache // local path -> AST
 	console    conslogging.ConsoleLogger
 
 	featureFlagOverrides string
+	extractBaseImages    bool
 }
 
 // NewResolver returns a new NewResolver.
-func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLookup *GitLookup, console conslogging.ConsoleLogger, featureFlagOverrides string) *Resolver {
+func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLookup *GitLookup, console conslogging.ConsoleLogger, featureFlagOverrides string, opt ResolverOpt) *Resolver {
+	if opt.GitSrcPath == "" {
+		opt.GitSrcPath = defaultGitSrcPath
+	}
+	if opt.GitDestPath == "" {
+		opt.GitDestPath = defaultGitDestPath
+	}
+	if opt.GitImage.Image == "" {
+		opt.GitImage.Image = defaultGitImage
+	}
+	if opt.LFS.Image == "" {
+		opt.LFS.Image = defaultGitLFSImage
+	}
+	if len(opt.CoAuthorTrailerKeys) == 0 {
+		opt.CoAuthorTrailerKeys = gitutil.DefaultCoAuthorTrailerKeys
+	}
+	if opt.BuildFileFS == nil {
+		opt.BuildFileFS = osBuildFileFS{}
+	}
+	if opt.Tracer == nil {
+		opt.Tracer = noopTracer{}
+	}
+	if opt.ProjectCache == nil {
+		opt.ProjectCache = synccache.New()
+	}
+	if opt.BuildFileCache == nil {
+		opt.BuildFileCache = synccache.New()
+	}
+	if opt.RecordCache == nil {
+		opt.RecordCache = synccache.New()
+	}
+	ss := &stateSource{
+		cleanCollection:     cleanCollection,
+		buildFileCache:      synccache.New(),
+		buildFileFS:         opt.BuildFileFS,
+		buildFileNames:      opt.BuildFileNames,
+		preserveLineEndings: opt.PreserveBuildFileLineEndings,
+		console:             console,
+		onWarning:           opt.OnWarning,
+	}
 	return &Resolver{
 		gr: &gitResolver{
 			cleanCollection: cleanCollection,
-			projectCache:    synccache.New(),
-			buildFileCache:  synccache.New(),
+			sessionID:       sessionID,
+			projectCache:    opt.ProjectCache,
+			secondaryKeys:   newSecondaryKeys(opt.MaxSecondaryGitEntries),
+			repoKeys:        newRepoKeys(),
+			buildFileCache:  opt.BuildFileCache,
+			recordCache:     opt.RecordCache,
+			cacheNamespace:  opt.CacheNamespace,
 			gitLookup:       gitLookup,
 			console:         console,
+			skipMeta:        opt.SkipGitMetadata,
+			gitMirror:       opt.GitMirror,
+			excludeGitDirs:  opt.ExcludeGitDirs,
+			gitSrcPath:      opt.GitSrcPath,
+			gitDestPath:     opt.GitDestPath,
+			gitImage:        opt.GitImage,
+			gitImageCache:   synccache.New(),
+			snapshotCache:   synccache.New(),
+
+			onGitMetaStats:      opt.OnGitMetaStats,
+			attachGitLabels:     opt.AttachGitLabels,
+			preserveGitRefOrder: opt.PreserveGitRefOrder,
+			tarExporter:         opt.TarExporter,
+			normalizeCacheHosts: opt.NormalizeCacheHosts,
+			lfs:                 opt.LFS,
+			remoteIgnoreFiles:   opt.RemoteIgnoreFiles,
+			tagChecks:           opt.TagChecks,
+			buildFileFS:         opt.BuildFileFS,
+			minEarthfileVersion: opt.MinEarthfileVersion,
+
+			gitCommandLogLevel: opt.GitCommandLogLevel,
+
+			metadataTransform:   opt.MetadataTransform,
+			coAuthorTrailerKeys: opt.CoAuthorTrailerKeys,
+			maxCommitBodyBytes:  opt.MaxCommitBodyBytes,
+			protectedBranch:     opt.ProtectedBranch,
+			maxResolveDuration:  opt.MaxResolveDuration,
+			analytics:           newGitAnalytics(opt.Analytics),
+			tracer:              opt.Tracer,
+
+			defaultBranch:           opt.DefaultBranch,
+			lazy:                    opt.LazyResolve,
+			gitExtraHosts:           opt.GitExtraHosts,
+			buildFileDigests:        opt.BuildFileDigests,
+			gitTransfer:             opt.GitTransfer,
+			searchParentBuildFiles:  opt.SearchParentBuildFiles,
+			buildFileLocations:      opt.BuildFileLocations,
+			readSubmodules:          opt.ReadSubmodules,
+			initSubmodules:          opt.InitSubmodules,
+			preserveLineEndings:     opt.PreserveBuildFileLineEndings,
+			readSigningKey:          opt.ReadSigningKey,
+			readRootCommit:          opt.ReadRootCommit,
+			primaryRefPolicy:        opt.PrimaryRefPolicy,
+			gitObjectStores:         opt.GitObjectStores,
+			futureTimestampPolicy:   opt.FutureTimestampPolicy,
+			futureTsTolerance:       opt.FutureTimestampTolerance,
+			maxGitRefs:              opt.MaxGitRefs,
+			refAdvertiseTimeout:     opt.RefAdvertisementTimeout,
+			credentialExpiryMargin:  opt.CredentialExpiryMargin,
+			verifyLockfile:          opt.VerifyLockfile,
+			cacheTTLFunc:            opt.CacheTTL,
+			readGitConfig:           opt.ReadGitConfig,
+			strictShortHash:         opt.StrictShortHash,
+			buildFileNames:          opt.BuildFileNames,
+			maxDeepenDepth:          opt.MaxDeepenDepth,
+			cacheKeyHash:            opt.CacheKeyHash,
+			onWarning:               opt.OnWarning,
+			transcodeCommitEncoding: opt.TranscodeCommitEncoding,
+			gitMetaCache:            opt.GitMetaCacheStrategy,
+			gitCloneVerbosity:       opt.GitCloneVerbosity,
+			gitCloneDepth:           opt.GitCloneDepth,
+			gitCloneDepths:          opt.GitCloneDepths,
+			pinHostKeys:             opt.PinHostKeys,
+			repoSparsePatterns:      opt.RepoSparsePatterns,
+			forbidLegacyBuildFile:   opt.ForbidLegacyBuildFile,
+			detectCaseCollisions:    opt.DetectCaseCollisions,
+			strictCaseCollisions:    opt.StrictCaseCollisions,
+			computeContextDigest:    opt.ComputeContextDigest,
+			scheduler:               newGitScheduler(opt.MaxConcurrentResolves, opt.MaxConcurrentResolvesPerHost),
+
+			gitTLS:              opt.GitTLS,
+			internalSecretStore: opt.InternalSecretStore,
+			gitTLSCache:         synccache.New(),
 		},
 		lr: &localResolver{
 			buildFileCache: synccache.New(),
 			gitMetaCache:   synccache.New(),
 			sessionID:      sessionID,
 			console:        console,
+			buildFileNames: opt.BuildFileNames,
+			onWarning:      opt.OnWarning,
 		},
+		ar:                   &archiveResolver{stateSource: ss},
+		or:                   &ociResolver{stateSource: ss},
 		parseCache:           synccache.New(),
 		console:              console,
 		featureFlagOverrides: featureFlagOverrides,
+		extractBaseImages:    opt.ExtractBaseImages,
+	}
+}
+
+// WithCacheNamespace returns a resolver sharing the caches of r, in which the cached remote projects
+// and build files are isolated from those of other namespaces. Resolvers with the same namespace
+// share cache entries. This allows e.g. servers to isolate tenants from one another, or not.
+func (r *Resolver) WithCacheNamespace(namespace string) *Resolver {
+	gr := *r.gr
+	gr.cacheNamespace = namespace
+	nr := *r
+	nr.gr = &gr
+	return &nr
+}
+
+// InvalidateRepo removes the cached remote projects (including those of branches and tags) and build
+// files of the repository of the given clone url, in all cache namespaces, so that they are
+// resolved afresh. The protocol and credentials of the url do not matter. Ongoing resolutions are
+// not affected.
+func (r *Resolver) InvalidateRepo(gitURL string) {
+	projectKeys, buildFileKeys := r.gr.repoKeys.remove(r.gr.cacheKeyURL(gitURL))
+	for _, key := range projectKeys {
+		r.gr.secondaryKeys.remove(key)
+		r.gr.projectCache.Delete(key)
+	}
+	for _, key := range buildFileKeys {
+		r.gr.buildFileCache.Delete(key)
 	}
 }
 
 // Resolve returns resolved context data for a given Earthly reference. If the reference is a target,
 // then the context will include a build context and possibly additional local directories.
 func (r *Resolver) Resolve(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference) (*Data, error) {
+	return r.ResolveForPlatform(ctx, gwClient, platr, ref, platutil.DefaultPlatform)
+}
+
+// ResolveForPlatform is like Resolve, but the build context of remote targets restricted to a
+// subdirectory is associated with the given platform, rather than the native one. Passing
+// platutil.DefaultPlatform is equivalent to calling Resolve.
+func (r *Resolver) ResolveForPlatform(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, contextPlatform platutil.Platform) (*Data, error) {
 	if ref.IsUnresolvedImportReference() {
 		return nil, errors.Errorf("cannot resolve non-dereferenced import ref %s", ref.String())
 	}
 	var d *Data
 	var err error
 	localDirs := make(map[string]string)
-	if ref.IsRemote() {
+	if domain.IsRemoteArchive(ref) {
+		// Remote archive.
+		d, err = r.ar.resolveArchive(ctx, gwClient, platr, ref, contextPlatform, r.featureFlagOverrides)
+		if err != nil {
+			return nil, err
+		}
+	} else if domain.IsRemoteImage(ref) {
+		// OCI image.
+		d, err = r.or.resolveImage(ctx, gwClient, platr, ref, contextPlatform, r.featureFlagOverrides)
+		if err != nil {
+			return nil, err
+		}
+	} else if ref.IsRemote() {
 		// Remote.
-		d, err = r.gr.resolveEarthProject(ctx, gwClient, platr, ref, r.featureFlagOverrides)
+		_, isTarget := ref.(domain.Target)
+		deferCtx := ctx
+		err = r.gr.withResolveBudget(ctx, ref, func(ctx context.Context) error {
+			if r.gr.lazy && isTarget && r.gr.snapshotRefs == nil {
+				d, err = r.gr.resolveEarthProjectLazy(ctx, deferCtx, gwClient, platr, ref, contextPlatform, r.featureFlagOverrides)
+				return err
+			}
+			d, err = r.gr.resolveEarthProject(ctx, gwClient, platr, ref, contextPlatform, r.featureFlagOverrides)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
+		for k, v := range d.LocalDirs {
+			// The build contexts of snapshots are local.
+			localDirs[k] = v
+		}
 	} else {
 		// Local.
 		if _, isTarget := ref.(domain.Target); isTarget {
@@ -101,21 +633,69 @@ func (r *Resolver) Resolve(ctx context.Context, gwClient gwclient.Client, platr
 			return nil, err
 		}
 	}
-	d.Ref = gitutil.ReferenceWithGitMeta(ref, d.GitMetadata)
+	if ref.IsRemote() {
+		d.Ref = gitutil.ReferenceWithGitMeta(r.gr.withPrimaryRef(ref, d.GitMetadata), d.GitMetadata)
+	} else {
+		d.Ref = gitutil.ReferenceWithGitMeta(ref, d.GitMetadata)
+	}
 	d.LocalDirs = localDirs
 	if !strings.HasPrefix(ref.GetName(), DockerfileMetaTarget) {
-		d.Earthfile, err = r.parseEarthfile(ctx, d.BuildFilePath)
+		var fsys BuildFileFS
+		if ref.IsRemote() {
+			fsys = r.gr.buildFileFS
+		}
+		d.Earthfile, err = r.parseEarthfile(ctx, d.BuildFilePath, fsys)
 		if err != nil {
 			return nil, err
 		}
+		if r.extractBaseImages {
+			d.BaseImages = earthfileBaseImages(d.Earthfile)
+		}
 	}
 	return d, nil
 }
 
-func (r *Resolver) parseEarthfile(ctx context.Context, path string) (spec.Earthfile, error) {
+// ResolveFeatures returns the features declared by the build file of a given Earthly reference. As
+// opposed to Resolve, neither the build context nor the git metadata of the reference are resolved.
+func (r *Resolver) ResolveFeatures(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference) (*features.Features, error) {
+	if ref.IsUnresolvedImportReference() {
+		return nil, errors.Errorf("cannot resolve non-dereferenced import ref %s", ref.String())
+	}
+	if domain.IsRemoteArchive(ref) {
+		return r.ar.resolveFeatures(ctx, gwClient, platr, ref, r.featureFlagOverrides)
+	}
+	if domain.IsRemoteImage(ref) {
+		return r.or.resolveFeatures(ctx, gwClient, platr, ref, r.featureFlagOverrides)
+	}
+	if ref.IsRemote() {
+		var ftrs *features.Features
+		err := r.gr.withResolveBudget(ctx, ref, func(ctx context.Context) error {
+			var err error
+			ftrs, err = r.gr.resolveFeatures(ctx, gwClient, platr, ref, r.featureFlagOverrides)
+			return err
+		})
+		return ftrs, err
+	}
+	bf, err := r.lr.resolveBuildFile(ctx, ref, r.featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	return bf.ftrs, nil
+}
+
+// parseEarthfile parses the Earthfile at path, read out of fsys (the build files of remote
+// references) or, if nil, out of the OS filesystem.
+func (r *Resolver) parseEarthfile(ctx context.Context, path string, fsys BuildFileFS) (spec.Earthfile, error) {
 	path = filepath.Clean(path)
 	efValue, err := r.parseCache.Do(ctx, path, func(ctx context.Context, k interface{}) (interface{}, error) {
-		return ast.Parse(ctx, k.(string), true)
+		if fsys == nil {
+			return ast.Parse(ctx, k.(string), true)
+		}
+		content, err := fsys.ReadFile(k.(string))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read build file %s", k.(string))
+		}
+		return ast.ParseContent(ctx, k.(string), content, true)
 	})
 	if err != nil {
 		return spec.Earthfile{}, err
@@ -0,0 +1,74 @@
+package buildcontext
+
+import (
+	"container/list"
+	"sync"
+)
+
+// secondaryKeys tracks the secondary (branch and tag) entries of the project cache, evicting the
+// least recently used ones beyond a maximum. Primary entries are never tracked, and thus never
+// evicted.
+type secondaryKeys struct {
+	max int // 0 means unlimited
+
+	mu    sync.Mutex
+	order *list.List // of string keys, most recently used first
+	elems map[string]*list.Element
+}
+
+func newSecondaryKeys(max int) *secondaryKeys {
+	return &secondaryKeys{
+		max:   max,
+		order: list.New(),
+		elems: make(map[string]*list.Element),
+	}
+}
+
+// add records a newly added secondary key, and returns the keys which should be evicted as a
+// consequence.
+func (sk *secondaryKeys) add(key string) []string {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	if elem, ok := sk.elems[key]; ok {
+		sk.order.MoveToFront(elem)
+		return nil
+	}
+	sk.elems[key] = sk.order.PushFront(key)
+	if sk.max <= 0 {
+		return nil
+	}
+	var evicted []string
+	for sk.order.Len() > sk.max {
+		elem := sk.order.Back()
+		sk.order.Remove(elem)
+		evictedKey := elem.Value.(string)
+		delete(sk.elems, evictedKey)
+		evicted = append(evicted, evictedKey)
+	}
+	return evicted
+}
+
+// touch marks a key as recently used, if it is a secondary key.
+func (sk *secondaryKeys) touch(key string) {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	if elem, ok := sk.elems[key]; ok {
+		sk.order.MoveToFront(elem)
+	}
+}
+
+// remove stops tracking a key, if it is a secondary key.
+func (sk *secondaryKeys) remove(key string) {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	if elem, ok := sk.elems[key]; ok {
+		sk.order.Remove(elem)
+		delete(sk.elems, key)
+	}
+}
+
+func (sk *secondaryKeys) len() int {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	return sk.order.Len()
+}
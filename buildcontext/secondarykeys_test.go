@@ -0,0 +1,55 @@
+package buildcontext
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/earthly/earthly/util/syncutil/synccache"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSecondaryProjectsCap(t *testing.T) {
+	ctx := context.Background()
+	gr := &gitResolver{
+		projectCache:  synccache.New(),
+		secondaryKeys: newSecondaryKeys(3),
+		repoKeys:      newRepoKeys(),
+	}
+	rgp := &resolvedGitProject{hash: "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5"}
+	cached := func(key string) bool {
+		hit := true
+		v, err := gr.projectCache.Do(ctx, key, func(ctx context.Context, k interface{}) (interface{}, error) {
+			hit = false
+			return &resolvedGitProject{}, nil
+		})
+		NoError(t, err)
+		if hit {
+			Same(t, rgp, v)
+		}
+		gr.projectCache.Delete(key)
+		if hit {
+			// Put back the entry removed by the check.
+			NoError(t, gr.projectCache.Add(ctx, key, v, nil))
+		}
+		return hit
+	}
+
+	primary := "https://github.com/earthly/test.git#main"
+	NoError(t, gr.projectCache.Add(ctx, primary, rgp, nil))
+	for i := 0; i < 10; i++ {
+		gr.addSecondaryProject(ctx, fmt.Sprintf("https://github.com/earthly/test.git#v1.0.%d", i), rgp)
+		// A secondary entry in use is kept.
+		gr.secondaryKeys.touch("https://github.com/earthly/test.git#v1.0.0")
+	}
+	// Adding the primary key again as secondary is a no-op.
+	gr.addSecondaryProject(ctx, primary, rgp)
+
+	Equal(t, 3, gr.secondaryKeys.len())
+	True(t, cached(primary))
+	True(t, cached("https://github.com/earthly/test.git#v1.0.0"))
+	True(t, cached("https://github.com/earthly/test.git#v1.0.9"))
+	True(t, cached("https://github.com/earthly/test.git#v1.0.8"))
+	False(t, cached("https://github.com/earthly/test.git#v1.0.7"))
+	False(t, cached("https://github.com/earthly/test.git#v1.0.1"))
+}
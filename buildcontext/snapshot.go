@@ -0,0 +1,337 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/earthly/earthly/util/platutil"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// SnapshotManifest maps the projects of remote references to their build contexts, exported ahead
+// of time, along with their git metadata, for them to be resolved offline. See
+// Resolver.UseSnapshotManifest.
+type SnapshotManifest struct {
+	Refs []SnapshotRef `json:"refs"`
+}
+
+// SnapshotRef is the resolution of the project of remote references recorded in a SnapshotManifest.
+type SnapshotRef struct {
+	// Ref is the project, in canonical form (e.g. github.com/earthly/earthly/examples:main).
+	Ref string `json:"ref"`
+	// Context is the path of the tar of the build context of the project, as written by
+	// Resolver.ExportContext. Relative paths are relative to the directory of the manifest.
+	Context string `json:"context"`
+	// Digest is the digest of the tar, checked before it is used.
+	Digest digest.Digest `json:"digest"`
+	// Metadata is the git metadata of the project.
+	Metadata *gitutil.GitMetadata `json:"metadata"`
+}
+
+// ErrNotInSnapshot is returned when resolving a remote reference whose project is missing from the
+// snapshot manifest in use.
+type ErrNotInSnapshot struct {
+	// Ref is the canonical form of the project reference (repo, subdirectory and ref).
+	Ref string
+}
+
+// Error is function required by error interface.
+func (err ErrNotInSnapshot) Error() string {
+	return fmt.Sprintf("%s is not in the snapshot manifest", err.Ref)
+}
+
+// ErrSnapshotDigestMismatch is returned when the build context of a project of the snapshot
+// manifest does not have the digest recorded in the manifest.
+type ErrSnapshotDigestMismatch struct {
+	// Ref is the canonical form of the project reference (repo, subdirectory and ref).
+	Ref string
+	// Expected is the digest recorded in the manifest, and Actual the one of the build context.
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+// Error is function required by error interface.
+func (err ErrSnapshotDigestMismatch) Error() string {
+	return fmt.Sprintf("the build context of %s has digest %s, rather than %s as per the snapshot manifest", err.Ref, err.Actual, err.Expected)
+}
+
+// UseSnapshotManifest switches the resolver to serving remote references entirely out of the
+// JSON-encoded SnapshotManifest at manifestPath, with neither network access nor git: their build
+// contexts are extracted from the tars of the manifest, once their digest is checked, and their
+// git metadata is the one recorded. Remote references missing from the manifest fail to resolve,
+// with ErrNotInSnapshot.
+func (r *Resolver) UseSnapshotManifest(manifestPath string) error {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return errors.Wrap(err, "read snapshot manifest")
+	}
+	var manifest SnapshotManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return errors.Wrap(err, "parse snapshot manifest")
+	}
+	refs := make(map[string]SnapshotRef, len(manifest.Refs))
+	for i, sr := range manifest.Refs {
+		if sr.Ref == "" || sr.Context == "" || sr.Metadata == nil {
+			return errors.Errorf("snapshot manifest entry %d: missing ref, context or metadata", i)
+		}
+		err := sr.Digest.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "snapshot manifest entry %d: invalid digest of %s", i, sr.Ref)
+		}
+		if !filepath.IsAbs(sr.Context) {
+			sr.Context = filepath.Join(filepath.Dir(manifestPath), filepath.FromSlash(sr.Context))
+		}
+		refs[sr.Ref] = sr
+	}
+	r.gr.snapshotRefs = refs
+	return nil
+}
+
+// resolveSnapshotProject resolves a remote reference out of the snapshot manifest. The build context
+// is served from a local directory, as for local references.
+func (gr *gitResolver) resolveSnapshotProject(ctx context.Context, platr *platutil.Resolver, ref domain.Reference, featureFlagOverrides string) (*Data, error) {
+	sr, dir, err := gr.snapshotContext(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	bf, err := gr.snapshotBuildFile(ctx, ref, dir, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	err = gr.verifyBuildFileDigest(ref, bf.path)
+	if err != nil {
+		return nil, err
+	}
+	gitMeta := sr.Metadata.Clone()
+	if gr.metadataTransform != nil {
+		err := gr.metadataTransform(gitMeta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "transform git metadata of %s", ref.String())
+		}
+	}
+	d := &Data{
+		BuildFilePath:     bf.path,
+		BuildFileLocation: bf.location,
+		GitMetadata:       gitMeta,
+		Features:          bf.ftrs,
+	}
+	if _, isTarget := ref.(domain.Target); isTarget {
+		d.BuildContextFactory = llbfactory.Local(
+			dir,
+			llb.SessionID(gr.sessionID),
+			llb.Platform(platr.LLBNative()),
+			llb.WithCustomNamef("[context %s] snapshot context %s", ref.String(), ref.ProjectCanonical()),
+		)
+		d.LocalDirs = map[string]string{dir: dir}
+	}
+	// Else not needed: Commands don't come with a build context.
+	return d, nil
+}
+
+// snapshotContext returns the entry of the snapshot manifest of the project of ref, and the
+// directory its build context is extracted to, once per project.
+func (gr *gitResolver) snapshotContext(ctx context.Context, ref domain.Reference) (SnapshotRef, string, error) {
+	sr, ok := gr.snapshotRefs[ref.ProjectCanonical()]
+	if !ok {
+		return SnapshotRef{}, "", ErrNotInSnapshot{Ref: ref.ProjectCanonical()}
+	}
+	dirValue, err := gr.snapshotCache.Do(ctx, "context#"+sr.Ref, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		actual, err := fileDigest(sr.Context, sr.Digest.Algorithm())
+		if err != nil {
+			return nil, err
+		}
+		if actual != sr.Digest {
+			return nil, ErrSnapshotDigestMismatch{
+				Ref:      sr.Ref,
+				Expected: sr.Digest,
+				Actual:   actual,
+			}
+		}
+		dir, err := os.MkdirTemp(os.TempDir(), "earthly-snapshot")
+		if err != nil {
+			return nil, errors.Wrap(err, "create temp dir for snapshot context")
+		}
+		gr.cleanCollection.Add(func() error {
+			return os.RemoveAll(dir)
+		})
+		err = extractTar(sr.Context, dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extract the snapshot context of %s", sr.Ref)
+		}
+		return dir, nil
+	})
+	if err != nil {
+		return SnapshotRef{}, "", err
+	}
+	return sr, dirValue.(string), nil
+}
+
+// snapshotBuildFile detects the build file of ref within the directory of its snapshot context, and
+// copies it to the build file filesystem, once per project (or Dockerfile).
+func (gr *gitResolver) snapshotBuildFile(ctx context.Context, ref domain.Reference, dir string, featureFlagOverrides string) (*buildFile, error) {
+	key := "buildfile#" + ref.ProjectCanonical()
+	isDockerfile := strings.HasPrefix(ref.GetName(), DockerfileMetaTarget)
+	if isDockerfile {
+		// Different key for dockerfiles to include the dockerfile name itself.
+		key = "buildfile#" + ref.StringCanonical()
+	}
+	bfValue, err := gr.snapshotCache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		bf, found, err := detectBuildFile(ref, dir, gr.buildFileNames)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) > 1 {
+			gr.warn(ref, WarningMultipleBuildFiles, "%s", multipleBuildFilesWarning(ref.ProjectCanonical(), found))
+		}
+		bfBytes, err := os.ReadFile(bf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read build file %s", bf)
+		}
+		if !isDockerfile && !gr.preserveLineEndings {
+			bfBytes = normalizeLineEndings(bfBytes)
+		}
+		earthfileTmpDir, err := gr.buildFileFS.MkdirTemp("earthly-snapshot")
+		if err != nil {
+			return nil, errors.Wrap(err, "create temp dir for Earthfile")
+		}
+		gr.cleanCollection.Add(func() error {
+			return gr.buildFileFS.RemoveAll(earthfileTmpDir)
+		})
+		localBuildFilePath := filepath.Join(earthfileTmpDir, filepath.Base(bf))
+		err = gr.buildFileFS.WriteFile(localBuildFilePath, bfBytes, 0700)
+		if err != nil {
+			return nil, errors.Wrapf(err, "write build file to tmp dir at %s", localBuildFilePath)
+		}
+		var ftrs *features.Features
+		if isDockerfile {
+			ftrs = new(features.Features)
+		} else {
+			ftrs, err = parseFeatures(gr.buildFileFS, localBuildFilePath, featureFlagOverrides, ref.ProjectCanonical(), gr.console)
+			if err != nil {
+				return nil, err
+			}
+			err = gr.checkMinEarthfileVersion(ref.ProjectCanonical(), ftrs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		location, err := filepath.Rel(dir, bf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "locate build file %s", bf)
+		}
+		return &buildFile{
+			path:     localBuildFilePath,
+			location: filepath.ToSlash(location),
+			ftrs:     ftrs,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bfValue.(*buildFile), nil
+}
+
+// fileDigest returns the digest of the file at p, as per the given algorithm.
+func fileDigest(p string, algorithm digest.Algorithm) (digest.Digest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", p)
+	}
+	defer f.Close()
+	d, err := algorithm.FromReader(f)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s", p)
+	}
+	return d, nil
+}
+
+// extractTar extracts the directories, regular files, symlinks and hardlinks of the tar at p into
+// dir. Entries are kept within dir, and none may be written through a symlink. Hardlinks may only
+// point to regular files extracted before them.
+func extractTar(p, dir string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", p)
+	}
+	defer f.Close()
+	symlinks := make(map[string]bool)
+	files := make(map[string]bool)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read %s", p)
+		}
+		name := cleanTarPath(hdr.Name)
+		if name == "" || name == "." {
+			continue
+		}
+		for parent := name; parent != "."; parent = path.Dir(parent) {
+			if symlinks[parent] {
+				return errors.Errorf("%s would be written through symlink %s", name, parent)
+			}
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if hdr.Typeflag != tar.TypeDir {
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err != nil {
+				return errors.Wrapf(err, "create parent dir of %s", name)
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			files[name] = true
+			err = writeTarFile(tr, target, os.FileMode(hdr.Mode).Perm())
+		case tar.TypeSymlink:
+			symlinks[name] = true
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeLink:
+			linkName := cleanTarPath(hdr.Linkname)
+			if !files[linkName] {
+				return errors.Errorf("%s links to %s, which is not a regular file of the tar", name, hdr.Linkname)
+			}
+			files[name] = true
+			err = os.Link(filepath.Join(dir, filepath.FromSlash(linkName)), target)
+		default:
+			// Devices, sockets and pipes have no place in a build context.
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "extract %s", name)
+		}
+	}
+}
+
+// writeTarFile writes the content of the current entry of tr to the file at target.
+func writeTarFile(tr *tar.Reader, target string, perm os.FileMode) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, tr)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
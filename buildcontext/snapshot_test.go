@@ -0,0 +1,168 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+// writeTestTar writes the given files to a tar at p, returning its digest.
+func writeTestTar(t *testing.T, p string, files map[string]string) digest.Digest {
+	f, err := os.Create(p)
+	NoError(t, err)
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}))
+		_, err = tw.Write([]byte(content))
+		NoError(t, err)
+	}
+	NoError(t, tw.Close())
+	NoError(t, f.Close())
+	dt, err := os.ReadFile(p)
+	NoError(t, err)
+	return digest.FromBytes(dt)
+}
+
+func TestResolveSnapshotManifest(t *testing.T) {
+	dir := t.TempDir()
+	ctxDigest := writeTestTar(t, filepath.Join(dir, "sub.tar"), map[string]string{
+		"Earthfile": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"main.go":   "package main\n",
+	})
+	meta := &gitutil.GitMetadata{
+		RemoteURL: "https://github.com/earthly/test.git",
+		RelDir:    "sub",
+		Hash:      "2f3b0f1d9c8e7a6b5c4d3e2f1a0b9c8d7e6f5a4b",
+		ShortHash: "2f3b0f1d",
+		Branch:    []string{"main"},
+		Timestamp: "1650000000",
+		Author:    "test@example.com",
+	}
+	writeManifest := func(d digest.Digest) string {
+		manifest := SnapshotManifest{Refs: []SnapshotRef{{
+			Ref:      "github.com/earthly/test/sub:main",
+			Context:  "sub.tar",
+			Digest:   d,
+			Metadata: meta,
+		}}}
+		dt, err := json.Marshal(manifest)
+		NoError(t, err)
+		p := filepath.Join(dir, "manifest.json")
+		NoError(t, os.WriteFile(p, dt, 0644))
+		return p
+	}
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:main+build")
+	NoError(t, err)
+
+	r := newTestResolver(t, ResolverOpt{})
+	NoError(t, r.UseSnapshotManifest(writeManifest(ctxDigest)))
+	gwClient := newTestGwClient(nil)
+	d, err := r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+	NoError(t, err, "Resolve failed")
+	// Neither cloned nor otherwise solved.
+	Empty(t, gwClient.solves)
+	Equal(t, meta, d.GitMetadata)
+	Equal(t, "Earthfile", d.BuildFileLocation)
+	Len(t, d.Earthfile.Targets, 1)
+	localFactory, ok := d.BuildContextFactory.(*llbfactory.LocalFactory)
+	if True(t, ok, "unexpected build context %T", d.BuildContextFactory) {
+		contextDir := localFactory.GetName()
+		Equal(t, map[string]string{contextDir: contextDir}, d.LocalDirs)
+		dt, err := os.ReadFile(filepath.Join(contextDir, "main.go"))
+		NoError(t, err)
+		Equal(t, "package main\n", string(dt))
+	}
+
+	// References missing from the manifest are not resolved otherwise.
+	other, err := domain.ParseTarget("github.com/earthly/test/other:main+build")
+	NoError(t, err)
+	_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), other)
+	var notInSnapshotErr ErrNotInSnapshot
+	True(t, errors.As(err, &notInSnapshotErr), "unexpected error %v", err)
+	Equal(t, "github.com/earthly/test/other:main", notInSnapshotErr.Ref)
+	Empty(t, gwClient.solves)
+
+	// The build context does not match the manifest.
+	r = newTestResolver(t, ResolverOpt{})
+	wrong := digest.FromString("something else")
+	NoError(t, r.UseSnapshotManifest(writeManifest(wrong)))
+	_, err = r.Resolve(context.Background(), newTestGwClient(nil), newTestPlatformResolver(), ref)
+	var mismatchErr ErrSnapshotDigestMismatch
+	True(t, errors.As(err, &mismatchErr), "unexpected error %v", err)
+	Equal(t, ErrSnapshotDigestMismatch{Ref: "github.com/earthly/test/sub:main", Expected: wrong, Actual: ctxDigest}, mismatchErr)
+
+	// The build file of the snapshot context is verified as the cloned ones are.
+	tampered := digest.FromString("VERSION 0.6\n")
+	r = newTestResolver(t, ResolverOpt{BuildFileDigests: map[string]digest.Digest{
+		"github.com/earthly/test/sub:main": tampered,
+	}})
+	NoError(t, r.UseSnapshotManifest(writeManifest(ctxDigest)))
+	_, err = r.Resolve(context.Background(), newTestGwClient(nil), newTestPlatformResolver(), ref)
+	var buildFileMismatchErr ErrBuildFileDigestMismatch
+	True(t, errors.As(err, &buildFileMismatchErr), "unexpected error %v", err)
+	Equal(t, ErrBuildFileDigestMismatch{
+		Ref:      "github.com/earthly/test/sub:main",
+		Expected: tampered,
+		Actual:   digest.FromString("VERSION 0.6\n\nbuild:\n\tFROM alpine\n"),
+	}, buildFileMismatchErr)
+}
+
+func TestExtractTarSymlinks(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "context.tar")
+	f, err := os.Create(p)
+	NoError(t, err)
+	tw := tar.NewWriter(f)
+	NoError(t, tw.WriteHeader(&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}))
+	NoError(t, tw.WriteHeader(&tar.Header{Name: "escape/file", Typeflag: tar.TypeReg, Mode: 0644}))
+	NoError(t, tw.Close())
+	NoError(t, f.Close())
+	err = extractTar(p, t.TempDir())
+	if Error(t, err) {
+		Contains(t, err.Error(), "escape/file would be written through symlink escape")
+	}
+}
+
+func TestExtractTarHardlinks(t *testing.T) {
+	writeTar := func(t *testing.T, linkname string) string {
+		p := filepath.Join(t.TempDir(), "context.tar")
+		f, err := os.Create(p)
+		NoError(t, err)
+		tw := tar.NewWriter(f)
+		NoError(t, tw.WriteHeader(&tar.Header{Name: "main.go", Typeflag: tar.TypeReg, Mode: 0644, Size: 13}))
+		_, err = tw.Write([]byte("package main\n"))
+		NoError(t, err)
+		NoError(t, tw.WriteHeader(&tar.Header{Name: "cmd/main.go", Typeflag: tar.TypeLink, Linkname: linkname}))
+		NoError(t, tw.Close())
+		NoError(t, f.Close())
+		return p
+	}
+
+	dir := t.TempDir()
+	NoError(t, extractTar(writeTar(t, "main.go"), dir))
+	dt, err := os.ReadFile(filepath.Join(dir, "cmd", "main.go"))
+	NoError(t, err)
+	Equal(t, "package main\n", string(dt))
+
+	// Links to anything but a file of the tar, e.g. out of the context, are refused.
+	for _, linkname := range []string{"/etc/passwd", "../../etc/passwd", "cmd"} {
+		err = extractTar(writeTar(t, linkname), t.TempDir())
+		if Error(t, err, linkname) {
+			Contains(t, err.Error(), "cmd/main.go links to "+linkname+", which is not a regular file of the tar")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package buildcontext
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/platutil"
+	"github.com/earthly/earthly/util/syncutil/synccache"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// stateSource resolves the references whose project is read out of an llb state, rather than
+// cloned from git or read from the local filesystem: remote archives and OCI images. Their build
+// file and ignore file are read out of the state, and their build context is copied out of it.
+// They come with no git metadata.
+type stateSource struct {
+	cleanCollection     *cleanup.Collection
+	buildFileCache      *synccache.SyncCache // project (or dockerfile) -> *buildFile
+	buildFileFS         BuildFileFS
+	buildFileNames      []string
+	preserveLineEndings bool
+	console             conslogging.ConsoleLogger
+	onWarning           func(Warning)
+}
+
+func (ss *stateSource) warn(ref domain.Reference, code WarningCode, format string, args ...interface{}) {
+	warn(ss.console, ss.onWarning, ref, code, format, args...)
+}
+
+// resolve resolves ref, whose project is the subDir directory of state. The source (e.g. "archive
+// https://example.com/project.tar.gz") describes the state in errors.
+func (ss *stateSource) resolve(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, state pllb.State, subDir, source string, contextPlatform platutil.Platform, featureFlagOverrides string) (*Data, error) {
+	bf, err := ss.resolveBuildFile(ctx, gwClient, platr, ref, state, subDir, source, featureFlagOverrides)
+	if err != nil {
+		return nil, err
+	}
+	d := &Data{
+		BuildFilePath:     bf.path,
+		BuildFileLocation: bf.location,
+		Features:          bf.ftrs,
+	}
+	if _, isTarget := ref.(domain.Target); isTarget {
+		// Unlike git repositories, these are applied their ignore files, as local directories are.
+		d.ExcludePatterns = bf.excludes
+		copyBase := platr.Scratch()
+		if contextPlatform != platutil.DefaultPlatform {
+			copyBase = pllb.Scratch().Platform(platr.ToLLBPlatform(contextPlatform))
+		}
+		d.BuildContextFactory = llbfactory.PreconstructedState(llbutil.CopyDirContentsOp(
+			state, subDir, copyBase, "./", "root:root", patternsOf(bf.excludes),
+			llb.WithCustomNamef("[internal] COPY context %s", ref.String())))
+	}
+	// Else not needed: Commands don't come with a build context.
+	return d, nil
+}
+
+// resolveBuildFile reads the build file of ref out of the subDir directory of state, along with the
+// exclude patterns of its ignore file, and parses its features. The result is cached per project
+// (or Dockerfile).
+func (ss *stateSource) resolveBuildFile(ctx context.Context, gwClient gwclient.Client, platr *platutil.Resolver, ref domain.Reference, state pllb.State, subDir, source string, featureFlagOverrides string) (*buildFile, error) {
+	key := ref.ProjectCanonical()
+	isDockerfile := strings.HasPrefix(ref.GetName(), DockerfileMetaTarget)
+	if isDockerfile {
+		// Different key for dockerfiles to include the dockerfile name itself.
+		key = ref.StringCanonical()
+	}
+	bfValue, err := ss.buildFileCache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		stateRef, err := llbutil.StateToRef(
+			ctx, gwClient, state, false,
+			platr.SubResolver(platutil.NativePlatform), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", source)
+		}
+		bf, found, err := detectBuildFileInRef(ctx, ref, stateRef, subDir, nil, false, ss.buildFileNames)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) > 1 {
+			ss.warn(ref, WarningMultipleBuildFiles, "%s", multipleBuildFilesWarning(ref.ProjectCanonical(), found))
+		}
+		bfBytes, err := stateRef.ReadFile(ctx, gwclient.ReadRequest{
+			Filename: bf,
+		})
+		if err != nil {
+			return nil, newBuildFileReadError(ref, bf, err)
+		}
+		if !isDockerfile && !ss.preserveLineEndings {
+			bfBytes = normalizeLineEndings(bfBytes)
+		}
+		earthfileTmpDir, err := ss.buildFileFS.MkdirTemp("earthly-state-source")
+		if err != nil {
+			return nil, errors.Wrap(err, "create temp dir for Earthfile")
+		}
+		ss.cleanCollection.Add(func() error {
+			return ss.buildFileFS.RemoveAll(earthfileTmpDir)
+		})
+		localBuildFilePath := filepath.Join(earthfileTmpDir, path.Base(bf))
+		err = ss.buildFileFS.WriteFile(localBuildFilePath, bfBytes, 0700)
+		if err != nil {
+			return nil, errors.Wrapf(err, "write build file to tmp dir at %s", localBuildFilePath)
+		}
+		var ftrs *features.Features
+		if isDockerfile {
+			ftrs = new(features.Features)
+		} else {
+			ftrs, err = parseFeatures(ss.buildFileFS, localBuildFilePath, featureFlagOverrides, ref.ProjectCanonical(), ss.console)
+			if err != nil {
+				return nil, err
+			}
+		}
+		excludes, err := readRefExcludePatterns(ctx, stateRef, subDir, ftrs.NoImplicitIgnore)
+		if err != nil {
+			return nil, err
+		}
+		return &buildFile{
+			path:     localBuildFilePath,
+			location: bf,
+			ftrs:     ftrs,
+			excludes: excludes,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bfValue.(*buildFile), nil
+}
@@ -0,0 +1,86 @@
+package buildcontext
+
+import (
+	"fmt"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+)
+
+// WarningCode identifies the condition a Warning is about.
+type WarningCode string
+
+const (
+	// WarningMultipleBuildFiles is emitted when the directory of a target holds several build files.
+	WarningMultipleBuildFiles WarningCode = "multiple-build-files"
+	// WarningLegacyBuildFile is emitted when the build file of a remote target is a deprecated
+	// build.earth file.
+	WarningLegacyBuildFile WarningCode = "legacy-build-file"
+	// WarningCaseCollision is emitted when the build context of a remote target holds paths which
+	// only differ in case.
+	WarningCaseCollision WarningCode = "case-collision"
+	// WarningLFSPointers is emitted when the build context of a remote target holds git LFS
+	// pointer files.
+	WarningLFSPointers WarningCode = "lfs-pointers"
+	// WarningTagMoved is emitted when a tag of a remote repository points at another commit than
+	// it used to.
+	WarningTagMoved WarningCode = "tag-moved"
+	// WarningDefaultBranchMismatch is emitted when the default branch of a remote repository is
+	// not the expected one.
+	WarningDefaultBranchMismatch WarningCode = "default-branch-mismatch"
+	// WarningStaleHead is emitted when the HEAD of a remote repository points at a nonexistent
+	// branch, and the default branch falls back to another one.
+	WarningStaleHead WarningCode = "stale-head"
+	// WarningFutureTimestamp is emitted when the commit of a remote reference is dated in the
+	// future.
+	WarningFutureTimestamp WarningCode = "future-timestamp"
+	// WarningCommitEncoding is emitted when the commit of a remote reference declares an encoding
+	// its text cannot be decoded out of.
+	WarningCommitEncoding WarningCode = "commit-encoding"
+	// WarningCredentialExpiry is emitted when the git credentials of a remote reference are about
+	// to expire.
+	WarningCredentialExpiry WarningCode = "credential-expiry"
+	// WarningCloneFallback is emitted when cloning a remote repository failed, and another url of
+	// it is tried.
+	WarningCloneFallback WarningCode = "clone-fallback"
+	// WarningShallowMetadata is emitted when the git metadata of a remote reference is extracted
+	// out of a shallow clone which, even deepened, has no tag reachable from the commit.
+	WarningShallowMetadata WarningCode = "shallow-metadata"
+	// WarningNoGitBinary is emitted when the git metadata of a local reference cannot be read, for
+	// git is not installed.
+	WarningNoGitBinary WarningCode = "no-git-binary"
+	// WarningBuildFileCasing is emitted when the build file of a local reference is found under
+	// another casing than its own, which only works on case-insensitive filesystems.
+	WarningBuildFileCasing WarningCode = "build-file-casing"
+)
+
+// Warning is a condition met while resolving a reference, which does not fail the resolution.
+type Warning struct {
+	// Code identifies the condition.
+	Code WarningCode
+	// Message describes it, as it is logged.
+	Message string
+	// Ref is the reference being resolved.
+	Ref domain.Reference
+}
+
+// warn logs the warning to the console, and passes it to onWarning, if set.
+func warn(console conslogging.ConsoleLogger, onWarning func(Warning), ref domain.Reference, code WarningCode, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	console.Warnf("Warning: %s\n", msg)
+	if onWarning != nil {
+		onWarning(Warning{
+			Code:    code,
+			Message: msg,
+			Ref:     ref,
+		})
+	}
+}
+
+func (gr *gitResolver) warn(ref domain.Reference, code WarningCode, format string, args ...interface{}) {
+	warn(gr.console, gr.onWarning, ref, code, format, args...)
+}
+
+func (lr *localResolver) warn(ref domain.Reference, code WarningCode, format string, args ...interface{}) {
+	warn(lr.console, lr.onWarning, ref, code, format, args...)
+}
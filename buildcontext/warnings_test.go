@@ -0,0 +1,63 @@
+package buildcontext
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestResolveOnWarning(t *testing.T) {
+	const (
+		oldHash = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c"
+		newHash = "a7b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5"
+	)
+	ref, err := domain.ParseTarget("github.com/earthly/test/sub:v1.0.0+build")
+	NoError(t, err)
+	recordCache := newFakeCache()
+	NoError(t, recordCache.Add(context.Background(), "tag#https://github.com/earthly/test.git#v1.0.0", oldHash, nil))
+	var (
+		mu       sync.Mutex
+		warnings []Warning
+	)
+	r := newTestResolver(t, ResolverOpt{
+		RecordCache:    recordCache,
+		TagChecks:      TagChecksOpt{DetectMoved: true},
+		BuildFileNames: []string{"build.earth", "Earthfile"},
+		MaxDeepenDepth: 16,
+		OnWarning: func(w Warning) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, w)
+		},
+	})
+	gwClient := newTestGwClient(map[string]string{
+		"sub/Earthfile":   "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"sub/build.earth": "VERSION 0.6\n\nbuild:\n\tFROM alpine\n",
+		"git-hash":        newHash + "\n",
+		gitShallowFile:    "true\n",
+		gitDescribeFile:   "",
+	})
+	for i := 0; i < 2; i++ {
+		_, err = r.Resolve(context.Background(), gwClient, newTestPlatformResolver(), ref)
+		NoError(t, err, "Resolve failed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var codes []WarningCode
+	for _, w := range warnings {
+		codes = append(codes, w.Code)
+		Equal(t, ref, w.Ref)
+		NotEmpty(t, w.Message)
+	}
+	// Resolving again out of the cache does not repeat them.
+	ElementsMatch(t, []WarningCode{
+		WarningShallowMetadata,
+		WarningTagMoved,
+		WarningMultipleBuildFiles,
+		WarningLegacyBuildFile,
+	}, codes)
+}
@@ -78,6 +78,10 @@ type Opt struct {
 	InternalSecretStore                   *secretprovider.MutableMapStore
 	InteractiveDebugging                  bool
 	InteractiveDebuggingDebugLevelLogging bool
+	GitCloneDepth                         int
+	GitCloneDepths                        map[string]int
+	GitImage                              string
+	GitLFSImage                           string
 }
 
 // BuildOpt is a collection of build options.
@@ -123,7 +127,16 @@ func NewBuilder(ctx context.Context, opt Opt) (*Builder, error) {
 		opt:      opt,
 		resolver: nil, // initialized below
 	}
-	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup, opt.Console, opt.FeatureFlagOverrides)
+	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup, opt.Console, opt.FeatureFlagOverrides, buildcontext.ResolverOpt{
+		InternalSecretStore: opt.InternalSecretStore,
+		GitCloneDepth:       opt.GitCloneDepth,
+		GitCloneDepths:      opt.GitCloneDepths,
+		GitImage:            buildcontext.GitImageOpt{Image: opt.GitImage},
+		LFS:                 buildcontext.LFSOpt{Image: opt.GitLFSImage},
+		// The resolver lives for a single build, throughout which each ref must resolve to the
+		// same commit.
+		CacheTTL: buildcontext.CacheForever,
+	})
 	return b, nil
 }
 
@@ -389,6 +389,24 @@ func (app *earthlyApp) actionBuildImp(cliCtx *cli.Context, flagArgs, nonFlagArgs
 		}
 		localRegistryAddr = lrURL.Host
 	}
+	gitCloneDepth := app.cfg.Global.GitCloneDepth
+	if cliCtx.IsSet("git-clone-depth") {
+		gitCloneDepth = app.gitCloneDepth
+	}
+	gitImage := app.cfg.Global.GitImage
+	if cliCtx.IsSet("git-image") {
+		gitImage = app.gitImage
+	}
+	gitLFSImage := app.cfg.Global.GitLFSImage
+	if cliCtx.IsSet("git-lfs-image") {
+		gitLFSImage = app.gitLFSImage
+	}
+	gitCloneDepths := make(map[string]int)
+	for k, v := range app.cfg.Git {
+		if v.Depth != nil {
+			gitCloneDepths[k] = *v.Depth
+		}
+	}
 	builderOpts := builder.Opt{
 		BkClient:                              bkClient,
 		Console:                               app.console,
@@ -418,6 +436,10 @@ func (app *earthlyApp) actionBuildImp(cliCtx *cli.Context, flagArgs, nonFlagArgs
 		InternalSecretStore:                   internalSecretStore,
 		InteractiveDebugging:                  app.interactiveDebugging,
 		InteractiveDebuggingDebugLevelLogging: app.debug,
+		GitCloneDepth:                         gitCloneDepth,
+		GitCloneDepths:                        gitCloneDepths,
+		GitImage:                              gitImage,
+		GitLFSImage:                           gitLFSImage,
 	}
 	b, err := builder.NewBuilder(cliCtx.Context, builderOpts)
 	if err != nil {
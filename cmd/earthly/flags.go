@@ -321,6 +321,24 @@ func (app *earthlyApp) buildFlags() []cli.Flag {
 			Destination: &app.noFakeDep,
 			Hidden:      true, // Internal.
 		},
+		&cli.IntFlag{
+			Name:        "git-clone-depth",
+			EnvVars:     []string{"EARTHLY_GIT_CLONE_DEPTH"},
+			Usage:       "The depth of the history of the clones of remote repositories; 0 means the full history",
+			Destination: &app.gitCloneDepth,
+		},
+		&cli.StringFlag{
+			Name:        "git-image",
+			EnvVars:     []string{"EARTHLY_GIT_IMAGE"},
+			Usage:       "The image used to run git when resolving remote targets, e.g. a copy of alpine/git in an internal registry",
+			Destination: &app.gitImage,
+		},
+		&cli.StringFlag{
+			Name:        "git-lfs-image",
+			EnvVars:     []string{"EARTHLY_GIT_LFS_IMAGE"},
+			Usage:       "The image used to fetch the git LFS objects of remote targets, e.g. a copy of alpine/git in an internal registry",
+			Destination: &app.gitLFSImage,
+		},
 		&cli.BoolFlag{
 			Name:        "strict",
 			EnvVars:     []string{"EARTHLY_STRICT"},
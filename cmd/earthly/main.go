@@ -92,6 +92,9 @@ type cliFlags struct {
 	gitUsernameOverride       string
 	gitPasswordOverride       string
 	interactiveDebugging      bool
+	gitCloneDepth             int
+	gitImage                  string
+	gitLFSImage               string
 	sshAuthSock               string
 	verbose                   bool
 	dryRun                    bool
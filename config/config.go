@@ -80,6 +80,9 @@ type GlobalConfig struct {
 	IPTables                 string   `yaml:"ip_tables"                  help:"Which iptables binary to use. Valid values are iptables-legacy or iptables-nft. Bypasses any autodetection."`
 	DisableLogSharing        bool     `yaml:"disable_log_sharing"        help:"Disable cloud log sharing when logged in with an Earthly account, see https://ci.earthly.dev for details."`
 	SecretProvider           string   `yaml:"secret_provider"            help:"Command to execute to retrieve secret."`
+	GitCloneDepth            int      `yaml:"git_clone_depth"            help:"The depth of the history of the clones of remote repositories, for those of huge repositories not to fetch all of it. 0 means the full history."`
+	GitImage                 string   `yaml:"git_image"                  help:"The image used to run git when resolving remote targets, e.g. a copy of alpine/git in an internal registry. Defaults to alpine/git."`
+	GitLFSImage              string   `yaml:"git_lfs_image"              help:"The image used to fetch the git LFS objects of remote targets, e.g. a copy of alpine/git in an internal registry. It must come with git-lfs. Defaults to alpine/git."`
 
 	// Obsolete.
 	CachePath      string `yaml:"cache_path"         help:" *Deprecated* The path to keep Earthly's cache."`
@@ -100,6 +103,7 @@ type GitConfig struct {
 	Password              string `yaml:"password"                     help:"The https password to use when auth is set to https. This setting is ignored when auth is ssh."`
 	ServerKey             string `yaml:"serverkey"                    help:"SSH fingerprints, like you would add in your known hosts file, or get from ssh-keyscan."`
 	StrictHostKeyChecking *bool  `yaml:"strict_host_key_checking"     help:"Allow ssh access to hosts with unknown server keys (e.g. no entries in known_hosts), defaults to true."`
+	Depth                 *int   `yaml:"depth"                        help:"The depth of the history of the clones of the repositories of the site, overriding the global git_clone_depth. 0 means the full history."`
 }
 
 // Satellite contains satellite config values
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// archiveURLPrefix is the prefix of the references to remote archives, e.g. in
+// "https://example.com/project.tar.gz+target". Those of git repositories may also carry it, and
+// are told apart by isArchiveURL.
+const archiveURLPrefix = "https://"
+
+// archiveSuffixes are the extensions of the archives which buildkit unpacks.
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz"}
+
+// archiveDigestPrefix is the prefix of the fragment of the url of a remote archive holding the
+// sha256 digest of the archive, e.g. in "https://example.com/project.tar.gz#sha256=abc...".
+const archiveDigestPrefix = "sha256="
+
+// RemoteArchive is the project of a reference to a remote archive (a tarball, possibly compressed),
+// e.g. "https://example.com/project.tar.gz//project-1.0#sha256=abc..." for the project-1.0
+// directory of the archive, with the given digest.
+type RemoteArchive struct {
+	// URL is the https url of the archive, without the subdirectory nor the digest.
+	URL string
+	// SubDir is the directory of the project within the archive, "." being its root.
+	SubDir string
+	// SHA256 is the hex-encoded sha256 digest of the archive, if pinned.
+	SHA256 string
+}
+
+// IsRemoteArchive returns whether the reference is that of a remote archive, rather than of a git
+// repository.
+func IsRemoteArchive(r Reference) bool {
+	return r.IsRemote() && isArchiveURL(r.GetGitURL())
+}
+
+// isArchiveURL returns whether the project of a reference is the url of a remote archive, that is
+// an https url pinned to a digest, or whose path has the extension of an archive. Other https urls
+// are those of git repositories.
+func isArchiveURL(project string) bool {
+	if !strings.HasPrefix(project, archiveURLPrefix) {
+		return false
+	}
+	archiveURL, fragment, _ := strings.Cut(project, "#")
+	if strings.HasPrefix(fragment, archiveDigestPrefix) {
+		return true
+	}
+	rest := strings.TrimPrefix(archiveURL, archiveURLPrefix)
+	if i := strings.Index(rest, "//"); i >= 0 {
+		rest = rest[:i]
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	rest = strings.ToLower(rest)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(rest, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinArchiveURL rejoins the parts of a reference split by "+" which make up the url of a remote
+// archive, as urls (unlike target names) may contain "+".
+func joinArchiveURL(partsPlus []string) []string {
+	if len(partsPlus) <= 2 || !strings.HasPrefix(partsPlus[0], archiveURLPrefix) {
+		return partsPlus
+	}
+	archiveURL := strings.Join(partsPlus[:len(partsPlus)-1], "+")
+	if !isArchiveURL(archiveURL) {
+		return partsPlus
+	}
+	return []string{archiveURL, partsPlus[len(partsPlus)-1]}
+}
+
+// ParseRemoteArchive parses the project of a remote archive reference (its GitURL).
+func ParseRemoteArchive(project string) (RemoteArchive, error) {
+	if !strings.HasPrefix(project, archiveURLPrefix) {
+		return RemoteArchive{}, errors.Errorf("%s is not an https url", project)
+	}
+	archiveURL, fragment, hasFragment := strings.Cut(project, "#")
+	var sha256 string
+	if hasFragment {
+		if !strings.HasPrefix(fragment, archiveDigestPrefix) {
+			return RemoteArchive{}, errors.Errorf("invalid digest %s of archive %s, expected %s<hex>", fragment, archiveURL, archiveDigestPrefix)
+		}
+		sha256 = strings.TrimPrefix(fragment, archiveDigestPrefix)
+		if len(sha256) != 64 || strings.Trim(sha256, "0123456789abcdef") != "" {
+			return RemoteArchive{}, errors.Errorf("invalid sha256 digest %s of archive %s", sha256, archiveURL)
+		}
+	}
+	// The subdirectory follows a double slash, past the host.
+	subDir := "."
+	rest := strings.TrimPrefix(archiveURL, archiveURLPrefix)
+	if i := strings.Index(rest, "//"); i >= 0 {
+		subDir = path.Clean(rest[i+2:])
+		if subDir == ".." || strings.HasPrefix(subDir, "../") || path.IsAbs(subDir) {
+			return RemoteArchive{}, errors.Errorf("subdirectory %s of archive %s is out of the archive", rest[i+2:], archiveURL)
+		}
+		archiveURL = archiveURLPrefix + rest[:i]
+	}
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return RemoteArchive{}, errors.Wrapf(err, "parse archive url %s", archiveURL)
+	}
+	if u.Host == "" || u.Path == "" || u.Path == "/" {
+		return RemoteArchive{}, errors.Errorf("archive url %s has no host or path", archiveURL)
+	}
+	return RemoteArchive{
+		URL:    archiveURL,
+		SubDir: subDir,
+		SHA256: sha256,
+	}, nil
+}
+
+// String returns the project of the references to the archive, as parsed by ParseRemoteArchive.
+func (ra RemoteArchive) String() string {
+	s := ra.URL
+	if ra.SubDir != "" && ra.SubDir != "." {
+		s += "//" + ra.SubDir
+	}
+	if ra.SHA256 != "" {
+		s += fmt.Sprintf("#%s%s", archiveDigestPrefix, ra.SHA256)
+	}
+	return s
+}
+
+// joinArchivePath returns the project of the given relative path within the project of a remote
+// archive reference.
+func joinArchivePath(project string, localPath string) (string, error) {
+	ra, err := ParseRemoteArchive(project)
+	if err != nil {
+		return "", err
+	}
+	subDir := path.Join(ra.SubDir, localPath)
+	if subDir == ".." || strings.HasPrefix(subDir, "../") {
+		return "", errors.Errorf("path %s is out of archive %s", localPath, ra.URL)
+	}
+	ra.SubDir = subDir
+	return ra.String(), nil
+}
@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+var archiveDigest = strings.Repeat("ab", 32)
+
+func TestParseRemoteArchive(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out RemoteArchive
+	}{
+		{"https://example.com/foo.tar.gz", RemoteArchive{URL: "https://example.com/foo.tar.gz", SubDir: "."}},
+		{"https://example.com/foo.tar.gz//foo-1.0/", RemoteArchive{URL: "https://example.com/foo.tar.gz", SubDir: "foo-1.0"}},
+		{"https://example.com:8443/foo.tar.gz#sha256=" + archiveDigest, RemoteArchive{URL: "https://example.com:8443/foo.tar.gz", SubDir: ".", SHA256: archiveDigest}},
+		{"https://example.com/foo.tar.gz//a/b#sha256=" + archiveDigest, RemoteArchive{URL: "https://example.com/foo.tar.gz", SubDir: "a/b", SHA256: archiveDigest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := ParseRemoteArchive(tt.in)
+			NoError(t, err)
+			Equal(t, tt.out, out)
+			if !strings.HasSuffix(tt.in, "/") {
+				Equal(t, tt.in, out.String())
+			}
+		})
+	}
+}
+
+func TestParseRemoteArchiveNegative(t *testing.T) {
+	var tests = []string{
+		"http://example.com/foo.tar.gz",
+		"https://example.com",
+		"https://example.com/",
+		"https://example.com/foo.tar.gz#md5=abc",
+		"https://example.com/foo.tar.gz#sha256=abc",
+		"https://example.com/foo.tar.gz#sha256=" + strings.ToUpper(archiveDigest),
+		"https://example.com/foo.tar.gz//../bar",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			_, err := ParseRemoteArchive(tt)
+			Error(t, err)
+		})
+	}
+}
+
+func TestParseTargetHTTPS(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out Target
+	}{
+		// Urls of archives may contain "+", escaped or not.
+		{"https://example.com/foo+bar.tar.gz+target", Target{Target: "target", GitURL: "https://example.com/foo+bar.tar.gz"}},
+		{"https://example.com/foo\\+bar.tar.gz+target", Target{Target: "target", GitURL: "https://example.com/foo+bar.tar.gz"}},
+		{"https://example.com/c++/project#sha256=" + archiveDigest + "+target", Target{Target: "target", GitURL: "https://example.com/c++/project#sha256=" + archiveDigest}},
+		// Other https urls are those of git repositories.
+		{"https://github.com/foo/bar+target", Target{Target: "target", GitURL: "github.com/foo/bar"}},
+		{"https://github.com/foo/bar:tag+target", Target{Target: "target", GitURL: "github.com/foo/bar", Tag: "tag"}},
+		{"https://example.com/foo.tar.gz.d/bar+target", Target{Target: "target", GitURL: "example.com/foo.tar.gz.d/bar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := ParseTarget(tt.in)
+			NoError(t, err)
+			Equal(t, tt.out, out)
+			Equal(t, IsRemoteArchive(out), strings.HasPrefix(out.GitURL, archiveURLPrefix))
+			// The reference is parsed back from its string.
+			again, err := ParseTarget(out.String())
+			NoError(t, err)
+			Equal(t, out, again)
+		})
+	}
+
+	artifact, err := ParseArtifact("https://example.com/foo+bar.tar.gz+target/art")
+	NoError(t, err)
+	Equal(t, Artifact{Target: Target{Target: "target", GitURL: "https://example.com/foo+bar.tar.gz"}, Artifact: "/art"}, artifact)
+
+	// Git urls may not contain "+" unescaped.
+	_, err = ParseTarget("https://github.com/foo/bar+baz+target")
+	Error(t, err)
+}
+
+func TestJoinReferencesArchive(t *testing.T) {
+	archive, err := ParseTarget("https://example.com/foo.tar.gz//foo#sha256=" + archiveDigest + "+target")
+	NoError(t, err)
+	True(t, IsRemoteArchive(archive))
+
+	local, err := ParseTarget("./bar+other")
+	NoError(t, err)
+	joined, err := JoinReferences(archive, local)
+	NoError(t, err)
+	Equal(t, "https://example.com/foo.tar.gz//foo/bar#sha256="+archiveDigest+"+other", joined.String())
+	True(t, IsRemoteArchive(joined))
+
+	up, err := ParseTarget("../../bar+other")
+	NoError(t, err)
+	_, err = JoinReferences(archive, up)
+	Error(t, err)
+
+	git, err := ParseTarget("github.com/foo/bar+target")
+	NoError(t, err)
+	False(t, IsRemoteArchive(git))
+}
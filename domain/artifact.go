@@ -30,6 +30,7 @@ func ParseArtifact(artifactName string) (Artifact, error) {
 	if err != nil {
 		return Artifact{}, err
 	}
+	parts = joinArchiveURL(parts)
 	if len(parts) != 2 {
 		return Artifact{}, errors.Errorf("invalid artifact name %s", artifactName)
 	}
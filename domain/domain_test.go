@@ -24,6 +24,11 @@ var targetTests = []struct {
 	{"github.com/foo/bar:tag+target", Target{Target: "target", GitURL: "github.com/foo/bar", Tag: "tag"}},
 	{"github.com/foo/bar:tag/with/slash+target", Target{Target: "target", GitURL: "github.com/foo/bar", Tag: "tag/with/slash"}},
 	{"import+target", Target{Target: "target", ImportRef: "import"}},
+	{"https://example.com/foo.tar.gz+target", Target{Target: "target", GitURL: "https://example.com/foo.tar.gz"}},
+	{"https://example.com:8443/foo.tar.gz//dir#sha256=abc+target", Target{Target: "target", GitURL: "https://example.com:8443/foo.tar.gz//dir#sha256=abc"}},
+	{"https://example.com/foo\\+bar.tar.gz+target", Target{Target: "target", GitURL: "https://example.com/foo+bar.tar.gz"}},
+	{"oci://registry.example.com:5000/foo/bar:v1+target", Target{Target: "target", GitURL: "oci://registry.example.com:5000/foo/bar:v1"}},
+	{"oci://foo/bar@sha256:abc//dir+target", Target{Target: "target", GitURL: "oci://foo/bar@sha256:abc//dir"}},
 	// \+
 	{"./a/local/dir-with-\\+-in-it+target", Target{Target: "target", LocalPath: "./a/local/dir-with-+-in-it"}},
 	{"/abs/local/dir-with-\\+-in+target", Target{Target: "target", LocalPath: "/abs/local/dir-with-+-in"}},
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// ociImagePrefix is the prefix of the references to OCI images, as opposed to git repositories,
+// e.g. in "oci://registry.example.com/platform/earthfiles:v1+target".
+const ociImagePrefix = "oci://"
+
+// RemoteImage is the project of a reference to an OCI image, e.g.
+// "oci://registry.example.com/platform/earthfiles:v1//go" for the /go directory of the image.
+type RemoteImage struct {
+	// Image is the image reference, e.g. registry.example.com/platform/earthfiles:v1, possibly
+	// pinned to a digest (@sha256:...).
+	Image string
+	// SubDir is the directory of the project within the filesystem of the image, "." being its
+	// root.
+	SubDir string
+}
+
+// IsRemoteImage returns whether the reference is that of an OCI image, rather than of a git
+// repository.
+func IsRemoteImage(r Reference) bool {
+	return r.IsRemote() && strings.HasPrefix(r.GetGitURL(), ociImagePrefix)
+}
+
+// ParseRemoteImage parses the project of an OCI image reference (its GitURL).
+func ParseRemoteImage(project string) (RemoteImage, error) {
+	if !strings.HasPrefix(project, ociImagePrefix) {
+		return RemoteImage{}, errors.Errorf("%s is not an %s reference", project, ociImagePrefix)
+	}
+	image := strings.TrimPrefix(project, ociImagePrefix)
+	// The subdirectory follows a double slash, which image references never contain.
+	subDir := "."
+	if i := strings.Index(image, "//"); i >= 0 {
+		subDir = path.Clean(image[i+2:])
+		if subDir == ".." || strings.HasPrefix(subDir, "../") || path.IsAbs(subDir) {
+			return RemoteImage{}, errors.Errorf("subdirectory %s of image %s is out of the image", image[i+2:], image[:i])
+		}
+		image = image[:i]
+	}
+	_, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return RemoteImage{}, errors.Wrapf(err, "parse image %s", image)
+	}
+	return RemoteImage{
+		Image:  image,
+		SubDir: subDir,
+	}, nil
+}
+
+// String returns the project of the references to the image, as parsed by ParseRemoteImage.
+func (ri RemoteImage) String() string {
+	s := ociImagePrefix + ri.Image
+	if ri.SubDir != "" && ri.SubDir != "." {
+		s += "//" + ri.SubDir
+	}
+	return s
+}
+
+// joinImagePath returns the project of the given relative path within the project of an OCI image
+// reference.
+func joinImagePath(project string, localPath string) (string, error) {
+	ri, err := ParseRemoteImage(project)
+	if err != nil {
+		return "", err
+	}
+	subDir := path.Join(ri.SubDir, localPath)
+	if subDir == ".." || strings.HasPrefix(subDir, "../") {
+		return "", errors.Errorf("path %s is out of image %s", localPath, ri.Image)
+	}
+	ri.SubDir = subDir
+	return ri.String(), nil
+}
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseRemoteImage(t *testing.T) {
+	imageDigest := "sha256:" + strings.Repeat("cd", 32)
+	var tests = []struct {
+		in  string
+		out RemoteImage
+	}{
+		{"oci://alpine", RemoteImage{Image: "alpine", SubDir: "."}},
+		{"oci://registry.example.com:5000/platform/earthfiles:v1", RemoteImage{Image: "registry.example.com:5000/platform/earthfiles:v1", SubDir: "."}},
+		{"oci://platform/earthfiles@" + imageDigest + "//go", RemoteImage{Image: "platform/earthfiles@" + imageDigest, SubDir: "go"}},
+		{"oci://platform/earthfiles:v1//go/lint", RemoteImage{Image: "platform/earthfiles:v1", SubDir: "go/lint"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := ParseRemoteImage(tt.in)
+			NoError(t, err)
+			Equal(t, tt.out, out)
+			Equal(t, tt.in, out.String())
+		})
+	}
+}
+
+func TestParseRemoteImageNegative(t *testing.T) {
+	var tests = []string{
+		"docker://alpine",
+		"oci://",
+		"oci://Upper/Case",
+		"oci://alpine//../etc",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			_, err := ParseRemoteImage(tt)
+			Error(t, err)
+		})
+	}
+}
+
+func TestJoinReferencesImage(t *testing.T) {
+	image, err := ParseTarget("oci://platform/earthfiles:v1//go+lint")
+	NoError(t, err)
+	True(t, IsRemoteImage(image))
+	False(t, IsRemoteArchive(image))
+
+	local, err := ParseTarget("../proto+gen")
+	NoError(t, err)
+	joined, err := JoinReferences(image, local)
+	NoError(t, err)
+	Equal(t, "oci://platform/earthfiles:v1//proto+gen", joined.String())
+
+	up, err := ParseTarget("../../proto+gen")
+	NoError(t, err)
+	_, err = JoinReferences(image, up)
+	Error(t, err)
+}
@@ -72,7 +72,18 @@ func JoinReferences(r1 Reference, r2 Reference) (Reference, error) {
 						"absolute path %s not supported as reference in external target context", r2.GetLocalPath())
 				}
 
-				gitURL = path.Join(r1.GetGitURL(), localPath)
+				var err error
+				switch {
+				case IsRemoteArchive(r1):
+					gitURL, err = joinArchivePath(r1.GetGitURL(), localPath)
+				case IsRemoteImage(r1):
+					gitURL, err = joinImagePath(r1.GetGitURL(), localPath)
+				default:
+					gitURL = path.Join(r1.GetGitURL(), localPath)
+				}
+				if err != nil {
+					return nil, err
+				}
 				localPath = ""
 			} else if r2.IsLocalInternal() {
 				gitURL = r1.GetGitURL()
@@ -175,6 +186,7 @@ func parseCommon(fullName string) (gitURL string, tag string, localPath string,
 	if err != nil {
 		return "", "", "", "", "", err
 	}
+	partsPlus = joinArchiveURL(partsPlus)
 	if len(partsPlus) != 2 {
 		return "", "", "", "", "", errors.Errorf("invalid target ref %s", fullName)
 	}
@@ -195,6 +207,16 @@ func parseCommon(fullName string) (gitURL string, tag string, localPath string,
 		return "", "", localPath, "", partsPlus[1], nil
 	}
 
+	if isArchiveURL(partsPlus[0]) {
+		// Remote archive target. The url has no tag, which would be ambiguous with its port.
+		return partsPlus[0], "", "", "", partsPlus[1], nil
+	}
+	// Any other https url is that of a git repository, which is referred to without its scheme.
+	partsPlus[0] = strings.TrimPrefix(partsPlus[0], archiveURLPrefix)
+	if strings.HasPrefix(partsPlus[0], ociImagePrefix) {
+		// OCI image target. The tag is that of the image.
+		return partsPlus[0], "", "", "", partsPlus[1], nil
+	}
 	if strings.ContainsAny(partsPlus[0], "/:") {
 		// Remote target.
 		partsColon := strings.SplitN(partsPlus[0], ":", 2)
@@ -344,7 +344,11 @@ func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPa
 	if err != nil {
 		return err
 	}
-	bcRawState, done := BuildContextFactory.Construct().RawState()
+	bcState, err := llbfactory.Construct(BuildContextFactory)
+	if err != nil {
+		return errors.Wrap(err, "construct build context for dockerfile")
+	}
+	bcRawState, done := bcState.RawState()
 	state, dfImg, _, err := dockerfile2llb.Dockerfile2LLB(ctx, dfData, dockerfile2llb.ConvertOpt{
 		BuildContext:     &bcRawState,
 		ContextLocalName: c.mts.FinalTarget().String(),
@@ -512,9 +516,12 @@ func (c *Converter) CopyClassical(ctx context.Context, srcs []string, dest strin
 	if c.ftrs.UseCopyIncludePatterns {
 		// create a new src state with the include patterns set (if this isn't done the entire context will be copied)
 		srcStateFactory := addIncludePathAndSharedKeyHint(c.buildContextFactory, srcs)
-		srcState = c.opt.LocalStateCache.getOrConstruct(srcStateFactory)
+		srcState, err = c.opt.LocalStateCache.getOrConstruct(srcStateFactory)
 	} else {
-		srcState = c.buildContextFactory.Construct()
+		srcState, err = llbfactory.Construct(c.buildContextFactory)
+	}
+	if err != nil {
+		return errors.Wrap(err, "construct build context")
 	}
 
 	c.nonSaveCommand()
@@ -27,10 +27,10 @@ func NewSharedLocalStateCache() *LocalStateCache {
 
 // getOrConstruct returns a cached pllb.State with the same sharedkey, or creates a new one
 // if it doesn't exist.
-func (lsc *LocalStateCache) getOrConstruct(factory llbfactory.Factory) pllb.State {
+func (lsc *LocalStateCache) getOrConstruct(factory llbfactory.Factory) (pllb.State, error) {
 	localFactory, ok := factory.(*llbfactory.LocalFactory)
 	if !ok {
-		return factory.Construct()
+		return llbfactory.Construct(factory)
 	}
 
 	lsc.mu.Lock()
@@ -39,12 +39,12 @@ func (lsc *LocalStateCache) getOrConstruct(factory llbfactory.Factory) pllb.Stat
 	key := localFactory.GetSharedKey()
 
 	if st, ok := lsc.cache[key]; ok {
-		return st
+		return st, nil
 	}
 
 	st := factory.Construct()
 	lsc.cache[key] = st
-	return st
+	return st, nil
 }
 
 func getSharedKeyHintFromInclude(name string, incl []string) string {
@@ -0,0 +1,84 @@
+package gitutil
+
+import (
+	"strings"
+)
+
+// CoAuthor is a co-author of a commit, as parsed out of a commit message trailer such as
+// "Co-authored-by: Jane Doe <jane@example.com>".
+type CoAuthor struct {
+	// Name and Email are those of the co-author. Both are empty when the trailer could not be
+	// parsed, in which case only Raw is set. Name may be empty on its own.
+	Name  string
+	Email string
+	// Raw is the value of the trailer, as is.
+	Raw string
+}
+
+// Parsed returns whether the trailer of the co-author could be parsed into its name and email.
+func (ca CoAuthor) Parsed() bool {
+	return ca.Email != ""
+}
+
+// ParseCoAuthorTrailersWithKeys returns the co-authors of a git body, out of the trailers with any
+// of the given keys (without the trailing colon), in order. Trailers whose value is not of the
+// form "Name <email>" are kept with their raw value only. Co-authors are listed once, by email or,
+// when unparsed, by raw value.
+func ParseCoAuthorTrailersWithKeys(body string, keys []string) []CoAuthor {
+	keySet := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		keySet[k] = struct{}{}
+	}
+	coAuthors := []CoAuthor{}
+	seen := map[string]struct{}{}
+	for _, s := range strings.Split(body, "\n") {
+		s = strings.TrimSpace(s)
+		i := strings.IndexByte(s, ':')
+		if i < 0 {
+			continue
+		}
+		if _, ok := keySet[s[:i]]; !ok {
+			continue
+		}
+		raw := strings.TrimSpace(s[i+1:])
+		if raw == "" {
+			continue
+		}
+		ca := parseCoAuthor(raw)
+		id := ca.Email
+		if !ca.Parsed() {
+			id = raw
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		coAuthors = append(coAuthors, ca)
+	}
+	return coAuthors
+}
+
+// parseCoAuthor parses the value of a co-author trailer, of the form "Name <email>", where the name
+// may be quoted.
+func parseCoAuthor(raw string) CoAuthor {
+	ca := CoAuthor{Raw: raw}
+	lt := strings.LastIndexByte(raw, '<')
+	if lt < 0 || !strings.HasSuffix(raw, ">") {
+		return ca
+	}
+	email := raw[lt+1 : len(raw)-1]
+	at := strings.IndexByte(email, '@')
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t<>") {
+		return ca
+	}
+	name := strings.TrimSpace(raw[:lt])
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		name = strings.ReplaceAll(name[1:len(name)-1], `\"`, `"`)
+	}
+	if strings.ContainsAny(name, "<>") {
+		return ca
+	}
+	ca.Name = name
+	ca.Email = email
+	return ca
+}
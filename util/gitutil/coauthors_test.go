@@ -0,0 +1,36 @@
+package gitutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseCoAuthorTrailersWithKeys(t *testing.T) {
+	body := "Fix the thing\n\n" +
+		"Co-authored-by: Jane Doe <jane@example.com>\n" +
+		"Co-authored-by: \"Doe, John\" <john@example.com>\n" +
+		"Co-authored-by: <anonymous@example.com>\n" +
+		"Co-authored-by: Jane D. <jane@example.com>\n" +
+		"Co-authored-by: Just A Name\n" +
+		"Co-authored-by: Broken <broken@example.com\n" +
+		"Co-authored-by: Not An Email <nobody>\n" +
+		"Co-authored-by:\n" +
+		"Reviewed-by: Reviewer <reviewer@example.com>\n"
+	Equal(t, []CoAuthor{
+		{Name: "Jane Doe", Email: "jane@example.com", Raw: "Jane Doe <jane@example.com>"},
+		{Name: "Doe, John", Email: "john@example.com", Raw: "\"Doe, John\" <john@example.com>"},
+		{Email: "anonymous@example.com", Raw: "<anonymous@example.com>"},
+		// Malformed trailers are kept as they are.
+		{Raw: "Just A Name"},
+		{Raw: "Broken <broken@example.com"},
+		{Raw: "Not An Email <nobody>"},
+	}, ParseCoAuthorTrailersWithKeys(body, DefaultCoAuthorTrailerKeys))
+	Equal(t, []CoAuthor{
+		{Name: "Reviewer", Email: "reviewer@example.com", Raw: "Reviewer <reviewer@example.com>"},
+	}, ParseCoAuthorTrailersWithKeys(body, []string{"Reviewed-by"}))
+	Empty(t, ParseCoAuthorTrailersWithKeys(body, nil))
+
+	True(t, CoAuthor{Name: "Jane Doe", Email: "jane@example.com"}.Parsed())
+	False(t, CoAuthor{Raw: "Just A Name"}.Parsed())
+}
@@ -40,6 +40,46 @@ type GitMetadata struct {
 	Timestamp string
 	Author    string
 	CoAuthors []string
+	// CoAuthorIdentities are the co-authors, split into their name and email. Those whose trailer
+	// could not be parsed are kept with their raw value only.
+	CoAuthorIdentities []CoAuthor
+	// RawTimestamp is the timestamp recorded in the commit, when Timestamp differs from it, having
+	// been clamped because the commit is dated in the future. It is only set for remote references.
+	RawTimestamp string
+	// SubtreeHash is the git tree hash of RelDir at Hash, which is the same for all commits with
+	// identical content in RelDir. It is only set for remote references.
+	SubtreeHash string
+	// ParentHashes are the parent commits of Hash, the first parent first, and IsMerge is set when
+	// there are more than one. They are only set for remote references.
+	ParentHashes []string
+	IsMerge      bool
+	// Describe is the output of git describe --tags for Hash: the closest tag reachable from it,
+	// followed, unless it is tagged, by the number of commits since and its abbreviated hash. It is
+	// empty when no tag is reachable, which is typically the case in shallow clones. It is only set
+	// for remote references.
+	Describe string
+	// Encoding is the encoding declared in the commit at Hash, empty when none is (i.e. for UTF-8).
+	// It is only set for remote references resolved with commit encoding transcoding enabled.
+	Encoding string
+	// Submodules are the submodules declared in the .gitmodules file at Hash, along with the
+	// commits they are pinned to. It is only set for remote references resolved with submodules
+	// reading enabled.
+	Submodules []Submodule
+	// SigningKeyFingerprint and SigningKeyID are the fingerprint and the ID of the key the commit
+	// at Hash is signed with. They are empty for unsigned commits, and the fingerprint is also
+	// empty when the key could not be found for verification. They are only set for remote
+	// references resolved with signing key reading enabled.
+	SigningKeyFingerprint string
+	SigningKeyID          string
+	// RootCommit is the root commit of the history of Hash (the oldest, when there are several),
+	// which may serve as a stable identifier of the repository. It is only set for remote
+	// references resolved with root commit reading enabled. It is left empty, with
+	// RootCommitUnavailable set, when the history is not available, e.g. in shallow clones.
+	RootCommit            string
+	RootCommitUnavailable bool
+	// Unpopulated is set when the metadata was deliberately not extracted, in which case
+	// all the other fields are left empty.
+	Unpopulated bool
 }
 
 // Metadata performs git metadata detection on the provided directory.
@@ -99,7 +139,7 @@ func Metadata(ctx context.Context, dir string) (*GitMetadata, error) {
 		retErr = err
 		// Keep going.
 	}
-	coAuthors, err := detectGitCoAuthors(ctx, dir)
+	coAuthors, coAuthorIdentities, err := detectGitCoAuthors(ctx, dir)
 	if err != nil {
 		retErr = err
 		// Keep going.
@@ -125,23 +165,34 @@ func Metadata(ctx context.Context, dir string) (*GitMetadata, error) {
 		Timestamp: timestamp,
 		Author:    author,
 		CoAuthors: coAuthors,
+
+		CoAuthorIdentities: coAuthorIdentities,
 	}, retErr
 }
 
 // Clone returns a copy of the GitMetadata object.
 func (gm *GitMetadata) Clone() *GitMetadata {
 	return &GitMetadata{
-		BaseDir:   gm.BaseDir,
-		RelDir:    gm.RelDir,
-		RemoteURL: gm.RemoteURL,
-		GitURL:    gm.GitURL,
-		Hash:      gm.Hash,
-		ShortHash: gm.ShortHash,
-		Branch:    gm.Branch,
-		Tags:      gm.Tags,
-		Timestamp: gm.Timestamp,
-		Author:    gm.Author,
-		CoAuthors: gm.CoAuthors,
+		BaseDir:     gm.BaseDir,
+		RelDir:      gm.RelDir,
+		RemoteURL:   gm.RemoteURL,
+		GitURL:      gm.GitURL,
+		Hash:        gm.Hash,
+		ShortHash:   gm.ShortHash,
+		Branch:      gm.Branch,
+		Tags:        gm.Tags,
+		Timestamp:   gm.Timestamp,
+		Author:      gm.Author,
+		CoAuthors:   gm.CoAuthors,
+		SubtreeHash: gm.SubtreeHash,
+		Unpopulated: gm.Unpopulated,
+
+		CoAuthorIdentities: gm.CoAuthorIdentities,
+
+		ParentHashes: gm.ParentHashes,
+		IsMerge:      gm.IsMerge,
+		Describe:     gm.Describe,
+		Encoding:     gm.Encoding,
 	}
 }
 
@@ -302,7 +353,7 @@ func detectGitAuthor(ctx context.Context, dir string) (string, error) {
 	return strings.SplitN(outStr, "\n", 2)[0], nil
 }
 
-func detectGitCoAuthors(ctx context.Context, dir string) ([]string, error) {
+func detectGitCoAuthors(ctx context.Context, dir string) ([]string, []CoAuthor, error) {
 	cmd := exec.CommandContext(ctx, "git", "log", "-1", "--format=%b")
 	cmd.Dir = dir
 	cmd.Stderr = nil // force capture of stderr on errors
@@ -310,18 +361,31 @@ func detectGitCoAuthors(ctx context.Context, dir string) ([]string, error) {
 	if err != nil {
 		exitError, ok := err.(*exec.ExitError)
 		if ok && strings.Contains(string(exitError.Stderr), "does not have any commits yet") {
-			return nil, nil
+			return nil, nil, nil
 		}
 		if out != nil && strings.Contains(string(out), "does not have any commits yet") {
-			return nil, nil
+			return nil, nil, nil
 		}
-		return nil, errors.Wrap(err, "detect git co-authors")
+		return nil, nil, errors.Wrap(err, "detect git co-authors")
 	}
-	return ParseCoAuthorsFromBody(string(out)), nil
+	return ParseCoAuthorsFromBody(string(out)), ParseCoAuthorTrailersWithKeys(string(out), DefaultCoAuthorTrailerKeys), nil
 }
 
+// DefaultCoAuthorTrailerKeys are the commit message trailer keys recognized as co-authors by default.
+var DefaultCoAuthorTrailerKeys = []string{"Co-authored-by"}
+
 // ParseCoAuthorsFromBody returns a list of coauthor emails from a git body
 func ParseCoAuthorsFromBody(body string) []string {
+	return ParseCoAuthorsFromBodyWithKeys(body, DefaultCoAuthorTrailerKeys)
+}
+
+// ParseCoAuthorsFromBodyWithKeys returns a list of coauthor emails from a git body, recognizing
+// the trailers with any of the given keys (without the trailing colon).
+func ParseCoAuthorsFromBodyWithKeys(body string, keys []string) []string {
+	keySet := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		keySet[k+":"] = struct{}{}
+	}
 	coAuthors := []string{}
 	coAuthorsSeen := map[string]struct{}{}
 	for _, s := range strings.Split(body, "\n") {
@@ -329,7 +393,7 @@ func ParseCoAuthorsFromBody(body string) []string {
 		splits := strings.Split(s, " ")
 		n := len(splits)
 		if n > 2 {
-			if splits[0] == "Co-authored-by:" {
+			if _, ok := keySet[splits[0]]; ok {
 				email := splits[n-1]
 				n = len(email)
 				if n > 2 {
@@ -50,3 +50,15 @@ func TestParseGitRemoteURL(t *testing.T) {
 		Equal(t, test.expectedGitURL, gitURL)
 	}
 }
+
+func TestParseCoAuthorsFromBodyWithKeys(t *testing.T) {
+	body := "Fix the thing\n\n" +
+		"Co-authored-by: Someone <someone@example.com>\n" +
+		"Mitverfasst-von: Jemand Anderes <jemand@example.com>\n" +
+		"Reviewed-by: Reviewer <reviewer@example.com>\n" +
+		"Reviewed-by: Reviewer <reviewer@example.com>\n"
+	Equal(t, []string{"someone@example.com"}, ParseCoAuthorsFromBody(body))
+	Equal(t, []string{"jemand@example.com", "reviewer@example.com"},
+		ParseCoAuthorsFromBodyWithKeys(body, []string{"Mitverfasst-von", "Reviewed-by"}))
+	Empty(t, ParseCoAuthorsFromBodyWithKeys(body, nil))
+}
@@ -0,0 +1,50 @@
+package gitutil
+
+import (
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/util/stringutil"
+	arg "github.com/earthly/earthly/variables/reserved"
+)
+
+// EnvAssignments returns the metadata as shell-exportable KEY=value assignments, named after the
+// builtin args, with the values quoted for a POSIX shell as needed (e.g. author names containing
+// spaces or quotes). They are, in order:
+//
+//	EARTHLY_GIT_HASH, EARTHLY_GIT_SHORT_HASH, EARTHLY_GIT_BRANCH (the first branch),
+//	EARTHLY_GIT_TAG (the first tag), EARTHLY_GIT_ORIGIN_URL_SCRUBBED,
+//	EARTHLY_GIT_COMMIT_TIMESTAMP, EARTHLY_GIT_AUTHOR and EARTHLY_GIT_CO_AUTHORS (space separated).
+//
+// The origin url is only rendered with credentials scrubbed, for the assignments to be safe to log.
+// Empty fields are omitted.
+func (gm *GitMetadata) EnvAssignments() []string {
+	var branch, tag string
+	if len(gm.Branch) > 0 {
+		branch = gm.Branch[0]
+	}
+	if len(gm.Tags) > 0 {
+		tag = gm.Tags[0]
+	}
+	vars := []struct {
+		key   string
+		value string
+	}{
+		{arg.EarthlyGitHash, gm.Hash},
+		{arg.EarthlyGitShortHash, gm.ShortHash},
+		{arg.EarthlyGitBranch, branch},
+		{arg.EarthlyGitTag, tag},
+		{arg.EarthlyGitOriginURLScrubbed, stringutil.ScrubCredentials(gm.RemoteURL)},
+		{arg.EarthlyGitCommitTimestamp, gm.Timestamp},
+		{arg.EarthlyGitAuthor, gm.Author},
+		{arg.EarthlyGitCoAuthors, strings.Join(gm.CoAuthors, " ")},
+	}
+	var ret []string
+	for _, v := range vars {
+		if v.value == "" {
+			continue
+		}
+		ret = append(ret, v.key+"="+shellescape.Quote(v.value))
+	}
+	return ret
+}
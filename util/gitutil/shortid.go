@@ -0,0 +1,37 @@
+package gitutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/util/stringutil"
+)
+
+// ShortID returns a short, human-readable identifier of the commit, meant for logging, such as
+// org/repo@abc1234 (main). The given ref, the branch or tag the commit was resolved from, is
+// omitted when empty or when it is the commit itself. Credentials are scrubbed from the url.
+func (gm *GitMetadata) ShortID(ref string) string {
+	var repo string
+	switch {
+	case gm.GitURL != "":
+		repo = gm.GitURL
+	case gm.RemoteURL != "":
+		repo, _ = ParseGitRemoteURL(stringutil.ScrubCredentials(gm.RemoteURL))
+	}
+	// Drop the host.
+	if parts := strings.SplitN(repo, "/", 2); len(parts) == 2 && parts[1] != "" {
+		repo = parts[1]
+	}
+	id := repo
+	shortHash := gm.ShortHash
+	if shortHash == "" && len(gm.Hash) > 7 {
+		shortHash = gm.Hash[:7]
+	}
+	if shortHash != "" {
+		id = fmt.Sprintf("%s@%s", id, shortHash)
+	}
+	if ref != "" && !(gm.Hash != "" && strings.HasPrefix(gm.Hash, ref)) {
+		id = fmt.Sprintf("%s (%s)", id, ref)
+	}
+	return id
+}
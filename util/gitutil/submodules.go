@@ -0,0 +1,86 @@
+package gitutil
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Submodule is a submodule of a repository, as declared in its .gitmodules file.
+type Submodule struct {
+	// Path is the path of the submodule, relative to the root of the repository.
+	Path string
+	// URL is the url the submodule is cloned from.
+	URL string
+	// Branch is the branch tracked by the submodule, if any.
+	Branch string
+	// Commit is the commit the submodule is pinned to by the superproject, whichever commit its
+	// checkout (if any) is detached at. It is only set for the submodules of remote references.
+	Commit string
+}
+
+// ParseGitModules parses the content of a .gitmodules file into its submodules, in the order they
+// are declared. Submodules without a path are ignored, as git does.
+func ParseGitModules(content string) ([]Submodule, error) {
+	submodules := []Submodule{}
+	var cur *Submodule
+	flush := func() {
+		if cur != nil && cur.Path != "" {
+			submodules = append(submodules, *cur)
+		}
+		cur = nil
+	}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			flush()
+			end := strings.IndexByte(line, ']')
+			if end == -1 {
+				return nil, errors.Errorf("invalid .gitmodules section on line %d: %s", i+1, line)
+			}
+			section := strings.Fields(line[1:end])
+			if len(section) > 0 && strings.EqualFold(section[0], "submodule") {
+				cur = &Submodule{}
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value, err := parseGitConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid .gitmodules value on line %d", i+1)
+		}
+		if cur == nil {
+			continue
+		}
+		switch key {
+		case "path":
+			cur.Path = value
+		case "url":
+			cur.URL = value
+		case "branch":
+			cur.Branch = value
+		}
+	}
+	flush()
+	return submodules, nil
+}
+
+// parseGitConfigValue strips the quotes and trailing comments of a git config value.
+func parseGitConfigValue(value string) (string, error) {
+	if strings.HasPrefix(value, "\"") {
+		end := strings.LastIndexByte(value, '"')
+		if end == 0 {
+			return "", errors.Errorf("unterminated quoted value %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	}
+	if i := strings.IndexAny(value, "#;"); i != -1 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
@@ -0,0 +1,43 @@
+package gitutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseGitModules(t *testing.T) {
+	submodules, err := ParseGitModules(`# The vendored dependencies.
+[submodule "vendor/lib"]
+	path = vendor/lib
+	url = https://github.com/earthly/lib.git
+[submodule "docs"]
+	path = "docs/site"  
+	url = git@github.com:earthly/docs.git ; mirrored
+	branch = main
+[core]
+	path = ignored
+[Submodule "no-path"]
+	url = https://github.com/earthly/nopath.git
+
+[submodule "tools"]
+	URL = ../tools.git
+	Path = tools
+	branch = .
+`)
+	NoError(t, err)
+	Equal(t, []Submodule{
+		{Path: "vendor/lib", URL: "https://github.com/earthly/lib.git"},
+		{Path: "docs/site", URL: "git@github.com:earthly/docs.git", Branch: "main"},
+		{Path: "tools", URL: "../tools.git", Branch: "."},
+	}, submodules)
+
+	submodules, err = ParseGitModules("")
+	NoError(t, err)
+	Equal(t, []Submodule{}, submodules)
+
+	_, err = ParseGitModules("[submodule \"broken\"\n\tpath = broken\n")
+	Error(t, err)
+	_, err = ParseGitModules("[submodule \"broken\"]\n\tpath = \"broken\n")
+	Error(t, err)
+}
@@ -71,6 +71,24 @@ func CopyOp(ctx context.Context, srcState pllb.State, srcs []string, destState p
 	return destState.File(fa, opts...), nil
 }
 
+// CopyDirContentsOp copies the contents of the directory src of srcState into dest of destState,
+// skipping any path matching one of the exclude patterns.
+func CopyDirContentsOp(srcState pllb.State, src string, destState pllb.State, dest string, chown string, excludes []string, opts ...llb.ConstraintsOpt) pllb.State {
+	copyOpts := []llb.CopyOption{
+		&llb.CopyInfo{
+			FollowSymlinks:      true,
+			CopyDirContentsOnly: true,
+			CreateDestPath:      true,
+			ExcludePatterns:     excludes,
+		},
+		llb.WithCreatedTime(*defaultTs()),
+	}
+	if chown != "" {
+		copyOpts = append(copyOpts, llb.WithUser(chown))
+	}
+	return destState.File(pllb.Copy(srcState, src, dest, copyOpts...), opts...)
+}
+
 // CopyWithRunOptions copies from `src` to `dest` and returns the result in a separate LLB State.
 // This operation is similar llb.Copy, however, it can apply llb.RunOptions (such as a mount)
 // Interanally, the operation runs on the internal COPY image used by Dockerfile.
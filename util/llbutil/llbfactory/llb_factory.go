@@ -12,6 +12,23 @@ type Factory interface {
 	Construct() pllb.State
 }
 
+// FallibleFactory is a Factory whose construction may fail, such as when the state is only
+// resolved upon its first construction.
+type FallibleFactory interface {
+	Factory
+	// ConstructErr is like Construct, but also returns the error of the construction.
+	ConstructErr() (pllb.State, error)
+}
+
+// Construct creates the pllb.State of the given factory, returning the construction error of
+// fallible factories.
+func Construct(f Factory) (pllb.State, error) {
+	if ff, ok := f.(FallibleFactory); ok {
+		return ff.ConstructErr()
+	}
+	return f.Construct(), nil
+}
+
 // PreconstructedFactory holds a preconstructed pllb.State for cases
 // where a factory is overkill.
 type PreconstructedFactory struct {
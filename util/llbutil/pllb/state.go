@@ -56,6 +56,13 @@ func Git(remote, ref string, opts ...llb.GitOption) State {
 	return State{st: llb.Git(remote, ref, opts...)}
 }
 
+// HTTP is a wrapper around llb.HTTP.
+func HTTP(url string, opts ...llb.HTTPOption) State {
+	gmu.Lock()
+	defer gmu.Unlock()
+	return State{st: llb.HTTP(url, opts...)}
+}
+
 // Merge is a wrapper around llb.Merge.
 func Merge(sts []State, opts ...llb.ConstraintsOpt) State {
 	sts2 := make([]llb.State, len(sts))
@@ -155,6 +162,20 @@ func (s State) AddExtraHost(hostname string, ip net.IP) State {
 	return State{st: s.st.AddExtraHost(hostname, ip)}
 }
 
+// WithValue is a wrapper around llb.WithValue.
+func (s State) WithValue(k, v interface{}) State {
+	gmu.Lock()
+	defer gmu.Unlock()
+	return State{st: s.st.WithValue(k, v)}
+}
+
+// Value is a wrapper around llb.Value.
+func (s State) Value(ctx context.Context, k interface{}) (interface{}, error) {
+	gmu.Lock()
+	defer gmu.Unlock()
+	return s.st.Value(ctx, k)
+}
+
 // ExecState is a wrapper around llb.ExecState.
 type ExecState struct {
 	est llb.ExecState
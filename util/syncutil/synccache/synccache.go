@@ -32,35 +32,53 @@ func New() *SyncCache {
 	}
 }
 
-// Do executes the constructor, if a value for key hasn't already been constructed.
+// Do executes the constructor, if a value for key hasn't already been constructed. It returns as
+// soon as ctx is done, while the construction carries on for the other callers, if any.
+//
+// Constructions failing with a context error, context.Canceled or context.DeadlineExceeded, are
+// not cached: such errors are those of the callers (all of which are gone), not of the value, so
+// the next call constructs it afresh. This deliberately includes expired deadlines, as a value
+// failing to be constructed within the deadline of a caller may well be within that of another.
 func (sc *SyncCache) Do(ctx context.Context, key interface{}, c Constructor) (interface{}, error) {
-	e, found := sc.getEntry(ctx, key)
-	if !found {
-		// We need to construct this.
-		go func() {
-			// The metaCtx will ensure that this stays alive even if the original Do has
-			// been canceled, thanks to the metaCtx. This is canceled only when ALL of
-			// the Do's are canceled.
-			e.value, e.err = c(e.metaCtx, key)
-			// Don't cache context canceled. Whoever is currently waiting will still get this,
-			// but no future callers to Do will.
-			if errors.Is(e.err, context.Canceled) {
-				sc.deleteEntry(key)
-			}
-			close(e.constructed)
-		}()
-	} else {
-		go func() {
-			select {
-			case <-e.constructed:
-			default:
-				// Add our context to metaCtx in case all others get canceled.
-				_ = e.metaCtx.Add(ctx)
+	for {
+		e, found := sc.getEntry(ctx, key)
+		if !found {
+			// We need to construct this.
+			go func() {
+				// The metaCtx will ensure that this stays alive even if the original Do has
+				// been canceled, thanks to the metaCtx. This is canceled only when ALL of
+				// the Do's are canceled.
+				e.value, e.err = c(e.metaCtx, key)
+				// Don't cache context canceled or expired. Whoever is currently waiting will still
+				// get this, but no future callers to Do will. The entry may have been deleted, and
+				// another one added for the key since, which is kept.
+				if isContextErr(e.err) {
+					sc.deleteEntryIf(key, e)
+				}
+				close(e.constructed)
+			}()
+		} else {
+			go func() {
+				select {
+				case <-e.constructed:
+				default:
+					// Add our context to metaCtx in case all others get canceled.
+					_ = e.metaCtx.Add(ctx)
+				}
+			}()
+		}
+		select {
+		case <-e.constructed:
+			if found && isContextErr(e.err) && ctx.Err() == nil {
+				// The construction has been canceled by the contexts of other callers, which have
+				// all gone away before this one joined. Try again (the entry is no longer stored).
+				continue
 			}
-		}()
+			return e.value, e.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	<-e.constructed
-	return e.value, e.err
 }
 
 // Add adds a readily constructed value for a given key.
@@ -75,6 +93,11 @@ func (sc *SyncCache) Add(ctx context.Context, key interface{}, value interface{}
 	return nil
 }
 
+// Delete removes the value for a given key, if any. Ongoing constructions are not canceled.
+func (sc *SyncCache) Delete(key interface{}) {
+	sc.deleteEntry(key)
+}
+
 func (sc *SyncCache) getEntry(ctx context.Context, key interface{}) (*entry, bool) {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
@@ -95,3 +118,18 @@ func (sc *SyncCache) deleteEntry(key interface{}) {
 	defer sc.mu.Unlock()
 	delete(sc.store, key)
 }
+
+// deleteEntryIf deletes the entry of key only if it is e, rather than one added since e was deleted.
+func (sc *SyncCache) deleteEntryIf(key interface{}, e *entry) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.store[key] == e {
+		delete(sc.store, key)
+	}
+}
+
+// isContextErr returns whether err is due to the context of the construction being canceled or
+// past its deadline.
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,202 @@
+package synccache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoShared(t *testing.T) {
+	sc := New()
+	var constructions int32
+	release := make(chan struct{})
+	c := func(ctx context.Context, key interface{}) (interface{}, error) {
+		atomic.AddInt32(&constructions, 1)
+		<-release
+		return "value", nil
+	}
+	results := make(chan interface{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			v, err := sc.Do(context.Background(), "key", c)
+			assert.NoError(t, err)
+			results <- v
+		}()
+	}
+	close(release)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "value", <-results)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&constructions))
+}
+
+func TestDoCanceled(t *testing.T) {
+	sc := New()
+	var constructions int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	c := func(ctx context.Context, key interface{}) (interface{}, error) {
+		if atomic.AddInt32(&constructions, 1) == 1 {
+			close(started)
+		}
+		select {
+		case <-release:
+			return "value", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, err := sc.Do(leaderCtx, "key", c)
+		leaderErr <- err
+	}()
+	<-started
+	followerValue := make(chan interface{})
+	go func() {
+		v, err := sc.Do(context.Background(), "key", c)
+		assert.NoError(t, err)
+		followerValue <- v
+	}()
+
+	// The leader returns as soon as its context is done, without waiting for the construction.
+	cancel()
+	assert.ErrorIs(t, <-leaderErr, context.Canceled)
+	// The construction carries on for the follower (or, had the follower joined after the leader
+	// was gone, is retried for it).
+	close(release)
+	assert.Equal(t, "value", <-followerValue)
+
+	// The value is cached.
+	n := atomic.LoadInt32(&constructions)
+	v, err := sc.Do(context.Background(), "key", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+	assert.Equal(t, n, atomic.LoadInt32(&constructions))
+}
+
+func TestDoRetryCanceledConstruction(t *testing.T) {
+	sc := New()
+	var constructions int32
+	canceled := make(chan struct{})
+	proceed := make(chan struct{})
+	c := func(ctx context.Context, key interface{}) (interface{}, error) {
+		if atomic.AddInt32(&constructions, 1) == 1 {
+			// The only caller is gone: the construction fails once the follower has joined.
+			<-ctx.Done()
+			close(canceled)
+			<-proceed
+			return nil, ctx.Err()
+		}
+		return "value", nil
+	}
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := sc.Do(leaderCtx, "key", c)
+	assert.ErrorIs(t, err, context.Canceled)
+	<-canceled
+
+	// The follower joins the canceled construction, whose failure it does not share.
+	followerValue := make(chan interface{})
+	go func() {
+		v, err := sc.Do(context.Background(), "key", c)
+		assert.NoError(t, err)
+		followerValue <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(proceed)
+	assert.Equal(t, "value", <-followerValue)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&constructions))
+}
+
+func TestDoDeadlineExceeded(t *testing.T) {
+	sc := New()
+	var constructions int32
+	c := func(ctx context.Context, key interface{}) (interface{}, error) {
+		if atomic.AddInt32(&constructions, 1) == 1 {
+			<-ctx.Done()
+			return nil, errors.Wrap(ctx.Err(), "construct")
+		}
+		return "value", nil
+	}
+	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := sc.Do(expiredCtx, "key", c)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	sc.mu.Lock()
+	expired := sc.store["key"]
+	sc.mu.Unlock()
+	if expired != nil {
+		<-expired.constructed
+		assert.ErrorIs(t, expired.err, context.DeadlineExceeded)
+	}
+
+	// The expired construction is not cached: it is constructed afresh for the next caller.
+	v, err := sc.Do(context.Background(), "key", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&constructions))
+
+	// Other errors are.
+	sc = New()
+	constructErr := errors.New("construct")
+	for i := 0; i < 2; i++ {
+		_, err = sc.Do(context.Background(), "key", func(ctx context.Context, key interface{}) (interface{}, error) {
+			assert.Equal(t, 0, i, "constructed again")
+			return nil, constructErr
+		})
+		assert.ErrorIs(t, err, constructErr)
+	}
+}
+
+func TestDeleteReAdd(t *testing.T) {
+	sc := New()
+	started := make(chan struct{})
+	c := func(ctx context.Context, key interface{}) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	staleCtx, cancel := context.WithCancel(context.Background())
+	staleErr := make(chan error)
+	go func() {
+		_, err := sc.Do(staleCtx, "key", c)
+		staleErr <- err
+	}()
+	<-started
+	sc.mu.Lock()
+	stale := sc.store["key"]
+	sc.mu.Unlock()
+
+	// The key is deleted and constructed afresh while the stale construction is ongoing.
+	sc.Delete("key")
+	v, err := sc.Do(context.Background(), "key", func(ctx context.Context, key interface{}) (interface{}, error) {
+		return "fresh", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+
+	// The failure of the stale construction does not drop the fresh entry.
+	cancel()
+	assert.ErrorIs(t, <-staleErr, context.Canceled)
+	<-stale.constructed
+	assert.ErrorIs(t, stale.err, context.Canceled)
+	v, err = sc.Do(context.Background(), "key", func(ctx context.Context, key interface{}) (interface{}, error) {
+		return "other", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+
+	// A readily constructed value may be added again once deleted.
+	sc.Delete("key")
+	assert.NoError(t, sc.Add(context.Background(), "key", "added", nil))
+	assert.Error(t, sc.Add(context.Background(), "key", "again", nil))
+	v, err = sc.Do(context.Background(), "key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "added", v)
+}